@@ -0,0 +1,98 @@
+// Package audit provides an interface for recording the outcome of every webhook delivery for later review.
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// type StepResult is a struct containing the outcome of an individual receiver or transformation step.
+type StepResult struct {
+	// Label is the label of the receiver or transformation.
+	Label string `json:"label,omitempty"`
+	// Size is the size, in bytes, of the output of the step.
+	Size int `json:"size"`
+	// Code is the status code of the error returned by the step, if any.
+	Code int `json:"code,omitempty"`
+	// Error is the message of the error returned by the step, if any.
+	Error string `json:"error,omitempty"`
+	// Duration is the amount of time, in milliseconds, the step took.
+	Duration float64 `json:"duration_ms"`
+}
+
+// type DispatcherResult is a struct containing the outcome of relaying a message to an individual dispatcher.
+type DispatcherResult struct {
+	// Label is the label of the dispatcher.
+	Label string `json:"label"`
+	// Status is the outcome of the dispatch. Valid options are: ok, halted, failed.
+	Status string `json:"status"`
+	// Code is the status code of the error returned by the dispatcher, if any.
+	Code int `json:"code,omitempty"`
+	// Error is the message of the error returned by the dispatcher, if any.
+	Error string `json:"error,omitempty"`
+	// Duration is the amount of time, in milliseconds, it took to dispatch the message.
+	Duration float64 `json:"duration_ms"`
+}
+
+// type Timings is a struct containing the amount of time, in milliseconds, spent in each stage of the webhook pipeline.
+type Timings struct {
+	// Receive is the time it took to receive the message.
+	Receive float64 `json:"receive_ms,omitempty"`
+	// Transform is the time it took to apply all of the transformations.
+	Transform float64 `json:"transform_ms,omitempty"`
+	// Dispatch is the time it took to relay the message to all of its dispatchers.
+	Dispatch float64 `json:"dispatch_ms,omitempty"`
+	// Process is the time it took to process the message from start to finish.
+	Process float64 `json:"process_ms,omitempty"`
+}
+
+// Milliseconds() returns 'd' as a (fractional) number of milliseconds.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// type Record is a struct containing the outcome of processing an individual webhook message.
+type Record struct {
+	// DeliveryID is the unique identifier for the message (delivery).
+	DeliveryID string `json:"delivery_id"`
+	// Endpoint is the relative URI of the webhook that received the message.
+	Endpoint string `json:"endpoint"`
+	// EventType is the type of event that triggered the message, if known.
+	EventType string `json:"event_type,omitempty"`
+	// Async is a boolean flag signaling that the message was processed by a queue worker.
+	Async bool `json:"async,omitempty"`
+	// Outcome is the outcome of processing the message.
+	Outcome string `json:"outcome"`
+	// Reason is the reason the message was halted, if it was halted by its receiver or a transformation.
+	Reason string `json:"reason,omitempty"`
+	// Received is the time the message was received.
+	Received time.Time `json:"received"`
+	// Receiver is the outcome of the receiver step. It is omitted for messages processed by a queue worker.
+	Receiver *StepResult `json:"receiver,omitempty"`
+	// Transformations is the list of outcomes for each transformation that was applied.
+	Transformations []*StepResult `json:"transformations,omitempty"`
+	// Route is the name of the route used to select the dispatchers for the message, if the message was dispatched.
+	Route string `json:"route,omitempty"`
+	// Dispatchers is the list of outcomes for each dispatcher, if the message was dispatched.
+	Dispatchers []*DispatcherResult `json:"dispatchers,omitempty"`
+	// Timings are the amount of time spent in each stage of the webhook pipeline.
+	Timings *Timings `json:"timings"`
+	// Payload is the body of the message as it was received, if payloads are captured.
+	Payload string `json:"payload,omitempty"`
+	// PayloadTruncated is a boolean flag signaling that 'Payload' was truncated because it exceeded the maximum payload size.
+	PayloadTruncated bool `json:"payload_truncated,omitempty"`
+}
+
+// type Sink is an interface for writing `Record` instances.
+type Sink interface {
+	// Write() writes a `Record` instance to the sink.
+	Write(context.Context, *Record) error
+	// Close() flushes any records that have not been written yet and closes the sink.
+	Close() error
+}
+
+// NewSink() returns a new `Sink` instance derived from 'uri'. Currently all sinks are backed by a `gocloud.dev/blob.Bucket`
+// so 'uri' is expected to be a valid and registered `gocloud.dev/blob` URI.
+func NewSink(ctx context.Context, uri string) (Sink, error) {
+	return NewBlobSink(ctx, uri)
+}
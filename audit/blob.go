@@ -0,0 +1,283 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	aa_log "github.com/aaronland/go-log/v2"
+	"gocloud.dev/blob"
+)
+
+// DEFAULT_FLUSH_INTERVAL is the default maximum amount of time records are buffered before they are written to the bucket.
+const DEFAULT_FLUSH_INTERVAL time.Duration = 10 * time.Second
+
+// DEFAULT_MAX_RECORDS is the default maximum number of records that are buffered before they are written to the bucket.
+const DEFAULT_MAX_RECORDS int = 100
+
+// BlobSink implements the `Sink` interface for writing records, as JSON lines, to a `gocloud.dev/blob.Bucket` instance.
+// Records are written to files whose keys are prefixed by the hour they were written, in the form of:
+//
+//	{YYYY}/{MM}/{DD}/{HH}/{UNIX_NANO}-{RANDOM}.jsonl
+//
+// Since blob files can not be appended to, a new file is started each hour, whenever the flush interval elapses and
+// whenever the maximum number of records has been written to the current file. Records are only visible in the bucket
+// once the file they are written to has been closed.
+type BlobSink struct {
+	Sink
+	mu *sync.Mutex
+	// bucket is the `gocloud.dev/blob.Bucket` instance where records are written.
+	bucket *blob.Bucket
+	// writer is the `blob.Writer` instance for the current file, if any.
+	writer *blob.Writer
+	// key is the key of the current file.
+	key string
+	// hour is the hour, truncated, that the current file was started.
+	hour time.Time
+	// flush_interval is the maximum amount of time records are buffered before the current file is closed.
+	flush_interval time.Duration
+	// max_records is the maximum number of records written to the current file before it is closed.
+	max_records int
+	// count is the number of records written to the current file.
+	count int
+	// flush_errors are the errors, if any, encountered closing files in the background flush loop. They are returned by `Close()`.
+	flush_errors []error
+	// done is closed when the sink is closed to stop the background flush loop.
+	done chan bool
+	// logger is the `log.Logger` instance used to report errors in the background flush loop.
+	logger *log.Logger
+}
+
+// NewBlobSink returns a new `BlobSink` instance configured by 'uri' which is expected to be a valid and registered
+// `gocloud.dev/blob.Bucket` URI. The following extra parameters are supported (and removed before the underlying
+// bucket is opened):
+// * `?flush_interval=` An optional `time.Duration` string for the maximum amount of time records are buffered before they are written to the bucket. Default is 10s.
+// * `?max_records=` An optional maximum number of records that are buffered before they are written to the bucket. Default is 100. Use 1 to write each record as soon as it is received.
+func NewBlobSink(ctx context.Context, uri string) (Sink, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	flush_interval := DEFAULT_FLUSH_INTERVAL
+
+	if q.Has("flush_interval") {
+
+		i, err := time.ParseDuration(q.Get("flush_interval"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse flush interval, %w", err)
+		}
+
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid flush interval, %v", i)
+		}
+
+		flush_interval = i
+		q.Del("flush_interval")
+	}
+
+	max_records := DEFAULT_MAX_RECORDS
+
+	if q.Has("max_records") {
+
+		i, err := strconv.Atoi(q.Get("max_records"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse max records, %w", err)
+		}
+
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid max records, %d", i)
+		}
+
+		max_records = i
+		q.Del("max_records")
+	}
+
+	u.RawQuery = q.Encode()
+
+	bucket, err := blob.OpenBucket(ctx, u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	s := &BlobSink{
+		mu:             new(sync.Mutex),
+		bucket:         bucket,
+		flush_interval: flush_interval,
+		max_records:    max_records,
+		flush_errors:   make([]error, 0),
+		done:           make(chan bool),
+		logger:         log.Default(),
+	}
+
+	go s.flushLoop()
+
+	return s, nil
+}
+
+// Write() encodes 'r' as a line of JSON and writes it to the current file, starting a new file if the hour has changed.
+// If the current file contains the maximum number of records it is closed, which causes it to be written to the bucket.
+func (s *BlobSink) Write(ctx context.Context, r *Record) error {
+
+	enc, err := json.Marshal(r)
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode record for %s, %w", r.DeliveryID, err)
+	}
+
+	enc = append(enc, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	hour := now.Truncate(time.Hour)
+
+	if s.writer != nil && !hour.Equal(s.hour) {
+
+		err := s.closeWriter()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.writer == nil {
+
+		err := s.openWriter(now)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.writer.Write(enc)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write record for %s to %s, %w", r.DeliveryID, s.key, err)
+	}
+
+	s.count += 1
+
+	if s.count >= s.max_records {
+		return s.closeWriter()
+	}
+
+	return nil
+}
+
+// Close() closes the current file, if any, and the underlying bucket. It returns any errors encountered closing files in
+// the background flush loop as well as errors closing the current file and the bucket.
+func (s *BlobSink) Close() error {
+
+	close(s.done)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	close_errors := s.flush_errors
+	s.flush_errors = make([]error, 0)
+
+	err := s.closeWriter()
+
+	if err != nil {
+		close_errors = append(close_errors, err)
+	}
+
+	err = s.bucket.Close()
+
+	if err != nil {
+		close_errors = append(close_errors, fmt.Errorf("Failed to close bucket, %w", err))
+	}
+
+	return errors.Join(close_errors...)
+}
+
+// openWriter() starts a new file for records written during the hour of 'now'.
+func (s *BlobSink) openWriter(now time.Time) error {
+
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	key := fmt.Sprintf("%s/%s-%s.jsonl", now.Format("2006/01/02/15"), strconv.FormatInt(now.UnixNano(), 10), hex.EncodeToString(b))
+
+	// The writer uses a background context since it outlives the request that caused it to be opened
+
+	wr, err := s.bucket.NewWriter(context.Background(), key, &blob.WriterOptions{ContentType: "application/x-ndjson"})
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new writer for %s, %w", key, err)
+	}
+
+	s.writer = wr
+	s.key = key
+	s.hour = now.Truncate(time.Hour)
+
+	return nil
+}
+
+// closeWriter() closes the current file, if any, which causes it to be written to the bucket.
+func (s *BlobSink) closeWriter() error {
+
+	if s.writer == nil {
+		return nil
+	}
+
+	err := s.writer.Close()
+
+	key := s.key
+
+	s.writer = nil
+	s.key = ""
+	s.count = 0
+
+	if err != nil {
+		return fmt.Errorf("Failed to close writer for %s, %w", key, err)
+	}
+
+	return nil
+}
+
+// flushLoop() closes the current file every flush interval until the sink is closed.
+func (s *BlobSink) flushLoop() {
+
+	ticker := time.NewTicker(s.flush_interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+
+			s.mu.Lock()
+
+			err := s.closeWriter()
+
+			if err != nil {
+				s.flush_errors = append(s.flush_errors, err)
+			}
+
+			s.mu.Unlock()
+
+			if err != nil {
+				aa_log.Error(s.logger, "Failed to flush audit records, %v", err)
+			}
+		}
+	}
+}
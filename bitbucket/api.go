@@ -0,0 +1,390 @@
+package bitbucket
+
+// https://developer.atlassian.com/cloud/bitbucket/rest/api-group-commits/#api-repositories-workspace-repo-slug-diffstat-spec-get
+// https://developer.atlassian.com/server/bitbucket/rest/v906/api-group-repository/#api-api-latest-projects-projectkey-repos-repositoryslug-changes-get
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_API_URI is the default root URI of the Bitbucket Cloud API.
+const DEFAULT_API_URI string = "https://api.bitbucket.org/2.0"
+
+// DEFAULT_API_TIMEOUT is the default maximum amount of time to wait for each Bitbucket API request.
+const DEFAULT_API_TIMEOUT time.Duration = 30 * time.Second
+
+// DEFAULT_MAX_ATTEMPTS is the default maximum number of times each Bitbucket API request is attempted.
+const DEFAULT_MAX_ATTEMPTS int = 3
+
+// DEFAULT_BACKOFF is the default amount of time to wait before retrying a failed Bitbucket API request. It is doubled for each subsequent retry.
+const DEFAULT_BACKOFF time.Duration = 1 * time.Second
+
+// type APIOptions is a struct containing configuration details for a new `API` instance.
+type APIOptions struct {
+	// APIURI is the root URI of the Bitbucket Cloud API. Default is `DEFAULT_API_URI`.
+	APIURI string
+	// ServerURI is the root URI of the Bitbucket Server instance, required to fetch changes for Bitbucket Server push events.
+	ServerURI string
+	// User is the optional username used to authenticate API requests with an app password.
+	User string
+	// Token is the optional access token (or app password, if `User` is not empty) used to authenticate API requests.
+	Token string
+	// Timeout is the maximum amount of time to wait for each API request. Default is `DEFAULT_API_TIMEOUT`.
+	Timeout time.Duration
+	// MaxAttempts is the maximum number of times each API request is attempted. Default is `DEFAULT_MAX_ATTEMPTS`.
+	MaxAttempts int
+	// Backoff is the amount of time to wait before retrying a failed API request. Default is `DEFAULT_BACKOFF`.
+	Backoff time.Duration
+}
+
+// type API is a struct for fetching the files changed by Bitbucket push events from the Bitbucket Cloud or Bitbucket Server APIs.
+type API struct {
+	// api_uri is the root URI of the Bitbucket Cloud API.
+	api_uri string
+	// server_uri is the root URI of the Bitbucket Server instance.
+	server_uri string
+	// user is the optional username used to authenticate API requests with an app password.
+	user string
+	// token is the optional access token (or app password) used to authenticate API requests.
+	token string
+	// client is the HTTP client used to perform API requests.
+	client *http.Client
+	// max_attempts is the maximum number of times each API request is attempted.
+	max_attempts int
+	// backoff is the amount of time to wait before retrying a failed API request.
+	backoff time.Duration
+}
+
+// NewAPI() returns a new `API` instance configured by 'opts'. If `opts.User` is not empty requests are authenticated using
+// `opts.User` and `opts.Token` (an app password), otherwise if `opts.Token` is not empty they are authenticated using
+// `opts.Token` (an access token).
+func NewAPI(opts *APIOptions) *API {
+
+	api := &API{
+		api_uri:      DEFAULT_API_URI,
+		server_uri:   strings.TrimRight(opts.ServerURI, "/"),
+		user:         opts.User,
+		token:        opts.Token,
+		client:       &http.Client{Timeout: DEFAULT_API_TIMEOUT},
+		max_attempts: DEFAULT_MAX_ATTEMPTS,
+		backoff:      DEFAULT_BACKOFF,
+	}
+
+	if opts.APIURI != "" {
+		api.api_uri = strings.TrimRight(opts.APIURI, "/")
+	}
+
+	if opts.Timeout > 0 {
+		api.client.Timeout = opts.Timeout
+	}
+
+	if opts.MaxAttempts > 0 {
+		api.max_attempts = opts.MaxAttempts
+	}
+
+	if opts.Backoff > 0 {
+		api.backoff = opts.Backoff
+	}
+
+	return api
+}
+
+// FetchChanges() assigns the paths of the files changed by each change in 'push', excluding deletions, and, for Bitbucket
+// Server push events, the message and author of the commit at the head of each change.
+func (api *API) FetchChanges(ctx context.Context, push *Push) error {
+
+	if push.Server && api.server_uri == "" {
+		return fmt.Errorf("Missing Bitbucket Server URI required to fetch changed paths from Bitbucket Server")
+	}
+
+	for _, c := range push.Changes {
+
+		if c.Deleted {
+			continue
+		}
+
+		var err error
+
+		if push.Server {
+			err = api.fetchServerChange(ctx, push, c)
+		} else {
+			err = api.fetchCloudChange(ctx, push, c)
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to fetch changed paths for %s, %w", c.Ref, err)
+		}
+	}
+
+	return nil
+}
+
+// fetchCloudChange() assigns the paths of the files changed by 'c' using the Bitbucket Cloud "diffstat" API. The paths for
+// changes which created their branch (reference) are those changed by the commit at the head of the branch.
+func (api *API) fetchCloudChange(ctx context.Context, push *Push, c *Change) error {
+
+	spec := c.After
+
+	if !c.Created {
+		spec = fmt.Sprintf("%s..%s", c.After, c.Before)
+	}
+
+	q := url.Values{}
+	q.Set("topic", "false")
+
+	next := fmt.Sprintf("%s/repositories/%s/diffstat/%s?%s", api.api_uri, escapePath(push.FullName), url.PathEscape(spec), q.Encode())
+
+	for next != "" {
+
+		var rsp struct {
+			Values []struct {
+				Status string `json:"status"`
+				Old    *struct {
+					Path string `json:"path"`
+				} `json:"old"`
+				New *struct {
+					Path string `json:"path"`
+				} `json:"new"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+
+		err := api.get(ctx, next, &rsp)
+
+		if err != nil {
+			return err
+		}
+
+		// The "old" and "new" properties are null for files that did not exist before, or after, the change so they
+		// are checked regardless of the status in case of unexpected responses
+
+		for _, v := range rsp.Values {
+
+			switch v.Status {
+			case "added":
+
+				if v.New != nil {
+					c.Added = append(c.Added, v.New.Path)
+				}
+
+			case "removed":
+
+				if v.Old != nil {
+					c.Removed = append(c.Removed, v.Old.Path)
+				}
+
+			case "renamed":
+
+				if v.Old != nil {
+					c.Removed = append(c.Removed, v.Old.Path)
+				}
+
+				if v.New != nil {
+					c.Added = append(c.Added, v.New.Path)
+				}
+
+			default:
+
+				if v.New != nil {
+					c.Modified = append(c.Modified, v.New.Path)
+				}
+			}
+		}
+
+		next = rsp.Next
+	}
+
+	return nil
+}
+
+// fetchServerChange() assigns the paths of the files changed by 'c' using the Bitbucket Server "changes" API and the message
+// and author of the commit at the head of 'c' using the "commits" API. The paths for changes which created their branch
+// (reference) are those changed by the commit at the head of the branch.
+func (api *API) fetchServerChange(ctx context.Context, push *Push, c *Change) error {
+
+	parts := strings.SplitN(push.FullName, "/", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Invalid repository name '%s', expected {PROJECT}/{REPOSITORY}", push.FullName)
+	}
+
+	repo_uri := fmt.Sprintf("%s/rest/api/1.0/projects/%s/repos/%s", api.server_uri, url.PathEscape(parts[0]), url.PathEscape(parts[1]))
+
+	var commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name         string `json:"name"`
+			DisplayName  string `json:"displayName"`
+			EmailAddress string `json:"emailAddress"`
+		} `json:"author"`
+		AuthorTimestamp int64 `json:"authorTimestamp"`
+	}
+
+	err := api.get(ctx, fmt.Sprintf("%s/commits/%s", repo_uri, url.PathEscape(c.After)), &commit)
+
+	if err != nil {
+		return err
+	}
+
+	c.Message = commit.Message
+	c.AuthorName = commit.Author.Name
+	c.AuthorEmail = commit.Author.EmailAddress
+
+	if commit.Author.DisplayName != "" {
+		c.AuthorName = commit.Author.DisplayName
+	}
+
+	if commit.AuthorTimestamp > 0 {
+		c.Timestamp = time.UnixMilli(commit.AuthorTimestamp).UTC().Format(time.RFC3339)
+	}
+
+	start := 0
+
+	for {
+
+		q := url.Values{}
+		q.Set("until", c.After)
+		q.Set("start", strconv.Itoa(start))
+		q.Set("limit", "1000")
+
+		if !c.Created {
+			q.Set("since", c.Before)
+		}
+
+		var rsp struct {
+			Values []struct {
+				Type string `json:"type"`
+				Path struct {
+					ToString string `json:"toString"`
+				} `json:"path"`
+				SrcPath *struct {
+					ToString string `json:"toString"`
+				} `json:"srcPath"`
+			} `json:"values"`
+			IsLastPage    bool `json:"isLastPage"`
+			NextPageStart int  `json:"nextPageStart"`
+		}
+
+		err := api.get(ctx, fmt.Sprintf("%s/changes?%s", repo_uri, q.Encode()), &rsp)
+
+		if err != nil {
+			return err
+		}
+
+		for _, v := range rsp.Values {
+
+			switch v.Type {
+			case "ADD", "COPY":
+				c.Added = append(c.Added, v.Path.ToString)
+			case "DELETE":
+				c.Removed = append(c.Removed, v.Path.ToString)
+			case "MOVE":
+
+				if v.SrcPath != nil {
+					c.Removed = append(c.Removed, v.SrcPath.ToString)
+				}
+
+				c.Added = append(c.Added, v.Path.ToString)
+			default:
+				c.Modified = append(c.Modified, v.Path.ToString)
+			}
+		}
+
+		if rsp.IsLastPage || rsp.NextPageStart <= start {
+			break
+		}
+
+		start = rsp.NextPageStart
+	}
+
+	return nil
+}
+
+// get() performs an HTTP GET request for 'uri' and decodes the JSON response in to 'rsp'. Requests which fail because of a
+// network error, or return a "429 Too Many Requests" or 5XX response, are retried with an exponential backoff until they
+// succeed, 'api.max_attempts' is reached or 'ctx' is cancelled.
+func (api *API) get(ctx context.Context, uri string, rsp interface{}) error {
+
+	backoff := api.backoff
+
+	for attempt := 1; ; attempt++ {
+
+		retry, err := api.getOnce(ctx, uri, rsp)
+
+		if err == nil || !retry || attempt >= api.max_attempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (%v)", err, ctx.Err())
+		case <-timer.C:
+			// pass
+		}
+
+		backoff = backoff * 2
+	}
+}
+
+// getOnce() performs a single HTTP GET request for 'uri' and decodes the JSON response in to 'rsp'. It returns a boolean
+// value indicating whether a failed request may be retried.
+func (api *API) getOnce(ctx context.Context, uri string, rsp interface{}) (bool, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	switch {
+	case api.user != "":
+		req.SetBasicAuth(api.user, api.token)
+	case api.token != "":
+		req.Header.Set("Authorization", "Bearer "+api.token)
+	}
+
+	http_rsp, err := api.client.Do(req)
+
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("Failed to execute request, %w", err)
+	}
+
+	defer http_rsp.Body.Close()
+
+	if http_rsp.StatusCode != http.StatusOK {
+		retry := http_rsp.StatusCode == http.StatusTooManyRequests || http_rsp.StatusCode >= 500
+		return retry, fmt.Errorf("Request for %s failed, %s", req.URL.Path, http_rsp.Status)
+	}
+
+	err = json.NewDecoder(http_rsp.Body).Decode(rsp)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to decode response, %w", err)
+	}
+
+	return false, nil
+}
+
+// escapePath() returns 'p' with each of its components escaped.
+func escapePath(p string) string {
+
+	parts := strings.Split(p, "/")
+
+	for idx, part := range parts {
+		parts[idx] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
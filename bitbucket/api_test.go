@@ -0,0 +1,140 @@
+package bitbucket
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestAPI() returns a new `API` instance for both the Bitbucket Cloud and Bitbucket Server APIs at 'uri' which does not retry requests.
+func newTestAPI(uri string) *API {
+
+	opts := &APIOptions{
+		APIURI:      uri,
+		ServerURI:   uri,
+		MaxAttempts: 1,
+	}
+
+	return NewAPI(opts)
+}
+
+func TestFetchCloudChangeNullPaths(t *testing.T) {
+
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		rsp.Header().Set("Content-Type", "application/json")
+
+		rsp.Write([]byte(`{"values": [
+			{"status": "added", "old": null, "new": {"path": "added.geojson"}},
+			{"status": "added", "old": null, "new": null},
+			{"status": "removed", "old": {"path": "removed.geojson"}, "new": null},
+			{"status": "removed", "old": null, "new": null},
+			{"status": "renamed", "old": {"path": "before.geojson"}, "new": {"path": "after.geojson"}},
+			{"status": "renamed", "old": null, "new": {"path": "renamed.geojson"}},
+			{"status": "modified", "old": {"path": "modified.geojson"}, "new": {"path": "modified.geojson"}},
+			{"status": "modified", "old": null, "new": null},
+			{"status": "merge conflict"}
+		]}`))
+	}))
+
+	defer server.Close()
+
+	push := &Push{
+		FullName: "whosonfirst-data/whosonfirst-data-admin-xy",
+		Changes: []*Change{
+			{Ref: "refs/heads/main", Before: "abc", After: "def"},
+		},
+	}
+
+	err := newTestAPI(server.URL).FetchChanges(ctx, push)
+
+	if err != nil {
+		t.Fatalf("Failed to fetch changes, %v", err)
+	}
+
+	c := push.Changes[0]
+
+	if !reflect.DeepEqual(c.Added, []string{"added.geojson", "after.geojson", "renamed.geojson"}) {
+		t.Fatalf("Unexpected added paths, %v", c.Added)
+	}
+
+	if !reflect.DeepEqual(c.Removed, []string{"removed.geojson", "before.geojson"}) {
+		t.Fatalf("Unexpected removed paths, %v", c.Removed)
+	}
+
+	if !reflect.DeepEqual(c.Modified, []string{"modified.geojson"}) {
+		t.Fatalf("Unexpected modified paths, %v", c.Modified)
+	}
+}
+
+func TestFetchServerChange(t *testing.T) {
+
+	ctx := context.Background()
+
+	var paths []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		paths = append(paths, req.URL.Path)
+
+		rsp.Header().Set("Content-Type", "application/json")
+
+		if strings.Contains(req.URL.Path, "/commits/") {
+			json.NewEncoder(rsp).Encode(map[string]interface{}{"message": "Update", "author": map[string]string{"name": "a"}})
+			return
+		}
+
+		rsp.Write([]byte(`{"values": [{"type": "MODIFY", "path": {"toString": "modified.geojson"}}], "isLastPage": true}`))
+	}))
+
+	defer server.Close()
+
+	api := newTestAPI(server.URL)
+
+	push := &Push{
+		FullName: "WOF/whosonfirst-data-admin-xy",
+		Server:   true,
+		Changes: []*Change{
+			{Ref: "refs/heads/main", Before: "abc", After: "def"},
+		},
+	}
+
+	err := api.FetchChanges(ctx, push)
+
+	if err != nil {
+		t.Fatalf("Failed to fetch changes, %v", err)
+	}
+
+	if !reflect.DeepEqual(push.Changes[0].Modified, []string{"modified.geojson"}) {
+		t.Fatalf("Unexpected modified paths, %v", push.Changes[0].Modified)
+	}
+
+	if paths[0] != "/rest/api/1.0/projects/WOF/repos/whosonfirst-data-admin-xy/commits/def" {
+		t.Fatalf("Unexpected commits request, %s", paths[0])
+	}
+
+	// Repository names without a project key are rejected rather than requested
+
+	paths = nil
+
+	for _, name := range []string{"whosonfirst-data-admin-xy", "", "WOF/", "/whosonfirst-data-admin-xy"} {
+
+		push.FullName = name
+
+		err := api.FetchChanges(ctx, push)
+
+		if err == nil {
+			t.Fatalf("Expected repository name '%s' to fail", name)
+		}
+	}
+
+	if len(paths) != 0 {
+		t.Fatalf("Expected invalid repository names not to be requested, %v", paths)
+	}
+}
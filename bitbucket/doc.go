@@ -0,0 +1,4 @@
+// Package bitbucket provides methods for parsing Bitbucket Cloud and Bitbucket Server push events, converting them in to
+// GitHub push events and fetching the files changed by each push from the Bitbucket APIs. It is used by the `bitbucket://`
+// receiver and the `bitbucketpaths://` transformation.
+package bitbucket
@@ -0,0 +1,414 @@
+package bitbucket
+
+// https://support.atlassian.com/bitbucket-cloud/docs/event-payloads/#Push
+// https://confluence.atlassian.com/bitbucketserver/event-payload-938025882.html#Eventpayload-Push
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ZERO_SHA is the commit hash used for the "before" property of changes which create a branch (or tag) and the "after"
+// property of changes which delete one.
+const ZERO_SHA string = "0000000000000000000000000000000000000000"
+
+// type Push is a struct containing the properties of a Bitbucket Cloud or Bitbucket Server push event.
+type Push struct {
+	// Repo is the name (slug) of the repository, without its workspace or project.
+	Repo string
+	// FullName is the name of the repository including its workspace (Bitbucket Cloud) or project key (Bitbucket Server).
+	FullName string
+	// Server is a boolean flag signaling that the push event was sent by Bitbucket Server.
+	Server bool
+	// Changes are the changes to individual branches (references) in the push event.
+	Changes []*Change
+}
+
+// type Change is a struct containing the properties of a change to an individual branch (reference) in a push event.
+type Change struct {
+	// Ref is the fully-qualified Git reference, for example "refs/heads/main", that was changed.
+	Ref string
+	// Before is the commit hash of the reference before the change or `ZERO_SHA` if it was created.
+	Before string
+	// After is the commit hash of the reference after the change or `ZERO_SHA` if it was deleted.
+	After string
+	// Created is a boolean flag signaling that the change created its reference.
+	Created bool
+	// Deleted is a boolean flag signaling that the change deleted its reference.
+	Deleted bool
+	// Forced is a boolean flag signaling that the change was a forced push.
+	Forced bool
+	// Message is the message of the commit at the head of the reference after the change.
+	Message string
+	// AuthorName is the name of the author of the commit at the head of the reference after the change.
+	AuthorName string
+	// AuthorEmail is the email address of the author of the commit at the head of the reference after the change.
+	AuthorEmail string
+	// Timestamp is the RFC3339 date of the commit at the head of the reference after the change.
+	Timestamp string
+	// Added are the paths of the files added by the change.
+	Added []string
+	// Modified are the paths of the files updated (modified) by the change.
+	Modified []string
+	// Removed are the paths of the files deleted by the change.
+	Removed []string
+}
+
+// type cloudRef is a struct containing the properties of the "old" and "new" references of a Bitbucket Cloud push change.
+type cloudRef struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target struct {
+		Hash    string `json:"hash"`
+		Message string `json:"message"`
+		Date    string `json:"date"`
+		Author  struct {
+			Raw  string `json:"raw"`
+			User *struct {
+				DisplayName string `json:"display_name"`
+			} `json:"user"`
+		} `json:"author"`
+	} `json:"target"`
+}
+
+// ParseCloudPush() returns a new `Push` instance derived from the Bitbucket Cloud "repo:push" event in 'body'.
+func ParseCloudPush(body []byte) (*Push, error) {
+
+	var event struct {
+		Repository struct {
+			Name     string `json:"name"`
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Push struct {
+			Changes []struct {
+				New     *cloudRef `json:"new"`
+				Old     *cloudRef `json:"old"`
+				Created bool      `json:"created"`
+				Closed  bool      `json:"closed"`
+				Forced  bool      `json:"forced"`
+			} `json:"changes"`
+		} `json:"push"`
+	}
+
+	err := json.Unmarshal(body, &event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	push := &Push{
+		Repo:     path.Base(event.Repository.FullName),
+		FullName: event.Repository.FullName,
+		Changes:  make([]*Change, 0),
+	}
+
+	for _, c := range event.Push.Changes {
+
+		change := &Change{
+			Before:  ZERO_SHA,
+			After:   ZERO_SHA,
+			Created: c.Created || c.Old == nil,
+			Deleted: c.Closed || c.New == nil,
+			Forced:  c.Forced,
+		}
+
+		ref := c.New
+
+		if ref == nil {
+			ref = c.Old
+		}
+
+		if ref == nil {
+			continue
+		}
+
+		switch ref.Type {
+		case "branch":
+			change.Ref = "refs/heads/" + ref.Name
+		case "tag":
+			change.Ref = "refs/tags/" + ref.Name
+		default:
+			change.Ref = ref.Name
+		}
+
+		if c.Old != nil {
+			change.Before = c.Old.Target.Hash
+		}
+
+		if c.New != nil {
+
+			author := c.New.Target.Author
+
+			change.After = c.New.Target.Hash
+			change.Message = c.New.Target.Message
+			change.Timestamp = c.New.Target.Date
+			change.AuthorName, change.AuthorEmail = parseRawAuthor(author.Raw)
+
+			if author.User != nil && author.User.DisplayName != "" {
+				change.AuthorName = author.User.DisplayName
+			}
+		}
+
+		push.Changes = append(push.Changes, change)
+	}
+
+	return push, nil
+}
+
+// ParseServerPush() returns a new `Push` instance derived from the Bitbucket Server "repo:refs_changed" event in 'body'.
+func ParseServerPush(body []byte) (*Push, error) {
+
+	var event struct {
+		Repository struct {
+			Slug    string `json:"slug"`
+			Project struct {
+				Key string `json:"key"`
+			} `json:"project"`
+		} `json:"repository"`
+		Changes []struct {
+			Ref struct {
+				ID string `json:"id"`
+			} `json:"ref"`
+			RefID    string `json:"refId"`
+			FromHash string `json:"fromHash"`
+			ToHash   string `json:"toHash"`
+			Type     string `json:"type"`
+		} `json:"changes"`
+	}
+
+	err := json.Unmarshal(body, &event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	push := &Push{
+		Repo:     event.Repository.Slug,
+		FullName: event.Repository.Project.Key + "/" + event.Repository.Slug,
+		Server:   true,
+		Changes:  make([]*Change, 0),
+	}
+
+	for _, c := range event.Changes {
+
+		ref := c.Ref.ID
+
+		if ref == "" {
+			ref = c.RefID
+		}
+
+		change := &Change{
+			Ref:     ref,
+			Before:  c.FromHash,
+			After:   c.ToHash,
+			Created: c.Type == "ADD" || c.FromHash == ZERO_SHA,
+			Deleted: c.Type == "DELETE" || c.ToHash == ZERO_SHA,
+		}
+
+		push.Changes = append(push.Changes, change)
+	}
+
+	return push, nil
+}
+
+// parseRawAuthor() returns the name and email address of a commit author in the form of "{NAME} <{EMAIL}>".
+func parseRawAuthor(raw string) (string, string) {
+
+	idx := strings.LastIndex(raw, " <")
+
+	if idx == -1 || !strings.HasSuffix(raw, ">") {
+		return strings.TrimSpace(raw), ""
+	}
+
+	return raw[:idx], raw[idx+2 : len(raw)-1]
+}
+
+// type gitHubAuthor is a struct containing the properties of the author of a commit in a GitHub push event.
+type gitHubAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+// type gitHubCommit is a struct containing the properties of a commit in a GitHub push event.
+type gitHubCommit struct {
+	ID        string       `json:"id"`
+	Message   string       `json:"message"`
+	Timestamp string       `json:"timestamp,omitempty"`
+	Author    gitHubAuthor `json:"author"`
+	Added     []string     `json:"added"`
+	Removed   []string     `json:"removed"`
+	Modified  []string     `json:"modified"`
+}
+
+// type gitHubRepository is a struct containing the properties of the repository in a GitHub push event.
+type gitHubRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+// type gitHubPushEvent is a struct containing the properties of a GitHub push event, and the Bitbucket specific properties
+// needed to fetch the files changed by each of its commits, derived from a `Push` instance.
+type gitHubPushEvent struct {
+	Ref        string           `json:"ref"`
+	Before     string           `json:"before"`
+	After      string           `json:"after"`
+	Created    bool             `json:"created"`
+	Deleted    bool             `json:"deleted"`
+	Forced     bool             `json:"forced"`
+	Commits    []*gitHubCommit  `json:"commits"`
+	HeadCommit *gitHubCommit    `json:"head_commit"`
+	Repository gitHubRepository `json:"repository"`
+	Bitbucket  *pushProperties  `json:"bitbucket"`
+}
+
+// type pushProperties is a struct containing the properties of a `Push` instance which are not part of a GitHub push event.
+type pushProperties struct {
+	// Server is a boolean flag signaling that the push event was sent by Bitbucket Server.
+	Server bool `json:"server"`
+	// Changes are the properties of each change, in the same order as the commits in the GitHub push event.
+	Changes []*changeProperties `json:"changes"`
+}
+
+// type changeProperties is a struct containing the properties of a `Change` instance which are not part of a GitHub commit.
+type changeProperties struct {
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	Created bool   `json:"created,omitempty"`
+	Forced  bool   `json:"forced,omitempty"`
+}
+
+// GitHubPushEvent() returns 'p' encoded as a GitHub push event. Each change, excluding deletions, is encoded as a commit
+// containing the files changed by that change. The properties of the push event itself ("ref", "after", "head_commit", etc.)
+// are those of the last change which is not a deletion, or the last change if they are all deletions. The properties of each
+// change which are not part of a GitHub commit are encoded in the "bitbucket" property so that the push event can be decoded
+// using `ParseGitHubPushEvent`.
+func (p *Push) GitHubPushEvent() ([]byte, error) {
+
+	event := &gitHubPushEvent{
+		Commits: make([]*gitHubCommit, 0),
+		Repository: gitHubRepository{
+			Name:     p.Repo,
+			FullName: p.FullName,
+		},
+		Bitbucket: &pushProperties{
+			Server:  p.Server,
+			Changes: make([]*changeProperties, 0),
+		},
+	}
+
+	var head *Change
+	var last *Change
+
+	for _, c := range p.Changes {
+
+		last = c
+
+		if c.Deleted {
+			continue
+		}
+
+		head = c
+
+		event.Commits = append(event.Commits, &gitHubCommit{
+			ID:        c.After,
+			Message:   c.Message,
+			Timestamp: c.Timestamp,
+			Author:    gitHubAuthor{Name: c.AuthorName, Email: c.AuthorEmail},
+			Added:     nonNil(c.Added),
+			Removed:   nonNil(c.Removed),
+			Modified:  nonNil(c.Modified),
+		})
+
+		event.Bitbucket.Changes = append(event.Bitbucket.Changes, &changeProperties{
+			Ref:     c.Ref,
+			Before:  c.Before,
+			Created: c.Created,
+			Forced:  c.Forced,
+		})
+
+		event.HeadCommit = event.Commits[len(event.Commits)-1]
+	}
+
+	if head == nil {
+		head = last
+	}
+
+	if head != nil {
+		event.Ref = head.Ref
+		event.Before = head.Before
+		event.After = head.After
+		event.Created = head.Created
+		event.Deleted = head.Deleted
+		event.Forced = head.Forced
+	}
+
+	enc, err := json.Marshal(event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal push event, %w", err)
+	}
+
+	return enc, nil
+}
+
+// ParseGitHubPushEvent() returns a new `Push` instance derived from 'body', a GitHub push event produced by the `GitHubPushEvent`
+// method. Deletions are not encoded in GitHub push events so none of the changes in the `Push` instance are deletions.
+func ParseGitHubPushEvent(body []byte) (*Push, error) {
+
+	var event gitHubPushEvent
+
+	err := json.Unmarshal(body, &event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	if event.Bitbucket == nil {
+		return nil, fmt.Errorf("Event is missing a bitbucket property")
+	}
+
+	if len(event.Bitbucket.Changes) != len(event.Commits) {
+		return nil, fmt.Errorf("Event has %d commits but %d changes", len(event.Commits), len(event.Bitbucket.Changes))
+	}
+
+	push := &Push{
+		Repo:     event.Repository.Name,
+		FullName: event.Repository.FullName,
+		Server:   event.Bitbucket.Server,
+		Changes:  make([]*Change, len(event.Commits)),
+	}
+
+	for idx, c := range event.Commits {
+
+		props := event.Bitbucket.Changes[idx]
+
+		push.Changes[idx] = &Change{
+			Ref:         props.Ref,
+			Before:      props.Before,
+			After:       c.ID,
+			Created:     props.Created,
+			Forced:      props.Forced,
+			Message:     c.Message,
+			AuthorName:  c.Author.Name,
+			AuthorEmail: c.Author.Email,
+			Timestamp:   c.Timestamp,
+			Added:       c.Added,
+			Modified:    c.Modified,
+			Removed:     c.Removed,
+		}
+	}
+
+	return push, nil
+}
+
+// nonNil() returns 'paths' or an empty list if 'paths' is nil.
+func nonNil(paths []string) []string {
+
+	if paths == nil {
+		return []string{}
+	}
+
+	return paths
+}
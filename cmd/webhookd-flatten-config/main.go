@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/whosonfirst/go-webhookd/v3/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
 	"io/ioutil"
 	"log"
 	"net/url"
@@ -0,0 +1,258 @@
+// webhookd-replay-dlq is a tool to list, and re-dispatch, webhook messages that were written to a dead letter store
+// because a dispatcher failed to relay them. Messages are re-dispatched using the dispatcher (label) that originally
+// failed, as defined in a webhookd config file, and removed from the dead letter store on success.
+//
+// For example:
+//
+//	$> ./bin/webhookd-replay-dlq -dryrun \
+//		-config-uri 'file:///usr/local/webhookd/config.json?decoder=string' \
+//		-dispatcher indexing
+package main
+
+import (
+	// necessary for blob dispatcher and dead letter store
+	_ "github.com/aaronland/gocloud-blob-s3"
+	// necessary for blob dispatcher and dead letter store
+	_ "gocloud.dev/blob/fileblob"
+	// necessary for pubsub dispatcher
+	_ "gocloud.dev/pubsub/awssnssqs"
+)
+
+import (
+	// defines the blob dispatcher
+	_ "github.com/whosonfirst/go-webhookd-gocloud"
+	// defines the lambda, pubsub and retry dispatchers
+	_ "github.com/whosonfirst/go-whosonfirst-webhookd/dispatcher"
+)
+
+import (
+	_ "gocloud.dev/runtimevar/awsparamstore"
+	_ "gocloud.dev/runtimevar/constantvar"
+	_ "gocloud.dev/runtimevar/filevar"
+)
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/daemon"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/deadletter"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/delivery"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/envelope"
+)
+
+func main() {
+
+	ctx := context.Background()
+	err := run(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run() replays, or lists, dead letters as defined by command line flags. It returns errors rather than exiting so that
+// the dead letter store and any dispatchers that have been created are always closed.
+func run(ctx context.Context) error {
+
+	fs := flagset.NewFlagSet("replay")
+
+	config_uri := fs.String("config-uri", "", "A valid Go Cloud runtimevar URI representing your webhookd config.")
+	dead_letter_uri := fs.String("dead-letter-uri", "", "A valid gocloud.dev/blob Bucket URI where dead letters are stored. If empty the value of the \"dead_letter\" property in your webhookd config will be used.")
+
+	endpoint := fs.String("endpoint", "", "Only process dead letters for this webhook endpoint. Endpoints containing secrets are identified by their redacted endpoint and a hash, as listed by the -list flag.")
+	label := fs.String("dispatcher", "", "Only process dead letters for this dispatcher label.")
+	delivery_id := fs.String("delivery-id", "", "Only process dead letters for this delivery ID.")
+	error_match := fs.String("error", "", "Only process dead letters whose error message matches this regular expression.")
+
+	list := fs.Bool("list", false, "List dead letters but don't re-dispatch them.")
+	dryrun := fs.Bool("dryrun", false, "Go through the motions but don't re-dispatch or remove any dead letters.")
+
+	flagset.Parse(fs)
+
+	err := flagset.SetFlagsFromEnvVarsWithFeedback(fs, "WEBHOOKD", true)
+
+	if err != nil {
+		return fmt.Errorf("Failed to set flags from env vars, %w", err)
+	}
+
+	cfg, err := config.NewConfigFromURI(ctx, *config_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to load config, %w", err)
+	}
+
+	if *dead_letter_uri == "" {
+		*dead_letter_uri = cfg.DeadLetter
+	}
+
+	if *dead_letter_uri == "" {
+		return fmt.Errorf("Missing dead letter URI")
+	}
+
+	var error_re *regexp.Regexp
+
+	if *error_match != "" {
+
+		re, err := regexp.Compile(*error_match)
+
+		if err != nil {
+			return fmt.Errorf("Failed to compile -error flag, %w", err)
+		}
+
+		error_re = re
+	}
+
+	store, err := deadletter.NewStore(ctx, *dead_letter_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create dead letter store, %w", err)
+	}
+
+	defer store.Close()
+
+	entries, err := store.List(ctx)
+
+	if err != nil {
+
+		if entries == nil {
+			return fmt.Errorf("Failed to list dead letters, %w", err)
+		}
+
+		log.Printf("Skipping dead letters that could not be read, %v", err)
+	}
+
+	dispatchers := make(map[string]webhookd.WebhookDispatcher)
+
+	defer closeDispatchers(ctx, dispatchers)
+
+	count := 0
+	replayed := 0
+
+	for _, e := range entries {
+
+		if *endpoint != "" && e.Endpoint != *endpoint {
+			continue
+		}
+
+		if *label != "" && e.Dispatcher != *label {
+			continue
+		}
+
+		if *delivery_id != "" && e.DeliveryID != *delivery_id {
+			continue
+		}
+
+		if error_re != nil && !error_re.MatchString(e.Error) {
+			continue
+		}
+
+		count += 1
+
+		if *list {
+			created := time.Unix(e.Created, 0).Format(time.RFC3339)
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, created, e.Endpoint, e.Dispatcher, e.DeliveryID, e.Error)
+			continue
+		}
+
+		err := replay(ctx, cfg, store, dispatchers, e, *dryrun)
+
+		if err != nil {
+			log.Printf("Failed to replay dead letter %s, %v", e.ID, err)
+			continue
+		}
+
+		replayed += 1
+	}
+
+	if !*list {
+		log.Printf("Replayed %d of %d dead letters", replayed, count)
+	}
+
+	return nil
+}
+
+func replay(ctx context.Context, cfg *config.WebhookConfig, store deadletter.Store, dispatchers map[string]webhookd.WebhookDispatcher, e *deadletter.Entry, dryrun bool) error {
+
+	d, ok := dispatchers[e.Dispatcher]
+
+	if !ok {
+
+		dispatcher_uri, err := cfg.GetDispatcherConfigByName(e.Dispatcher)
+
+		if err != nil {
+			return fmt.Errorf("Failed to get dispatcher configuration for '%s', %w", e.Dispatcher, err)
+		}
+
+		dr, err := dispatcher.NewDispatcher(ctx, dispatcher_uri)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create dispatcher for '%s', %w", e.Dispatcher, err)
+		}
+
+		dispatchers[e.Dispatcher] = dr
+		d = dr
+	}
+
+	if dryrun {
+		log.Printf("Dispatch dead letter %s (delivery %s) to %s\n", e.ID, e.DeliveryID, e.Dispatcher)
+		return nil
+	}
+
+	ctx = delivery.WithID(ctx, e.DeliveryID)
+	ctx = delivery.WithDispatcher(ctx, e.Dispatcher)
+
+	env := &envelope.Envelope{
+		Body:       e.Body,
+		DeliveryID: e.DeliveryID,
+		Endpoint:   e.Endpoint,
+		ReceivedAt: time.Unix(e.Created, 0),
+	}
+
+	dispatch_err := envelope.NewDispatcherAdapter(d).DispatchEnvelope(ctx, env)
+
+	if dispatch_err != nil {
+
+		switch dispatch_err.Code {
+		case webhookd.UnhandledEvent, webhookd.HaltEvent:
+			log.Printf("Dispatcher %s halted dead letter %s, %v", e.Dispatcher, e.ID, dispatch_err)
+		default:
+			return fmt.Errorf("Failed to dispatch, %w", dispatch_err)
+		}
+	}
+
+	err := store.Remove(ctx, e)
+
+	if err != nil {
+		return fmt.Errorf("Failed to remove dead letter, %w", err)
+	}
+
+	return nil
+}
+
+// closeDispatchers() closes any dispatchers in 'dispatchers' that implement the `daemon.ClosableDispatcher` interface,
+// flushing any messages that have not been sent yet.
+func closeDispatchers(ctx context.Context, dispatchers map[string]webhookd.WebhookDispatcher) {
+
+	for label, dr := range dispatchers {
+
+		c, ok := dr.(daemon.ClosableDispatcher)
+
+		if !ok {
+			continue
+		}
+
+		err := c.Close(ctx)
+
+		if err != nil {
+			log.Printf("Failed to close dispatcher %s, %v", label, err)
+		}
+	}
+}
@@ -1,5 +1,5 @@
-// webhookd is an instance of the default `whosonfirst/go-webhookd` daemon with a variety of Who's On First
-// specific packages enabled.
+// webhookd is an instance of the `whosonfirst/go-whosonfirst-webhookd/daemon` daemon, itself derived from the
+// default `whosonfirst/go-webhookd` daemon, with a variety of Who's On First specific packages enabled.
 package main
 
 import (
@@ -12,12 +12,16 @@ import (
 )
 
 import (
-	// defines the lambda dispatcher
-	_ "github.com/whosonfirst/go-webhookd-aws/v2"
 	// defines the github* transformations
 	_ "github.com/whosonfirst/go-webhookd-github"
 	// defines the blob dispatcher
 	_ "github.com/whosonfirst/go-webhookd-gocloud"
+	// defines the lambda, pubsub and retry dispatchers
+	_ "github.com/whosonfirst/go-whosonfirst-webhookd/dispatcher"
+	// defines the wofgithub, gitlab, gitea and bitbucket receivers
+	_ "github.com/whosonfirst/go-whosonfirst-webhookd/receiver"
+	// defines the gitlab* and bitbucketpaths transformations
+	_ "github.com/whosonfirst/go-whosonfirst-webhookd/transformation"
 )
 
 import (
@@ -29,15 +33,18 @@ import (
 import (
 	"context"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	aa_log "github.com/aaronland/go-log/v2"
 	"github.com/sfomuseum/go-flags/flagset"
 	"github.com/sfomuseum/runtimevar"
-	"github.com/whosonfirst/go-webhookd/v3/config"
-	"github.com/whosonfirst/go-webhookd/v3/daemon"
-	
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/daemon"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
 )
 
 func main() {
@@ -45,22 +52,45 @@ func main() {
 	fs := flagset.NewFlagSet("webhooks")
 
 	config_uri := fs.String("config-uri", "", "A valid Go Cloud runtimevar URI representing your webhookd config.")
+	watch_config := fs.Bool("watch-config", false, "Watch the -config-uri runtimevar for changes and reload webhooks (and their receivers, transformations and dispatchers) when it changes.")
+	log_format := fs.String("log-format", logging.DEFAULT_FORMAT, "The format to emit log messages in. Valid options are: text, json.")
+	log_level := fs.String("log-level", logging.DEFAULT_LEVEL, "The minimum level of log messages to emit. Valid options are: debug, info, warn, error.")
 
 	flagset.Parse(fs)
 
-	ctx := context.Background()
+	// Cancel ctx on SIGINT or SIGTERM so that the daemon stops accepting new requests and waits for
+	// in-flight deliveries to complete before exiting
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	logger := log.Default()
-	
+
 	err := flagset.SetFlagsFromEnvVarsWithFeedback(fs, "WEBHOOKD", true)
 
 	if err != nil {
 		aa_log.Fatal(logger, "Failed to set flags from env vars, %v", err)
 	}
-	
+
+	sl, err := logging.NewSlogLogger(os.Stderr, *log_format, *log_level)
+
+	if err != nil {
+		aa_log.Fatal(logger, "Failed to create logger, %v", err)
+	}
+
+	// Relay everything written to the default logger, by packages that use log.Default(), to the structured logger
+
+	slog.SetDefault(sl)
+
+	fatal := func(msg string, err error) {
+		sl.Error(msg, logging.ERROR_KEY, err)
+		os.Exit(1)
+	}
+
 	str_cfg, err := runtimevar.StringVar(ctx, *config_uri)
 
 	if err != nil {
-		aa_log.Fatal(logger, "Failed to open runtimevar, %v", err)
+		fatal("Failed to open runtimevar", err)
 	}
 
 	cfg_r := strings.NewReader(str_cfg)
@@ -68,20 +98,32 @@ func main() {
 	cfg, err := config.NewConfigFromReader(ctx, cfg_r)
 
 	if err != nil {
-		aa_log.Fatal(logger, "Failed to load config from reader, %v", err)
+		fatal("Failed to load config from reader", err)
 	}
 
 	wh_daemon, err := daemon.NewWebhookDaemonFromConfig(ctx, cfg)
 
 	if err != nil {
-		aa_log.Fatal(logger, "Failed to create new webhookd, %v", err)
+		fatal("Failed to create new webhookd", err)
+	}
+
+	wh_daemon.SetStructuredLogger(sl)
+
+	if *watch_config {
+
+		go func() {
+
+			err := wh_daemon.WatchConfigWithLogger(ctx, *config_uri, logger)
+
+			if err != nil {
+				sl.Error("Failed to watch config", logging.ERROR_KEY, err)
+			}
+		}()
 	}
 
 	err = wh_daemon.StartWithLogger(ctx, logger)
 
 	if err != nil {
-		aa_log.Fatal(logger, "Failed to serve requests, %v", err)
+		fatal("Failed to serve requests", err)
 	}
-
-	os.Exit(0)
 }
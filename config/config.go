@@ -0,0 +1,308 @@
+// Package config provides data structures and methods for configuring a Who's On First specific `webhookd` instance.
+// It is a superset of the `whosonfirst/go-webhookd/v3/config` package and any valid `go-webhookd` config file is also
+// a valid config file for this package.
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sfomuseum/runtimevar"
+)
+
+// type WebhookConfig is a struct containing configuration information for a `webhookd` instance.
+type WebhookConfig struct {
+	// Daemon is a valid `aaronland/go-http-server` URI. This determines how the `webhookd` server will be
+	// instantiated and listen for requests.
+	Daemon string `json:"daemon"`
+	// Receivers is a dictionary of available receivers where the key is a unique label used to identify the
+	// receiver (in `WebhookWebhooksConfig`) and the value is a URI used to instantiate the reciever.
+	Receivers map[string]string `json:"receivers"`
+	// Dispatchers is a dictionary of available dispatchers where the key is a unique label used to identify the
+	// dispatcher (in `WebhookWebhooksConfig`) and the value is a URI used to instantiate the dispatcher.
+	Dispatchers map[string]string `json:"dispatchers"`
+	// Transformations is a dictionary of available transformations where the key is a unique label used to identify the
+	// transformation (in `WebhookWebhooksConfig`) and the value is a URI used to instantiate the transformation.
+	Transformations map[string]string `json:"transformations"`
+	// Webhooks is a list of `WebhookWebhooksConfig` used to configure the webhooks that a `webhookd` instance will respond to.
+	Webhooks []WebhookWebhooksConfig `json:"webhooks"`
+	// Queue is an optional `WebhookQueueConfig` used to configure the durable queue for webhooks that are processed asynchronously.
+	Queue *WebhookQueueConfig `json:"queue,omitempty"`
+	// DeadLetter is an optional valid and registered `gocloud.dev/blob` URI where messages that could not be dispatched will be stored.
+	DeadLetter string `json:"dead_letter,omitempty"`
+	// Health is an optional `WebhookHealthConfig` used to configure the liveness and readiness endpoints.
+	Health *WebhookHealthConfig `json:"health,omitempty"`
+	// Metrics is an optional `WebhookMetricsConfig` used to configure the metrics endpoint.
+	Metrics *WebhookMetricsConfig `json:"metrics,omitempty"`
+	// Tracing is an optional `WebhookTracingConfig` used to configure how traces are exported.
+	Tracing *WebhookTracingConfig `json:"tracing,omitempty"`
+	// ShutdownTimeout is the optional number of seconds to wait for in-flight webhook messages to finish processing when the daemon is shut down.
+	ShutdownTimeout int `json:"shutdown_timeout,omitempty"`
+	// Limits is an optional `WebhookLimitsConfig` used to configure the default and global limits for webhook requests.
+	Limits *WebhookLimitsConfig `json:"limits,omitempty"`
+	// Idempotency is an optional `WebhookIdempotencyConfig` used to configure how duplicate webhook messages are detected.
+	Idempotency *WebhookIdempotencyConfig `json:"idempotency,omitempty"`
+	// Admin is an optional `WebhookAdminConfig` used to enable and configure the admin API.
+	Admin *WebhookAdminConfig `json:"admin,omitempty"`
+	// Timeouts is an optional `WebhookTimeoutsConfig` used to limit the amount of time spent processing messages for every webhook.
+	Timeouts *WebhookTimeoutsConfig `json:"timeouts,omitempty"`
+	// Audit is an optional `WebhookAuditConfig` used to configure the audit log of every webhook delivery.
+	Audit *WebhookAuditConfig `json:"audit,omitempty"`
+}
+
+// type WebhookWebhooksConfig is a struct containing configuration information for an individual webhook.
+type WebhookWebhooksConfig struct {
+	// Endpoint is the relative URI where the webhook will be installed.
+	Endpoint string `json:"endpoint"`
+	// Receiver the label for a recievier configured in `WebhookConfig.Receivers` that will be used to process an
+	// initial webhook request.
+	Receiver string `json:"receiver"`
+	// Transformations is a list of transformation labels configured in `WebhookConfig.Transformations`. These transformations
+	// will be applied in the order they are listed. The first transformation will be applied to the output of `Receiver` and
+	// subsequent transformations will be applied to the output of the previous transformation.
+	Transformations []string `json:"transformations"`
+	// Dispatchers is a list of dispatcher labels configured in `WebhookConfig.Dispatchers`. Each dispatcher takes the output
+	// of the last transformation and relays ("dispatches") it acccording to its internal rules.
+	Dispatchers []string `json:"dispatchers"`
+	// Async is an optional boolean flag signaling that messages should be written to the queue defined in `WebhookConfig.Queue`
+	// after they have been received and that transformations and dispatchers should be applied in the background. Requests
+	// for asynchronous webhooks return a `202 Accepted` response as soon as the message has been queued.
+	Async bool `json:"async,omitempty"`
+	// StatusPolicy is an optional string used to derive the HTTP status code of a webhook response from the outcomes of its dispatchers.
+	// Valid options are: "any-failure" (return an error if any dispatcher fails), "all-failure" (return an error only if every dispatcher
+	// fails) and "best-effort" (never return an error for dispatcher failures). Default is "any-failure".
+	StatusPolicy string `json:"status_policy,omitempty"`
+	// EmptyBody is an optional string used to determine what happens to messages whose body is empty after they have been received
+	// or transformed. Valid options are: "halt" (stop processing the message), "log" (stop processing the message and log the reason
+	// why) and "pass" (relay the empty body to the next transformation or the dispatchers). Default is "halt".
+	EmptyBody string `json:"empty_body,omitempty"`
+	// MaxBodySize is the optional maximum size, in bytes, of a webhook request body. Larger requests receive a `413 Request Entity Too Large`
+	// response. If zero the value of `WebhookLimitsConfig.MaxBodySize` is used.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	// MaxConcurrent is the optional maximum number of requests for the webhook that may be processed at the same time. Requests received
+	// while the webhook is saturated receive a `503 Service Unavailable` response with a "Retry-After" header. If zero there is no limit.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// RateLimit is an optional `WebhookRateLimitConfig` used to limit the rate at which messages are dispatched.
+	RateLimit *WebhookRateLimitConfig `json:"rate_limit,omitempty"`
+	// IdempotencyKey is an optional string used to identify duplicate messages for the webhook. It overrides the value of
+	// `WebhookIdempotencyConfig.Key`. The value "none" disables duplicate detection for the webhook.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Routes is an optional list of `WebhookRouteConfig` used to select the dispatchers for each message. Routes are evaluated
+	// in the order they are listed and messages are relayed to the dispatchers of the first route that matches. Messages that
+	// don't match any route are relayed to `Dispatchers` (the default route).
+	Routes []*WebhookRouteConfig `json:"routes,omitempty"`
+	// Timeouts is an optional `WebhookTimeoutsConfig` used to limit the amount of time spent processing messages for the webhook.
+	// Each timeout that it defines overrides the same timeout in `WebhookConfig.Timeouts`.
+	Timeouts *WebhookTimeoutsConfig `json:"timeouts,omitempty"`
+}
+
+// type WebhookTimeoutsConfig is a struct containing configuration information for limiting the amount of time spent processing
+// webhook messages. Each timeout is a `time.Duration` string. If empty there is no timeout.
+type WebhookTimeoutsConfig struct {
+	// Pipeline is the optional maximum amount of time to transform and dispatch a message.
+	Pipeline string `json:"pipeline,omitempty"`
+	// Transformation is the optional maximum amount of time for each transformation to transform a message.
+	Transformation string `json:"transformation,omitempty"`
+	// Dispatcher is the optional maximum amount of time for each dispatcher to relay a message, including any retries.
+	Dispatcher string `json:"dispatcher,omitempty"`
+}
+
+// type WebhookRouteConfig is a struct containing configuration information for relaying the messages that match a set of
+// predicates to a list of dispatchers.
+type WebhookRouteConfig struct {
+	// Name is the unique name of the route. It is included in webhook responses and used to label metrics and logs.
+	Name string `json:"name"`
+	// Match is the `WebhookRouteMatchConfig` a message must match in order to be relayed to `Dispatchers`.
+	Match *WebhookRouteMatchConfig `json:"match"`
+	// Dispatchers is a list of dispatcher labels configured in `WebhookConfig.Dispatchers` that messages matching the route
+	// are relayed to instead of `WebhookWebhooksConfig.Dispatchers`.
+	Dispatchers []string `json:"dispatchers"`
+}
+
+// type WebhookRouteMatchConfig is a struct containing the predicates for a route. Each predicate is a regular expression and
+// a message matches the route only if it matches every predicate that is defined.
+type WebhookRouteMatchConfig struct {
+	// Body is an optional regular expression matched against the body of the message after it has been transformed.
+	Body string `json:"body,omitempty"`
+	// Repository is an optional regular expression matched against the name of the repository (the `repository.full_name`
+	// property, or `project.path_with_namespace` for GitLab) of the message, as it was received.
+	Repository string `json:"repository,omitempty"`
+	// Event is an optional regular expression matched against the event type of the message, for example the value of the
+	// `X-GitHub-Event` header.
+	Event string `json:"event,omitempty"`
+	// Headers is an optional dictionary mapping request header names to regular expressions matched against their values.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// type WebhookRateLimitConfig is a struct containing configuration information for limiting the rate of messages for a webhook
+// using token buckets.
+type WebhookRateLimitConfig struct {
+	// Requests is the number of messages allowed per `Interval`.
+	Requests int `json:"requests"`
+	// Interval is an optional `time.Duration` string for the interval over which `Requests` messages are allowed. Default is "1m".
+	Interval string `json:"interval,omitempty"`
+	// Burst is the optional maximum number of messages allowed at once. Default is the value of `Requests`.
+	Burst int `json:"burst,omitempty"`
+	// Key is an optional string used to derive a separate rate limit for each message. Valid options are: "repository" (the
+	// `repository.full_name`, or GitLab `project.path_with_namespace`, property of the message body) and "header:{NAME}" (the value of the {NAME} request header). If empty
+	// all messages share the same rate limit.
+	Key string `json:"key,omitempty"`
+	// Overflow is an optional dispatcher label configured in `WebhookConfig.Dispatchers`. Messages that exceed the rate limit are
+	// transformed and relayed to this dispatcher instead of the webhook's dispatchers. If empty these messages receive a
+	// `429 Too Many Requests` response.
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// type WebhookQueueConfig is a struct containing configuration information for the durable queue used to store asynchronous
+// webhook messages until they have been processed.
+type WebhookQueueConfig struct {
+	// URI is a valid and registered `queue.Queue` URI. For example `file:///usr/local/webhookd/queue`.
+	URI string `json:"uri"`
+	// Workers is the maximum number of queued messages that will be processed at the same time. Default is 4.
+	Workers int `json:"workers,omitempty"`
+	// PollInterval is a `time.Duration` string for the amount of time between checks for queued messages that could not
+	// be handed off to a worker when they were queued, for example because every worker was busy. Default is "30s".
+	PollInterval string `json:"poll_interval,omitempty"`
+}
+
+// type WebhookHealthConfig is a struct containing configuration information for the liveness ("/healthz") and
+// readiness ("/readyz") endpoints.
+type WebhookHealthConfig struct {
+	// Prefix is an optional path prefix for the liveness and readiness endpoints. For example if prefix is "/_webhookd"
+	// then the endpoints will be "/_webhookd/healthz" and "/_webhookd/readyz".
+	Prefix string `json:"prefix,omitempty"`
+	// ProbeDispatchers is an optional boolean flag signaling that the readiness endpoint should probe each of the dispatchers
+	// (as well as the queue and dead letter store) used by the webhookd instance.
+	ProbeDispatchers bool `json:"probe_dispatchers,omitempty"`
+	// Timeout is the maximum number of seconds to wait for an individual probe to complete. Default is 5.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// type WebhookMetricsConfig is a struct containing configuration information for the (Prometheus) metrics endpoint.
+type WebhookMetricsConfig struct {
+	// Path is an optional relative URI for the metrics endpoint. Default is "/metrics".
+	Path string `json:"path,omitempty"`
+}
+
+// type WebhookIdempotencyConfig is a struct containing configuration information for detecting duplicate webhook messages.
+type WebhookIdempotencyConfig struct {
+	// Store is an optional valid and registered `idempotency.Store` URI where the keys of messages that have been seen are recorded.
+	// Default is "mem://".
+	Store string `json:"store,omitempty"`
+	// TTL is an optional `time.Duration` string for the amount of time a message is remembered. Default is "24h".
+	TTL string `json:"ttl,omitempty"`
+	// Key is an optional string used to identify duplicate messages. Valid options are: "delivery" (the value of the
+	// `X-GitHub-Delivery` header, or another header listed in `envelope.DELIVERY_HEADERS`), "header:{NAME}" (the value
+	// of the {NAME} request header), "body" (the SHA-256 hash of the received message body) and "none". Default is "delivery".
+	Key string `json:"key,omitempty"`
+}
+
+// type WebhookAdminConfig is a struct containing configuration information for the admin API.
+type WebhookAdminConfig struct {
+	// Prefix is an optional path prefix for the admin API endpoints. Default is "/_admin".
+	Prefix string `json:"prefix,omitempty"`
+	// Token is the secret that clients must pass in an "Authorization: Bearer {TOKEN}" header to access the admin API.
+	Token string `json:"token"`
+	// History is the optional number of delivery outcomes to remember for each webhook endpoint. Default is 50.
+	History int `json:"history,omitempty"`
+}
+
+// type WebhookAuditConfig is a struct containing configuration information for the audit log of every webhook delivery.
+type WebhookAuditConfig struct {
+	// URI is a valid and registered `audit.Sink` URI where audit records are written. For example
+	// `s3blob://{BUCKET}?region={REGION}&prefix=audit/&credentials=session`.
+	URI string `json:"uri"`
+	// CapturePayload is an optional boolean flag signaling that the body of each message, as it was received, should be
+	// included in its audit record.
+	CapturePayload bool `json:"capture_payload,omitempty"`
+	// MaxPayloadSize is the optional maximum number of bytes of each message body to include in its audit record. Default is 65536.
+	MaxPayloadSize int `json:"max_payload_size,omitempty"`
+}
+
+// type WebhookLimitsConfig is a struct containing configuration information for the default and global limits applied to webhook requests.
+type WebhookLimitsConfig struct {
+	// MaxBodySize is the optional default maximum size, in bytes, of a webhook request body for webhooks that do not define their own.
+	// If zero there is no limit.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	// MaxConcurrent is the optional maximum number of requests, across all webhooks, that may be processed at the same time. If zero there is no limit.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// type WebhookTracingConfig is a struct containing configuration information for exporting (OpenTelemetry) traces.
+type WebhookTracingConfig struct {
+	// Exporter is a valid and registered `tracing.Exporter` URI. For example `stdout://`, `file:///usr/local/webhookd/spans.jsonl` or `otlphttp://localhost:4318`.
+	// Default is `null://` which discards spans.
+	Exporter string `json:"exporter,omitempty"`
+	// ServiceName is an optional name of the service reported with exported spans. Default is "webhookd".
+	ServiceName string `json:"service_name,omitempty"`
+}
+
+// NewConfigFromURI returns a new `WebhookConfig` instance derived from 'uri' which is expected to take the form of
+// a valid `gocloud.dev/runtimevar` URI. The value of that URI is expected to be a JSON-encoded `WebhookConfig` string.
+func NewConfigFromURI(ctx context.Context, uri string) (*WebhookConfig, error) {
+
+	str_cfg, err := runtimevar.StringVar(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open config URI, %w", err)
+	}
+
+	cfg_fh := strings.NewReader(str_cfg)
+
+	return NewConfigFromReader(ctx, cfg_fh)
+}
+
+// NewConfigFromReader returns a new `WebhookConfig` instance derived from 'r'.The body of 'r' is expected to be a JSON-encoded `WebhookConfig`
+// string.
+func NewConfigFromReader(ctx context.Context, r io.Reader) (*WebhookConfig, error) {
+
+	var cfg *WebhookConfig
+
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode config, %w", err)
+	}
+
+	return cfg, nil
+}
+
+// GetReceiverConfigByName returns the receiver URI for 'name'.
+func (c *WebhookConfig) GetReceiverConfigByName(name string) (string, error) {
+
+	config, ok := c.Receivers[name]
+
+	if !ok {
+		return "", fmt.Errorf("Invalid receiver name '%s'", name)
+	}
+
+	return config, nil
+}
+
+// GetDispatcherConfigByName returns the dispatcher URI for 'name'.
+func (c *WebhookConfig) GetDispatcherConfigByName(name string) (string, error) {
+
+	config, ok := c.Dispatchers[name]
+
+	if !ok {
+		return "", fmt.Errorf("Invalid dispatcher name '%s'", name)
+	}
+
+	return config, nil
+}
+
+// GetTransformationConfigByName returns the transformation URI for 'name'.
+func (c *WebhookConfig) GetTransformationConfigByName(name string) (string, error) {
+
+	config, ok := c.Transformations[name]
+
+	if !ok {
+		return "", fmt.Errorf("Invalid transformations name '%s'", name)
+	}
+
+	return config, nil
+}
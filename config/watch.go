@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aaronland/go-aws-session"
+	gc "gocloud.dev/runtimevar"
+	"gocloud.dev/runtimevar/awsparamstore"
+)
+
+// WatchConfigFunc is a function that is invoked by `WatchConfigFromURI` each time a config changes. If the new value
+// could not be retrieved or decoded then the `WebhookConfig` instance will be nil and the error will be non-nil.
+type WatchConfigFunc func(context.Context, *WebhookConfig, error)
+
+// WatchConfigFromURI watches 'uri', which is expected to take the form of a valid `gocloud.dev/runtimevar` URI, for changes
+// invoking 'cb' with a new `WebhookConfig` instance each time the value changes. 'cb' is also invoked with the current value
+// when the method is first called. This method blocks until 'ctx' is cancelled.
+func WatchConfigFromURI(ctx context.Context, uri string, cb WatchConfigFunc) error {
+
+	v, err := openVariable(ctx, uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to open config URI, %w", err)
+	}
+
+	defer v.Close()
+
+	for {
+
+		snapshot, err := v.Watch(ctx)
+
+		if err != nil {
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			cb(ctx, nil, fmt.Errorf("Failed to watch config URI, %w", err))
+			continue
+		}
+
+		str_cfg, ok := snapshot.Value.(string)
+
+		if !ok {
+			cb(ctx, nil, fmt.Errorf("Invalid config value, expected string but got %T", snapshot.Value))
+			continue
+		}
+
+		cfg, err := NewConfigFromReader(ctx, strings.NewReader(str_cfg))
+		cb(ctx, cfg, err)
+	}
+}
+
+// openVariable returns a new `gocloud.dev/runtimevar.Variable` instance for 'uri'. It mirrors the way that
+// `sfomuseum/runtimevar.StringVar` opens variables, including support for explicit AWS credentials when using
+// the `awsparamstore://` scheme, so that any URI that can be read by `NewConfigFromURI` can also be watched.
+func openVariable(ctx context.Context, uri string) (*gc.Variable, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	if q.Get("decoder") == "" {
+		q.Set("decoder", "string")
+		u.RawQuery = q.Encode()
+	}
+
+	if u.Scheme == "awsparamstore" {
+
+		creds := q.Get("credentials")
+		region := q.Get("region")
+
+		if creds != "" {
+
+			dsn_str := fmt.Sprintf("region=%s credentials=%s", region, creds)
+			sess, err := session.NewSessionWithDSN(dsn_str)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create new AWS session, %w", err)
+			}
+
+			return awsparamstore.OpenVariable(sess, u.Host, gc.StringDecoder, nil)
+		}
+	}
+
+	return gc.OpenVariable(ctx, u.String())
+}
@@ -0,0 +1,462 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-webhookd/v3/receiver"
+	"github.com/whosonfirst/go-webhookd/v3/transformation"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+)
+
+// DEFAULT_ADMIN_PREFIX is the default path prefix for the admin API endpoints.
+const DEFAULT_ADMIN_PREFIX string = "/_admin"
+
+// REDACTED is the value used to replace secrets in URIs returned by the admin API.
+const REDACTED string = "REDACTED"
+
+// SECRET_PARAMETERS is the list of substrings that, if present in the (lower-cased) name of a URI query parameter,
+// cause its value to be redacted in URIs returned by the admin API.
+var SECRET_PARAMETERS = []string{
+	"secret",
+	"token",
+	"password",
+	"passwd",
+	"key",
+	"signature",
+	"auth",
+	"credentials",
+}
+
+// DSN_PARAMETERS is the list of (lower-cased) names of URI query parameters whose values are DSN strings, for example
+// the "region={REGION} credentials={CREDENTIALS}" strings used to create AWS sessions, whose secrets are redacted
+// in URIs returned by the admin API.
+var DSN_PARAMETERS = []string{
+	"dsn",
+}
+
+// type AdminComponent is a struct containing the label and (redacted) URI of a receiver, transformation or dispatcher.
+type AdminComponent struct {
+	// Label is the label of the component in the webhookd config.
+	Label string `json:"label"`
+	// URI is the URI used to create the component, with any secrets redacted.
+	URI string `json:"uri,omitempty"`
+}
+
+// type AdminWebhook is a struct containing information about a webhook loaded by a `WebhookDaemon` instance.
+type AdminWebhook struct {
+	// Endpoint is the relative URI of the webhook.
+	Endpoint string `json:"endpoint"`
+	// Receiver is the receiver for the webhook.
+	Receiver *AdminComponent `json:"receiver,omitempty"`
+	// Transformations is the list of transformations for the webhook.
+	Transformations []*AdminComponent `json:"transformations"`
+	// Dispatchers is the list of dispatchers for the webhook.
+	Dispatchers []*AdminComponent `json:"dispatchers"`
+	// Overflow is the overflow dispatcher for messages that exceed the webhook's rate limit, if any.
+	Overflow *AdminComponent `json:"overflow,omitempty"`
+	// Routes is the list of routes used to select the dispatchers for each message, if any.
+	Routes []*AdminRoute `json:"routes,omitempty"`
+	// Async is a boolean flag signaling that the webhook's messages are processed asynchronously.
+	Async bool `json:"async,omitempty"`
+	// StatusPolicy is the status policy used to derive HTTP status codes from dispatcher outcomes.
+	StatusPolicy string `json:"status_policy"`
+}
+
+// type AdminRoute is a struct containing information about a route used to select the dispatchers for a webhook's messages.
+type AdminRoute struct {
+	// Name is the name of the route.
+	Name string `json:"name"`
+	// Dispatchers is the list of dispatchers for messages that match the route.
+	Dispatchers []*AdminComponent `json:"dispatchers"`
+}
+
+// type AdminSchemes is a struct containing the lists of registered receiver, transformation and dispatcher schemes.
+type AdminSchemes struct {
+	// Receivers is the list of registered receiver schemes.
+	Receivers []string `json:"receivers"`
+	// Transformations is the list of registered transformation schemes.
+	Transformations []string `json:"transformations"`
+	// Dispatchers is the list of registered dispatcher schemes.
+	Dispatchers []string `json:"dispatchers"`
+}
+
+// SetAdminFromConfig() assigns the path prefix, authentication token and history size for the admin API from 'cfg'.
+func (d *WebhookDaemon) SetAdminFromConfig(cfg *config.WebhookConfig) error {
+
+	if cfg.Admin == nil {
+		return nil
+	}
+
+	if cfg.Admin.Token == "" {
+		return fmt.Errorf("Admin API requires a token")
+	}
+
+	prefix := DEFAULT_ADMIN_PREFIX
+
+	if cfg.Admin.Prefix != "" {
+
+		if !strings.HasPrefix(cfg.Admin.Prefix, "/") {
+			return fmt.Errorf("Invalid admin prefix '%s', must start with '/'", cfg.Admin.Prefix)
+		}
+
+		prefix = path.Clean(cfg.Admin.Prefix)
+	}
+
+	if prefix == "/" {
+		return fmt.Errorf("Invalid admin prefix '%s'", cfg.Admin.Prefix)
+	}
+
+	for _, p := range []string{d.HealthzPath(), d.ReadyzPath(), d.MetricsPath()} {
+
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return fmt.Errorf("Admin prefix '%s' conflicts with '%s'", prefix, p)
+		}
+	}
+
+	if cfg.Admin.History < 0 {
+		return fmt.Errorf("Invalid admin history size, %d", cfg.Admin.History)
+	}
+
+	size := DEFAULT_HISTORY_SIZE
+
+	if cfg.Admin.History > 0 {
+		size = cfg.Admin.History
+	}
+
+	d.admin_prefix = prefix
+	d.admin_token = cfg.Admin.Token
+	d.history = newDeliveryHistory(size)
+
+	return nil
+}
+
+// AdminPrefix() returns the path prefix for the admin API endpoints for 'd'. If the admin API is not enabled the
+// empty string is returned.
+func (d *WebhookDaemon) AdminPrefix() string {
+	return d.admin_prefix
+}
+
+// AdminHandler() returns a `http.Handler` for the admin API for 'd'. All requests must include an "Authorization: Bearer {TOKEN}"
+// header. The following endpoints, relative to `AdminPrefix()`, are supported:
+// * `/webhooks` The list of webhooks and their receivers, transformations and dispatchers, with secrets redacted.
+// * `/schemes` The lists of registered receiver, transformation and dispatcher schemes.
+// * `/deliveries` The most recent delivery outcomes for each endpoint. Results can be limited to a single endpoint using the `?endpoint=` parameter.
+func (d *WebhookDaemon) AdminHandler() (http.Handler, error) {
+
+	if d.admin_prefix == "" {
+		return nil, fmt.Errorf("Admin API is not enabled")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path.Join(d.admin_prefix, "webhooks"), d.adminWebhooksHandlerFunc)
+	mux.HandleFunc(path.Join(d.admin_prefix, "schemes"), d.adminSchemesHandlerFunc)
+	mux.HandleFunc(path.Join(d.admin_prefix, "deliveries"), d.adminDeliveriesHandlerFunc)
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if !d.isAdmin(req) {
+			rsp.Header().Set("WWW-Authenticate", `Bearer realm="webhookd"`)
+			http.Error(rsp, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if req.Method != http.MethodGet {
+			http.Error(rsp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		mux.ServeHTTP(rsp, req)
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// isAdmin() returns a boolean value indicating whether 'req' contains the admin token for 'd'.
+func (d *WebhookDaemon) isAdmin(req *http.Request) bool {
+
+	auth := req.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(d.admin_token)) == 1
+}
+
+func (d *WebhookDaemon) adminWebhooksHandlerFunc(rsp http.ResponseWriter, req *http.Request) {
+
+	d.mu.RLock()
+	cfg := d.config
+	hooks := d.hooks
+	d.mu.RUnlock()
+
+	webhooks := make([]*AdminWebhook, 0, len(hooks))
+
+	for _, entry := range hooks {
+
+		wh := &AdminWebhook{
+			Endpoint:        entry.redacted,
+			Transformations: make([]*AdminComponent, 0),
+			Dispatchers:     make([]*AdminComponent, 0),
+			Async:           entry.async,
+			StatusPolicy:    entry.policy,
+		}
+
+		if entry.receiver != "" {
+			wh.Receiver = adminComponent(cfg, cfg.Receivers, entry.receiver)
+		}
+
+		for _, label := range entry.transformations {
+			wh.Transformations = append(wh.Transformations, adminComponent(cfg, cfg.Transformations, label))
+		}
+
+		for _, label := range entry.dispatchers {
+			wh.Dispatchers = append(wh.Dispatchers, adminComponent(cfg, cfg.Dispatchers, label))
+		}
+
+		if entry.overflow != nil && len(entry.overflow.dispatchers) > 0 {
+			wh.Overflow = adminComponent(cfg, cfg.Dispatchers, entry.overflow.dispatchers[0])
+		}
+
+		for _, r := range entry.routes {
+
+			ar := &AdminRoute{
+				Name:        r.name,
+				Dispatchers: make([]*AdminComponent, 0),
+			}
+
+			for _, label := range r.entry.dispatchers {
+				ar.Dispatchers = append(ar.Dispatchers, adminComponent(cfg, cfg.Dispatchers, label))
+			}
+
+			wh.Routes = append(wh.Routes, ar)
+		}
+
+		webhooks = append(webhooks, wh)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Endpoint < webhooks[j].Endpoint
+	})
+
+	writeJSON(rsp, webhooks)
+}
+
+func (d *WebhookDaemon) adminSchemesHandlerFunc(rsp http.ResponseWriter, req *http.Request) {
+
+	s := &AdminSchemes{
+		Receivers:       receiver.Schemes(),
+		Transformations: transformation.Schemes(),
+		Dispatchers:     dispatcher.Schemes(),
+	}
+
+	writeJSON(rsp, s)
+}
+
+func (d *WebhookDaemon) adminDeliveriesHandlerFunc(rsp http.ResponseWriter, req *http.Request) {
+
+	deliveries := make(map[string][]*DeliveryRecord)
+
+	endpoint := req.URL.Query().Get("endpoint")
+
+	if endpoint != "" {
+		deliveries[endpoint] = d.history.list(endpoint)
+	} else {
+
+		for _, e := range d.history.endpoints() {
+			deliveries[e] = d.history.list(e)
+		}
+	}
+
+	writeJSON(rsp, deliveries)
+}
+
+// recordDelivery() adds 'r' to the delivery history for 'd', if the admin API is enabled.
+func (d *WebhookDaemon) recordDelivery(r *DeliveryRecord) {
+
+	if d.history == nil {
+		return
+	}
+
+	d.history.add(r)
+}
+
+// adminComponent() returns a new `AdminComponent` for 'label' whose URI is derived from 'uris' with any secrets redacted.
+// 'cfg' may be nil, for example if webhooks were added programmatically.
+func adminComponent(cfg *config.WebhookConfig, uris map[string]string, label string) *AdminComponent {
+
+	c := &AdminComponent{
+		Label: label,
+	}
+
+	if cfg != nil {
+		c.URI = redactURI(uris[label])
+	}
+
+	return c
+}
+
+// type EndpointRedactor is an optional interface for `webhookd.WebhookReceiver` implementations whose webhook endpoints
+// contain secrets, for example the `bitbucket://` receiver's `?path_secret=` parameter.
+type EndpointRedactor interface {
+	// RedactEndpoint() returns a copy of 'endpoint' with any secrets replaced.
+	RedactEndpoint(string) string
+}
+
+// redactEndpoint() returns 'endpoint' with any secrets replaced if the receiver for 'wh' implements the `EndpointRedactor`
+// interface. The redacted endpoint is used in log messages, metrics, traces, audit records, delivery outcomes and the admin API.
+func redactEndpoint(wh webhookd.WebhookHandler, endpoint string) string {
+
+	r, ok := wh.Receiver().(EndpointRedactor)
+
+	if !ok {
+		return endpoint
+	}
+
+	return r.RedactEndpoint(endpoint)
+}
+
+// redactURI() returns a copy of 'uri' with any passwords and the values of any query parameters whose names
+// contain one of the strings in `SECRET_PARAMETERS` replaced by `REDACTED`. Query parameters whose values are
+// themselves URIs, for example the `retry://` dispatcher's `?dispatcher=` parameter, are redacted recursively and
+// the values of query parameters listed in `DSN_PARAMETERS` are redacted using `redactDSN`. If 'uri' can not be
+// parsed then `REDACTED` is returned.
+func redactURI(uri string) string {
+
+	if uri == "" {
+		return ""
+	}
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return REDACTED
+	}
+
+	changed := false
+
+	if u.User != nil {
+
+		_, has_password := u.User.Password()
+
+		if has_password {
+			u.User = url.UserPassword(u.User.Username(), REDACTED)
+			changed = true
+		}
+	}
+
+	q := u.Query()
+
+	for k, values := range q {
+
+		secret := isSecretParameter(k)
+		is_dsn := isDSNParameter(k)
+
+		for i, v := range values {
+
+			redacted := v
+
+			switch {
+			case secret:
+				redacted = REDACTED
+			case is_dsn:
+				redacted = redactDSN(v)
+			case strings.Contains(v, "://"):
+				redacted = redactURI(v)
+			}
+
+			if redacted != v {
+				values[i] = redacted
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return uri
+	}
+
+	u.RawQuery = q.Encode()
+	redacted := u.String()
+
+	// Preserve the "//" in URIs with an empty host, for example "log://", which `url.URL.String()` removes
+
+	if strings.HasPrefix(uri, u.Scheme+"://") && !strings.HasPrefix(redacted, u.Scheme+"://") {
+		redacted = u.Scheme + "://" + strings.TrimPrefix(redacted, u.Scheme+":")
+	}
+
+	return redacted
+}
+
+// isSecretParameter() returns a boolean value indicating whether the query parameter 'name' is likely to contain a secret.
+func isSecretParameter(name string) bool {
+
+	name = strings.ToLower(name)
+
+	for _, s := range SECRET_PARAMETERS {
+
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDSNParameter() returns a boolean value indicating whether the query parameter 'name' contains a DSN string.
+func isDSNParameter(name string) bool {
+
+	name = strings.ToLower(name)
+
+	for _, s := range DSN_PARAMETERS {
+
+		if name == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactDSN() returns a copy of the DSN string 'dsn', a space-separated list of "{KEY}={VALUE}" pairs, with the values
+// of any keys whose names contain one of the strings in `SECRET_PARAMETERS` replaced by `REDACTED`. If 'dsn' can not
+// be parsed then `REDACTED` is returned.
+func redactDSN(dsn string) string {
+
+	pairs := strings.Split(strings.Trim(dsn, " "), " ")
+
+	for i, str_pair := range pairs {
+
+		k, _, ok := strings.Cut(str_pair, "=")
+
+		if !ok {
+			return REDACTED
+		}
+
+		if isSecretParameter(k) {
+			pairs[i] = fmt.Sprintf("%s=%s", k, REDACTED)
+		}
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func writeJSON(rsp http.ResponseWriter, v interface{}) {
+
+	rsp.Header().Set("Content-Type", "application/json")
+	rsp.Header().Set("Cache-Control", "no-store")
+
+	enc := json.NewEncoder(rsp)
+	enc.Encode(v)
+}
@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/audit"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+)
+
+// DEFAULT_AUDIT_MAX_PAYLOAD_SIZE is the default maximum number of bytes of each message body to include in its audit record.
+const DEFAULT_AUDIT_MAX_PAYLOAD_SIZE int = 64 * 1024
+
+// SetAuditFromConfig() assigns the sink where the audit record for each webhook delivery is written from 'cfg'.
+func (d *WebhookDaemon) SetAuditFromConfig(ctx context.Context, cfg *config.WebhookConfig) error {
+
+	if cfg.Audit == nil {
+		return nil
+	}
+
+	if cfg.Audit.URI == "" {
+		return fmt.Errorf("Missing audit URI")
+	}
+
+	if cfg.Audit.MaxPayloadSize < 0 {
+		return fmt.Errorf("Invalid audit max payload size, %d", cfg.Audit.MaxPayloadSize)
+	}
+
+	s, err := audit.NewSink(ctx, cfg.Audit.URI)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create audit sink, %w", err)
+	}
+
+	max_payload := 0
+
+	if cfg.Audit.CapturePayload {
+
+		max_payload = DEFAULT_AUDIT_MAX_PAYLOAD_SIZE
+
+		if cfg.Audit.MaxPayloadSize > 0 {
+			max_payload = cfg.Audit.MaxPayloadSize
+		}
+	}
+
+	d.SetAuditSink(s, max_payload)
+	return nil
+}
+
+// SetAuditSink() assigns 's' as the sink where the audit record for each webhook delivery is written. If 'max_payload'
+// is greater than zero then up to that many bytes of each message body are included in its audit record.
+func (d *WebhookDaemon) SetAuditSink(s audit.Sink, max_payload int) {
+	d.audit = s
+	d.audit_payloads = max_payload
+}
+
+// capturePayload() assigns 'body' to the payload of 'ar', truncated to the maximum payload size, if payloads are captured.
+func (d *WebhookDaemon) capturePayload(ar *audit.Record, body []byte) {
+
+	if d.audit == nil || d.audit_payloads <= 0 {
+		return
+	}
+
+	if len(body) > d.audit_payloads {
+		body = body[:d.audit_payloads]
+		ar.PayloadTruncated = true
+	}
+
+	ar.Payload = string(body)
+}
+
+// writeAudit() writes 'ar' to the audit sink for 'd', if present.
+func (d *WebhookDaemon) writeAudit(logger *slog.Logger, ar *audit.Record) {
+
+	if d.audit == nil {
+		return
+	}
+
+	// Use a new context since the request may already have been cancelled.
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := d.audit.Write(ctx, ar)
+
+	if err != nil {
+		logger.Error("Failed to write audit record", logging.ERROR_KEY, err)
+	}
+}
+
+// auditDispatchers() returns the list of `audit.DispatcherResult` instances derived from 'r'.
+func auditDispatchers(r *WebhookResult) []*audit.DispatcherResult {
+
+	results := make([]*audit.DispatcherResult, len(r.Dispatchers))
+
+	for idx, dr := range r.Dispatchers {
+
+		results[idx] = &audit.DispatcherResult{
+			Label:    dr.Dispatcher,
+			Status:   dr.Status,
+			Code:     dr.Code,
+			Error:    dr.Error,
+			Duration: audit.Milliseconds(dr.elapsed),
+		}
+	}
+
+	return results
+}
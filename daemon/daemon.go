@@ -0,0 +1,1701 @@
+// Package daemon provides methods for implementing a long-running daemon to listen for and process webhooks.
+// It is derived from the `whosonfirst/go-webhookd/v3/daemon` package and adds Who's On First specific
+// functionality like asynchronous webhooks.
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aaronland/go-http-server"
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-webhookd/v3/receiver"
+	"github.com/whosonfirst/go-webhookd/v3/transformation"
+	"github.com/whosonfirst/go-webhookd/v3/webhook"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/audit"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/deadletter"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/delivery"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/envelope"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/health"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/idempotency"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/queue"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/tracing"
+)
+
+// DEFAULT_WORKERS is the default number of workers used to process asynchronous webhooks.
+const DEFAULT_WORKERS int = 4
+
+// type WebhookDaemon is a struct that implements a long-running daemon to listen for	and process webhooks.
+type WebhookDaemon struct {
+	// server is a `aaronland/go-http-server.Server` instance that handles HTTP requests and responses.
+	server server.Server
+	// hooks is a dictionary of URIs and their corresponding `webhookEntry` instances. It is replaced, rather than modified, when webhooks are reloaded.
+	hooks map[string]*webhookEntry
+	// hooks_active tracks the requests, and queued messages, that are being processed using 'hooks'. It is replaced when
+	// webhooks are reloaded so that the webhooks being replaced can be closed once they are no longer in use.
+	hooks_active *sync.WaitGroup
+	// config is the `config.WebhookConfig` instance that 'd' was created, or last reloaded, from.
+	config *config.WebhookConfig
+	// mu is used to synchronize access to 'hooks', 'hooks_active', 'probes' and 'config'.
+	mu *sync.RWMutex
+	// queue is the `queue.Queue` instance where asynchronous webhook messages are stored until they are processed.
+	queue queue.Queue
+	// workers is the maximum number of asynchronous webhook messages that will be processed at the same time.
+	workers int
+	// messages is the (buffered) channel used to hand off asynchronous webhook messages to workers.
+	messages chan *queue.Message
+	// scheduled tracks the asynchronous webhook messages that have been handed off to workers.
+	scheduled *scheduledMessages
+	// poll_interval is the amount of time between checks for queued messages that have not been handed off to workers.
+	poll_interval time.Duration
+	// deadletters is the `deadletter.Store` instance where messages that could not be dispatched are stored.
+	deadletters deadletter.Store
+	// health_prefix is the path prefix for the liveness and readiness endpoints.
+	health_prefix string
+	// probes is a dictionary of component names and their corresponding `health.Probe` instances used by the readiness endpoint.
+	probes map[string]health.Probe
+	// probe_timeout is the maximum amount of time to wait for an individual readiness probe to complete.
+	probe_timeout time.Duration
+	// metrics is the set of metrics recorded for webhook requests and dispatchers.
+	metrics *daemonMetrics
+	// metrics_path is the relative URI of the metrics endpoint.
+	metrics_path string
+	// tracer is the `tracing.Tracer` instance used to record spans for each stage of the webhook pipeline.
+	tracer *tracing.Tracer
+	// inflight is used to track the webhook messages that are currently being processed.
+	inflight *inflightDeliveries
+	// stopping is closed when 'd' starts shutting down.
+	stopping chan bool
+	// stop_once ensures that 'stopping' is only closed once.
+	stop_once *sync.Once
+	// abandon is closed when 'd' stops waiting for in-flight webhook messages to finish processing.
+	abandon chan bool
+	// shutdown_timeout is the amount of time to wait for in-flight webhook messages to finish processing when 'd' is shut down.
+	shutdown_timeout time.Duration
+	// concurrent limits the number of webhook requests, across all webhooks, that are processed at the same time.
+	concurrent semaphore
+	// seen is the `idempotency.Store` instance used to record which webhook messages have been seen.
+	seen idempotency.Store
+	// seen_ttl is the amount of time a webhook message is remembered in 'seen'.
+	seen_ttl time.Duration
+	// admin_prefix is the path prefix for the admin API endpoints. If empty the admin API is disabled.
+	admin_prefix string
+	// admin_token is the secret required to access the admin API.
+	admin_token string
+	// history is the record of recent delivery outcomes returned by the admin API.
+	history *deliveryHistory
+	// audit is the `audit.Sink` instance where the audit record for each webhook delivery is written.
+	audit audit.Sink
+	// audit_payloads is the maximum number of bytes of each message body to include in its audit record. If zero payloads are not captured.
+	audit_payloads int
+	// structured is the optional `slog.Logger` instance used to log messages. Loggers for each webhook request and queued message,
+	// that include request ID and endpoint attributes in every log message, are derived from it.
+	structured *slog.Logger
+	// AllowDebug is a boolean flag to enable debugging reporting in webhook responses.
+	AllowDebug bool
+}
+
+// NewWebhookDaemonFromConfig() returns a new `WebhookDaemon` derived from configuration data in 'cfg'.
+func NewWebhookDaemonFromConfig(ctx context.Context, cfg *config.WebhookConfig) (*WebhookDaemon, error) {
+
+	d, err := NewWebhookDaemon(ctx, cfg.Daemon)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new webhookd daemon, %w", err)
+	}
+
+	if cfg.Queue != nil {
+
+		err := d.SetQueueFromConfig(ctx, cfg.Queue)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to set queue for daemon, %w", err)
+		}
+	}
+
+	if cfg.DeadLetter != "" {
+
+		s, err := deadletter.NewStore(ctx, cfg.DeadLetter)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create dead letter store, %w", err)
+		}
+
+		d.SetDeadLetterStore(s)
+	}
+
+	err = d.SetHealthFromConfig(ctx, cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set health checks for daemon, %w", err)
+	}
+
+	err = d.SetMetricsFromConfig(cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set metrics for daemon, %w", err)
+	}
+
+	err = d.SetAuditFromConfig(ctx, cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set audit log for daemon, %w", err)
+	}
+
+	err = d.SetAdminFromConfig(cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set admin API for daemon, %w", err)
+	}
+
+	err = d.SetTracingFromConfig(ctx, cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set tracing for daemon, %w", err)
+	}
+
+	err = d.SetIdempotencyFromConfig(ctx, cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set idempotency for daemon, %w", err)
+	}
+
+	err = d.SetLimitsFromConfig(cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set limits for daemon, %w", err)
+	}
+
+	if cfg.ShutdownTimeout < 0 {
+		return nil, fmt.Errorf("Invalid shutdown timeout, %d", cfg.ShutdownTimeout)
+	}
+
+	if cfg.ShutdownTimeout > 0 {
+		d.SetShutdownTimeout(time.Duration(cfg.ShutdownTimeout) * time.Second)
+	}
+
+	err = d.AddWebhooksFromConfig(ctx, cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to add webhooks to daemon, %w", err)
+	}
+
+	d.config = cfg
+	return d, nil
+}
+
+// NewWebhookDaemon() returns a `WebhookDaemon` instance derived from 'uri' which is expected to take
+// the form of any valid `aaronland/go-http-server.Server` URI with the following parameters:
+// * `?allow_debug=` An optional boolean flag to enable debugging output in webhook responses.
+func NewWebhookDaemon(ctx context.Context, uri string) (*WebhookDaemon, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse daemon URI, %w", err)
+	}
+
+	q := u.Query()
+
+	str_debug := q.Get("allow_debug")
+
+	allow_debug := false
+
+	if str_debug != "" {
+
+		v, err := strconv.ParseBool(str_debug)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?allow_debug parameter, %w", err)
+		}
+
+		allow_debug = v
+	}
+
+	var srv server.Server
+
+	switch u.Scheme {
+	case "http", "https":
+		srv, err = newHTTPServer(ctx, uri)
+	default:
+		srv, err = server.NewServer(ctx, uri)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new server instance, %w", err)
+	}
+
+	tracer, err := tracing.NewTracer(ctx, DEFAULT_TRACING_EXPORTER, "")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new tracer, %w", err)
+	}
+
+	hooks := make(map[string]*webhookEntry)
+	probes := make(map[string]health.Probe)
+
+	d := WebhookDaemon{
+		server:           srv,
+		hooks:            hooks,
+		hooks_active:     new(sync.WaitGroup),
+		mu:               new(sync.RWMutex),
+		probes:           probes,
+		probe_timeout:    DEFAULT_PROBE_TIMEOUT,
+		metrics:          newDaemonMetrics(),
+		metrics_path:     DEFAULT_METRICS_PATH,
+		tracer:           tracer,
+		workers:          DEFAULT_WORKERS,
+		inflight:         newInflightDeliveries(),
+		scheduled:        newScheduledMessages(),
+		poll_interval:    DEFAULT_QUEUE_POLL_INTERVAL,
+		stopping:         make(chan bool),
+		stop_once:        new(sync.Once),
+		abandon:          make(chan bool),
+		shutdown_timeout: DEFAULT_SHUTDOWN_TIMEOUT,
+		AllowDebug:       allow_debug,
+	}
+
+	return &d, nil
+}
+
+// SetQueueFromConfig() assigns the queue used to store asynchronous webhook messages, and the number of workers
+// used to process them, from 'cfg'.
+func (d *WebhookDaemon) SetQueueFromConfig(ctx context.Context, cfg *config.WebhookQueueConfig) error {
+
+	if cfg.URI == "" {
+		return fmt.Errorf("Missing queue URI")
+	}
+
+	q, err := queue.NewQueue(ctx, cfg.URI)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new queue, %w", err)
+	}
+
+	d.queue = q
+
+	if cfg.Workers > 0 {
+		d.workers = cfg.Workers
+	}
+
+	if cfg.PollInterval != "" {
+
+		t, err := time.ParseDuration(cfg.PollInterval)
+
+		if err != nil {
+			return fmt.Errorf("Invalid queue poll interval, %w", err)
+		}
+
+		if t <= 0 {
+			return fmt.Errorf("Invalid queue poll interval, must be greater than zero")
+		}
+
+		d.poll_interval = t
+	}
+
+	return nil
+}
+
+// SetDeadLetterStore() assigns 's' as the store where messages that could not be dispatched will be written.
+func (d *WebhookDaemon) SetDeadLetterStore(s deadletter.Store) {
+	d.deadletters = s
+}
+
+// AddWebhooksFromConfig() appends the webhooks defined in 'cfg' to 'd'.
+func (d *WebhookDaemon) AddWebhooksFromConfig(ctx context.Context, cfg *config.WebhookConfig) error {
+
+	hooks, err := d.hooksFromConfig(ctx, cfg)
+
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for endpoint, h := range hooks {
+
+		err := d.ensureEndpoint(d.hooks, endpoint)
+
+		if err != nil {
+			return fmt.Errorf("Failed to add new webhook for '%s', %w", endpoint, err)
+		}
+
+		d.hooks[endpoint] = h
+	}
+
+	return nil
+}
+
+// hooksFromConfig() returns a dictionary of URIs and their corresponding `webhookEntry` instances for the webhooks defined in 'cfg'.
+func (d *WebhookDaemon) hooksFromConfig(ctx context.Context, cfg *config.WebhookConfig) (map[string]*webhookEntry, error) {
+
+	if len(cfg.Webhooks) == 0 {
+		return nil, fmt.Errorf("No webhooks defined")
+	}
+
+	hooks := make(map[string]*webhookEntry)
+
+	for i, hook := range cfg.Webhooks {
+
+		if hook.Endpoint == "" {
+			return nil, fmt.Errorf("Missing endpoint at offset %d", i+1)
+		}
+
+		if hook.Receiver == "" {
+			return nil, fmt.Errorf("Missing receiver at offset %d", i+1)
+		}
+
+		if len(hook.Dispatchers) == 0 {
+			return nil, fmt.Errorf("Missing dispatchers at offset %d", i+1)
+		}
+
+		if hook.Async && d.queue == nil {
+			return nil, fmt.Errorf("Webhook at offset %d is asynchronous but no queue has been configured", i+1)
+		}
+
+		policy := hook.StatusPolicy
+
+		if policy == "" {
+			policy = DEFAULT_STATUS_POLICY
+		}
+
+		err := ensureStatusPolicy(policy)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid status policy at offset %d, %w", i+1, err)
+		}
+
+		empty_body := hook.EmptyBody
+
+		if empty_body == "" {
+			empty_body = DEFAULT_EMPTY_BODY
+		}
+
+		err = ensureEmptyBody(empty_body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid empty body policy at offset %d, %w", i+1, err)
+		}
+
+		if hook.MaxBodySize < 0 {
+			return nil, fmt.Errorf("Invalid max body size at offset %d, %d", i+1, hook.MaxBodySize)
+		}
+
+		if hook.MaxConcurrent < 0 {
+			return nil, fmt.Errorf("Invalid max concurrent value at offset %d, %d", i+1, hook.MaxConcurrent)
+		}
+
+		idempotency_key, err := idempotencyKeyFromConfig(cfg, hook)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid idempotency key at offset %d, %w", i+1, err)
+		}
+
+		receiver_uri, err := cfg.GetReceiverConfigByName(hook.Receiver)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get receiver config for '%s', %w", hook.Receiver, err)
+		}
+
+		receiver, err := receiver.NewReceiver(ctx, receiver_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to add receiver '%s', %w", receiver_uri, err)
+		}
+
+		var steps []webhookd.WebhookTransformation
+		var step_labels []string
+
+		for _, name := range hook.Transformations {
+
+			if strings.HasPrefix(name, "#") {
+				continue
+			}
+
+			transformation_uri, err := cfg.GetTransformationConfigByName(name)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get transformation configuration for '%s', %w", name, err)
+			}
+
+			step, err := transformation.NewTransformation(ctx, transformation_uri)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create new transformation for '%s', %w", transformation_uri, err)
+			}
+
+			steps = append(steps, step)
+			step_labels = append(step_labels, name)
+		}
+
+		var sendto []webhookd.WebhookDispatcher
+		var labels []string
+
+		for _, name := range hook.Dispatchers {
+
+			if strings.HasPrefix(name, "#") {
+				continue
+			}
+
+			dispatcher_uri, err := cfg.GetDispatcherConfigByName(name)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get dispatcher configuration for '%s', %w", name, err)
+			}
+
+			dispatcher, err := dispatcher.NewDispatcher(ctx, dispatcher_uri)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to create dispatcher for '%s', %w", dispatcher_uri, err)
+			}
+
+			sendto = append(sendto, dispatcher)
+			labels = append(labels, name)
+		}
+
+		wh, err := webhook.NewWebhook(ctx, hook.Endpoint, receiver, steps, sendto)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create new webhook for '%s', %w", hook.Endpoint, err)
+		}
+
+		err = d.ensureEndpoint(hooks, hook.Endpoint)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to add new webhook for '%s', %w", hook.Endpoint, err)
+		}
+
+		redacted := redactEndpoint(wh, hook.Endpoint)
+
+		entry := &webhookEntry{
+			endpoint:        hook.Endpoint,
+			redacted:        redacted,
+			id:              endpointID(hook.Endpoint, redacted),
+			webhook:         wh,
+			receiver:        hook.Receiver,
+			transformations: step_labels,
+			dispatchers:     labels,
+			async:           hook.Async,
+			policy:          policy,
+			empty_body:      empty_body,
+			max_body_size:   maxBodySize(cfg, hook),
+			concurrent:      newSemaphore(hook.MaxConcurrent),
+			idempotency_key: idempotency_key,
+		}
+
+		err = timeoutsFromConfig(cfg, hook, entry)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timeouts for '%s', %w", hook.Endpoint, err)
+		}
+
+		if hook.RateLimit != nil {
+
+			err := rateLimitFromConfig(ctx, cfg, hook.RateLimit, entry)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rate limit for '%s', %w", hook.Endpoint, err)
+			}
+		}
+
+		if len(hook.Routes) > 0 {
+
+			err := routesFromConfig(ctx, cfg, hook.Routes, entry)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid routes for '%s', %w", hook.Endpoint, err)
+			}
+		}
+
+		hooks[hook.Endpoint] = entry
+	}
+
+	return hooks, nil
+}
+
+// AddWebhook() adds 'wh' to 'd'.
+func (d *WebhookDaemon) AddWebhook(ctx context.Context, wh webhook.Webhook) error {
+
+	endpoint := wh.Endpoint()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.ensureEndpoint(d.hooks, endpoint)
+
+	if err != nil {
+		return err
+	}
+
+	redacted := redactEndpoint(wh, endpoint)
+
+	d.hooks[endpoint] = &webhookEntry{
+		endpoint:   endpoint,
+		redacted:   redacted,
+		id:         endpointID(endpoint, redacted),
+		webhook:    wh,
+		policy:     DEFAULT_STATUS_POLICY,
+		empty_body: DEFAULT_EMPTY_BODY,
+	}
+
+	return nil
+}
+
+// ensureEndpoint() returns an error if 'endpoint' is already present in 'hooks' or conflicts with the health or metrics endpoints for 'd'.
+func (d *WebhookDaemon) ensureEndpoint(hooks map[string]*webhookEntry, endpoint string) error {
+
+	_, ok := hooks[endpoint]
+
+	if ok {
+		return fmt.Errorf("endpoint already configured")
+	}
+
+	if endpoint == d.HealthzPath() || endpoint == d.ReadyzPath() {
+		return fmt.Errorf("endpoint conflicts with health endpoints, consider setting a health prefix")
+	}
+
+	if endpoint == d.MetricsPath() {
+		return fmt.Errorf("endpoint conflicts with metrics endpoint, consider setting a metrics path")
+	}
+
+	if d.admin_prefix != "" && (endpoint == d.admin_prefix || strings.HasPrefix(endpoint, d.admin_prefix+"/")) {
+		return fmt.Errorf("endpoint conflicts with admin endpoints, consider setting an admin prefix")
+	}
+
+	return nil
+}
+
+// HandlerFunc() returns a `http.HandlerFunc` that handles HTTP (webhook) requests and response for 'd'.
+func (d *WebhookDaemon) HandlerFunc() (http.HandlerFunc, error) {
+	logger := log.Default()
+	return d.HandlerFuncWithLogger(logger)
+}
+
+// HandlerFuncWithLogger() returns a `http.HandlerFunc` that handles HTTP (webhook) requests and response for 'd'
+// logging events to 'logger'.
+func (d *WebhookDaemon) HandlerFuncWithLogger(logger *log.Logger) (http.HandlerFunc, error) {
+
+	sl := d.slogger(logger)
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		request_id := deliveryID(req)
+		ctx = delivery.WithID(ctx, request_id)
+
+		rsp.Header().Set(REQUEST_ID_HEADER, request_id)
+
+		endpoint := req.URL.Path
+
+		// Look up the webhook once so that the entire request is processed using the same
+		// webhook even if webhooks are reloaded while the request is in flight.
+
+		entry, release, ok := d.acquireWebhookEntry(endpoint)
+
+		if !ok {
+			requestLogger(sl, request_id, endpoint).Warn("Endpoint not found")
+			http.Error(rsp, "404 Not found", http.StatusNotFound)
+			return
+		}
+
+		defer release()
+
+		// The request ID is the same as the delivery ID and is included, along with the redacted endpoint, in every log message
+		// for the request. The redacted endpoint is also used for metrics, traces, audit records and delivery outcomes.
+
+		redacted := entry.redacted
+
+		logger := requestLogger(sl, request_id, redacted)
+
+		done, ok := d.inflight.add(delivery.ID(ctx), redacted)
+
+		if !ok {
+			rsp.Header().Set("Retry-After", "30")
+			http.Error(rsp, "Service is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		defer done()
+
+		// Cancel the request if the daemon stops waiting for it to finish during shutdown
+
+		go func(ctx context.Context) {
+			select {
+			case <-ctx.Done():
+			case <-d.abandon:
+				cancel()
+			}
+		}(ctx)
+
+		traceparent := req.Header.Get(tracing.TRACEPARENT_HEADER)
+
+		if traceparent != "" {
+
+			sc, err := tracing.ParseTraceparent(traceparent)
+
+			if err != nil {
+				logger.Debug("Ignoring invalid traceparent header", logging.ERROR_KEY, err)
+			} else {
+				ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+			}
+		}
+
+		ctx, span := d.tracer.Start(ctx, "webhookd.request", tracing.SPAN_KIND_SERVER)
+
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("webhookd.endpoint", redacted)
+		span.SetAttribute("webhookd.delivery_id", delivery.ID(ctx))
+
+		github_event := req.Header.Get("X-GitHub-Event")
+
+		if github_event != "" {
+			span.SetAttribute("github.event", github_event)
+		}
+
+		d.metrics.in_flight.Inc()
+		defer d.metrics.in_flight.Dec()
+
+		// outcome is updated before each return below and recorded when the handler exits
+
+		outcome := OUTCOME_OK
+
+		// seen_key is the key recorded in the idempotency store for the message, if any
+
+		seen_key := ""
+
+		// results are the dispatcher outcomes for the message, if it was dispatched
+
+		var results *WebhookResult
+
+		received := time.Now()
+
+		// ar is the audit record for the message which is updated as it moves through the pipeline
+
+		ar := &audit.Record{
+			DeliveryID: delivery.ID(ctx),
+			Endpoint:   redacted,
+			EventType:  envelope.EventType(req.Header),
+			Received:   received,
+			Timings:    &audit.Timings{},
+		}
+
+		defer func() {
+
+			d.metrics.requests.Inc(redacted, outcome)
+
+			ar.Outcome = outcome
+
+			if ar.Timings.Process == 0 {
+				ar.Timings.Process = audit.Milliseconds(time.Since(received))
+			}
+
+			d.writeAudit(logger, ar)
+
+			r := &DeliveryRecord{
+				DeliveryID: delivery.ID(ctx),
+				Endpoint:   redacted,
+				Outcome:    outcome,
+				Reason:     ar.Reason,
+				Received:   received,
+				Duration:   fmt.Sprintf("%v", time.Since(received)),
+			}
+
+			if results != nil {
+				r.Route = results.Route
+				r.Dispatchers = results.Dispatchers
+			}
+
+			d.recordDelivery(r)
+
+			// Commit messages that were processed, or durably queued, and forget messages that could not be processed
+			// so that they can be delivered again. If the daemon crashes before either happens the pending record expires.
+
+			switch outcome {
+			case OUTCOME_OK, OUTCOME_HALTED, OUTCOME_EMPTY, OUTCOME_ACCEPTED:
+				d.commitMessage(logger, seen_key)
+			case OUTCOME_FAILED, OUTCOME_REJECTED, OUTCOME_THROTTLED, OUTCOME_TIMEOUT:
+				d.forgetMessage(logger, seen_key)
+			}
+
+			span.SetAttribute("webhookd.outcome", outcome)
+
+			switch outcome {
+			case OUTCOME_FAILED, OUTCOME_REJECTED, OUTCOME_TIMEOUT:
+				span.SetError(outcome)
+			default:
+				span.SetOK()
+			}
+
+			span.End()
+		}()
+
+		// Check the per-webhook limit before the global limit so that a single saturated webhook can not hold
+		// slots in the global limit while it is rejecting requests
+
+		if !entry.concurrent.acquire() {
+			logger.Warn("Too many concurrent requests for endpoint, rejecting delivery")
+			outcome = OUTCOME_THROTTLED
+			saturated(rsp)
+			return
+		}
+
+		defer entry.concurrent.release()
+
+		if !d.concurrent.acquire() {
+			logger.Warn("Too many concurrent requests, rejecting delivery")
+			outcome = OUTCOME_THROTTLED
+			saturated(rsp)
+			return
+		}
+
+		defer d.concurrent.release()
+
+		var limited_body *limitedBody
+
+		if entry.max_body_size > 0 {
+
+			if req.ContentLength > entry.max_body_size {
+				logger.Warn("Request body is too large", "content_length", req.ContentLength)
+				outcome = OUTCOME_REJECTED
+				http.Error(rsp, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			limited_body = &limitedBody{
+				ReadCloser: req.Body,
+				remaining:  entry.max_body_size,
+			}
+
+			req.Body = limited_body
+		}
+
+		t1 := time.Now()
+
+		var ta time.Time
+		var tb time.Duration
+
+		var ttr time.Duration // time to receive
+		var ttt time.Duration // time to transform
+		var ttd time.Duration // time to dispatch
+
+		ta = time.Now()
+
+		rcvr := entry.webhook.Receiver()
+
+		_, receive_span := d.tracer.Start(ctx, "webhookd.receive", tracing.SPAN_KIND_INTERNAL)
+		receive_span.SetAttribute("webhookd.receiver", fmt.Sprintf("%T", rcvr))
+
+		body, err := rcvr.Receive(ctx, req)
+
+		if limited_body != nil && limited_body.exceeded {
+			err = &webhookd.WebhookError{Code: http.StatusRequestEntityTooLarge, Message: "Request body too large"}
+		}
+
+		endSpan(receive_span, err)
+
+		ar.Receiver = &audit.StepResult{
+			Label:    entry.receiver,
+			Size:     len(body),
+			Duration: audit.Milliseconds(time.Since(ta)),
+		}
+
+		if err != nil {
+			ar.Receiver.Code = err.Code
+			ar.Receiver.Error = err.Message
+		}
+
+		d.capturePayload(ar, body)
+
+		// we use -1 to signal that this is an unhandled event but
+		// not an error, for example when github sends a ping message
+		// (20190212/thisisaaronland)
+
+		if err != nil {
+
+			switch err.Code {
+			case webhookd.UnhandledEvent, webhookd.HaltEvent:
+				logger.Info("Receiver step returned non-fatal error and exiting", logging.STAGE_KEY, STAGE_RECEIVE, logging.STEP_KEY, fmt.Sprintf("%T", rcvr), logging.ERROR_KEY, err)
+				outcome = OUTCOME_HALTED
+				ar.Reason = err.Message
+				return
+			default:
+				logger.Error("Receiver step failed", logging.STAGE_KEY, STAGE_RECEIVE, logging.STEP_KEY, fmt.Sprintf("%T", rcvr), logging.ERROR_KEY, err)
+				outcome = OUTCOME_REJECTED
+				http.Error(rsp, err.Error(), err.Code)
+				return
+			}
+		}
+
+		tb = time.Since(ta)
+
+		ttr = tb
+		ar.Timings.Receive = audit.Milliseconds(ttr)
+
+		repo := repositoryName(body)
+
+		if repo != "" {
+			span.SetAttribute("github.repository", repo)
+		}
+		d.metrics.observeStage(redacted, STAGE_RECEIVE, ttr)
+
+		if entry.idempotency_key != "" && d.seen != nil {
+
+			key := idempotencyKey(entry.idempotency_key, req, body)
+
+			if key != "" {
+
+				store_key := fmt.Sprintf("%s#%s", entry.id, key)
+
+				ok, err := d.seen.Add(ctx, store_key, d.pendingTTL(entry))
+
+				switch {
+				case err != nil:
+					logger.Error("Failed to check idempotency store, processing anyway", logging.ERROR_KEY, err)
+				case !ok:
+					logger.Info("Delivery has already been seen, skipping", "idempotency_key", key)
+					span.SetAttribute("webhookd.duplicate", true)
+					outcome = OUTCOME_DUPLICATE
+					rsp.Header().Set("X-Webhookd-Duplicate", "true")
+					rsp.Header().Set("X-Webhookd-Delivery-Id", delivery.ID(ctx))
+					rsp.Header().Set("Content-Type", "text/plain")
+					rsp.WriteHeader(http.StatusOK)
+					rsp.Write([]byte("Duplicate delivery, already processed"))
+					return
+				default:
+					seen_key = store_key
+				}
+			}
+		}
+
+		overflow := false
+
+		if entry.limiter != nil {
+
+			key := rateLimitKey(entry.rate_limit_key, req, body)
+			allowed, wait := entry.limiter.Allow(key)
+
+			if !allowed {
+
+				span.SetAttribute("webhookd.rate_limited", true)
+
+				if entry.overflow == nil {
+					logger.Warn("Delivery exceeded rate limit, rejecting", "rate_limit_key", key)
+					d.metrics.rate_limited.Inc(redacted, RATE_LIMIT_REJECTED)
+					outcome = OUTCOME_THROTTLED
+					rsp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					http.Error(rsp, "Rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
+
+				logger.Warn("Delivery exceeded rate limit, relaying to overflow dispatcher", "rate_limit_key", key)
+				d.metrics.rate_limited.Inc(redacted, RATE_LIMIT_OVERFLOW)
+
+				overflow = true
+				entry = entry.overflow
+
+				rsp.Header().Set("X-Webhookd-Overflow", "true")
+			}
+		}
+
+		if entry.async {
+
+			msg := &queue.Message{
+				ID:             delivery.ID(ctx),
+				Endpoint:       entry.id,
+				Body:           body,
+				Headers:        queuedHeaders(entry, req.Header),
+				Created:        time.Now().Unix(),
+				Traceparent:    tracing.Traceparent(ctx),
+				Overflow:       overflow,
+				IdempotencyKey: seen_key,
+			}
+
+			err := d.queue.Push(ctx, msg)
+
+			if err != nil {
+				logger.Error("Failed to queue delivery", logging.ERROR_KEY, err)
+				outcome = OUTCOME_FAILED
+				http.Error(rsp, "Failed to queue message", http.StatusInternalServerError)
+				return
+			}
+
+			// Hand the message off to a worker without waiting for one to become available. If every worker is busy
+			// the message is left in the queue, where it has already been durably stored, and handed off by a later poll.
+
+			if d.schedule(msg, time.Now()) {
+				logger.Debug("Queued delivery")
+			} else {
+				logger.Debug("Queued delivery, workers are busy so it will be processed by a later poll")
+			}
+
+			outcome = OUTCOME_ACCEPTED
+
+			rsp.Header().Set("X-Webhookd-Time-To-Receive", fmt.Sprintf("%v", ttr))
+			rsp.Header().Set("X-Webhookd-Delivery-Id", msg.ID)
+			rsp.Header().Set("Content-Type", "application/json")
+			rsp.WriteHeader(http.StatusAccepted)
+
+			enc := json.NewEncoder(rsp)
+			enc.Encode(map[string]string{"delivery_id": msg.ID})
+			return
+		}
+
+		env := envelope.NewEnvelope(req, entry.id, delivery.ID(ctx), body)
+
+		// The pipeline timeout applies to transforming and dispatching the message
+
+		ctx, cancel_pipeline := withTimeout(ctx, entry.pipeline_timeout)
+		defer cancel_pipeline()
+
+		ta = time.Now()
+
+		env, steps, err := d.transform(ctx, logger, entry, env)
+
+		ar.Transformations = steps
+
+		if err != nil {
+
+			switch {
+			case isHalted(err):
+				outcome = haltedOutcome(err)
+				ar.Reason = err.Message
+				return
+			case delivery.IsDeadlineExceeded(err):
+				outcome = OUTCOME_TIMEOUT
+				http.Error(rsp, err.Error(), http.StatusGatewayTimeout)
+				return
+			default:
+				outcome = OUTCOME_REJECTED
+				http.Error(rsp, err.Error(), err.Code)
+				return
+			}
+		}
+
+		tb = time.Since(ta)
+		ttt = tb
+		ar.Timings.Transform = audit.Milliseconds(ttt)
+
+		// check to see if there is anything to dispatch
+		// https://github.com/whosonfirst/go-webhookd/v3/issues/7
+
+		ta = time.Now()
+
+		results = d.dispatch(ctx, logger, entry.route(env, body), env)
+		ar.Route = results.Route
+		ar.Dispatchers = auditDispatchers(results)
+
+		tb = time.Since(ta)
+		ttd = tb
+		ar.Timings.Dispatch = audit.Milliseconds(ttd)
+
+		t2 := time.Since(t1)
+		ar.Timings.Process = audit.Milliseconds(t2)
+		d.metrics.observeStage(redacted, STAGE_PROCESS, t2)
+
+		outcome = results.Outcome()
+
+		logger.Debug("Processed delivery", "receive_ms", audit.Milliseconds(ttr), "transform_ms", audit.Milliseconds(ttt), "dispatch_ms", audit.Milliseconds(ttd), "process_ms", audit.Milliseconds(t2))
+
+		rsp.Header().Set("X-Webhookd-Time-To-Receive", fmt.Sprintf("%v", ttr))
+		rsp.Header().Set("X-Webhookd-Time-To-Transform", fmt.Sprintf("%v", ttt))
+		rsp.Header().Set("X-Webhookd-Time-To-Dispatch", fmt.Sprintf("%v", ttd))
+		rsp.Header().Set("X-Webhookd-Time-To-Process", fmt.Sprintf("%v", t2))
+
+		status := results.StatusCode(entry.policy)
+
+		if status == http.StatusOK && d.AllowDebug {
+
+			query := req.URL.Query()
+			debug := query.Get("debug")
+
+			if debug != "" {
+				rsp.Header().Set("Content-Type", "text/plain")
+				rsp.Header().Set("Access-Control-Allow-Origin", "*")
+				rsp.Write(env.Body)
+				return
+			}
+		}
+
+		rsp.Header().Set("X-Webhookd-Delivery-Id", results.DeliveryID)
+		rsp.Header().Set("Content-Type", "application/json")
+		rsp.WriteHeader(status)
+
+		enc := json.NewEncoder(rsp)
+		enc.Encode(results)
+		return
+	}
+
+	return http.HandlerFunc(handler), nil
+}
+
+// transform() applies each of the transformations defined by 'entry' to 'body' returning the final output or an error.
+// Errors with `webhookd.UnhandledEvent`, `webhookd.HaltEvent` or `EMPTY_BODY_LOGGED` codes are non-fatal and signal that there is nothing left to do.
+func (d *WebhookDaemon) transform(ctx context.Context, logger *slog.Logger, entry *webhookEntry, env *envelope.Envelope) (*envelope.Envelope, []*audit.StepResult, *webhookd.WebhookError) {
+
+	t1 := time.Now()
+
+	ctx, span := d.tracer.Start(ctx, "webhookd.transform", tracing.SPAN_KIND_INTERNAL)
+
+	var err *webhookd.WebhookError
+
+	defer func() {
+		d.metrics.observeStage(entry.redacted, STAGE_TRANSFORM, time.Since(t1))
+		endSpan(span, err)
+	}()
+
+	labels := entry.transformations
+
+	steps := make([]*audit.StepResult, 0)
+
+	for idx, step := range entry.webhook.Transformations() {
+
+		label := fmt.Sprintf("%T", step)
+
+		if idx < len(labels) {
+			label = labels[idx]
+		}
+
+		_, step_span := d.tracer.Start(ctx, "webhookd.transformation", tracing.SPAN_KIND_INTERNAL)
+		step_span.SetAttribute("webhookd.transformation", label)
+		step_span.SetAttribute("webhookd.transformation.offset", idx)
+
+		ts := time.Now()
+
+		var next *envelope.Envelope
+
+		tr := envelope.NewTransformationAdapter(step)
+
+		err = runWithTimeout(ctx, entry.transformation_timeout, func(ctx context.Context) *webhookd.WebhookError {
+
+			out, err := tr.TransformEnvelope(ctx, env)
+
+			if err == nil {
+				next = out
+			}
+
+			return err
+		})
+
+		// Transformations do not relay messages so a transformation whose outcome is unknown is treated as having timed out
+
+		if delivery.IsUnknownOutcome(err) {
+			err = &webhookd.WebhookError{Code: delivery.DEADLINE_EXCEEDED, Message: err.Message}
+		}
+
+		endSpan(step_span, err)
+
+		sr := &audit.StepResult{
+			Label:    label,
+			Duration: audit.Milliseconds(time.Since(ts)),
+		}
+
+		steps = append(steps, sr)
+
+		if err != nil {
+
+			sr.Code = err.Code
+			sr.Error = err.Message
+
+			step_logger := logger.With(logging.STAGE_KEY, STAGE_TRANSFORM, logging.STEP_KEY, fmt.Sprintf("%T", step), logging.OFFSET_KEY, idx)
+
+			switch {
+			case err.Code == webhookd.UnhandledEvent, err.Code == webhookd.HaltEvent:
+				step_logger.Info("Transformation step returned non-fatal error and exiting", logging.ERROR_KEY, err)
+			case delivery.IsDeadlineExceeded(err):
+				step_logger.Error("Transformation step timed out", logging.ERROR_KEY, err)
+			default:
+				step_logger.Error("Transformation step failed", logging.ERROR_KEY, err)
+			}
+
+			return nil, steps, err
+		}
+
+		env = next
+		sr.Size = len(env.Body)
+
+		// check to see if there is anything left the transformation
+		// https://github.com/whosonfirst/go-webhookd/v3/issues/7
+
+		err = checkEmptyBody(logger, entry, env, fmt.Sprintf("Transformation '%s'", label))
+
+		if err != nil {
+			return nil, steps, err
+		}
+	}
+
+	// If there are no transformations check the body returned by the receiver instead
+
+	if len(steps) == 0 {
+
+		err = checkEmptyBody(logger, entry, env, fmt.Sprintf("Receiver '%s'", entry.receiver))
+
+		if err != nil {
+			return nil, steps, err
+		}
+	}
+
+	return env, steps, nil
+}
+
+// dispatch() relays 'body' to each of the dispatchers defined by 'entry' returning a `WebhookResult` instance
+// containing the outcome of each dispatcher.
+func (d *WebhookDaemon) dispatch(ctx context.Context, logger *slog.Logger, entry *webhookEntry, env *envelope.Envelope) *WebhookResult {
+
+	t1 := time.Now()
+
+	ctx, span := d.tracer.Start(ctx, "webhookd.dispatch", tracing.SPAN_KIND_INTERNAL)
+
+	defer func() {
+		d.metrics.observeStage(entry.redacted, STAGE_DISPATCH, time.Since(t1))
+		span.End()
+	}()
+
+	span.SetAttribute("webhookd.route", entry.routeName())
+
+	labels := entry.dispatchers
+	dispatchers := entry.webhook.Dispatchers()
+
+	// Each dispatcher writes to its own slot so there is no need to synchronize access to results
+	// https://github.com/whosonfirst/go-webhookd/issues/14
+
+	results := make([]*DispatchResult, len(dispatchers))
+
+	wg := new(sync.WaitGroup)
+
+	for idx, dr := range dispatchers {
+
+		wg.Add(1)
+
+		label := fmt.Sprintf("%T", dr)
+
+		if idx < len(labels) {
+			label = labels[idx]
+		}
+
+		dispatch_ctx := delivery.WithDispatcher(ctx, label)
+
+		go func(ctx context.Context, idx int, label string, dr webhookd.WebhookDispatcher, env *envelope.Envelope) {
+
+			defer wg.Done()
+
+			t1 := time.Now()
+
+			ctx, dispatch_span := d.tracer.Start(ctx, "webhookd.dispatcher", tracing.SPAN_KIND_CLIENT)
+			dispatch_span.SetAttribute("webhookd.dispatcher", label)
+
+			r := &DispatchResult{
+				Dispatcher: label,
+				Status:     STATUS_OK,
+			}
+
+			err := runWithTimeout(ctx, entry.dispatcher_timeout, func(ctx context.Context) *webhookd.WebhookError {
+				return envelope.NewDispatcherAdapter(dr).DispatchEnvelope(ctx, env)
+			})
+
+			if err != nil {
+
+				r.Code = err.Code
+				r.Error = err.Message
+
+				dispatcher_logger := logger.With(logging.STAGE_KEY, STAGE_DISPATCH, logging.DISPATCHER_KEY, label, logging.OFFSET_KEY, idx)
+
+				switch {
+				case err.Code == webhookd.UnhandledEvent, err.Code == webhookd.HaltEvent:
+					dispatcher_logger.Info("Dispatch step returned non-fatal error and exiting", logging.ERROR_KEY, err)
+					r.Status = STATUS_HALTED
+				case delivery.IsUnknownOutcome(err):
+					// The dispatcher may still relay the message so it is not written to the dead letter store
+					dispatcher_logger.Warn("Dispatch step did not return after its deadline, outcome unknown", logging.ERROR_KEY, err)
+					r.Status = STATUS_UNKNOWN
+				case delivery.IsDeadlineExceeded(err):
+					dispatcher_logger.Error("Dispatch step timed out", logging.ERROR_KEY, err)
+					r.Status = STATUS_TIMEOUT
+					d.deadLetter(ctx, dispatcher_logger, entry.id, env.Body, err)
+				default:
+					dispatcher_logger.Error("Dispatch step failed", logging.ERROR_KEY, err)
+					r.Status = STATUS_FAILED
+					d.deadLetter(ctx, dispatcher_logger, entry.id, env.Body, err)
+				}
+			}
+
+			r.elapsed = time.Since(t1)
+			r.Duration = fmt.Sprintf("%v", r.elapsed)
+			results[idx] = r
+
+			d.metrics.dispatches.Inc(entry.redacted, label, r.Status)
+
+			dispatch_span.SetAttribute("webhookd.dispatch.status", r.Status)
+			endSpan(dispatch_span, err)
+
+		}(dispatch_ctx, idx, label, dr, env)
+	}
+
+	wg.Wait()
+
+	r := &WebhookResult{
+		DeliveryID:  delivery.ID(ctx),
+		Endpoint:    entry.redacted,
+		Route:       entry.routeName(),
+		Dispatchers: results,
+	}
+
+	return r
+}
+
+// deadLetter() writes 'body', received by the webhook identified by 'endpoint' (see `endpointID`), and the error returned
+// by the dispatcher associated with 'ctx' to the dead letter store for 'd', if present.
+func (d *WebhookDaemon) deadLetter(ctx context.Context, logger *slog.Logger, endpoint string, body []byte, dispatch_err *webhookd.WebhookError) {
+
+	if d.deadletters == nil {
+		return
+	}
+
+	delivery_id := delivery.ID(ctx)
+	label := delivery.Dispatcher(ctx)
+
+	e := deadletter.NewEntry(delivery_id, endpoint, label, dispatch_err.Code, dispatch_err.Message, body)
+
+	// Use a new context since the request may already have been cancelled.
+
+	err := d.deadletters.Put(context.Background(), e)
+
+	if err != nil {
+		logger.Error("Failed to write dead letter", logging.ERROR_KEY, err)
+		return
+	}
+
+	logger.Info("Wrote dead letter", "dead_letter", e.ID)
+}
+
+// startWorkers() starts the workers used to process asynchronous webhook messages, schedules any messages that were
+// queued but not processed before the daemon last stopped and starts polling the queue for messages that could not be
+// handed off to a worker when they were queued.
+func (d *WebhookDaemon) startWorkers(ctx context.Context, logger *slog.Logger) error {
+
+	// Workers use their own context, rather than 'ctx', so that messages that are being processed when 'ctx'
+	// is cancelled can finish during shutdown. It is cancelled if the daemon stops waiting for them.
+
+	work_ctx, work_cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-d.abandon
+		work_cancel()
+	}()
+
+	// Messages are buffered so that requests for asynchronous webhooks never wait for a worker to become available
+
+	d.messages = make(chan *queue.Message, d.workers)
+
+	for i := 0; i < d.workers; i++ {
+		go d.work(work_ctx, logger)
+	}
+
+	if !d.schedulePending(ctx, logger) {
+		return fmt.Errorf("Failed to retrieve pending messages from queue")
+	}
+
+	go d.poll(work_ctx, logger)
+
+	return nil
+}
+
+// work() processes asynchronous webhook messages until 'ctx' is cancelled or 'd' starts shutting down.
+func (d *WebhookDaemon) work(ctx context.Context, logger *slog.Logger) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stopping:
+			return
+		case msg := <-d.messages:
+			d.processMessage(ctx, logger, msg)
+			d.complete(msg)
+		}
+	}
+}
+
+// processMessage() applies the transformations and dispatchers for the webhook associated with 'msg' and then
+// removes 'msg' from the queue.
+func (d *WebhookDaemon) processMessage(ctx context.Context, logger *slog.Logger, msg *queue.Message) {
+
+	entry, release, ok := d.acquireWebhookEntryByID(msg.Endpoint)
+
+	if !ok {
+		d.discardMessage(ctx, logger, msg)
+		return
+	}
+
+	defer release()
+
+	redacted := entry.redacted
+
+	logger = requestLogger(logger, msg.ID, redacted)
+
+	if msg.Overflow {
+
+		if entry.overflow == nil {
+			logger.Warn("Queued delivery exceeded rate limit but endpoint no longer has an overflow dispatcher, using default dispatchers")
+		} else {
+			entry = entry.overflow
+		}
+	}
+
+	done, ok := d.inflight.add(msg.ID, redacted)
+
+	if !ok {
+		logger.Info("Shutting down, leaving delivery in queue")
+		return
+	}
+
+	defer done()
+
+	ctx = delivery.WithID(ctx, msg.ID)
+
+	if msg.Traceparent != "" {
+
+		sc, err := tracing.ParseTraceparent(msg.Traceparent)
+
+		if err == nil {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+
+	ctx, span := d.tracer.Start(ctx, "webhookd.process", tracing.SPAN_KIND_INTERNAL)
+
+	span.SetAttribute("webhookd.endpoint", redacted)
+	span.SetAttribute("webhookd.delivery_id", msg.ID)
+
+	repo := repositoryName(msg.Body)
+
+	if repo != "" {
+		span.SetAttribute("github.repository", repo)
+	}
+
+	d.metrics.in_flight.Inc()
+	defer d.metrics.in_flight.Dec()
+
+	t1 := time.Now()
+
+	env := &envelope.Envelope{
+		Body:       msg.Body,
+		Headers:    msg.Headers,
+		EventType:  envelope.EventType(msg.Headers),
+		DeliveryID: msg.ID,
+		Endpoint:   msg.Endpoint,
+		ReceivedAt: time.Unix(msg.Created, 0),
+	}
+
+	ar := &audit.Record{
+		DeliveryID: msg.ID,
+		Endpoint:   redacted,
+		EventType:  env.EventType,
+		Async:      true,
+		Received:   env.ReceivedAt,
+		Timings:    &audit.Timings{},
+	}
+
+	d.capturePayload(ar, msg.Body)
+
+	// The pipeline timeout applies to transforming and dispatching the message. It is applied to a separate context
+	// so that messages that time out are not mistaken for messages that were abandoned during shutdown.
+
+	pipeline_ctx, cancel_pipeline := withTimeout(ctx, entry.pipeline_timeout)
+	defer cancel_pipeline()
+
+	ta := time.Now()
+
+	env, steps, err := d.transform(pipeline_ctx, logger, entry, env)
+
+	ar.Transformations = steps
+	ar.Timings.Transform = audit.Milliseconds(time.Since(ta))
+
+	// processed is false if the message was rejected by a transformation or any of its dispatchers failed
+
+	processed := true
+
+	r := &DeliveryRecord{
+		DeliveryID: msg.ID,
+		Endpoint:   redacted,
+		Outcome:    OUTCOME_OK,
+		Async:      true,
+		Received:   ar.Received,
+	}
+
+	if err == nil {
+
+		ta := time.Now()
+
+		results := d.dispatch(pipeline_ctx, logger, entry.route(env, msg.Body), env)
+		r.Route = results.Route
+		r.Dispatchers = results.Dispatchers
+
+		ar.Route = results.Route
+		ar.Dispatchers = auditDispatchers(results)
+		ar.Timings.Dispatch = audit.Milliseconds(time.Since(ta))
+
+		failed := results.Failed()
+
+		if failed > 0 {
+			logger.Error("Dispatchers failed for queued delivery", "failed", failed, "dispatchers", len(results.Dispatchers))
+			span.SetError(fmt.Sprintf("%d of %d dispatchers failed", failed, len(results.Dispatchers)))
+			processed = false
+			r.Outcome = results.Outcome()
+		}
+
+	} else if delivery.IsDeadlineExceeded(err) {
+		processed = false
+		r.Outcome = OUTCOME_TIMEOUT
+	} else if !isHalted(err) {
+		processed = false
+		r.Outcome = OUTCOME_REJECTED
+	} else {
+		r.Outcome = haltedOutcome(err)
+		r.Reason = err.Message
+		ar.Reason = err.Message
+	}
+
+	endSpan(span, err)
+
+	t2 := time.Since(t1)
+	d.metrics.observeStage(redacted, STAGE_PROCESS, t2)
+
+	r.Duration = fmt.Sprintf("%v", t2)
+	d.recordDelivery(r)
+
+	ar.Outcome = r.Outcome
+	ar.Timings.Process = audit.Milliseconds(t2)
+	d.writeAudit(logger, ar)
+
+	logger.Debug("Processed queued delivery", "process_ms", audit.Milliseconds(t2))
+
+	// If processing was abandoned during shutdown leave the message in the queue so that it is resumed when the daemon restarts
+
+	if ctx.Err() != nil {
+		logger.Warn("Processing delivery was abandoned, leaving it in queue")
+		return
+	}
+
+	if !processed {
+		d.forgetMessage(logger, msg.IdempotencyKey)
+	}
+
+	rm_err := d.queue.Remove(ctx, msg)
+
+	if rm_err != nil {
+		logger.Error("Failed to remove delivery from queue", logging.ERROR_KEY, rm_err)
+	}
+}
+
+// discardMessage() writes 'msg', whose endpoint is no longer configured, to the dead letter store for 'd', if present, and
+// removes it from the queue so that it is not read again.
+func (d *WebhookDaemon) discardMessage(ctx context.Context, logger *slog.Logger, msg *queue.Message) {
+
+	// The endpoint is not logged since it is no longer possible to know whether it contains any secrets
+
+	logger = logger.With(logging.REQUEST_ID_KEY, msg.ID)
+	logger.Warn("Endpoint for queued delivery is not configured, discarding")
+
+	ctx = delivery.WithID(ctx, msg.ID)
+
+	err := &webhookd.WebhookError{Code: http.StatusNotFound, Message: "Endpoint is not configured"}
+	d.deadLetter(ctx, logger, msg.Endpoint, msg.Body, err)
+
+	d.forgetMessage(logger, msg.IdempotencyKey)
+
+	rm_err := d.queue.Remove(ctx, msg)
+
+	if rm_err != nil {
+		logger.Error("Failed to remove delivery from queue", logging.ERROR_KEY, rm_err)
+	}
+}
+
+// Start() causes 'd' to listen for, and process, requests.
+func (d *WebhookDaemon) Start(ctx context.Context) error {
+	logger := log.Default()
+	return d.StartWithLogger(ctx, logger)
+}
+
+// StartWithLogger() causes 'd' to listen for, and process, requests logging events to 'logger'.
+func (d *WebhookDaemon) StartWithLogger(ctx context.Context, logger *log.Logger) error {
+
+	handler, err := d.HandlerFuncWithLogger(logger)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create handler func, %w", err)
+	}
+
+	if d.queue != nil {
+
+		err := d.startWorkers(ctx, d.slogger(logger))
+
+		if err != nil {
+			return fmt.Errorf("Failed to start workers, %w", err)
+		}
+	}
+
+	healthz_handler, err := d.HealthzHandlerFunc()
+
+	if err != nil {
+		return fmt.Errorf("Failed to create healthz handler func, %w", err)
+	}
+
+	readyz_handler, err := d.ReadyzHandlerFunc()
+
+	if err != nil {
+		return fmt.Errorf("Failed to create readyz handler func, %w", err)
+	}
+
+	metrics_handler, err := d.MetricsHandlerFunc()
+
+	if err != nil {
+		return fmt.Errorf("Failed to create metrics handler func, %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handler)
+	mux.HandleFunc(d.HealthzPath(), healthz_handler)
+	mux.HandleFunc(d.ReadyzPath(), readyz_handler)
+	mux.HandleFunc(d.MetricsPath(), metrics_handler)
+
+	if d.admin_prefix != "" {
+
+		admin_handler, err := d.AdminHandler()
+
+		if err != nil {
+			return fmt.Errorf("Failed to create admin handler, %w", err)
+		}
+
+		mux.Handle(d.admin_prefix+"/", admin_handler)
+	}
+
+	svr := d.server
+
+	d.slogger(logger).Info("webhookd listening for requests", "address", svr.Address())
+
+	svr_err := make(chan error, 1)
+
+	go func() {
+		svr_err <- svr.ListenAndServe(ctx, mux)
+	}()
+
+	select {
+	case err := <-svr_err:
+
+		if err != nil {
+			return fmt.Errorf("Failed to listen for requests, %w", err)
+		}
+
+	case <-ctx.Done():
+		// pass
+	}
+
+	shutdown_ctx, shutdown_cancel := context.WithTimeout(context.Background(), d.shutdown_timeout)
+	defer shutdown_cancel()
+
+	// Stop listening for new requests while in-flight deliveries finish processing
+
+	listener_err := make(chan error, 1)
+
+	go func() {
+
+		ss, ok := svr.(shutdownServer)
+
+		if !ok {
+			listener_err <- nil
+			return
+		}
+
+		listener_err <- ss.Shutdown(shutdown_ctx)
+	}()
+
+	err = d.ShutdownWithLogger(shutdown_ctx, logger)
+
+	if err != nil {
+		return fmt.Errorf("Failed to shut down cleanly, %w", err)
+	}
+
+	err = <-listener_err
+
+	if err != nil {
+		return fmt.Errorf("Failed to shut down server, %w", err)
+	}
+
+	return nil
+}
+
+// queuedHeaders() returns the subset of 'h' that is stored in the queue, along with the body, for asynchronous messages
+// received by 'entry'. Only the headers used after a message has been queued are stored: the headers in `envelope.EVENT_HEADERS`
+// and `envelope.DELIVERY_HEADERS`, the W3C Trace Context "traceparent" header and the headers matched by the routes for 'entry'
+// (and its overflow webhook). Other headers, which may contain credentials like `Authorization` or `X-Gitlab-Token`, are not stored.
+func queuedHeaders(entry *webhookEntry, h http.Header) http.Header {
+
+	names := make([]string, 0)
+	names = append(names, envelope.EVENT_HEADERS...)
+	names = append(names, envelope.DELIVERY_HEADERS...)
+	names = append(names, tracing.TRACEPARENT_HEADER)
+
+	for _, e := range []*webhookEntry{entry, entry.overflow} {
+
+		if e == nil {
+			continue
+		}
+
+		for _, r := range e.routes {
+
+			for name := range r.headers {
+				names = append(names, name)
+			}
+		}
+	}
+
+	queued := make(http.Header)
+
+	for _, name := range names {
+
+		k := http.CanonicalHeaderKey(name)
+		v, ok := h[k]
+
+		if ok {
+			queued[k] = append([]string(nil), v...)
+		}
+	}
+
+	return queued
+}
+
+// deliveryID() returns the unique identifier for the message (delivery) in 'req'. If present the value of the
+// first header in `envelope.DELIVERY_HEADERS`, for example `X-GitHub-Delivery`, is used, otherwise a new identifier is generated.
+func deliveryID(req *http.Request) string {
+
+	id := envelope.DeliveryID(req.Header)
+
+	if id != "" {
+		return id
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
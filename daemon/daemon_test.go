@@ -0,0 +1,278 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-webhookd/v3/receiver"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+// test_dispatchers is a dictionary mapping the names of `testDispatcher` instances to the instances themselves so that
+// tests can inspect the dispatchers created by a daemon from its config.
+var test_dispatchers = new(sync.Map)
+
+// test_dispatcher_count is used to derive unique names for `testDispatcher` instances.
+var test_dispatcher_count = new(int64)
+
+func init() {
+
+	ctx := context.Background()
+	err := dispatcher.RegisterDispatcher(ctx, "testdispatch", newTestDispatcher)
+
+	if err != nil {
+		panic(err)
+	}
+
+	err = receiver.RegisterReceiver(ctx, "testsecret", newSecretReceiver)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// type secretReceiver implements the `webhookd.WebhookReceiver` and `EndpointRedactor` interfaces for receivers whose
+// endpoints contain a secret.
+type secretReceiver struct {
+	webhookd.WebhookReceiver
+	// secret is the secret contained in the endpoint.
+	secret string
+}
+
+// newSecretReceiver() returns a new `secretReceiver` instance configured by 'uri' in the form of:
+//
+//	testsecret://?secret={SECRET}
+func newSecretReceiver(ctx context.Context, uri string) (webhookd.WebhookReceiver, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	wh := &secretReceiver{
+		secret: u.Query().Get("secret"),
+	}
+
+	return wh, nil
+}
+
+// Receive() returns the body of 'req'.
+func (wh *secretReceiver) Receive(ctx context.Context, req *http.Request) ([]byte, *webhookd.WebhookError) {
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		return nil, &webhookd.WebhookError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	return body, nil
+}
+
+// RedactEndpoint() returns a copy of 'endpoint' with the secret for 'wh' replaced by "{SECRET}".
+func (wh *secretReceiver) RedactEndpoint(endpoint string) string {
+	return strings.ReplaceAll(endpoint, wh.secret, "{SECRET}")
+}
+
+// type testDispatcher implements the `webhookd.WebhookDispatcher` interface for recording the messages dispatched by a daemon.
+type testDispatcher struct {
+	webhookd.WebhookDispatcher
+	// mu is used to synchronize access to 'bodies' and 'calls'.
+	mu *sync.Mutex
+	// bodies are the messages that were dispatched successfully.
+	bodies []string
+	// calls is the number of times Dispatch() has been called.
+	calls int
+	// fail is the number of calls which fail before messages are dispatched successfully.
+	fail int
+	// code is the error code returned by calls which fail.
+	code int
+	// release is an optional channel which Dispatch() waits to be closed before doing anything.
+	release chan bool
+}
+
+// newTestDispatcher() returns the `testDispatcher` instance named by the host of 'uri', creating it if necessary. 'uri' is
+// expected to take the form of:
+//
+//	testdispatch://{NAME}?fail={COUNT}&code={CODE}&block={BOOLEAN}
+func newTestDispatcher(ctx context.Context, uri string) (webhookd.WebhookDispatcher, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+
+	dr := &testDispatcher{
+		mu:   new(sync.Mutex),
+		code: http.StatusInternalServerError,
+	}
+
+	if q.Has("fail") {
+		dr.fail, _ = strconv.Atoi(q.Get("fail"))
+	}
+
+	if q.Has("code") {
+		dr.code, _ = strconv.Atoi(q.Get("code"))
+	}
+
+	if q.Get("block") == "true" {
+		dr.release = make(chan bool)
+	}
+
+	v, _ := test_dispatchers.LoadOrStore(u.Host, dr)
+	return v.(*testDispatcher), nil
+}
+
+// Dispatch() records 'body' unless 'dr' has been configured to fail.
+func (dr *testDispatcher) Dispatch(ctx context.Context, body []byte) *webhookd.WebhookError {
+
+	if dr.release != nil {
+
+		select {
+		case <-ctx.Done():
+			return &webhookd.WebhookError{Code: http.StatusGatewayTimeout, Message: ctx.Err().Error()}
+		case <-dr.release:
+			// pass
+		}
+	}
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	dr.calls += 1
+
+	if dr.calls <= dr.fail {
+		return &webhookd.WebhookError{Code: dr.code, Message: "Failed to dispatch"}
+	}
+
+	dr.bodies = append(dr.bodies, string(body))
+	return nil
+}
+
+// dispatched() returns a copy of the messages that were dispatched successfully by 'dr'.
+func (dr *testDispatcher) dispatched() []string {
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	bodies := make([]string, len(dr.bodies))
+	copy(bodies, dr.bodies)
+
+	return bodies
+}
+
+// testDispatcherName() returns a unique name for a `testDispatcher` instance.
+func testDispatcherName() string {
+	return fmt.Sprintf("test%d", atomic.AddInt64(test_dispatcher_count, 1))
+}
+
+// getTestDispatcher() returns the `testDispatcher` instance named 'name'.
+func getTestDispatcher(t *testing.T, name string) *testDispatcher {
+
+	v, ok := test_dispatchers.Load(name)
+
+	if !ok {
+		t.Fatalf("Missing test dispatcher %s", name)
+	}
+
+	return v.(*testDispatcher)
+}
+
+// testLogger() returns a `log.Logger` instance which discards everything written to it.
+func testLogger() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
+
+// newTestDaemon() returns a new `WebhookDaemon` instance derived from the JSON-encoded config 'str_cfg' which is shut
+// down when the test completes.
+func newTestDaemon(t *testing.T, str_cfg string) *WebhookDaemon {
+
+	ctx := context.Background()
+
+	var cfg *config.WebhookConfig
+
+	err := json.Unmarshal([]byte(str_cfg), &cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to decode config, %v", err)
+	}
+
+	if cfg.Daemon == "" {
+		cfg.Daemon = "http://localhost:8080"
+	}
+
+	d, err := NewWebhookDaemonFromConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to create daemon, %v", err)
+	}
+
+	t.Cleanup(func() {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		d.ShutdownWithLogger(ctx, testLogger())
+	})
+
+	return d
+}
+
+// newTestHandler() returns the `http.HandlerFunc` for webhook requests for 'd'.
+func newTestHandler(t *testing.T, d *WebhookDaemon) http.HandlerFunc {
+
+	h, err := d.HandlerFuncWithLogger(testLogger())
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	return h
+}
+
+// post() sends 'body' to 'path', with optional headers in the form of "{NAME}", "{VALUE}" pairs, using 'h' and returns the response.
+func post(h http.HandlerFunc, path string, body string, headers ...string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rsp := httptest.NewRecorder()
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	h(rsp, req)
+	return rsp
+}
+
+// waitFor() waits for 'cond' to return true, failing the test if it does not within 5 seconds.
+func waitFor(t *testing.T, label string, cond func() bool) {
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !cond() {
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", label)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
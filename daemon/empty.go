@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/envelope"
+)
+
+// EMPTY_BODY_HALT is the empty body policy that halts messages with an empty body without logging anything.
+const EMPTY_BODY_HALT string = "halt"
+
+// EMPTY_BODY_LOG is the empty body policy that halts messages with an empty body and logs the reason they were halted.
+const EMPTY_BODY_LOG string = "log"
+
+// EMPTY_BODY_PASS is the empty body policy that relays messages with an empty body to the next transformation or dispatchers.
+const EMPTY_BODY_PASS string = "pass"
+
+// DEFAULT_EMPTY_BODY is the default empty body policy for webhooks.
+const DEFAULT_EMPTY_BODY string = EMPTY_BODY_HALT
+
+// EMPTY_BODY_LOGGED is the `webhookd.WebhookError` code for messages halted by the `EMPTY_BODY_LOG` policy. Errors with this code
+// are treated the same as `webhookd.HaltEvent` errors except that messages are recorded with the `OUTCOME_EMPTY` outcome, and the
+// reason they were halted, in metrics, traces, the admin API and the audit log.
+const EMPTY_BODY_LOGGED int = -3
+
+// ensureEmptyBody() returns an error if 'policy' is not a valid empty body policy.
+func ensureEmptyBody(policy string) error {
+
+	switch policy {
+	case EMPTY_BODY_HALT, EMPTY_BODY_LOG, EMPTY_BODY_PASS:
+		return nil
+	default:
+		return fmt.Errorf("Invalid empty body policy '%s'", policy)
+	}
+}
+
+// checkEmptyBody() returns a `webhookd.HaltEvent` error if the body of 'env', produced by the step labeled 'source', is empty
+// (or only contains whitespace) and the empty body policy for 'entry' halts messages. If the policy is `EMPTY_BODY_LOG` the
+// reason is logged and the error has the `EMPTY_BODY_LOGGED` code instead. Otherwise it returns nil.
+func checkEmptyBody(logger *slog.Logger, entry *webhookEntry, env *envelope.Envelope, source string) *webhookd.WebhookError {
+
+	if entry.empty_body == EMPTY_BODY_PASS || len(bytes.TrimSpace(env.Body)) > 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s produced an empty body", source)
+
+	if entry.empty_body == EMPTY_BODY_LOG {
+		logger.Info("Halting delivery", "reason", msg)
+		return &webhookd.WebhookError{Code: EMPTY_BODY_LOGGED, Message: msg}
+	}
+
+	return &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: msg}
+}
+
+// isHalted() returns a boolean value indicating whether 'err' signals that there is nothing left to do for a message, rather
+// than a failure. This is the case for errors with `webhookd.UnhandledEvent`, `webhookd.HaltEvent` or `EMPTY_BODY_LOGGED` codes.
+func isHalted(err *webhookd.WebhookError) bool {
+
+	if err == nil {
+		return false
+	}
+
+	switch err.Code {
+	case webhookd.UnhandledEvent, webhookd.HaltEvent, EMPTY_BODY_LOGGED:
+		return true
+	default:
+		return false
+	}
+}
+
+// haltedOutcome() returns the `OUTCOME_` constant for a message halted by 'err'.
+func haltedOutcome(err *webhookd.WebhookError) string {
+
+	if err.Code == EMPTY_BODY_LOGGED {
+		return OUTCOME_EMPTY
+	}
+
+	return OUTCOME_HALTED
+}
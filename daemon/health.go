@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/health"
+)
+
+// DEFAULT_PROBE_TIMEOUT is the default amount of time to wait for an individual readiness probe to complete.
+const DEFAULT_PROBE_TIMEOUT time.Duration = 5 * time.Second
+
+// SetHealthFromConfig() assigns the path prefix for the liveness and readiness endpoints, and any readiness probes, from 'cfg'.
+func (d *WebhookDaemon) SetHealthFromConfig(ctx context.Context, cfg *config.WebhookConfig) error {
+
+	if cfg.Health == nil {
+		return nil
+	}
+
+	prefix := strings.TrimRight(cfg.Health.Prefix, "/")
+
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("Invalid health prefix '%s', must start with '/'", cfg.Health.Prefix)
+	}
+
+	d.health_prefix = prefix
+
+	if cfg.Health.Timeout > 0 {
+		d.probe_timeout = time.Duration(cfg.Health.Timeout) * time.Second
+	}
+
+	probes, err := probesFromConfig(ctx, cfg)
+
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.probes = probes
+	return nil
+}
+
+// probesFromConfig() returns a dictionary of component names and their corresponding `health.Probe` instances for the
+// dispatchers, queue and dead letter store defined in 'cfg'.
+func probesFromConfig(ctx context.Context, cfg *config.WebhookConfig) (map[string]health.Probe, error) {
+
+	probes := make(map[string]health.Probe)
+
+	if cfg.Health == nil || !cfg.Health.ProbeDispatchers {
+		return probes, nil
+	}
+
+	for _, hook := range cfg.Webhooks {
+
+		for _, name := range webhookDispatcherNames(hook) {
+
+			if strings.HasPrefix(name, "#") {
+				continue
+			}
+
+			component := fmt.Sprintf("dispatcher:%s", name)
+
+			_, exists := probes[component]
+
+			if exists {
+				continue
+			}
+
+			dispatcher_uri, err := cfg.GetDispatcherConfigByName(name)
+
+			if err != nil {
+				health.Close(ctx, probes)
+				return nil, fmt.Errorf("Failed to get dispatcher configuration for '%s', %w", name, err)
+			}
+
+			err = addProbe(ctx, probes, component, dispatcher_uri)
+
+			if err != nil {
+				health.Close(ctx, probes)
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.Queue != nil {
+
+		err := addProbe(ctx, probes, "queue", cfg.Queue.URI)
+
+		if err != nil {
+			health.Close(ctx, probes)
+			return nil, err
+		}
+	}
+
+	if cfg.DeadLetter != "" {
+
+		err := addProbe(ctx, probes, "dead_letter", cfg.DeadLetter)
+
+		if err != nil {
+			health.Close(ctx, probes)
+			return nil, err
+		}
+	}
+
+	return probes, nil
+}
+
+// webhookDispatcherNames() returns the labels of all the dispatchers used by 'hook': its default dispatchers, the dispatchers
+// for each of its routes and its overflow dispatcher, if any.
+func webhookDispatcherNames(hook config.WebhookWebhooksConfig) []string {
+
+	names := make([]string, 0)
+	names = append(names, hook.Dispatchers...)
+
+	for _, r := range hook.Routes {
+		names = append(names, r.Dispatchers...)
+	}
+
+	if hook.RateLimit != nil && hook.RateLimit.Overflow != "" {
+		names = append(names, hook.RateLimit.Overflow)
+	}
+
+	return names
+}
+
+func addProbe(ctx context.Context, probes map[string]health.Probe, component string, uri string) error {
+
+	p, err := health.NewDispatcherProbe(ctx, uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create probe for '%s', %w", component, err)
+	}
+
+	if p != nil {
+		probes[component] = p
+	}
+
+	return nil
+}
+
+// HealthzPath() returns the relative URI of the liveness endpoint for 'd'.
+func (d *WebhookDaemon) HealthzPath() string {
+	return path.Join("/", d.health_prefix, "healthz")
+}
+
+// ReadyzPath() returns the relative URI of the readiness endpoint for 'd'.
+func (d *WebhookDaemon) ReadyzPath() string {
+	return path.Join("/", d.health_prefix, "readyz")
+}
+
+// HealthzHandlerFunc() returns a `http.HandlerFunc` that reports whether 'd' is alive. It always returns a `200 OK` response.
+func (d *WebhookDaemon) HealthzHandlerFunc() (http.HandlerFunc, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		r := &health.Report{
+			Status: health.STATUS_OK,
+		}
+
+		writeReport(rsp, r)
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// ReadyzHandlerFunc() returns a `http.HandlerFunc` that reports whether 'd' is ready to process webhooks, including the
+// outcome of any readiness probes. If any probe fails, or 'd' is shutting down, a `503 Service Unavailable` response is returned.
+func (d *WebhookDaemon) ReadyzHandlerFunc() (http.HandlerFunc, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		// Report that the daemon is not ready as soon as it starts shutting down so that load balancers
+		// stop sending it new requests
+
+		select {
+		case <-d.stopping:
+			writeReport(rsp, &health.Report{Status: health.STATUS_UNAVAILABLE})
+			return
+		default:
+			// pass
+		}
+
+		ctx := req.Context()
+		d.mu.RLock()
+		probes := d.probes
+		d.mu.RUnlock()
+
+		r := health.Run(ctx, probes, d.probe_timeout)
+
+		writeReport(rsp, r)
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+func writeReport(rsp http.ResponseWriter, r *health.Report) {
+
+	status := http.StatusOK
+
+	if r.Status != health.STATUS_OK {
+		status = http.StatusServiceUnavailable
+	}
+
+	rsp.Header().Set("Content-Type", "application/json")
+	rsp.Header().Set("Cache-Control", "no-store")
+	rsp.WriteHeader(status)
+
+	enc := json.NewEncoder(rsp)
+	enc.Encode(r)
+}
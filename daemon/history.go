@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+)
+
+// DEFAULT_HISTORY_SIZE is the default number of delivery outcomes remembered for each webhook endpoint.
+const DEFAULT_HISTORY_SIZE int = 50
+
+// type DeliveryRecord is a struct containing the outcome of processing an individual webhook message.
+type DeliveryRecord struct {
+	// DeliveryID is the unique identifier for the message (delivery).
+	DeliveryID string `json:"delivery_id"`
+	// Endpoint is the relative URI of the webhook that received the message.
+	Endpoint string `json:"endpoint"`
+	// Outcome is the outcome of processing the message. Valid options are the `OUTCOME_` constants.
+	Outcome string `json:"outcome"`
+	// Reason is the reason the message was halted, if it was halted by its receiver or a transformation.
+	Reason string `json:"reason,omitempty"`
+	// Async is a boolean flag signaling that the message was processed by a queue worker.
+	Async bool `json:"async,omitempty"`
+	// Received is the time the message was received.
+	Received time.Time `json:"received"`
+	// Duration is the amount of time it took to process the message.
+	Duration string `json:"duration"`
+	// Route is the name of the route used to select the dispatchers for the message, if the message was dispatched.
+	Route string `json:"route,omitempty"`
+	// Dispatchers is the list of outcomes for each dispatcher, if the message was dispatched.
+	Dispatchers []*DispatchResult `json:"dispatchers,omitempty"`
+}
+
+// type deliveryHistory is a struct containing a fixed-size ring buffer of `DeliveryRecord` instances for each webhook endpoint.
+type deliveryHistory struct {
+	mu *sync.RWMutex
+	// size is the maximum number of records per endpoint.
+	size int
+	// records is a dictionary mapping endpoints to their ring buffers.
+	records map[string]*ring
+}
+
+// type ring is a struct containing a fixed-size ring buffer of `DeliveryRecord` instances.
+type ring struct {
+	// records is the underlying buffer.
+	records []*DeliveryRecord
+	// next is the offset in 'records' where the next record will be written.
+	next int
+	// full is a boolean flag signaling that 'records' has wrapped around.
+	full bool
+}
+
+func newDeliveryHistory(size int) *deliveryHistory {
+
+	h := &deliveryHistory{
+		mu:      new(sync.RWMutex),
+		size:    size,
+		records: make(map[string]*ring),
+	}
+
+	return h
+}
+
+// add() appends 'r' to the ring buffer for its endpoint, replacing the oldest record if the buffer is full.
+func (h *deliveryHistory) add(r *DeliveryRecord) {
+
+	if h.size <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rb, ok := h.records[r.Endpoint]
+
+	if !ok {
+		rb = &ring{
+			records: make([]*DeliveryRecord, h.size),
+		}
+
+		h.records[r.Endpoint] = rb
+	}
+
+	rb.records[rb.next] = r
+	rb.next = (rb.next + 1) % h.size
+
+	if rb.next == 0 {
+		rb.full = true
+	}
+}
+
+// list() returns the records for 'endpoint', most recent first.
+func (h *deliveryHistory) list(endpoint string) []*DeliveryRecord {
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	list := make([]*DeliveryRecord, 0)
+
+	rb, ok := h.records[endpoint]
+
+	if !ok {
+		return list
+	}
+
+	count := rb.next
+
+	if rb.full {
+		count = h.size
+	}
+
+	for i := 1; i <= count; i++ {
+		idx := (rb.next - i + h.size) % h.size
+		list = append(list, rb.records[idx])
+	}
+
+	return list
+}
+
+// endpoints() returns the list of endpoints that have records.
+func (h *deliveryHistory) endpoints() []string {
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	endpoints := make([]string, 0, len(h.records))
+
+	for endpoint := range h.records {
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints
+}
@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/envelope"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/idempotency"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+)
+
+// DEFAULT_IDEMPOTENCY_STORE is the default `idempotency.Store` URI used to record which webhook messages have been seen.
+const DEFAULT_IDEMPOTENCY_STORE string = "mem://"
+
+// DEFAULT_IDEMPOTENCY_TTL is the default amount of time a webhook message is remembered for.
+const DEFAULT_IDEMPOTENCY_TTL time.Duration = 24 * time.Hour
+
+// DEFAULT_IDEMPOTENCY_PENDING_TTL is the default amount of time a webhook message is remembered for while it is being
+// processed. It is remembered for the idempotency TTL once it has been processed successfully.
+const DEFAULT_IDEMPOTENCY_PENDING_TTL time.Duration = 15 * time.Minute
+
+// IDEMPOTENCY_KEY_DELIVERY is the idempotency key for identifying webhook messages by their delivery ID header, for example `X-GitHub-Delivery`.
+const IDEMPOTENCY_KEY_DELIVERY string = "delivery"
+
+// IDEMPOTENCY_KEY_BODY is the idempotency key for identifying webhook messages by the SHA-256 hash of their (received) body.
+const IDEMPOTENCY_KEY_BODY string = "body"
+
+// IDEMPOTENCY_KEY_HEADER is the prefix for idempotency keys that identify webhook messages by the value of a request header.
+const IDEMPOTENCY_KEY_HEADER string = "header:"
+
+// IDEMPOTENCY_KEY_NONE is the idempotency key for webhooks whose messages should never be considered duplicates.
+const IDEMPOTENCY_KEY_NONE string = "none"
+
+// DEFAULT_IDEMPOTENCY_KEY is the default idempotency key.
+const DEFAULT_IDEMPOTENCY_KEY string = IDEMPOTENCY_KEY_DELIVERY
+
+// SetIdempotencyFromConfig() assigns the store, and the amount of time, used to record which webhook messages have been seen from 'cfg'.
+func (d *WebhookDaemon) SetIdempotencyFromConfig(ctx context.Context, cfg *config.WebhookConfig) error {
+
+	if cfg.Idempotency == nil {
+		return nil
+	}
+
+	ttl := DEFAULT_IDEMPOTENCY_TTL
+
+	if cfg.Idempotency.TTL != "" {
+
+		t, err := time.ParseDuration(cfg.Idempotency.TTL)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse idempotency TTL, %w", err)
+		}
+
+		if t <= 0 {
+			return fmt.Errorf("Invalid idempotency TTL, %v", t)
+		}
+
+		ttl = t
+	}
+
+	store_uri := cfg.Idempotency.Store
+
+	if store_uri == "" {
+		store_uri = DEFAULT_IDEMPOTENCY_STORE
+	}
+
+	s, err := idempotency.NewStore(ctx, store_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create idempotency store, %w", err)
+	}
+
+	d.SetIdempotencyStore(s, ttl)
+	return nil
+}
+
+// SetIdempotencyStore() assigns 's' as the store used to record which webhook messages have been seen, and 'ttl'
+// as the amount of time they are remembered for.
+func (d *WebhookDaemon) SetIdempotencyStore(s idempotency.Store, ttl time.Duration) {
+	d.seen = s
+	d.seen_ttl = ttl
+}
+
+// idempotencyKeyFromConfig() returns the idempotency key for 'hook'. If idempotency has not been configured in 'cfg'
+// or the webhook has disabled it the empty string is returned.
+func idempotencyKeyFromConfig(cfg *config.WebhookConfig, hook config.WebhookWebhooksConfig) (string, error) {
+
+	key := hook.IdempotencyKey
+
+	if key == "" && cfg.Idempotency != nil {
+		key = cfg.Idempotency.Key
+	}
+
+	if key == "" {
+		key = DEFAULT_IDEMPOTENCY_KEY
+	}
+
+	switch {
+	case key == IDEMPOTENCY_KEY_NONE:
+		return "", nil
+	case key == IDEMPOTENCY_KEY_DELIVERY, key == IDEMPOTENCY_KEY_BODY:
+		// pass
+	case strings.HasPrefix(key, IDEMPOTENCY_KEY_HEADER) && len(key) > len(IDEMPOTENCY_KEY_HEADER):
+		// pass
+	default:
+		return "", fmt.Errorf("Invalid idempotency key '%s'", key)
+	}
+
+	if cfg.Idempotency == nil {
+
+		if hook.IdempotencyKey != "" {
+			return "", fmt.Errorf("Idempotency key is set but no idempotency store has been configured")
+		}
+
+		return "", nil
+	}
+
+	return key, nil
+}
+
+// idempotencyKey() returns the value used to identify a webhook message derived from 'key', 'req' and 'body'. If the
+// value can not be derived then the empty string is returned and the message is never considered a duplicate.
+func idempotencyKey(key string, req *http.Request, body []byte) string {
+
+	switch {
+	case key == IDEMPOTENCY_KEY_DELIVERY:
+		return envelope.DeliveryID(req.Header)
+	case key == IDEMPOTENCY_KEY_BODY:
+		sum := sha256.Sum256(body)
+		return hex.EncodeToString(sum[:])
+	case strings.HasPrefix(key, IDEMPOTENCY_KEY_HEADER):
+		return req.Header.Get(strings.TrimPrefix(key, IDEMPOTENCY_KEY_HEADER))
+	default:
+		return ""
+	}
+}
+
+// pendingTTL() returns the amount of time a webhook message for 'entry' is remembered for while it is being processed. This is
+// `DEFAULT_IDEMPOTENCY_PENDING_TTL` or the pipeline timeout for 'entry', whichever is longer, but never longer than the idempotency TTL.
+func (d *WebhookDaemon) pendingTTL(entry *webhookEntry) time.Duration {
+
+	ttl := DEFAULT_IDEMPOTENCY_PENDING_TTL
+
+	if entry.pipeline_timeout > ttl {
+		ttl = entry.pipeline_timeout
+	}
+
+	if ttl > d.seen_ttl {
+		ttl = d.seen_ttl
+	}
+
+	return ttl
+}
+
+// commitMessage() records that the webhook message identified by 'key' has been processed successfully so that it is
+// remembered for the idempotency TTL rather than the pending TTL it was added with.
+func (d *WebhookDaemon) commitMessage(logger *slog.Logger, key string) {
+
+	if key == "" || d.seen == nil {
+		return
+	}
+
+	// Use a new context since the request context may have been cancelled
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := d.seen.Commit(ctx, key, d.seen_ttl)
+
+	if err != nil {
+		logger.Error("Failed to commit idempotency record", "idempotency_key", key, logging.ERROR_KEY, err)
+	}
+}
+
+// forgetMessage() removes the record for 'key' from the idempotency store for 'd' so that a webhook message which
+// failed to be processed can be delivered again.
+func (d *WebhookDaemon) forgetMessage(logger *slog.Logger, key string) {
+
+	if key == "" || d.seen == nil {
+		return
+	}
+
+	// Use a new context since the request context may have been cancelled
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := d.seen.Remove(ctx, key)
+
+	if err != nil {
+		logger.Error("Failed to remove idempotency record", "idempotency_key", key, logging.ERROR_KEY, err)
+	}
+}
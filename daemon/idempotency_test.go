@@ -0,0 +1,166 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/idempotency"
+)
+
+// type recordingStore implements the `idempotency.Store` interface for recording the keys added to an underlying store.
+type recordingStore struct {
+	idempotency.Store
+	// mu is used to synchronize access to 'keys'.
+	mu *sync.Mutex
+	// keys are the keys that have been added to the store.
+	keys []string
+}
+
+// Add() records 'key' and adds it to the underlying store.
+func (s *recordingStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	s.mu.Lock()
+	s.keys = append(s.keys, key)
+	s.mu.Unlock()
+
+	return s.Store.Add(ctx, key, ttl)
+}
+
+func TestWebhookIdempotency(t *testing.T) {
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"receivers": { "insecure": "insecure://" },
+		"dispatchers": { "test": "testdispatch://%s", "failing": "testdispatch://%s-failing?fail=1" },
+		"idempotency": { "store": "mem://" },
+		"webhooks": [
+			{ "endpoint": "/a", "receiver": "insecure", "dispatchers": [ "test" ] },
+			{ "endpoint": "/b", "receiver": "insecure", "dispatchers": [ "test" ] },
+			{ "endpoint": "/body", "receiver": "insecure", "dispatchers": [ "test" ], "idempotency_key": "body" },
+			{ "endpoint": "/none", "receiver": "insecure", "dispatchers": [ "test" ], "idempotency_key": "none" },
+			{ "endpoint": "/failing", "receiver": "insecure", "dispatchers": [ "failing" ] }
+		]
+	}`, name, name)
+
+	d := newTestDaemon(t, cfg)
+	h := newTestHandler(t, d)
+
+	tests := []struct {
+		label     string
+		path      string
+		body      string
+		delivery  string
+		code      int
+		duplicate bool
+	}{
+		{"first delivery", "/a", "hello", "1", http.StatusOK, false},
+		{"redelivery", "/a", "hello", "1", http.StatusOK, true},
+		{"redelivery with a different body", "/a", "world", "1", http.StatusOK, true},
+		{"new delivery", "/a", "hello", "2", http.StatusOK, false},
+		{"same delivery for another endpoint", "/b", "hello", "1", http.StatusOK, false},
+		{"delivery without delivery ID", "/a", "hello", "", http.StatusOK, false},
+		{"redelivery without delivery ID", "/a", "hello", "", http.StatusOK, false},
+		{"first body", "/body", "hello", "3", http.StatusOK, false},
+		{"same body", "/body", "hello", "4", http.StatusOK, true},
+		{"different body", "/body", "world", "3", http.StatusOK, false},
+		{"no key", "/none", "hello", "5", http.StatusOK, false},
+		{"no key redelivery", "/none", "hello", "5", http.StatusOK, false},
+		{"failed delivery", "/failing", "hello", "6", http.StatusInternalServerError, false},
+		{"failed delivery redelivery", "/failing", "hello", "6", http.StatusOK, false},
+		{"successful delivery redelivery", "/failing", "hello", "6", http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+
+		headers := []string{}
+
+		if test.delivery != "" {
+			headers = append(headers, "X-GitHub-Delivery", test.delivery)
+		}
+
+		rsp := post(h, test.path, test.body, headers...)
+
+		if rsp.Code != test.code {
+			t.Fatalf("Expected %d response for '%s', got %d", test.code, test.label, rsp.Code)
+		}
+
+		duplicate := rsp.Header().Get("X-Webhookd-Duplicate") == "true"
+
+		if duplicate != test.duplicate {
+			t.Fatalf("Expected '%s' duplicate to be %t", test.label, test.duplicate)
+		}
+	}
+}
+
+func TestWebhookIdempotencyEndpointSecret(t *testing.T) {
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"receivers": { "secret": "testsecret://?secret=s33kret", "other": "testsecret://?secret=0ther" },
+		"dispatchers": { "test": "testdispatch://%s" },
+		"idempotency": { "store": "mem://" },
+		"webhooks": [
+			{ "endpoint": "/hook/s33kret", "receiver": "secret", "dispatchers": [ "test" ] },
+			{ "endpoint": "/hook/0ther", "receiver": "other", "dispatchers": [ "test" ] }
+		]
+	}`, name)
+
+	d := newTestDaemon(t, cfg)
+
+	s := &recordingStore{
+		Store: d.seen,
+		mu:    new(sync.Mutex),
+	}
+
+	d.SetIdempotencyStore(s, d.seen_ttl)
+
+	h := newTestHandler(t, d)
+
+	for _, path := range []string{"/hook/s33kret", "/hook/0ther", "/hook/s33kret"} {
+		post(h, path, "hello", "X-GitHub-Delivery", "1")
+	}
+
+	if len(getTestDispatcher(t, name).dispatched()) != 2 {
+		t.Fatalf("Expected endpoints whose redacted forms are the same to be identified separately")
+	}
+
+	for _, key := range s.keys {
+
+		if strings.Contains(key, "s33kret") || strings.Contains(key, "0ther") {
+			t.Fatalf("Idempotency key '%s' contains endpoint secret", key)
+		}
+
+		if !strings.HasPrefix(key, "/hook/{SECRET}#") {
+			t.Fatalf("Expected idempotency key '%s' to start with the redacted endpoint", key)
+		}
+	}
+}
+
+func TestEndpointID(t *testing.T) {
+
+	if endpointID("/github", "/github") != "/github" {
+		t.Fatalf("Expected endpoint without secrets to be its own identifier")
+	}
+
+	a := endpointID("/hook/s33kret", "/hook/{SECRET}")
+	b := endpointID("/hook/0ther", "/hook/{SECRET}")
+
+	if a == b {
+		t.Fatalf("Expected endpoints with different secrets to have different identifiers")
+	}
+
+	if a != endpointID("/hook/s33kret", "/hook/{SECRET}") {
+		t.Fatalf("Expected endpoint identifiers to be stable")
+	}
+
+	if strings.Contains(a, "s33kret") || !strings.HasPrefix(a, "/hook/{SECRET}#") {
+		t.Fatalf("Unexpected identifier '%s'", a)
+	}
+}
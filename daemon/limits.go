@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+)
+
+// DEFAULT_RETRY_AFTER is the amount of time clients are asked to wait, using the "Retry-After" header, before retrying
+// a request that was rejected because a concurrency limit was reached.
+const DEFAULT_RETRY_AFTER time.Duration = 5 * time.Second
+
+// type semaphore is a channel used to limit the number of requests that may be processed at the same time. A nil
+// semaphore imposes no limit.
+type semaphore chan bool
+
+// newSemaphore() returns a new `semaphore` instance that allows up to 'max' concurrent holders. If 'max' is zero
+// a nil semaphore is returned.
+func newSemaphore(max int) semaphore {
+
+	if max <= 0 {
+		return nil
+	}
+
+	return make(semaphore, max)
+}
+
+// acquire() attempts to acquire 's' without blocking, returning false if it is already held by the maximum number of holders.
+func (s semaphore) acquire() bool {
+
+	if s == nil {
+		return true
+	}
+
+	select {
+	case s <- true:
+		return true
+	default:
+		return false
+	}
+}
+
+// release() releases a previous successful call to acquire().
+func (s semaphore) release() {
+
+	if s == nil {
+		return
+	}
+
+	<-s
+}
+
+// type limitedBody is a struct implementing the `io.ReadCloser` interface that returns an error once more than
+// a maximum number of bytes have been read, recording that the limit was exceeded.
+type limitedBody struct {
+	io.ReadCloser
+	// remaining is the number of bytes that may still be read.
+	remaining int64
+	// exceeded is a boolean flag signaling that the body was larger than the limit.
+	exceeded bool
+}
+
+// Read() reads up to len(p) bytes from the underlying body, returning an error if the limit has been exceeded.
+func (b *limitedBody) Read(p []byte) (int, error) {
+
+	if b.exceeded {
+		return 0, fmt.Errorf("Request body too large")
+	}
+
+	// Read one byte more than the limit so that a body of exactly the maximum size is not considered too large
+
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		b.exceeded = true
+		return n, fmt.Errorf("Request body too large")
+	}
+
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// saturated() writes a `503 Service Unavailable` response, with a "Retry-After" header, to 'rsp'.
+func saturated(rsp http.ResponseWriter) {
+	rsp.Header().Set("Retry-After", strconv.Itoa(int(DEFAULT_RETRY_AFTER.Seconds())))
+	http.Error(rsp, "Too many concurrent requests", http.StatusServiceUnavailable)
+}
+
+// SetLimitsFromConfig() assigns the global limits for webhook requests from 'cfg'. The default maximum body size
+// is applied to each webhook when webhooks are added from a config.
+func (d *WebhookDaemon) SetLimitsFromConfig(cfg *config.WebhookConfig) error {
+
+	if cfg.Limits == nil {
+		return nil
+	}
+
+	if cfg.Limits.MaxConcurrent < 0 {
+		return fmt.Errorf("Invalid max concurrent value, %d", cfg.Limits.MaxConcurrent)
+	}
+
+	if cfg.Limits.MaxBodySize < 0 {
+		return fmt.Errorf("Invalid max body size, %d", cfg.Limits.MaxBodySize)
+	}
+
+	d.SetMaxConcurrent(cfg.Limits.MaxConcurrent)
+	return nil
+}
+
+// SetMaxConcurrent() assigns the maximum number of webhook requests, across all webhooks, that 'd' will process at the same time.
+// If 'max' is zero there is no limit.
+func (d *WebhookDaemon) SetMaxConcurrent(max int) {
+	d.concurrent = newSemaphore(max)
+}
+
+// maxBodySize() returns the maximum body size for 'hook' falling back to the default maximum body size defined in 'cfg'.
+func maxBodySize(cfg *config.WebhookConfig, hook config.WebhookWebhooksConfig) int64 {
+
+	if hook.MaxBodySize != 0 {
+		return hook.MaxBodySize
+	}
+
+	if cfg.Limits != nil {
+		return cfg.Limits.MaxBodySize
+	}
+
+	return 0
+}
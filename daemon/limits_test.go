@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSemaphore(t *testing.T) {
+
+	var s semaphore
+
+	if newSemaphore(0) != nil {
+		t.Fatalf("Expected zero semaphore to be nil")
+	}
+
+	for i := 0; i < 10; i++ {
+
+		if !s.acquire() {
+			t.Fatalf("Expected nil semaphore to always be acquired")
+		}
+	}
+
+	s.release()
+
+	s = newSemaphore(2)
+
+	if !s.acquire() || !s.acquire() {
+		t.Fatalf("Expected semaphore to be acquired twice")
+	}
+
+	if s.acquire() {
+		t.Fatalf("Expected semaphore not to be acquired a third time")
+	}
+
+	s.release()
+
+	if !s.acquire() {
+		t.Fatalf("Expected semaphore to be acquired after being released")
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+
+	tests := []struct {
+		body     string
+		limit    int64
+		exceeded bool
+	}{
+		{"", 10, false},
+		{"hello", 10, false},
+		{"helloworld", 10, false},
+		{"hello world", 10, true},
+		{strings.Repeat("a", 100000), 65536, true},
+		{strings.Repeat("a", 65536), 65536, false},
+	}
+
+	for _, test := range tests {
+
+		b := &limitedBody{
+			ReadCloser: io.NopCloser(strings.NewReader(test.body)),
+			remaining:  test.limit,
+		}
+
+		out, err := io.ReadAll(b)
+
+		if b.exceeded != test.exceeded {
+			t.Fatalf("Expected %d byte body with %d byte limit exceeded to be %t", len(test.body), test.limit, test.exceeded)
+		}
+
+		if test.exceeded {
+
+			if err == nil {
+				t.Fatalf("Expected %d byte body with %d byte limit to fail", len(test.body), test.limit)
+			}
+
+			if int64(len(out)) != test.limit {
+				t.Fatalf("Expected %d bytes to be read, got %d", test.limit, len(out))
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to read %d byte body with %d byte limit, %v", len(test.body), test.limit, err)
+		}
+
+		if string(out) != test.body {
+			t.Fatalf("Unexpected body for %d byte body with %d byte limit", len(test.body), test.limit)
+		}
+	}
+}
+
+func TestWebhookMaxBodySize(t *testing.T) {
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"receivers": { "insecure": "insecure://" },
+		"dispatchers": { "test": "testdispatch://%s" },
+		"limits": { "max_body_size": 20 },
+		"webhooks": [
+			{ "endpoint": "/limited", "receiver": "insecure", "dispatchers": [ "test" ], "max_body_size": 10 },
+			{ "endpoint": "/default", "receiver": "insecure", "dispatchers": [ "test" ] }
+		]
+	}`, name)
+
+	d := newTestDaemon(t, cfg)
+	h := newTestHandler(t, d)
+
+	tests := []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/limited", "helloworld", http.StatusOK},
+		{"/limited", "hello world", http.StatusRequestEntityTooLarge},
+		{"/default", "hello world", http.StatusOK},
+		{"/default", strings.Repeat("a", 21), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+
+		rsp := post(h, test.path, test.body)
+
+		if rsp.Code != test.code {
+			t.Fatalf("Expected %d response for %d bytes to %s, got %d", test.code, len(test.body), test.path, rsp.Code)
+		}
+
+		// Requests without a Content-Length header are limited as they are read
+
+		req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+		req.ContentLength = -1
+
+		rsp = httptest.NewRecorder()
+		h(rsp, req)
+
+		if rsp.Code != test.code {
+			t.Fatalf("Expected %d response for %d bytes of unknown length to %s, got %d", test.code, len(test.body), test.path, rsp.Code)
+		}
+	}
+
+	if len(getTestDispatcher(t, name).dispatched()) != 4 {
+		t.Fatalf("Expected 4 messages to be dispatched, got %d", len(getTestDispatcher(t, name).dispatched()))
+	}
+}
+
+func TestWebhookMaxConcurrent(t *testing.T) {
+
+	tests := []struct {
+		label   string
+		limits  string
+		webhook string
+	}{
+		{"per-webhook", `{}`, `"max_concurrent": 1`},
+		{"global", `{ "max_concurrent": 1 }`, `"max_concurrent": 0`},
+	}
+
+	for _, test := range tests {
+
+		name := testDispatcherName()
+
+		cfg := fmt.Sprintf(`{
+			"receivers": { "insecure": "insecure://" },
+			"dispatchers": { "test": "testdispatch://%s?block=true" },
+			"limits": %s,
+			"webhooks": [ { "endpoint": "/limited", "receiver": "insecure", "dispatchers": [ "test" ], %s } ]
+		}`, name, test.limits, test.webhook)
+
+		d := newTestDaemon(t, cfg)
+		h := newTestHandler(t, d)
+
+		s := d.concurrent
+
+		if s == nil {
+			s = d.hooks["/limited"].concurrent
+		}
+
+		done := make(chan int)
+
+		go func() {
+			done <- post(h, "/limited", "first").Code
+		}()
+
+		waitFor(t, "first request to acquire the semaphore", func() bool {
+			return len(s) == 1
+		})
+
+		rsp := post(h, "/limited", "second")
+
+		if rsp.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected %s concurrent request to be rejected with %d, got %d", test.label, http.StatusServiceUnavailable, rsp.Code)
+		}
+
+		if rsp.Header().Get("Retry-After") == "" {
+			t.Fatalf("Expected %s concurrent request to have a Retry-After header", test.label)
+		}
+
+		close(getTestDispatcher(t, name).release)
+
+		code := <-done
+
+		if code != http.StatusOK {
+			t.Fatalf("Expected %s first request to succeed, got %d", test.label, code)
+		}
+
+		if len(s) != 0 {
+			t.Fatalf("Expected %s semaphore to be released", test.label)
+		}
+
+		rsp = post(h, "/limited", "third")
+
+		if rsp.Code != http.StatusOK {
+			t.Fatalf("Expected %s request after release to succeed, got %d", test.label, rsp.Code)
+		}
+	}
+}
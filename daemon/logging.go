@@ -0,0 +1,36 @@
+package daemon
+
+import (
+	"log"
+	"log/slog"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+)
+
+// REQUEST_ID_HEADER is the response header containing the ID of each webhook request. It is the same as the delivery ID
+// which is taken from the "X-GitHub-Delivery" (or another header listed in `envelope.DELIVERY_HEADERS`) request header, if present, or generated otherwise.
+const REQUEST_ID_HEADER string = "X-Webhookd-Request-Id"
+
+// SetStructuredLogger() assigns 'sl' as the `slog.Logger` instance used to log messages. Messages logged for a webhook request,
+// or queued message, include "request_id" and "endpoint" attributes. If 'sl' is nil then messages are written to the `log.Logger`
+// instance passed to `HandlerFuncWithLogger`, `StartWithLogger`, `ReloadWithLogger` or `ShutdownWithLogger`.
+func (d *WebhookDaemon) SetStructuredLogger(sl *slog.Logger) {
+	d.structured = sl
+}
+
+// slogger() returns the `slog.Logger` instance used to log messages for 'd'. If 'd' does not have a structured logger then
+// records are written to 'logger'.
+func (d *WebhookDaemon) slogger(logger *log.Logger) *slog.Logger {
+
+	if d.structured != nil {
+		return d.structured
+	}
+
+	return slog.New(logging.NewLogHandler(logger))
+}
+
+// requestLogger() returns a `slog.Logger` instance, derived from 'sl', for logging messages about the request (or queued message)
+// whose ID is 'id' for 'endpoint'.
+func requestLogger(sl *slog.Logger, id string, endpoint string) *slog.Logger {
+	return sl.With(logging.REQUEST_ID_KEY, id, logging.ENDPOINT_KEY, endpoint)
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/metrics"
+)
+
+// DEFAULT_METRICS_PATH is the default relative URI of the metrics endpoint.
+const DEFAULT_METRICS_PATH string = "/metrics"
+
+// OUTCOME_OK is the outcome for a webhook request whose message was dispatched successfully.
+const OUTCOME_OK string = "ok"
+
+// OUTCOME_ACCEPTED is the outcome for an asynchronous webhook request whose message was queued.
+const OUTCOME_ACCEPTED string = "accepted"
+
+// OUTCOME_HALTED is the outcome for a webhook request that was halted by its receiver or a transformation.
+const OUTCOME_HALTED string = "halted"
+
+// OUTCOME_EMPTY is the outcome for a webhook request that was halted, and logged, because its receiver or a transformation
+// produced an empty body and the webhook's empty body policy is "log".
+const OUTCOME_EMPTY string = "empty"
+
+// OUTCOME_REJECTED is the outcome for a webhook request that was rejected by its receiver or a transformation.
+const OUTCOME_REJECTED string = "rejected"
+
+// OUTCOME_THROTTLED is the outcome for a webhook request that was rejected because a concurrency limit was reached.
+const OUTCOME_THROTTLED string = "throttled"
+
+// OUTCOME_DUPLICATE is the outcome for a webhook request whose message had already been seen.
+const OUTCOME_DUPLICATE string = "duplicate"
+
+// OUTCOME_FAILED is the outcome for a webhook request where one or more dispatchers, or the queue, failed.
+const OUTCOME_FAILED string = "failed"
+
+// OUTCOME_TIMEOUT is the outcome for a webhook request whose message was not transformed, or dispatched, before its deadline.
+const OUTCOME_TIMEOUT string = "timeout"
+
+// STAGE_RECEIVE is the stage label for the time it takes to receive a webhook message.
+const STAGE_RECEIVE string = "receive"
+
+// STAGE_TRANSFORM is the stage label for the time it takes to apply all of the transformations for a webhook message.
+const STAGE_TRANSFORM string = "transform"
+
+// STAGE_DISPATCH is the stage label for the time it takes to relay a webhook message to all of its dispatchers.
+const STAGE_DISPATCH string = "dispatch"
+
+// STAGE_PROCESS is the stage label for the time it takes to process a webhook message from start to finish.
+const STAGE_PROCESS string = "process"
+
+// type daemonMetrics is a struct containing the metrics recorded by a `WebhookDaemon` instance.
+type daemonMetrics struct {
+	registry *metrics.Registry
+	// requests counts webhook requests by endpoint and outcome.
+	requests *metrics.CounterVec
+	// stages records the duration of each pipeline stage by endpoint and stage.
+	stages *metrics.HistogramVec
+	// dispatches counts dispatcher outcomes by endpoint, dispatcher and status.
+	dispatches *metrics.CounterVec
+	// rate_limited counts messages that exceeded a rate limit by endpoint and action.
+	rate_limited *metrics.CounterVec
+	// in_flight is the number of webhook messages currently being processed.
+	in_flight *metrics.Gauge
+}
+
+func newDaemonMetrics() *daemonMetrics {
+
+	r := metrics.NewRegistry()
+
+	m := &daemonMetrics{
+		registry:     r,
+		requests:     r.NewCounterVec("webhookd_requests_total", "Total number of webhook requests by endpoint and outcome.", "endpoint", "outcome"),
+		stages:       r.NewHistogramVec("webhookd_stage_duration_seconds", "Time spent in each stage of the webhook pipeline.", nil, "endpoint", "stage"),
+		dispatches:   r.NewCounterVec("webhookd_dispatches_total", "Total number of dispatches by endpoint, dispatcher and status.", "endpoint", "dispatcher", "status"),
+		rate_limited: r.NewCounterVec("webhookd_rate_limited_total", "Total number of messages that exceeded a rate limit by endpoint and action.", "endpoint", "action"),
+		in_flight:    r.NewGauge("webhookd_in_flight", "Number of webhook messages currently being processed."),
+	}
+
+	return m
+}
+
+func (m *daemonMetrics) observeStage(endpoint string, stage string, d time.Duration) {
+	m.stages.Observe(d.Seconds(), endpoint, stage)
+}
+
+// SetMetricsFromConfig() assigns the relative URI of the metrics endpoint from 'cfg'.
+func (d *WebhookDaemon) SetMetricsFromConfig(cfg *config.WebhookConfig) error {
+
+	if cfg.Metrics == nil || cfg.Metrics.Path == "" {
+		return nil
+	}
+
+	if !strings.HasPrefix(cfg.Metrics.Path, "/") {
+		return fmt.Errorf("Invalid metrics path '%s', must start with '/'", cfg.Metrics.Path)
+	}
+
+	metrics_path := path.Clean(cfg.Metrics.Path)
+
+	if metrics_path == d.HealthzPath() || metrics_path == d.ReadyzPath() {
+		return fmt.Errorf("Metrics path '%s' conflicts with health endpoints", metrics_path)
+	}
+
+	d.metrics_path = metrics_path
+	return nil
+}
+
+// MetricsPath() returns the relative URI of the metrics endpoint for 'd'.
+func (d *WebhookDaemon) MetricsPath() string {
+	return d.metrics_path
+}
+
+// MetricsHandlerFunc() returns a `http.HandlerFunc` that writes the metrics for 'd' using the Prometheus text-based exposition format.
+func (d *WebhookDaemon) MetricsHandlerFunc() (http.HandlerFunc, error) {
+	return d.metrics.registry.HandlerFunc()
+}
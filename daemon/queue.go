@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/queue"
+)
+
+// DEFAULT_QUEUE_POLL_INTERVAL is the default amount of time between checks for queued messages that have not been handed
+// off to a worker, for example because every worker was busy when they were queued.
+const DEFAULT_QUEUE_POLL_INTERVAL time.Duration = 30 * time.Second
+
+// type scheduledMessages is a struct used to track which queued messages have been handed off to workers so that each
+// message is only processed once, even if it is read from the queue more than once.
+type scheduledMessages struct {
+	// mu is used to synchronize access to 'scheduled' and 'completed'.
+	mu *sync.Mutex
+	// scheduled is the set of keys for messages that have been handed off to a worker but not completed.
+	scheduled map[string]bool
+	// completed maps the keys of messages that have been completed to the time they were completed. Messages which
+	// were read from the queue by a poll that started before they were removed are not handed off again.
+	completed map[string]time.Time
+}
+
+// newScheduledMessages() returns a new `scheduledMessages` instance.
+func newScheduledMessages() *scheduledMessages {
+
+	s := &scheduledMessages{
+		mu:        new(sync.Mutex),
+		scheduled: make(map[string]bool),
+		completed: make(map[string]time.Time),
+	}
+
+	return s
+}
+
+// schedule() hands 'msg' off to the first available worker without blocking. It returns false if 'd' is shutting down
+// or every worker is busy and there is no room to buffer 'msg', in which case it is left in the queue and handed off by
+// a later poll. Messages that have already been handed off, or completed since 'since', are not handed off again.
+func (d *WebhookDaemon) schedule(msg *queue.Message, since time.Time) bool {
+
+	select {
+	case <-d.stopping:
+		return false
+	default:
+		// pass
+	}
+
+	d.scheduled.mu.Lock()
+	defer d.scheduled.mu.Unlock()
+
+	if d.scheduled.scheduled[msg.Key] {
+		return true
+	}
+
+	t, ok := d.scheduled.completed[msg.Key]
+
+	if ok && !t.Before(since) {
+		return true
+	}
+
+	select {
+	case d.messages <- msg:
+		d.scheduled.scheduled[msg.Key] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// complete() records that a worker has finished with 'msg'.
+func (d *WebhookDaemon) complete(msg *queue.Message) {
+
+	d.scheduled.mu.Lock()
+	defer d.scheduled.mu.Unlock()
+
+	delete(d.scheduled.scheduled, msg.Key)
+	d.scheduled.completed[msg.Key] = time.Now()
+}
+
+// schedulePending() hands off the messages in the queue, that have not already been handed off, to workers until
+// there is no room left to buffer them. It returns false if the queue could not be read.
+func (d *WebhookDaemon) schedulePending(ctx context.Context, logger *slog.Logger) bool {
+
+	since := time.Now()
+
+	// Messages completed before this poll started were removed from the queue before it was read so they
+	// can be forgotten. If they could not be removed they will be processed again.
+
+	d.scheduled.mu.Lock()
+
+	for k, t := range d.scheduled.completed {
+
+		if t.Before(since) {
+			delete(d.scheduled.completed, k)
+		}
+	}
+
+	d.scheduled.mu.Unlock()
+
+	pending, err := d.queue.Pending(ctx)
+
+	if err != nil {
+
+		if pending == nil {
+			logger.Error("Failed to retrieve pending messages from queue", logging.ERROR_KEY, err)
+			return false
+		}
+
+		logger.Warn("Skipping queued deliveries that could not be read", logging.ERROR_KEY, err)
+	}
+
+	for _, msg := range pending {
+
+		if !d.schedule(msg, since) {
+			break
+		}
+	}
+
+	return true
+}
+
+// poll() hands off messages in the queue to workers every 'd.poll_interval' until 'd' starts shutting down.
+func (d *WebhookDaemon) poll(ctx context.Context, logger *slog.Logger) {
+
+	ticker := time.NewTicker(d.poll_interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stopping:
+			return
+		case <-ticker.C:
+			d.schedulePending(ctx, logger)
+		}
+	}
+}
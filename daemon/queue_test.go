@@ -0,0 +1,326 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/deadletter"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/queue"
+)
+
+// pendingCount() returns the number of messages in the queue for 'd'.
+func pendingCount(t *testing.T, d *WebhookDaemon) int {
+
+	pending, err := d.queue.Pending(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve pending messages, %v", err)
+	}
+
+	return len(pending)
+}
+
+func TestAsyncWebhook(t *testing.T) {
+
+	ctx := context.Background()
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"queue": { "uri": "file://%s", "workers": 1 },
+		"receivers": { "insecure": "insecure://" },
+		"dispatchers": { "test": "testdispatch://%s" },
+		"webhooks": [ { "endpoint": "/async", "receiver": "insecure", "dispatchers": [ "test" ], "async": true } ]
+	}`, t.TempDir(), name)
+
+	d := newTestDaemon(t, cfg)
+	h := newTestHandler(t, d)
+
+	err := d.startWorkers(ctx, d.slogger(testLogger()))
+
+	if err != nil {
+		t.Fatalf("Failed to start workers, %v", err)
+	}
+
+	rsp := post(h, "/async", "hello world")
+
+	if rsp.Code != http.StatusAccepted {
+		t.Fatalf("Expected %d response, got %d", http.StatusAccepted, rsp.Code)
+	}
+
+	dr := getTestDispatcher(t, name)
+
+	waitFor(t, "message to be dispatched", func() bool {
+		return len(dr.dispatched()) == 1
+	})
+
+	if dr.dispatched()[0] != "hello world" {
+		t.Fatalf("Unexpected message, '%s'", dr.dispatched()[0])
+	}
+
+	waitFor(t, "message to be removed from queue", func() bool {
+		return pendingCount(t, d) == 0
+	})
+}
+
+func TestAsyncWebhookBusyWorkers(t *testing.T) {
+
+	ctx := context.Background()
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"queue": { "uri": "file://%s", "workers": 1, "poll_interval": "20ms" },
+		"receivers": { "insecure": "insecure://" },
+		"dispatchers": { "test": "testdispatch://%s?block=true" },
+		"webhooks": [ { "endpoint": "/async", "receiver": "insecure", "dispatchers": [ "test" ], "async": true } ]
+	}`, t.TempDir(), name)
+
+	d := newTestDaemon(t, cfg)
+	h := newTestHandler(t, d)
+
+	err := d.startWorkers(ctx, d.slogger(testLogger()))
+
+	if err != nil {
+		t.Fatalf("Failed to start workers, %v", err)
+	}
+
+	// Requests are accepted without waiting for the (single, blocked) worker even though there is only room
+	// to buffer one message
+
+	count := 5
+
+	for i := 0; i < count; i++ {
+
+		rsp := post(h, "/async", fmt.Sprintf("message %d", i))
+
+		if rsp.Code != http.StatusAccepted {
+			t.Fatalf("Expected %d response, got %d", http.StatusAccepted, rsp.Code)
+		}
+	}
+
+	dr := getTestDispatcher(t, name)
+
+	// Let the queue be polled a few times while the worker is blocked to ensure messages are not handed off twice
+
+	time.Sleep(100 * time.Millisecond)
+	close(dr.release)
+
+	waitFor(t, "messages to be removed from queue", func() bool {
+		return pendingCount(t, d) == 0
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	dispatched := dr.dispatched()
+
+	if len(dispatched) != count {
+		t.Fatalf("Expected %d messages to be dispatched, got %d (%v)", count, len(dispatched), dispatched)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, body := range dispatched {
+
+		if seen[body] {
+			t.Fatalf("Message '%s' was dispatched more than once", body)
+		}
+
+		seen[body] = true
+	}
+}
+
+func TestAsyncWebhookUnconfiguredEndpoint(t *testing.T) {
+
+	ctx := context.Background()
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"queue": { "uri": "file://%s", "workers": 1 },
+		"dead_letter": "file://%s",
+		"receivers": { "insecure": "insecure://" },
+		"dispatchers": { "test": "testdispatch://%s" },
+		"webhooks": [ { "endpoint": "/async", "receiver": "insecure", "dispatchers": [ "test" ], "async": true } ]
+	}`, t.TempDir(), t.TempDir(), name)
+
+	d := newTestDaemon(t, cfg)
+
+	// A message for a webhook which was removed before it was processed
+
+	msg := &queue.Message{
+		ID:       "delivery-1",
+		Endpoint: "/removed",
+		Body:     []byte("hello world"),
+		Created:  time.Now().Unix(),
+	}
+
+	err := d.queue.Push(ctx, msg)
+
+	if err != nil {
+		t.Fatalf("Failed to push message, %v", err)
+	}
+
+	err = d.startWorkers(ctx, d.slogger(testLogger()))
+
+	if err != nil {
+		t.Fatalf("Failed to start workers, %v", err)
+	}
+
+	waitFor(t, "message to be removed from queue", func() bool {
+		return pendingCount(t, d) == 0
+	})
+
+	var entries []*deadletter.Entry
+
+	waitFor(t, "dead letter to be written", func() bool {
+
+		entries, err = d.deadletters.List(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to list dead letters, %v", err)
+		}
+
+		return len(entries) == 1
+	})
+
+	if entries[0].DeliveryID != msg.ID || string(entries[0].Body) != "hello world" {
+		t.Fatalf("Unexpected dead letter, %v", entries[0])
+	}
+
+	if len(getTestDispatcher(t, name).dispatched()) != 0 {
+		t.Fatalf("Expected message not to be dispatched")
+	}
+}
+
+func TestAsyncWebhookEndpointSecret(t *testing.T) {
+
+	ctx := context.Background()
+
+	name := testDispatcherName()
+
+	queue_root := t.TempDir()
+	deadletter_root := t.TempDir()
+
+	cfg := fmt.Sprintf(`{
+		"queue": { "uri": "file://%s", "workers": 1, "poll_interval": "20ms" },
+		"dead_letter": "file://%s",
+		"receivers": { "secret": "testsecret://?secret=s33kret" },
+		"dispatchers": { "test": "testdispatch://%s", "failing": "testdispatch://%s-failing?fail=100" },
+		"webhooks": [ { "endpoint": "/hook/s33kret", "receiver": "secret", "dispatchers": [ "test", "failing" ], "async": true } ]
+	}`, queue_root, deadletter_root, name, name)
+
+	d := newTestDaemon(t, cfg)
+	h := newTestHandler(t, d)
+
+	// Workers have not been started so the message stays in the queue
+
+	rsp := post(h, "/hook/s33kret", "hello world")
+
+	if rsp.Code != http.StatusAccepted {
+		t.Fatalf("Expected %d response, got %d", http.StatusAccepted, rsp.Code)
+	}
+
+	assertNoSecret(t, queue_root, "s33kret")
+
+	pending, err := d.queue.Pending(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve pending messages, %v", err)
+	}
+
+	if len(pending) != 1 || pending[0].Endpoint != endpointID("/hook/s33kret", "/hook/{SECRET}") {
+		t.Fatalf("Unexpected pending messages, %v", pending)
+	}
+
+	// Messages queued by earlier versions are identified by their unredacted endpoint
+
+	legacy := &queue.Message{
+		ID:       "legacy",
+		Endpoint: "/hook/s33kret",
+		Body:     []byte("legacy"),
+		Created:  time.Now().Unix(),
+	}
+
+	err = d.queue.Push(ctx, legacy)
+
+	if err != nil {
+		t.Fatalf("Failed to push message, %v", err)
+	}
+
+	err = d.startWorkers(ctx, d.slogger(testLogger()))
+
+	if err != nil {
+		t.Fatalf("Failed to start workers, %v", err)
+	}
+
+	dr := getTestDispatcher(t, name)
+
+	waitFor(t, "messages to be dispatched", func() bool {
+		return len(dr.dispatched()) == 2
+	})
+
+	var entries []*deadletter.Entry
+
+	waitFor(t, "dead letters to be written", func() bool {
+
+		entries, err = d.deadletters.List(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to list dead letters, %v", err)
+		}
+
+		return len(entries) == 2
+	})
+
+	for _, e := range entries {
+
+		if e.Endpoint != endpointID("/hook/s33kret", "/hook/{SECRET}") {
+			t.Fatalf("Unexpected dead letter endpoint '%s'", e.Endpoint)
+		}
+	}
+
+	assertNoSecret(t, deadletter_root, "s33kret")
+}
+
+// assertNoSecret() fails the test if the names or contents of any of the files in 'root' contain 'secret'.
+func assertNoSecret(t *testing.T, root string, secret string) {
+
+	err := filepath.WalkDir(root, func(path string, info fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(path, secret) {
+			t.Fatalf("File name '%s' contains secret", path)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(string(body), secret) {
+			t.Fatalf("File '%s' contains secret", path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to walk %s, %v", root, err)
+	}
+}
@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-webhookd/v3/webhook"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/ratelimit"
+)
+
+// DEFAULT_RATE_LIMIT_INTERVAL is the default interval over which a webhook's rate limit is applied.
+const DEFAULT_RATE_LIMIT_INTERVAL time.Duration = 1 * time.Minute
+
+// RATE_LIMIT_KEY_REPOSITORY is the rate limit key for deriving a separate rate limit for each (GitHub) repository.
+const RATE_LIMIT_KEY_REPOSITORY string = "repository"
+
+// RATE_LIMIT_KEY_HEADER is the prefix for rate limit keys that derive a separate rate limit for each value of a request header.
+const RATE_LIMIT_KEY_HEADER string = "header:"
+
+// RATE_LIMIT_REJECTED is the action label for messages that were rejected because they exceeded a rate limit.
+const RATE_LIMIT_REJECTED string = "rejected"
+
+// RATE_LIMIT_OVERFLOW is the action label for messages that were relayed to an overflow dispatcher because they exceeded a rate limit.
+const RATE_LIMIT_OVERFLOW string = "overflow"
+
+// rateLimitFromConfig() assigns the rate limiter, rate limit key and overflow webhook for 'entry' from 'rl_cfg'.
+func rateLimitFromConfig(ctx context.Context, cfg *config.WebhookConfig, rl_cfg *config.WebhookRateLimitConfig, entry *webhookEntry) error {
+
+	interval := DEFAULT_RATE_LIMIT_INTERVAL
+
+	if rl_cfg.Interval != "" {
+
+		i, err := time.ParseDuration(rl_cfg.Interval)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse interval, %w", err)
+		}
+
+		interval = i
+	}
+
+	err := ensureRateLimitKey(rl_cfg.Key)
+
+	if err != nil {
+		return err
+	}
+
+	l, err := ratelimit.NewLimiter(rl_cfg.Requests, interval, rl_cfg.Burst)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create rate limiter, %w", err)
+	}
+
+	entry.limiter = l
+	entry.rate_limit_key = rl_cfg.Key
+
+	if rl_cfg.Overflow == "" {
+		return nil
+	}
+
+	dispatcher_uri, err := cfg.GetDispatcherConfigByName(rl_cfg.Overflow)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get overflow dispatcher configuration for '%s', %w", rl_cfg.Overflow, err)
+	}
+
+	dr, err := dispatcher.NewDispatcher(ctx, dispatcher_uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create overflow dispatcher for '%s', %w", dispatcher_uri, err)
+	}
+
+	wh, err := webhook.NewWebhook(ctx, entry.endpoint, entry.webhook.Receiver(), entry.webhook.Transformations(), []webhookd.WebhookDispatcher{dr})
+
+	if err != nil {
+		return fmt.Errorf("Failed to create overflow webhook, %w", err)
+	}
+
+	// The overflow webhook is processed exactly like the webhook it is derived from except for its dispatchers
+
+	entry.overflow = entry.derive(wh, []string{rl_cfg.Overflow})
+	return nil
+}
+
+// ensureRateLimitKey() returns an error if 'key' is not a valid rate limit key.
+func ensureRateLimitKey(key string) error {
+
+	switch {
+	case key == "", key == RATE_LIMIT_KEY_REPOSITORY:
+		return nil
+	case strings.HasPrefix(key, RATE_LIMIT_KEY_HEADER) && len(key) > len(RATE_LIMIT_KEY_HEADER):
+		return nil
+	default:
+		return fmt.Errorf("Invalid rate limit key '%s'", key)
+	}
+}
+
+// rateLimitKey() returns the value used to select the token bucket for a message derived from 'key', 'req' and 'body'.
+// If the value can not be derived then the empty string is returned and the message shares a bucket with all the other
+// messages for which no value could be derived.
+func rateLimitKey(key string, req *http.Request, body []byte) string {
+
+	switch {
+	case key == RATE_LIMIT_KEY_REPOSITORY:
+		return repositoryName(body)
+	case strings.HasPrefix(key, RATE_LIMIT_KEY_HEADER):
+		return req.Header.Get(strings.TrimPrefix(key, RATE_LIMIT_KEY_HEADER))
+	default:
+		return ""
+	}
+}
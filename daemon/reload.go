@@ -0,0 +1,361 @@
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/health"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/ratelimit"
+)
+
+// type webhookEntry is a struct containing a webhook and the information needed to process messages it receives.
+type webhookEntry struct {
+	// endpoint is the relative URI of the webhook.
+	endpoint string
+	// redacted is the relative URI of the webhook with any secrets replaced. It is used instead of 'endpoint' in log messages,
+	// metrics, traces, audit records, delivery outcomes and the admin API.
+	redacted string
+	// id is the identifier for the webhook used in records that are stored outside of the daemon, for example idempotency
+	// store keys. It does not contain any secrets; see `endpointID`.
+	id string
+	// webhook is the `webhookd.WebhookHandler` instance for the webhook.
+	webhook webhookd.WebhookHandler
+	// receiver is the label of the webhook's receiver.
+	receiver string
+	// transformations are the labels of the webhook's transformations, in the same order as `webhookd.WebhookHandler.Transformations()`.
+	transformations []string
+	// dispatchers are the labels of the webhook's dispatchers, in the same order as `webhookd.WebhookHandler.Dispatchers()`.
+	dispatchers []string
+	// async is a boolean flag signaling that the webhook's messages are processed asynchronously.
+	async bool
+	// policy is the status policy used to derive HTTP status codes from dispatcher outcomes.
+	policy string
+	// empty_body is the policy for messages whose body is empty after they have been received or transformed.
+	empty_body string
+	// max_body_size is the maximum size, in bytes, of a request body. If zero there is no limit.
+	max_body_size int64
+	// concurrent limits the number of requests for the webhook that are processed at the same time.
+	concurrent semaphore
+	// limiter is the optional rate limiter for the webhook.
+	limiter *ratelimit.Limiter
+	// rate_limit_key is the rate limit key used to select a token bucket in 'limiter' for each message.
+	rate_limit_key string
+	// idempotency_key is the idempotency key used to identify duplicate messages. If empty messages are never considered duplicates.
+	idempotency_key string
+	// overflow is the optional webhook used to process messages that exceed the rate limit.
+	overflow *webhookEntry
+	// pipeline_timeout is the maximum amount of time to transform and dispatch a message. If zero there is no limit.
+	pipeline_timeout time.Duration
+	// transformation_timeout is the maximum amount of time for each transformation. If zero there is no limit.
+	transformation_timeout time.Duration
+	// dispatcher_timeout is the maximum amount of time for each dispatcher. If zero there is no limit.
+	dispatcher_timeout time.Duration
+	// route_name is the name of the route the webhook relays messages to. It is empty for the default route.
+	route_name string
+	// routes are the optional routes used to select the dispatchers for each message.
+	routes []*webhookRoute
+}
+
+// endpointID() returns the identifier for the webhook 'endpoint', whose redacted form is 'redacted', used in records that are
+// stored outside of the daemon. If 'endpoint' does not contain any secrets it is returned as-is. Otherwise the identifier is
+// 'redacted' followed by "#" and the hex-encoded SHA-256 hash of 'endpoint' so that endpoints whose redacted forms are the
+// same are still distinguished.
+func endpointID(endpoint string, redacted string) string {
+
+	if redacted == endpoint {
+		return endpoint
+	}
+
+	sum := sha256.Sum256([]byte(endpoint))
+	return fmt.Sprintf("%s#%s", redacted, hex.EncodeToString(sum[:]))
+}
+
+// derive() returns a new `webhookEntry` instance which is processed exactly like 'e' except that messages are relayed to
+// the dispatchers of 'wh', labeled 'dispatchers'. The new entry does not have a rate limiter, overflow webhook or routes.
+func (e *webhookEntry) derive(wh webhookd.WebhookHandler, dispatchers []string) *webhookEntry {
+
+	return &webhookEntry{
+		endpoint:               e.endpoint,
+		redacted:               e.redacted,
+		id:                     e.id,
+		webhook:                wh,
+		receiver:               e.receiver,
+		transformations:        e.transformations,
+		dispatchers:            dispatchers,
+		async:                  e.async,
+		policy:                 e.policy,
+		empty_body:             e.empty_body,
+		max_body_size:          e.max_body_size,
+		concurrent:             e.concurrent,
+		idempotency_key:        e.idempotency_key,
+		pipeline_timeout:       e.pipeline_timeout,
+		transformation_timeout: e.transformation_timeout,
+		dispatcher_timeout:     e.dispatcher_timeout,
+		route_name:             e.route_name,
+	}
+}
+
+// acquireWebhookEntry() returns the `webhookEntry` instance for 'endpoint', a function to call when it is no longer
+// being used and a boolean value indicating whether it exists. Webhooks that are replaced when the config is reloaded
+// are not closed until every function returned for them has been called.
+func (d *WebhookDaemon) acquireWebhookEntry(endpoint string) (*webhookEntry, func(), bool) {
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entry, ok := d.hooks[endpoint]
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	active := d.hooks_active
+	active.Add(1)
+
+	return entry, active.Done, true
+}
+
+// acquireWebhookEntryByID() returns the `webhookEntry` instance whose identifier (see `endpointID`) is 'id', a function to
+// call when it is no longer being used and a boolean value indicating whether it exists. Messages queued by earlier versions
+// of the daemon are identified by their (unredacted) endpoint so entries are also matched by endpoint.
+func (d *WebhookDaemon) acquireWebhookEntryByID(id string) (*webhookEntry, func(), bool) {
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	entry, ok := d.hooks[id]
+
+	if !ok {
+
+		for _, e := range d.hooks {
+
+			if e.id == id {
+				entry = e
+				ok = true
+				break
+			}
+		}
+	}
+
+	if !ok {
+		return nil, nil, false
+	}
+
+	active := d.hooks_active
+	active.Add(1)
+
+	return entry, active.Done, true
+}
+
+// Reload() replaces the webhooks for 'd', and their receivers, transformations, dispatchers and readiness probes, with
+// those defined in 'cfg'.
+func (d *WebhookDaemon) Reload(ctx context.Context, cfg *config.WebhookConfig) error {
+	logger := log.Default()
+	return d.ReloadWithLogger(ctx, cfg, logger)
+}
+
+// ReloadWithLogger() replaces the webhooks for 'd', and their receivers, transformations, dispatchers and readiness probes,
+// with those defined in 'cfg' logging events to 'logger'. If 'cfg' is invalid then an error is returned and the current
+// webhooks are left unchanged. Requests that are already being processed will finish using the webhooks they started with
+// after which the dispatchers for the webhooks that were replaced, and the readiness probes that were replaced, are closed.
+// Changes to other properties (daemon, queue, dead letter, health prefix and timeout, metrics, tracing and shutdown timeout) require a restart
+// and are logged but otherwise ignored.
+func (d *WebhookDaemon) ReloadWithLogger(ctx context.Context, cfg *config.WebhookConfig, logger *log.Logger) error {
+
+	sl := d.slogger(logger)
+
+	d.mu.RLock()
+	current := d.config
+	d.mu.RUnlock()
+
+	if current != nil && reflect.DeepEqual(current, cfg) {
+		sl.Debug("Config has not changed, skipping reload")
+		return nil
+	}
+
+	hooks, err := d.hooksFromConfig(ctx, cfg)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create webhooks, %w", err)
+	}
+
+	probes, err := probesFromConfig(ctx, cfg)
+
+	if err != nil {
+		closeWebhooks(ctx, sl, hooks)
+		return fmt.Errorf("Failed to create readiness probes, %w", err)
+	}
+
+	if current != nil {
+
+		for _, name := range restartRequired(current, cfg) {
+			sl.Warn("Config property has changed but will not be applied until webhookd is restarted", "property", name)
+		}
+	}
+
+	d.mu.Lock()
+
+	previous_hooks := d.hooks
+	previous_probes := d.probes
+	previous_active := d.hooks_active
+
+	d.hooks = hooks
+	d.hooks_active = new(sync.WaitGroup)
+	d.probes = probes
+	d.config = cfg
+
+	d.mu.Unlock()
+
+	sl.Info("Reloaded config", "webhooks", len(hooks))
+
+	go d.retireWebhooks(sl, previous_hooks, previous_probes, previous_active)
+	return nil
+}
+
+// retireWebhooks() waits for the requests being processed using 'hooks', as tracked by 'active', to finish and then closes the
+// dispatchers for 'hooks' and the readiness probes in 'probes'.
+func (d *WebhookDaemon) retireWebhooks(logger *slog.Logger, hooks map[string]*webhookEntry, probes map[string]health.Probe, active *sync.WaitGroup) {
+
+	active.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.shutdown_timeout)
+	defer cancel()
+
+	closeWebhooks(ctx, logger, hooks)
+
+	err := health.Close(ctx, probes)
+
+	if err != nil {
+		logger.Error("Failed to close readiness probes", logging.ERROR_KEY, err)
+	}
+
+	logger.Debug("Closed webhooks replaced by reload", "webhooks", len(hooks))
+}
+
+// WatchConfig() watches 'uri', which is expected to take the form of a valid `gocloud.dev/runtimevar` URI, for changes
+// and reloads the webhooks for 'd' each time it changes. This method blocks until 'ctx' is cancelled.
+func (d *WebhookDaemon) WatchConfig(ctx context.Context, uri string) error {
+	logger := log.Default()
+	return d.WatchConfigWithLogger(ctx, uri, logger)
+}
+
+// WatchConfigWithLogger() watches 'uri', which is expected to take the form of a valid `gocloud.dev/runtimevar` URI, for changes
+// and reloads the webhooks for 'd' each time it changes logging events to 'logger'. If a new config can not be read or is invalid
+// the error is logged and the current webhooks are left unchanged. This method blocks until 'ctx' is cancelled.
+func (d *WebhookDaemon) WatchConfigWithLogger(ctx context.Context, uri string, logger *log.Logger) error {
+
+	sl := d.slogger(logger)
+
+	cb := func(ctx context.Context, cfg *config.WebhookConfig, err error) {
+
+		if err != nil {
+			sl.Error("Failed to read updated config, keeping current config", logging.ERROR_KEY, err)
+			return
+		}
+
+		err = d.ReloadWithLogger(ctx, cfg, logger)
+
+		if err != nil {
+			sl.Error("Failed to reload config, keeping current config", logging.ERROR_KEY, err)
+		}
+	}
+
+	return config.WatchConfigFromURI(ctx, uri, cb)
+}
+
+// restartRequired() returns the names of the properties that differ between 'a' and 'b' that can not be reloaded.
+func restartRequired(a *config.WebhookConfig, b *config.WebhookConfig) []string {
+
+	changed := make([]string, 0)
+
+	if a.Daemon != b.Daemon {
+		changed = append(changed, "daemon")
+	}
+
+	if !reflect.DeepEqual(a.Queue, b.Queue) {
+		changed = append(changed, "queue")
+	}
+
+	if a.DeadLetter != b.DeadLetter {
+		changed = append(changed, "dead_letter")
+	}
+
+	var a_prefix, b_prefix string
+	var a_timeout, b_timeout int
+
+	if a.Health != nil {
+		a_prefix = a.Health.Prefix
+		a_timeout = a.Health.Timeout
+	}
+
+	if b.Health != nil {
+		b_prefix = b.Health.Prefix
+		b_timeout = b.Health.Timeout
+	}
+
+	if a_prefix != b_prefix || a_timeout != b_timeout {
+		changed = append(changed, "health")
+	}
+
+	if !reflect.DeepEqual(a.Metrics, b.Metrics) {
+		changed = append(changed, "metrics")
+	}
+
+	if !reflect.DeepEqual(a.Tracing, b.Tracing) {
+		changed = append(changed, "tracing")
+	}
+
+	var a_concurrent, b_concurrent int
+
+	if a.Limits != nil {
+		a_concurrent = a.Limits.MaxConcurrent
+	}
+
+	if b.Limits != nil {
+		b_concurrent = b.Limits.MaxConcurrent
+	}
+
+	if a_concurrent != b_concurrent {
+		changed = append(changed, "limits")
+	}
+
+	var a_store, b_store, a_ttl, b_ttl string
+
+	if a.Idempotency != nil {
+		a_store = a.Idempotency.Store
+		a_ttl = a.Idempotency.TTL
+	}
+
+	if b.Idempotency != nil {
+		b_store = b.Idempotency.Store
+		b_ttl = b.Idempotency.TTL
+	}
+
+	if (a.Idempotency == nil) != (b.Idempotency == nil) || a_store != b_store || a_ttl != b_ttl {
+		changed = append(changed, "idempotency")
+	}
+
+	if !reflect.DeepEqual(a.Audit, b.Audit) {
+		changed = append(changed, "audit")
+	}
+
+	if !reflect.DeepEqual(a.Admin, b.Admin) {
+		changed = append(changed, "admin")
+	}
+
+	if a.ShutdownTimeout != b.ShutdownTimeout {
+		changed = append(changed, "shutdown_timeout")
+	}
+
+	return changed
+}
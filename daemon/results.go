@@ -0,0 +1,151 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// STATUS_OK is the status for a dispatcher that relayed a message successfully.
+const STATUS_OK string = "ok"
+
+// STATUS_HALTED is the status for a dispatcher that returned a `webhookd.HaltEvent` or `webhookd.UnhandledEvent` error.
+const STATUS_HALTED string = "halted"
+
+// STATUS_FAILED is the status for a dispatcher that failed to relay a message.
+const STATUS_FAILED string = "failed"
+
+// STATUS_TIMEOUT is the status for a dispatcher that failed to relay a message before its deadline.
+const STATUS_TIMEOUT string = "timeout"
+
+// STATUS_UNKNOWN is the status for a dispatcher that was still relaying a message after its deadline, and a grace period, had passed.
+const STATUS_UNKNOWN string = "unknown"
+
+// POLICY_ANY_FAILURE is the status policy that returns an error response if any dispatcher fails.
+const POLICY_ANY_FAILURE string = "any-failure"
+
+// POLICY_ALL_FAILURE is the status policy that returns an error response only if every dispatcher fails.
+const POLICY_ALL_FAILURE string = "all-failure"
+
+// POLICY_BEST_EFFORT is the status policy that never returns an error response for dispatcher failures.
+const POLICY_BEST_EFFORT string = "best-effort"
+
+// DEFAULT_STATUS_POLICY is the default status policy for webhooks.
+const DEFAULT_STATUS_POLICY string = POLICY_ANY_FAILURE
+
+// type DispatchResult is a struct containing the outcome of relaying a message to an individual dispatcher.
+type DispatchResult struct {
+	// Dispatcher is the label of the dispatcher.
+	Dispatcher string `json:"dispatcher"`
+	// Status is the outcome of the dispatch. Valid options are: ok, halted, failed, timeout, unknown.
+	Status string `json:"status"`
+	// Code is the status code of the error returned by the dispatcher, if any.
+	Code int `json:"code,omitempty"`
+	// Error is the message of the error returned by the dispatcher, if any.
+	Error string `json:"error,omitempty"`
+	// Duration is the amount of time it took to dispatch the message.
+	Duration string `json:"duration"`
+	// elapsed is the amount of time it took to dispatch the message, as a `time.Duration`, used by audit records.
+	elapsed time.Duration
+}
+
+// type WebhookResult is a struct containing the outcome of processing a webhook message. It is returned, encoded as JSON,
+// in webhook responses.
+type WebhookResult struct {
+	// DeliveryID is the unique identifier for the message (delivery).
+	DeliveryID string `json:"delivery_id"`
+	// Endpoint is the relative URI of the webhook.
+	Endpoint string `json:"endpoint"`
+	// Route is the name of the route used to select the dispatchers for the message.
+	Route string `json:"route"`
+	// Dispatchers is the list of outcomes for each dispatcher, in the order they were configured.
+	Dispatchers []*DispatchResult `json:"dispatchers"`
+}
+
+// Failed() returns the number of dispatchers in 'r' that failed, including those that timed out or whose outcome is unknown.
+func (r *WebhookResult) Failed() int {
+
+	count := 0
+
+	for _, d := range r.Dispatchers {
+
+		if d.Status == STATUS_FAILED || d.Status == STATUS_TIMEOUT || d.Status == STATUS_UNKNOWN {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+// TimedOut() returns the number of dispatchers in 'r' that timed out, including those whose outcome is unknown.
+func (r *WebhookResult) TimedOut() int {
+
+	count := 0
+
+	for _, d := range r.Dispatchers {
+
+		if d.Status == STATUS_TIMEOUT || d.Status == STATUS_UNKNOWN {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+// Outcome() returns the `OUTCOME_` constant for a message whose dispatcher outcomes are 'r'.
+func (r *WebhookResult) Outcome() string {
+
+	failed := r.Failed()
+
+	switch {
+	case failed == 0:
+		return OUTCOME_OK
+	case failed == r.TimedOut():
+		return OUTCOME_TIMEOUT
+	default:
+		return OUTCOME_FAILED
+	}
+}
+
+// StatusCode() returns the HTTP status code for 'r' derived from 'policy'.
+func (r *WebhookResult) StatusCode(policy string) int {
+
+	failed := r.Failed()
+
+	if failed == 0 {
+		return http.StatusOK
+	}
+
+	// Only report a timeout if every failure was a timeout
+
+	status := http.StatusInternalServerError
+
+	if failed == r.TimedOut() {
+		status = http.StatusGatewayTimeout
+	}
+
+	switch policy {
+	case POLICY_BEST_EFFORT:
+		return http.StatusOK
+	case POLICY_ALL_FAILURE:
+
+		if failed < len(r.Dispatchers) {
+			return http.StatusOK
+		}
+
+		return status
+	default:
+		return status
+	}
+}
+
+// ensureStatusPolicy() returns an error if 'policy' is not a valid status policy.
+func ensureStatusPolicy(policy string) error {
+
+	switch policy {
+	case POLICY_ANY_FAILURE, POLICY_ALL_FAILURE, POLICY_BEST_EFFORT:
+		return nil
+	default:
+		return fmt.Errorf("Invalid status policy '%s'", policy)
+	}
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTestWebhookResult() returns a new `WebhookResult` instance with a `DispatchResult` for each of 'statuses'.
+func newTestWebhookResult(statuses ...string) *WebhookResult {
+
+	r := &WebhookResult{
+		Dispatchers: make([]*DispatchResult, len(statuses)),
+	}
+
+	for i, status := range statuses {
+		r.Dispatchers[i] = &DispatchResult{Status: status}
+	}
+
+	return r
+}
+
+func TestWebhookResult(t *testing.T) {
+
+	tests := []struct {
+		statuses    []string
+		failed      int
+		timed_out   int
+		outcome     string
+		any_failure int
+		all_failure int
+		best_effort int
+	}{
+		{[]string{}, 0, 0, OUTCOME_OK, http.StatusOK, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_OK}, 0, 0, OUTCOME_OK, http.StatusOK, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_OK, STATUS_HALTED}, 0, 0, OUTCOME_OK, http.StatusOK, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_HALTED, STATUS_HALTED}, 0, 0, OUTCOME_OK, http.StatusOK, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_FAILED}, 1, 0, OUTCOME_FAILED, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+		{[]string{STATUS_TIMEOUT}, 1, 1, OUTCOME_TIMEOUT, http.StatusGatewayTimeout, http.StatusGatewayTimeout, http.StatusOK},
+		{[]string{STATUS_UNKNOWN}, 1, 1, OUTCOME_TIMEOUT, http.StatusGatewayTimeout, http.StatusGatewayTimeout, http.StatusOK},
+		{[]string{STATUS_OK, STATUS_FAILED}, 1, 0, OUTCOME_FAILED, http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_OK, STATUS_TIMEOUT}, 1, 1, OUTCOME_TIMEOUT, http.StatusGatewayTimeout, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_HALTED, STATUS_FAILED}, 1, 0, OUTCOME_FAILED, http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+		{[]string{STATUS_FAILED, STATUS_FAILED}, 2, 0, OUTCOME_FAILED, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+		{[]string{STATUS_TIMEOUT, STATUS_UNKNOWN}, 2, 2, OUTCOME_TIMEOUT, http.StatusGatewayTimeout, http.StatusGatewayTimeout, http.StatusOK},
+		{[]string{STATUS_FAILED, STATUS_TIMEOUT}, 2, 1, OUTCOME_FAILED, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+		{[]string{STATUS_OK, STATUS_FAILED, STATUS_UNKNOWN}, 2, 1, OUTCOME_FAILED, http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+	}
+
+	for _, test := range tests {
+
+		label := strings.Join(test.statuses, ",")
+		r := newTestWebhookResult(test.statuses...)
+
+		if r.Failed() != test.failed {
+			t.Fatalf("Expected '%s' to have %d failures, got %d", label, test.failed, r.Failed())
+		}
+
+		if r.TimedOut() != test.timed_out {
+			t.Fatalf("Expected '%s' to have %d timeouts, got %d", label, test.timed_out, r.TimedOut())
+		}
+
+		if r.Outcome() != test.outcome {
+			t.Fatalf("Expected '%s' to have outcome '%s', got '%s'", label, test.outcome, r.Outcome())
+		}
+
+		codes := map[string]int{
+			POLICY_ANY_FAILURE: test.any_failure,
+			POLICY_ALL_FAILURE: test.all_failure,
+			POLICY_BEST_EFFORT: test.best_effort,
+		}
+
+		for policy, code := range codes {
+
+			if r.StatusCode(policy) != code {
+				t.Fatalf("Expected '%s' to have status code %d for policy '%s', got %d", label, code, policy, r.StatusCode(policy))
+			}
+		}
+	}
+}
+
+func TestEnsureStatusPolicy(t *testing.T) {
+
+	for _, policy := range []string{POLICY_ANY_FAILURE, POLICY_ALL_FAILURE, POLICY_BEST_EFFORT} {
+
+		err := ensureStatusPolicy(policy)
+
+		if err != nil {
+			t.Fatalf("Expected '%s' to be a valid policy, %v", policy, err)
+		}
+	}
+
+	for _, policy := range []string{"", "any", "ANY-FAILURE"} {
+
+		err := ensureStatusPolicy(policy)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to be an invalid policy", policy)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-webhookd/v3/webhook"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/envelope"
+)
+
+// DEFAULT_ROUTE is the name of the route for messages that don't match any of a webhook's routes.
+const DEFAULT_ROUTE string = "default"
+
+// type webhookRoute is a struct containing the predicates for a route and the webhook used to process the messages that match them.
+type webhookRoute struct {
+	// name is the unique name of the route.
+	name string
+	// body is the optional regular expression matched against the (transformed) body of a message.
+	body *regexp.Regexp
+	// repository is the optional regular expression matched against the repository name of a message.
+	repository *regexp.Regexp
+	// event is the optional regular expression matched against the event type of a message.
+	event *regexp.Regexp
+	// headers is the optional dictionary mapping request header names to regular expressions matched against their values.
+	headers map[string]*regexp.Regexp
+	// entry is the webhook used to process messages that match the route.
+	entry *webhookEntry
+}
+
+// matches() returns a boolean value indicating whether 'env', and 'received' which is the body of the message as it
+// was received, match all of the predicates for 'r'.
+func (r *webhookRoute) matches(env *envelope.Envelope, received []byte) bool {
+
+	if r.event != nil && !r.event.MatchString(env.EventType) {
+		return false
+	}
+
+	for name, re := range r.headers {
+
+		if !re.MatchString(env.Headers.Get(name)) {
+			return false
+		}
+	}
+
+	if r.repository != nil && !r.repository.MatchString(repositoryName(received)) {
+		return false
+	}
+
+	if r.body != nil && !r.body.Match(env.Body) {
+		return false
+	}
+
+	return true
+}
+
+// route() returns the webhook used to dispatch 'env', and 'received' which is the body of the message as it was received.
+// This is the webhook of the first route that matches the message or 'e' itself if there are no matching routes.
+func (e *webhookEntry) route(env *envelope.Envelope, received []byte) *webhookEntry {
+
+	for _, r := range e.routes {
+
+		if r.matches(env, received) {
+			return r.entry
+		}
+	}
+
+	return e
+}
+
+// routeName() returns the name of the route that 'e' relays messages to.
+func (e *webhookEntry) routeName() string {
+
+	if e.route_name == "" {
+		return DEFAULT_ROUTE
+	}
+
+	return e.route_name
+}
+
+// routesFromConfig() assigns the routes for 'entry' from 'routes_cfg'.
+func routesFromConfig(ctx context.Context, cfg *config.WebhookConfig, routes_cfg []*config.WebhookRouteConfig, entry *webhookEntry) error {
+
+	routes := make([]*webhookRoute, len(routes_cfg))
+	seen := make(map[string]bool)
+
+	for idx, route_cfg := range routes_cfg {
+
+		if route_cfg.Name == "" {
+			return fmt.Errorf("Missing name for route at offset %d", idx+1)
+		}
+
+		if route_cfg.Name == DEFAULT_ROUTE {
+			return fmt.Errorf("Route name '%s' is reserved", DEFAULT_ROUTE)
+		}
+
+		if seen[route_cfg.Name] {
+			return fmt.Errorf("Duplicate route name '%s'", route_cfg.Name)
+		}
+
+		seen[route_cfg.Name] = true
+
+		r, err := routeFromConfig(ctx, cfg, route_cfg, entry)
+
+		if err != nil {
+			return fmt.Errorf("Invalid route '%s', %w", route_cfg.Name, err)
+		}
+
+		routes[idx] = r
+	}
+
+	entry.routes = routes
+	return nil
+}
+
+// routeFromConfig() returns a new `webhookRoute` instance for 'entry' derived from 'route_cfg'.
+func routeFromConfig(ctx context.Context, cfg *config.WebhookConfig, route_cfg *config.WebhookRouteConfig, entry *webhookEntry) (*webhookRoute, error) {
+
+	m := route_cfg.Match
+
+	if m == nil || (m.Body == "" && m.Repository == "" && m.Event == "" && len(m.Headers) == 0) {
+		return nil, fmt.Errorf("Missing match predicates")
+	}
+
+	if len(route_cfg.Dispatchers) == 0 {
+		return nil, fmt.Errorf("Missing dispatchers")
+	}
+
+	r := &webhookRoute{
+		name:    route_cfg.Name,
+		headers: make(map[string]*regexp.Regexp),
+	}
+
+	var err error
+
+	r.body, err = compileRoutePredicate("body", m.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.repository, err = compileRoutePredicate("repository", m.Repository)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.event, err = compileRoutePredicate("event", m.Event)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, pat := range m.Headers {
+
+		re, err := regexp.Compile(pat)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to compile header predicate for '%s', %w", name, err)
+		}
+
+		r.headers[name] = re
+	}
+
+	var sendto []webhookd.WebhookDispatcher
+	var labels []string
+
+	for _, name := range route_cfg.Dispatchers {
+
+		if strings.HasPrefix(name, "#") {
+			continue
+		}
+
+		dispatcher_uri, err := cfg.GetDispatcherConfigByName(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get dispatcher configuration for '%s', %w", name, err)
+		}
+
+		dr, err := dispatcher.NewDispatcher(ctx, dispatcher_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create dispatcher for '%s', %w", dispatcher_uri, err)
+		}
+
+		sendto = append(sendto, dr)
+		labels = append(labels, name)
+	}
+
+	wh, err := webhook.NewWebhook(ctx, entry.endpoint, entry.webhook.Receiver(), entry.webhook.Transformations(), sendto)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create route webhook, %w", err)
+	}
+
+	// The route webhook is processed exactly like the webhook it is derived from except for its dispatchers
+
+	route_entry := entry.derive(wh, labels)
+	route_entry.route_name = route_cfg.Name
+
+	r.entry = route_entry
+	return r, nil
+}
+
+// compileRoutePredicate() returns the compiled regular expression for the route predicate 'name' or nil if 'pat' is empty.
+func compileRoutePredicate(name string, pat string) (*regexp.Regexp, error) {
+
+	if pat == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(pat)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compile %s predicate, %w", name, err)
+	}
+
+	return re, nil
+}
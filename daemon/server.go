@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aaronland/go-http-server"
+)
+
+// type shutdownServer is an optional interface for `aaronland/go-http-server.Server` implementations that can stop
+// listening for requests, and wait for active requests to finish, until a context is cancelled.
+type shutdownServer interface {
+	// Shutdown() stops listening for requests and waits for active requests to finish or the context to be cancelled.
+	Shutdown(context.Context) error
+}
+
+// type httpServer is a struct that implements the `aaronland/go-http-server.Server` interface, as well as the `shutdownServer`
+// interface, using a `net/http.Server` instance. Unlike the default "http" and "https" servers it does not shut itself down when
+// the process receives a signal; it is shut down by the daemon, with a deadline, instead.
+type httpServer struct {
+	server.Server
+	// url is the URI that the server is listening for requests on.
+	url *url.URL
+	// http_server is the underlying `net/http.Server` instance.
+	http_server *http.Server
+	// cert is the optional path to a TLS certificate.
+	cert string
+	// key is the optional path to a TLS key.
+	key string
+}
+
+// newHTTPServer() returns a new `httpServer` instance configured by 'uri' which takes the same form, and parameters, as
+// the `aaronland/go-http-server` "http" and "https" servers:
+//
+//	{SCHEME}://{ADDRESS}:{PORT}?{PARAMETERS}
+//
+// Valid parameters are:
+// * `?cert=` and `?key=` The paths to a TLS certificate and key. If both are present requests are served using TLS.
+// * `?read_timeout=` A custom setting, in seconds, for HTTP read timeouts. Default is 2 seconds.
+// * `?write_timeout=` A custom setting, in seconds, for HTTP write timeouts. Default is 10 seconds.
+// * `?idle_timeout=` A custom setting, in seconds, for HTTP idle timeouts. Default is 15 seconds.
+// * `?header_timeout=` A custom setting, in seconds, for HTTP header timeouts. Default is 2 seconds.
+func newHTTPServer(ctx context.Context, uri string) (*httpServer, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse server URI, %w", err)
+	}
+
+	u.Scheme = "http"
+
+	q := u.Query()
+
+	timeouts := map[string]time.Duration{
+		"read_timeout":   2 * time.Second,
+		"write_timeout":  10 * time.Second,
+		"idle_timeout":   15 * time.Second,
+		"header_timeout": 2 * time.Second,
+	}
+
+	for k := range timeouts {
+
+		str_v := q.Get(k)
+
+		if str_v == "" {
+			continue
+		}
+
+		v, err := strconv.Atoi(str_v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?%s= parameter, %w", k, err)
+		}
+
+		timeouts[k] = time.Duration(v) * time.Second
+	}
+
+	cert := q.Get("cert")
+	key := q.Get("key")
+
+	switch {
+	case cert != "" && key != "":
+
+		for _, path := range []string{cert, key} {
+
+			_, err := os.Stat(path)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to stat %s, %w", path, err)
+			}
+		}
+
+		u.Scheme = "https"
+
+	case cert != "":
+		return nil, fmt.Errorf("Missing ?key= parameter")
+	case key != "":
+		return nil, fmt.Errorf("Missing ?cert= parameter")
+	}
+
+	http_server := &http.Server{
+		Addr:              u.Host,
+		ReadTimeout:       timeouts["read_timeout"],
+		WriteTimeout:      timeouts["write_timeout"],
+		IdleTimeout:       timeouts["idle_timeout"],
+		ReadHeaderTimeout: timeouts["header_timeout"],
+	}
+
+	s := &httpServer{
+		url:         u,
+		http_server: http_server,
+		cert:        cert,
+		key:         key,
+	}
+
+	return s, nil
+}
+
+// Address() returns the fully-qualified URI that 's' is listening for requests on.
+func (s *httpServer) Address() string {
+
+	u := *s.url
+	u.RawQuery = ""
+
+	return u.String()
+}
+
+// ListenAndServe() listens for requests using 'mux' for routing until 's' is shut down.
+func (s *httpServer) ListenAndServe(ctx context.Context, mux http.Handler) error {
+
+	s.http_server.Handler = mux
+
+	var err error
+
+	if s.cert != "" && s.key != "" {
+		err = s.http_server.ListenAndServeTLS(s.cert, s.key)
+	} else {
+		err = s.http_server.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// Shutdown() closes the listener for 's' and waits for active requests to finish until 'ctx' is cancelled.
+func (s *httpServer) Shutdown(ctx context.Context) error {
+	return s.http_server.Shutdown(ctx)
+}
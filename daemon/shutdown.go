@@ -0,0 +1,289 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/audit"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/health"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/logging"
+)
+
+// DEFAULT_SHUTDOWN_TIMEOUT is the default amount of time to wait for in-flight webhook messages to finish processing when the daemon is shut down.
+const DEFAULT_SHUTDOWN_TIMEOUT time.Duration = 30 * time.Second
+
+// type ClosableDispatcher is an optional interface for `webhookd.WebhookDispatcher` implementations that hold resources,
+// for example pubsub topics, that should be released when the daemon is shut down.
+type ClosableDispatcher interface {
+	// Close() releases any resources held by the dispatcher, flushing any messages that have not been sent yet.
+	Close(context.Context) error
+}
+
+// type inflightDelivery is a struct containing information about a webhook message that is currently being processed.
+type inflightDelivery struct {
+	// ID is the unique identifier for the message (delivery).
+	ID string
+	// Endpoint is the relative URI of the webhook that received the message.
+	Endpoint string
+	// Started is the time the message started being processed.
+	Started time.Time
+}
+
+// type inflightDeliveries is a struct for tracking the webhook messages that are currently being processed.
+type inflightDeliveries struct {
+	mu         *sync.Mutex
+	wg         *sync.WaitGroup
+	seq        uint64
+	closed     bool
+	deliveries map[uint64]*inflightDelivery
+}
+
+func newInflightDeliveries() *inflightDeliveries {
+
+	i := &inflightDeliveries{
+		mu:         new(sync.Mutex),
+		wg:         new(sync.WaitGroup),
+		deliveries: make(map[uint64]*inflightDelivery),
+	}
+
+	return i
+}
+
+// add() records that the message identified by 'id' is being processed and returns a function to call when it is done.
+// If 'i' has been closed then no message is recorded and the method returns false.
+func (i *inflightDeliveries) add(id string, endpoint string) (func(), bool) {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		return nil, false
+	}
+
+	i.seq += 1
+	key := i.seq
+
+	i.deliveries[key] = &inflightDelivery{
+		ID:       id,
+		Endpoint: endpoint,
+		Started:  time.Now(),
+	}
+
+	i.wg.Add(1)
+
+	done := func() {
+		i.mu.Lock()
+		delete(i.deliveries, key)
+		i.mu.Unlock()
+		i.wg.Done()
+	}
+
+	return done, true
+}
+
+// close() prevents any more messages from being recorded by 'i'.
+func (i *inflightDeliveries) close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closed = true
+}
+
+// list() returns the messages that are currently being processed, ordered by the time they started.
+func (i *inflightDeliveries) list() []*inflightDelivery {
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	list := make([]*inflightDelivery, 0, len(i.deliveries))
+
+	for _, d := range i.deliveries {
+		list = append(list, d)
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Started.Before(list[b].Started)
+	})
+
+	return list
+}
+
+// wait() blocks until there are no messages being processed or 'ctx' is cancelled, returning false in the latter case.
+func (i *inflightDeliveries) wait(ctx context.Context) bool {
+
+	done := make(chan bool)
+
+	go func() {
+		i.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// SetShutdownTimeout() assigns the amount of time to wait for in-flight webhook messages to finish processing when 'd' is shut down.
+func (d *WebhookDaemon) SetShutdownTimeout(timeout time.Duration) {
+	d.shutdown_timeout = timeout
+}
+
+// Shutdown() stops 'd' from accepting new webhook messages and waits for in-flight messages to finish processing
+// until 'ctx' is cancelled.
+func (d *WebhookDaemon) Shutdown(ctx context.Context) error {
+	logger := log.Default()
+	return d.ShutdownWithLogger(ctx, logger)
+}
+
+// ShutdownWithLogger() stops 'd' from accepting new webhook messages and waits for in-flight messages to finish processing
+// until 'ctx' is cancelled, logging events to 'logger'. New webhook requests receive a `503 Service Unavailable` response
+// while the daemon is shutting down. Messages that are still being processed when 'ctx' is cancelled are abandoned and
+// their delivery IDs are logged; asynchronous messages are left in the queue and will be resumed when the daemon is restarted.
+// Finally any dispatchers implementing the `ClosableDispatcher` interface, the readiness probes, the queue, the dead letter store, the idempotency store, the audit log and the tracer are closed.
+func (d *WebhookDaemon) ShutdownWithLogger(ctx context.Context, logger *log.Logger) error {
+
+	sl := d.slogger(logger)
+
+	d.stop_once.Do(func() {
+		d.inflight.close()
+		close(d.stopping)
+	})
+
+	sl.Info("Shutting down, waiting for in-flight deliveries to complete")
+
+	ok := d.inflight.wait(ctx)
+
+	if !ok {
+
+		for _, i := range d.inflight.list() {
+			requestLogger(sl, i.ID, i.Endpoint).Warn("Abandoned delivery", "elapsed_ms", audit.Milliseconds(time.Since(i.Started)))
+		}
+
+		close(d.abandon)
+
+		// Give abandoned deliveries a moment to notice they have been cancelled before resources are closed
+
+		abandon_ctx, abandon_cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer abandon_cancel()
+
+		d.inflight.wait(abandon_ctx)
+	}
+
+	// Closing resources shares the deadline for 'ctx', rather than starting a new one, so that the entire shutdown is
+	// bounded by that deadline. A new context is used since 'ctx' may already have been cancelled.
+
+	deadline, ok_deadline := ctx.Deadline()
+
+	if !ok_deadline {
+		deadline = time.Now().Add(d.shutdown_timeout)
+	}
+
+	close_ctx, close_cancel := context.WithDeadline(context.Background(), deadline)
+	defer close_cancel()
+
+	d.mu.RLock()
+	hooks := d.hooks
+	probes := d.probes
+	d.mu.RUnlock()
+
+	closeWebhooks(close_ctx, sl, hooks)
+
+	err := health.Close(close_ctx, probes)
+
+	if err != nil {
+		sl.Error("Failed to close readiness probes", logging.ERROR_KEY, err)
+	}
+
+	if d.queue != nil {
+
+		err := d.queue.Close()
+
+		if err != nil {
+			sl.Error("Failed to close queue", logging.ERROR_KEY, err)
+		}
+	}
+
+	if d.deadletters != nil {
+
+		err := d.deadletters.Close()
+
+		if err != nil {
+			sl.Error("Failed to close dead letter store", logging.ERROR_KEY, err)
+		}
+	}
+
+	if d.audit != nil {
+
+		err := d.audit.Close()
+
+		if err != nil {
+			sl.Error("Failed to close audit log", logging.ERROR_KEY, err)
+		}
+	}
+
+	if d.seen != nil {
+
+		err := d.seen.Close()
+
+		if err != nil {
+			sl.Error("Failed to close idempotency store", logging.ERROR_KEY, err)
+		}
+	}
+
+	err = d.tracer.Close()
+
+	if err != nil {
+		sl.Error("Failed to close tracer", logging.ERROR_KEY, err)
+	}
+
+	if !ok {
+		return fmt.Errorf("Timed out waiting for in-flight deliveries to complete")
+	}
+
+	sl.Info("Shutdown complete")
+	return nil
+}
+
+// closeWebhooks() closes any dispatchers, including route and overflow dispatchers, for the webhooks in 'hooks' that implement
+// the `ClosableDispatcher` interface.
+func closeWebhooks(ctx context.Context, logger *slog.Logger, hooks map[string]*webhookEntry) {
+
+	for _, entry := range hooks {
+
+		closeDispatchers(ctx, logger, entry)
+
+		if entry.overflow != nil {
+			closeDispatchers(ctx, logger, entry.overflow)
+		}
+
+		for _, r := range entry.routes {
+			closeDispatchers(ctx, logger, r.entry)
+		}
+	}
+}
+
+// closeDispatchers() closes any dispatchers for 'entry' that implement the `ClosableDispatcher` interface.
+func closeDispatchers(ctx context.Context, logger *slog.Logger, entry *webhookEntry) {
+
+	for idx, dr := range entry.webhook.Dispatchers() {
+
+		c, ok := dr.(ClosableDispatcher)
+
+		if !ok {
+			continue
+		}
+
+		err := c.Close(ctx)
+
+		if err != nil {
+			logger.Error("Failed to close dispatcher", logging.ENDPOINT_KEY, entry.redacted, logging.OFFSET_KEY, idx, logging.ERROR_KEY, err)
+		}
+	}
+}
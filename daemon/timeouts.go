@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/delivery"
+)
+
+// TIMEOUT_GRACE_PERIOD is the amount of time to wait for a transformation or dispatcher to return once its deadline has passed.
+const TIMEOUT_GRACE_PERIOD time.Duration = 5 * time.Second
+
+// timeoutsFromConfig() assigns the pipeline, transformation and dispatcher timeouts for 'entry' from 'cfg' and 'hook'.
+// Timeouts defined by 'hook' take precedence over those defined by 'cfg'.
+func timeoutsFromConfig(cfg *config.WebhookConfig, hook config.WebhookWebhooksConfig, entry *webhookEntry) error {
+
+	var pipeline, transformation, dispatcher string
+
+	for _, t_cfg := range []*config.WebhookTimeoutsConfig{cfg.Timeouts, hook.Timeouts} {
+
+		if t_cfg == nil {
+			continue
+		}
+
+		if t_cfg.Pipeline != "" {
+			pipeline = t_cfg.Pipeline
+		}
+
+		if t_cfg.Transformation != "" {
+			transformation = t_cfg.Transformation
+		}
+
+		if t_cfg.Dispatcher != "" {
+			dispatcher = t_cfg.Dispatcher
+		}
+	}
+
+	var err error
+
+	entry.pipeline_timeout, err = parseTimeout("pipeline", pipeline)
+
+	if err != nil {
+		return err
+	}
+
+	entry.transformation_timeout, err = parseTimeout("transformation", transformation)
+
+	if err != nil {
+		return err
+	}
+
+	entry.dispatcher_timeout, err = parseTimeout("dispatcher", dispatcher)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// parseTimeout() parses 'str' as the `time.Duration` value for the timeout 'name'. If 'str' is empty then zero (no timeout) is returned.
+func parseTimeout(name string, str string) (time.Duration, error) {
+
+	if str == "" {
+		return 0, nil
+	}
+
+	t, err := time.ParseDuration(str)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse %s timeout, %w", name, err)
+	}
+
+	if t < 0 {
+		return 0, fmt.Errorf("Invalid %s timeout, %v", name, t)
+	}
+
+	return t, nil
+}
+
+// withTimeout() returns a copy of 'ctx' that is cancelled after 'timeout'. If 'timeout' is zero then 'ctx' is returned
+// with a no-op cancel function.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runWithTimeout() invokes 'fn' with a copy of 'ctx' that is cancelled after 'timeout'. Transformations and dispatchers must honour
+// context cancellation, returning as soon as possible once 'ctx' is done, since there is no way to stop them otherwise. If 'ctx' is
+// done before 'fn' returns then 'fn' is given `TIMEOUT_GRACE_PERIOD` to return its own outcome. If it still has not returned then an
+// error with the `delivery.UNKNOWN_OUTCOME` code is returned, since 'fn' may yet succeed, and 'fn' is left to finish in the background.
+// Errors returned by 'fn' after the deadline for 'ctx' has been exceeded are reported as `delivery.DEADLINE_EXCEEDED` errors.
+func runWithTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context) *webhookd.WebhookError) *webhookd.WebhookError {
+	return runWithGracePeriod(ctx, timeout, TIMEOUT_GRACE_PERIOD, fn)
+}
+
+// runWithGracePeriod() is the implementation of `runWithTimeout` with an explicit grace period.
+func runWithGracePeriod(ctx context.Context, timeout time.Duration, grace_period time.Duration, fn func(context.Context) *webhookd.WebhookError) *webhookd.WebhookError {
+
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	done_ch := make(chan *webhookd.WebhookError, 1)
+
+	go func() {
+		done_ch <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done_ch:
+		return deadlineError(ctx, err)
+	case <-ctx.Done():
+		// pass
+	}
+
+	grace := time.NewTimer(grace_period)
+	defer grace.Stop()
+
+	select {
+	case err := <-done_ch:
+		return deadlineError(ctx, err)
+	case <-grace.C:
+		msg := fmt.Sprintf("Outcome unknown, still running %v after %v", grace_period, ctx.Err())
+		return &webhookd.WebhookError{Code: delivery.UNKNOWN_OUTCOME, Message: msg}
+	}
+}
+
+// deadlineError() returns 'err', the error returned by a function invoked with 'ctx', as a `delivery.DEADLINE_EXCEEDED` error
+// if the deadline for 'ctx' has been exceeded. Otherwise 'err' is returned unchanged.
+func deadlineError(ctx context.Context, err *webhookd.WebhookError) *webhookd.WebhookError {
+
+	if err != nil && err.Code >= 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) && !delivery.IsDeadlineExceeded(err) {
+		err = &webhookd.WebhookError{Code: delivery.DEADLINE_EXCEEDED, Message: fmt.Sprintf("%s (%v)", err.Message, ctx.Err())}
+	}
+
+	return err
+}
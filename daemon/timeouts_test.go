@@ -0,0 +1,195 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/delivery"
+)
+
+func TestRunWithTimeout(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		label   string
+		timeout time.Duration
+		fn      func(context.Context) *webhookd.WebhookError
+		code    int
+	}{
+		{"success", time.Second, func(ctx context.Context) *webhookd.WebhookError {
+			return nil
+		}, 0},
+		{"error before deadline", time.Second, func(ctx context.Context) *webhookd.WebhookError {
+			return &webhookd.WebhookError{Code: http.StatusGatewayTimeout, Message: "Remote timeout"}
+		}, http.StatusGatewayTimeout},
+		{"no timeout", 0, func(ctx context.Context) *webhookd.WebhookError {
+
+			_, ok := ctx.Deadline()
+
+			if ok {
+				return &webhookd.WebhookError{Code: 999, Message: "Unexpected deadline"}
+			}
+
+			return nil
+		}, 0},
+		{"error after deadline", 10 * time.Millisecond, func(ctx context.Context) *webhookd.WebhookError {
+			<-ctx.Done()
+			return &webhookd.WebhookError{Code: http.StatusServiceUnavailable, Message: "Cancelled"}
+		}, delivery.DEADLINE_EXCEEDED},
+		{"context error after deadline", 10 * time.Millisecond, func(ctx context.Context) *webhookd.WebhookError {
+			<-ctx.Done()
+			return delivery.ContextError(ctx)
+		}, delivery.DEADLINE_EXCEEDED},
+		{"success after deadline", 10 * time.Millisecond, func(ctx context.Context) *webhookd.WebhookError {
+			<-ctx.Done()
+			return nil
+		}, 0},
+		{"halt after deadline", 10 * time.Millisecond, func(ctx context.Context) *webhookd.WebhookError {
+			<-ctx.Done()
+			return &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Halt"}
+		}, webhookd.HaltEvent},
+	}
+
+	for _, test := range tests {
+
+		err := runWithTimeout(ctx, test.timeout, test.fn)
+
+		code := 0
+
+		if err != nil {
+			code = err.Code
+		}
+
+		if code != test.code {
+			t.Fatalf("Expected %s to return code %d, got %d (%v)", test.label, test.code, code, err)
+		}
+	}
+
+	// Cancellation, rather than an exceeded deadline, is not reported as a deadline error
+
+	cancel_ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	err := runWithTimeout(cancel_ctx, time.Second, func(ctx context.Context) *webhookd.WebhookError {
+		<-ctx.Done()
+		return delivery.ContextError(ctx)
+	})
+
+	if err == nil || err.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected cancelled context to return %d, got %v", http.StatusServiceUnavailable, err)
+	}
+
+	if delivery.IsDeadlineExceeded(err) {
+		t.Fatalf("Expected cancelled context not to be reported as a deadline error")
+	}
+}
+
+func TestRunWithGracePeriod(t *testing.T) {
+
+	ctx := context.Background()
+
+	// A function that returns within the grace period reports its own outcome
+
+	err := runWithGracePeriod(ctx, 10*time.Millisecond, time.Second, func(ctx context.Context) *webhookd.WebhookError {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected function returning within grace period to succeed, got %v", err)
+	}
+
+	// A function that is still running after the grace period has an unknown outcome and is left running
+
+	release := make(chan bool)
+	finished := make(chan bool)
+
+	ta := time.Now()
+
+	err = runWithGracePeriod(ctx, 10*time.Millisecond, 50*time.Millisecond, func(ctx context.Context) *webhookd.WebhookError {
+		<-release
+		close(finished)
+		return nil
+	})
+
+	tb := time.Since(ta)
+
+	if !delivery.IsUnknownOutcome(err) {
+		t.Fatalf("Expected unknown outcome, got %v", err)
+	}
+
+	if delivery.IsDeadlineExceeded(err) {
+		t.Fatalf("Expected unknown outcome not to be reported as a deadline error")
+	}
+
+	if tb < 60*time.Millisecond || tb > 5*time.Second {
+		t.Fatalf("Expected to wait for timeout and grace period, waited %v", tb)
+	}
+
+	select {
+	case <-finished:
+		t.Fatalf("Expected function to still be running")
+	default:
+		// pass
+	}
+
+	close(release)
+
+	waitFor(t, "function to finish in the background", func() bool {
+
+		select {
+		case <-finished:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+func TestDeadlineExceededCode(t *testing.T) {
+
+	// Deadline errors must not be confused with HTTP status codes returned by remote services
+
+	if delivery.DEADLINE_EXCEEDED >= 100 && delivery.DEADLINE_EXCEEDED <= 599 {
+		t.Fatalf("Expected DEADLINE_EXCEEDED to be outside the range of HTTP status codes, got %d", delivery.DEADLINE_EXCEEDED)
+	}
+
+	remote := &webhookd.WebhookError{Code: http.StatusGatewayTimeout, Message: "Gateway timeout"}
+
+	if delivery.IsDeadlineExceeded(remote) {
+		t.Fatalf("Expected a remote 504 error not to be reported as a deadline error")
+	}
+}
+
+func TestWebhookDispatcherTimeout(t *testing.T) {
+
+	name := testDispatcherName()
+
+	cfg := fmt.Sprintf(`{
+		"receivers": { "insecure": "insecure://" },
+		"dispatchers": { "test": "testdispatch://%s?block=true" },
+		"webhooks": [ { "endpoint": "/timeout", "receiver": "insecure", "dispatchers": [ "test" ], "timeouts": { "dispatcher": "20ms" } } ]
+	}`, name)
+
+	d := newTestDaemon(t, cfg)
+	h := newTestHandler(t, d)
+
+	defer close(getTestDispatcher(t, name).release)
+
+	rsp := post(h, "/timeout", "hello")
+
+	if rsp.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected %d, got %d", http.StatusGatewayTimeout, rsp.Code)
+	}
+
+	if !strings.Contains(rsp.Body.String(), STATUS_TIMEOUT) {
+		t.Fatalf("Expected response to report a timeout, got '%s'", rsp.Body.String())
+	}
+}
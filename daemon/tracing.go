@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/config"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/tracing"
+)
+
+// DEFAULT_TRACING_EXPORTER is the default `tracing.Exporter` URI used to export spans.
+const DEFAULT_TRACING_EXPORTER string = "null://"
+
+// SetTracingFromConfig() assigns the tracer used to record spans for each stage of the webhook pipeline from 'cfg'.
+func (d *WebhookDaemon) SetTracingFromConfig(ctx context.Context, cfg *config.WebhookConfig) error {
+
+	if cfg.Tracing == nil {
+		return nil
+	}
+
+	exporter_uri := cfg.Tracing.Exporter
+
+	if exporter_uri == "" {
+		exporter_uri = DEFAULT_TRACING_EXPORTER
+	}
+
+	t, err := tracing.NewTracer(ctx, exporter_uri, cfg.Tracing.ServiceName)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new tracer, %w", err)
+	}
+
+	d.SetTracer(t)
+	return nil
+}
+
+// SetTracer() assigns 't' as the tracer used to record spans for each stage of the webhook pipeline.
+func (d *WebhookDaemon) SetTracer(t *tracing.Tracer) {
+	d.tracer = t
+}
+
+// endSpan() assigns a status to 'span' derived from 'err' and then ends it. Errors with `webhookd.UnhandledEvent`,
+// `webhookd.HaltEvent` or `EMPTY_BODY_LOGGED` codes are not considered failures; the reason the message was halted is
+// recorded instead. If 'span' already has an error status it is left unchanged.
+func endSpan(span *tracing.Span, err *webhookd.WebhookError) {
+
+	if err != nil {
+
+		span.SetAttribute("webhookd.error.code", err.Code)
+
+		switch {
+		case isHalted(err):
+			span.SetAttribute("webhookd.halted", true)
+			span.SetAttribute("webhookd.halt_reason", err.Message)
+		default:
+			if span.Status() != tracing.STATUS_ERROR {
+				span.SetError(err.Message)
+			}
+		}
+	}
+
+	span.End()
+}
+
+// repositoryName() returns the value of the `repository.full_name` property in 'body', if present, or the value of the
+// `project.path_with_namespace` property for GitLab webhook messages. This is used to add the repository to spans for
+// GitHub (and GitLab) webhook messages.
+func repositoryName(body []byte) string {
+
+	var msg struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Project struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}
+
+	err := json.Unmarshal(body, &msg)
+
+	if err != nil {
+		return ""
+	}
+
+	if msg.Repository.FullName != "" {
+		return msg.Repository.FullName
+	}
+
+	return msg.Project.PathWithNamespace
+}
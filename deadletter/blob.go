@@ -0,0 +1,157 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gocloud.dev/blob"
+)
+
+// BlobStore implements the `Store` interface for storing webhook messages that could not be dispatched in a
+// `gocloud.dev/blob.Bucket` instance. Each entry is stored as a JSON-encoded file whose name is derived from the entry ID.
+type BlobStore struct {
+	Store
+	// bucket is the `gocloud.dev/blob.Bucket` instance where entries are stored.
+	bucket *blob.Bucket
+}
+
+// NewBlobStore returns a new `BlobStore` instance configured by 'uri' which is expected to be a valid
+// and registered `gocloud.dev/blob.Bucket` URI. For example:
+//
+//	s3blob://{BUCKET}?region={REGION}&prefix=dead-letter/&credentials=session
+func NewBlobStore(ctx context.Context, uri string) (Store, error) {
+
+	bucket, err := blob.OpenBucket(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	s := &BlobStore{
+		bucket: bucket,
+	}
+
+	return s, nil
+}
+
+// Put() writes 'e' to the underlying bucket.
+func (s *BlobStore) Put(ctx context.Context, e *Entry) error {
+
+	wr, err := s.bucket.NewWriter(ctx, s.key(e), nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new writer for %s, %w", e.ID, err)
+	}
+
+	enc := json.NewEncoder(wr)
+	err = enc.Encode(e)
+
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Failed to encode entry %s, %w", e.ID, err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close writer for %s, %w", e.ID, err)
+	}
+
+	return nil
+}
+
+// List() returns all the entries stored in the underlying bucket, sorted by the time they were created. Entries that
+// can not be read are skipped and reported in the error returned alongside the entries that could be read.
+func (s *BlobStore) List(ctx context.Context) ([]*Entry, error) {
+
+	entries := make([]*Entry, 0)
+	read_errors := make([]error, 0)
+
+	iter := s.bucket.List(nil)
+
+	for {
+
+		obj, err := iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to iterate bucket, %w", err)
+		}
+
+		if obj.IsDir || filepath.Ext(obj.Key) != ".json" {
+			continue
+		}
+
+		e, err := s.read(ctx, obj.Key)
+
+		if err != nil {
+			read_errors = append(read_errors, err)
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, errors.Join(read_errors...)
+}
+
+// Remove() deletes 'e' from the underlying bucket.
+func (s *BlobStore) Remove(ctx context.Context, e *Entry) error {
+
+	err := s.bucket.Delete(ctx, s.key(e))
+
+	if err != nil {
+		return fmt.Errorf("Failed to delete entry %s, %w", e.ID, err)
+	}
+
+	return nil
+}
+
+// Close() closes the underlying bucket.
+func (s *BlobStore) Close() error {
+	return s.bucket.Close()
+}
+
+func (s *BlobStore) read(ctx context.Context, key string) (*Entry, error) {
+
+	r, err := s.bucket.NewReader(ctx, key, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", key, err)
+	}
+
+	defer r.Close()
+
+	var e *Entry
+
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&e)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", key, err)
+	}
+
+	if e == nil {
+		return nil, fmt.Errorf("Failed to decode %s, empty entry", key)
+	}
+
+	return e, nil
+}
+
+func (s *BlobStore) key(e *Entry) string {
+	id := strings.Replace(e.ID, "/", "-", -1)
+	return fmt.Sprintf("%s.json", id)
+}
@@ -0,0 +1,144 @@
+package deadletter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "gocloud.dev/blob/fileblob"
+)
+
+// newTestStore() returns a new `BlobStore` instance backed by a temporary directory, and the path to that directory.
+func newTestStore(t *testing.T) (*BlobStore, string) {
+
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	s, err := NewBlobStore(ctx, "file://"+root)
+
+	if err != nil {
+		t.Fatalf("Failed to create store, %v", err)
+	}
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	return s.(*BlobStore), root
+}
+
+func TestBlobStore(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, _ := newTestStore(t)
+
+	entries := []*Entry{
+		{ID: "3-c", DeliveryID: "delivery-3", Endpoint: "/a", Dispatcher: "test", Body: []byte("three")},
+		{ID: "1-a", DeliveryID: "delivery-1", Endpoint: "/a", Dispatcher: "test", Body: []byte("one")},
+		{ID: "2-b", DeliveryID: "delivery-2", Endpoint: "/b", Dispatcher: "test", Body: []byte("two")},
+	}
+
+	for _, e := range entries {
+
+		err := s.Put(ctx, e)
+
+		if err != nil {
+			t.Fatalf("Failed to put entry, %v", err)
+		}
+	}
+
+	listed, err := s.List(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to list entries, %v", err)
+	}
+
+	if len(listed) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(listed))
+	}
+
+	for idx, expected := range []string{"one", "two", "three"} {
+
+		if string(listed[idx].Body) != expected {
+			t.Fatalf("Expected entry %d to be '%s', got '%s'", idx, expected, listed[idx].Body)
+		}
+	}
+
+	err = s.Remove(ctx, listed[0])
+
+	if err != nil {
+		t.Fatalf("Failed to remove entry, %v", err)
+	}
+
+	listed, err = s.List(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to list entries, %v", err)
+	}
+
+	if len(listed) != 2 || listed[0].DeliveryID != "delivery-2" {
+		t.Fatalf("Unexpected entries after removal, %v", listed)
+	}
+}
+
+func TestBlobStoreListUnreadable(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, root := newTestStore(t)
+
+	e := NewEntry("delivery-1", "/a", "test", 500, "Failed", []byte("one"))
+
+	err := s.Put(ctx, e)
+
+	if err != nil {
+		t.Fatalf("Failed to put entry, %v", err)
+	}
+
+	unreadable := map[string]string{
+		"corrupt.json": "{",
+		"null.json":    "null",
+	}
+
+	for name, body := range unreadable {
+
+		err := os.WriteFile(filepath.Join(root, name), []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", name, err)
+		}
+	}
+
+	// Files without a .json extension are ignored
+
+	err = os.WriteFile(filepath.Join(root, "README.txt"), []byte("hello"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write README.txt, %v", err)
+	}
+
+	listed, err := s.List(ctx)
+
+	if err == nil {
+		t.Fatalf("Expected an error for unreadable entries")
+	}
+
+	if listed == nil || len(listed) != 1 {
+		t.Fatalf("Expected the readable entry to be returned, %v", listed)
+	}
+
+	if listed[0].ID != e.ID || string(listed[0].Body) != "one" {
+		t.Fatalf("Unexpected entry, %v", listed[0])
+	}
+
+	for name := range unreadable {
+
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("Expected error to describe %s, %v", name, err)
+		}
+	}
+}
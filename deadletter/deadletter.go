@@ -0,0 +1,75 @@
+// Package deadletter provides an interface for storing, and retrieving, webhook messages that could not be dispatched.
+package deadletter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// type Entry is a struct containing a webhook message that a dispatcher failed to relay.
+type Entry struct {
+	// ID is the unique identifier for the entry.
+	ID string `json:"id"`
+	// DeliveryID is the unique identifier for the delivery (webhook message) that failed.
+	DeliveryID string `json:"delivery_id"`
+	// Endpoint is the identifier of the webhook that received the message. This is typically its relative URI but it
+	// does not contain any secrets, like a secret path component, since it is stored with the message.
+	Endpoint string `json:"endpoint"`
+	// Dispatcher is the label of the dispatcher that failed to relay the message.
+	Dispatcher string `json:"dispatcher"`
+	// Code is the status code of the error returned by the dispatcher.
+	Code int `json:"code"`
+	// Error is the message of the error returned by the dispatcher.
+	Error string `json:"error"`
+	// Body is the (transformed) message that the dispatcher failed to relay.
+	Body []byte `json:"body"`
+	// Created is the Unix timestamp when the entry was created.
+	Created int64 `json:"created"`
+}
+
+// type Store is an interface for storing, and retrieving, webhook messages that could not be dispatched.
+type Store interface {
+	// Put() stores an `Entry` instance.
+	Put(context.Context, *Entry) error
+	// List() returns all the `Entry` instances in the store sorted by the time they were created. If some entries can
+	// not be read they are skipped and List() returns the entries that could be read along with an error describing the
+	// ones that could not. If the store itself can not be read the list of entries is nil.
+	List(context.Context) ([]*Entry, error)
+	// Remove() removes an `Entry` instance from the store.
+	Remove(context.Context, *Entry) error
+	// Close() closes the store and any underlying resources.
+	Close() error
+}
+
+// NewStore() returns a new `Store` instance derived from 'uri'. Currently all stores are backed by
+// a `gocloud.dev/blob.Bucket` so 'uri' is expected to be a valid and registered `gocloud.dev/blob` URI.
+func NewStore(ctx context.Context, uri string) (Store, error) {
+	return NewBlobStore(ctx, uri)
+}
+
+// NewEntry() returns a new `Entry` instance with a unique ID and the current time.
+func NewEntry(delivery_id string, endpoint string, dispatcher string, code int, message string, body []byte) *Entry {
+
+	now := time.Now()
+
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	id := fmt.Sprintf("%d-%s", now.UnixNano(), hex.EncodeToString(b))
+
+	e := &Entry{
+		ID:         id,
+		DeliveryID: delivery_id,
+		Endpoint:   endpoint,
+		Dispatcher: dispatcher,
+		Code:       code,
+		Error:      message,
+		Body:       body,
+		Created:    now.Unix(),
+	}
+
+	return e
+}
@@ -0,0 +1,46 @@
+// Package delivery provides methods for associating metadata about an individual webhook delivery with a `context.Context`
+// instance so that it is available to receivers, transformations and dispatchers.
+package delivery
+
+import (
+	"context"
+)
+
+type contextKey string
+
+// ID_CONTEXT_KEY is the context key used to store the unique identifier for a delivery.
+const ID_CONTEXT_KEY contextKey = "webhookd.delivery.id"
+
+// DISPATCHER_CONTEXT_KEY is the context key used to store the label of the dispatcher processing a delivery.
+const DISPATCHER_CONTEXT_KEY contextKey = "webhookd.delivery.dispatcher"
+
+// WithID returns a copy of 'ctx' associated with the delivery identifier 'id'.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ID_CONTEXT_KEY, id)
+}
+
+// ID returns the delivery identifier associated with 'ctx' or an empty string if there is none.
+func ID(ctx context.Context) string {
+	return stringValue(ctx, ID_CONTEXT_KEY)
+}
+
+// WithDispatcher returns a copy of 'ctx' associated with the dispatcher label 'label'.
+func WithDispatcher(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, DISPATCHER_CONTEXT_KEY, label)
+}
+
+// Dispatcher returns the dispatcher label associated with 'ctx' or an empty string if there is none.
+func Dispatcher(ctx context.Context) string {
+	return stringValue(ctx, DISPATCHER_CONTEXT_KEY)
+}
+
+func stringValue(ctx context.Context, k contextKey) string {
+
+	v := ctx.Value(k)
+
+	if v == nil {
+		return ""
+	}
+
+	return v.(string)
+}
@@ -0,0 +1,45 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// DEADLINE_EXCEEDED is the `webhookd.WebhookError` code for deliveries that were not processed, or relayed, before their deadline.
+// It is deliberately not an HTTP status code so that deadline errors can not be confused with a `504 Gateway Timeout` error
+// returned by a remote service. Deadline errors are reported to HTTP clients as `504 Gateway Timeout` responses.
+const DEADLINE_EXCEEDED int = 998
+
+// UNKNOWN_OUTCOME is the `webhookd.WebhookError` code for deliveries that were still being processed, or relayed, when
+// their deadline (and a grace period) passed so whether they were processed successfully is not known.
+const UNKNOWN_OUTCOME int = 599
+
+// ContextError returns a `webhookd.WebhookError` describing why 'ctx' is done. If its deadline was exceeded the error
+// code is `DEADLINE_EXCEEDED`, otherwise it is `http.StatusServiceUnavailable`.
+func ContextError(ctx context.Context) *webhookd.WebhookError {
+
+	err := ctx.Err()
+
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &webhookd.WebhookError{Code: DEADLINE_EXCEEDED, Message: err.Error()}
+	}
+
+	return &webhookd.WebhookError{Code: http.StatusServiceUnavailable, Message: err.Error()}
+}
+
+// IsDeadlineExceeded returns a boolean value indicating whether 'err' signals that a deadline was exceeded.
+func IsDeadlineExceeded(err *webhookd.WebhookError) bool {
+	return err != nil && err.Code == DEADLINE_EXCEEDED
+}
+
+// IsUnknownOutcome returns a boolean value indicating whether 'err' signals that the outcome of a delivery is not known.
+func IsUnknownOutcome(err *webhookd.WebhookError) bool {
+	return err != nil && err.Code == UNKNOWN_OUTCOME
+}
@@ -0,0 +1,28 @@
+package dispatcher
+
+import (
+	"context"
+	"strings"
+
+	wh_dispatcher "github.com/whosonfirst/go-webhookd/v3/dispatcher"
+)
+
+// type closer is an interface for dispatchers that hold resources which should be released when they are no longer needed.
+type closer interface {
+	Close(context.Context) error
+}
+
+// registerDispatcher() associates 'scheme' with 'init_func' unless another package has already registered a dispatcher
+// for 'scheme'. This allows the dispatchers in this package, which are derived from their equivalents in other packages,
+// to be used alongside those packages without triggering duplicate registration errors.
+func registerDispatcher(ctx context.Context, scheme string, init_func wh_dispatcher.DispatcherInitializationFunc) error {
+
+	for _, s := range wh_dispatcher.Schemes() {
+
+		if strings.EqualFold(s, scheme+"://") {
+			return nil
+		}
+	}
+
+	return wh_dispatcher.RegisterDispatcher(ctx, scheme, init_func)
+}
@@ -0,0 +1,6 @@
+// Package dispatcher provides Who's On First specific implementations of the `whosonfirst/go-webhookd/v3.WebhookDispatcher` interface.
+//
+// The Lambda and pubsub dispatchers in this package are derived from the `whosonfirst/go-webhookd-aws` and `whosonfirst/go-webhookd-gocloud`
+// packages respectively and additionally propagate W3C Trace Context information to their targets. They are only registered if no other
+// package has already registered a dispatcher for the same scheme.
+package dispatcher
@@ -0,0 +1,331 @@
+package dispatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aaronland/go-aws-session"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/delivery"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/tracing"
+)
+
+var preamble_re = regexp.MustCompile(`^#\s?([^\s]+)\s(.*)$`)
+
+func init() {
+
+	ctx := context.Background()
+	err := registerDispatcher(ctx, "lambda", NewLambdaDispatcher)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// type lambdaClientContext is a struct containing the client context passed to AWS Lambda functions. Values in the
+// `Custom` dictionary are available to functions as `lambdacontext.ClientContext.Custom`.
+type lambdaClientContext struct {
+	Custom map[string]string `json:"custom"`
+}
+
+// type lambdaFunctionError is a struct containing the error payload returned by AWS Lambda functions that fail.
+type lambdaFunctionError struct {
+	ErrorMessage string `json:"errorMessage"`
+	ErrorType    string `json:"errorType"`
+}
+
+// LambdaDispatcher implements the `webhookd.WebhookDispatcher` interface for dispatching messages to an AWS Lambda function.
+// It is derived from the `whosonfirst/go-webhookd-aws` Lambda dispatcher and additionally passes the W3C Trace Context
+// "traceparent" value and the delivery ID for each message in the Lambda ClientContext.
+type LambdaDispatcher struct {
+	webhookd.WebhookDispatcher
+	// LambdaFunction is the name of the Lambda function to invoke.
+	LambdaFunction string
+	// LambdaService is `aws-sdk-go/service/lambda.Lambda` instance use to invoke a Lambda function.
+	LambdaService *lambda.Lambda
+	// invocation_type is the name of AWS Lambda invocation type.
+	invocation_type string
+	// An optional regular expression that will be compared to the commit message; if it matches the dispatcher will return an error with code `webhookd.HaltEvent`
+	halt_on_message *regexp.Regexp
+	// An optional regular expression that will be compared to the commit author; if it matches the dispatcher will return an error with code `webhookd.HaltEvent`
+	halt_on_author *regexp.Regexp
+}
+
+// NewLambdaDispatcher returns a new `LambdaDispatcher` instance configured by 'uri' in the form of:
+//
+//	lambda://{FUNCTION_NAME}?{PARAMETERS}
+//
+// Where {PARAMETERS} are:
+// * `dsn=` A valid `aaronland/go-aws-session` string used to create an AWS session instance.
+// * `invocation_type=` The name of AWS Lambda invocation type. Valid options are: RequestResponse, Event, DryRun.
+// * `?halt_on_message` An optional regular expression that will be compared to the commit message; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+// * `?halt_on_author` An optional regular expression that will be compared to the commit author; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+func NewLambdaDispatcher(ctx context.Context, uri string) (webhookd.WebhookDispatcher, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	lambda_function := u.Host
+
+	q := u.Query()
+
+	lambda_dsn := q.Get("dsn")
+
+	lambda_sess, err := session.NewSessionWithDSN(lambda_dsn)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new AWS session, %w", err)
+	}
+
+	invocation_type := q.Get("invocation_type")
+
+	switch invocation_type {
+	case "":
+		invocation_type = "RequestResponse"
+	case "RequestResponse", "Event", "DryRun":
+		// pass
+	default:
+		return nil, fmt.Errorf("Invalid invocation_type parameter")
+	}
+
+	lambda_svc := lambda.New(lambda_sess)
+
+	d := LambdaDispatcher{
+		LambdaFunction:  lambda_function,
+		LambdaService:   lambda_svc,
+		invocation_type: invocation_type,
+	}
+
+	q_halt_on_message := q.Get("halt_on_message")
+	q_halt_on_author := q.Get("halt_on_author")
+
+	if q_halt_on_message != "" {
+
+		r, err := regexp.Compile(q_halt_on_message)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?halt_on_message= parameter, %w", err)
+		}
+
+		d.halt_on_message = r
+	}
+
+	if q_halt_on_author != "" {
+
+		r, err := regexp.Compile(q_halt_on_author)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?halt_on_author= parameter, %w", err)
+		}
+
+		d.halt_on_author = r
+	}
+
+	return &d, nil
+}
+
+// Dispatch() relays 'body' as base64-endoded JSON string to the AWS Lambda function defined when 'd' was instantiated.
+// If the function was invoked synchronously (the "RequestResponse" invocation type) and it failed then an error containing
+// the message returned by the function is returned.
+func (d *LambdaDispatcher) Dispatch(ctx context.Context, body []byte) *webhookd.WebhookError {
+
+	select {
+	case <-ctx.Done():
+		return delivery.ContextError(ctx)
+	default:
+		// pass
+	}
+
+	body, err := d.processBody(ctx, body)
+
+	if err != nil {
+		return err.(*webhookd.WebhookError)
+	}
+
+	// I don't understand why I need to base64 encode this...
+	// (20200526/thisisaaronland)
+
+	enc_body := base64.StdEncoding.EncodeToString(body)
+
+	payload, err := json.Marshal(enc_body)
+
+	if err != nil {
+		return &webhookd.WebhookError{Code: 999, Message: err.Error()}
+	}
+
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(d.LambdaFunction),
+		Payload:        payload,
+		InvocationType: aws.String(d.invocation_type),
+	}
+
+	client_ctx, err := d.clientContext(ctx)
+
+	if err != nil {
+		return &webhookd.WebhookError{Code: 999, Message: err.Error()}
+	}
+
+	if client_ctx != "" {
+		input.ClientContext = aws.String(client_ctx)
+	}
+
+	rsp, err := d.LambdaService.InvokeWithContext(ctx, input)
+
+	if err != nil {
+		return &webhookd.WebhookError{Code: 999, Message: err.Error()}
+	}
+
+	return d.functionError(rsp)
+}
+
+// functionError() returns a `webhookd.WebhookError` derived from the error payload in 'rsp' if the Lambda function
+// failed or nil if it did not. Lambda reports function errors with a successful (200) status code and the "FunctionError"
+// field set so they are not returned as errors by the AWS SDK.
+func (d *LambdaDispatcher) functionError(rsp *lambda.InvokeOutput) *webhookd.WebhookError {
+
+	if rsp == nil || aws.StringValue(rsp.FunctionError) == "" {
+		return nil
+	}
+
+	var fn_err lambdaFunctionError
+
+	err := json.Unmarshal(rsp.Payload, &fn_err)
+
+	if err != nil || fn_err.ErrorMessage == "" {
+		fn_err.ErrorMessage = strings.TrimSpace(string(rsp.Payload))
+	}
+
+	msg := fmt.Sprintf("Lambda function %s failed (%s)", d.LambdaFunction, aws.StringValue(rsp.FunctionError))
+
+	if fn_err.ErrorType != "" {
+		msg = fmt.Sprintf("%s, %s", msg, fn_err.ErrorType)
+	}
+
+	if fn_err.ErrorMessage != "" {
+		msg = fmt.Sprintf("%s: %s", msg, fn_err.ErrorMessage)
+	}
+
+	return &webhookd.WebhookError{Code: 999, Message: msg}
+}
+
+// clientContext() returns the base64-encoded Lambda ClientContext for 'ctx' or an empty string if there is nothing to propagate.
+func (d *LambdaDispatcher) clientContext(ctx context.Context) (string, error) {
+
+	custom := make(map[string]string)
+
+	traceparent := tracing.Traceparent(ctx)
+
+	if traceparent != "" {
+		custom[tracing.TRACEPARENT_HEADER] = traceparent
+	}
+
+	delivery_id := delivery.ID(ctx)
+
+	if delivery_id != "" {
+		custom["webhookd_delivery_id"] = delivery_id
+	}
+
+	if len(custom) == 0 {
+		return "", nil
+	}
+
+	enc, err := json.Marshal(&lambdaClientContext{Custom: custom})
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal client context, %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// processBody() returns a copy of 'body' with any "#{KEY} {VALUE}" preamble lines, like those added by the `?prepend_message`
+// and `?prepend_author` transformation parameters, removed. All other lines are preserved, each followed by a newline ("\r\n"
+// line endings are normalized to "\n"). A trailing newline is only included if 'body' ended with one. If 'body' contains a
+// "#message" or "#author" preamble matching the ?halt_on_message= or ?halt_on_author= parameters then a `webhookd.HaltEvent`
+// error is returned. If neither parameter is set then 'body' is returned unchanged.
+func (d *LambdaDispatcher) processBody(ctx context.Context, body []byte) ([]byte, error) {
+
+	if d.halt_on_message == nil && d.halt_on_author == nil {
+		return body, nil
+	}
+
+	var buf bytes.Buffer
+	wr := bufio.NewWriter(&buf)
+
+	var message string
+	var author string
+
+	br := bytes.NewReader(body)
+	scanner := bufio.NewScanner(br)
+
+	// Don't fail on long lines, for example a single line of JSON, up to the size of 'body'
+
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+
+	for scanner.Scan() {
+
+		ln := scanner.Text()
+		m := preamble_re.FindStringSubmatch(ln)
+
+		if len(m) != 3 {
+
+			if strings.HasPrefix(ln, "#") {
+				log.Printf("Unhandled comment '%s'", ln)
+			}
+
+			wr.WriteString(ln)
+			wr.WriteString("\n")
+			continue
+		}
+
+		switch m[1] {
+		case "message":
+
+			message = m[2]
+
+			if d.halt_on_message != nil && d.halt_on_message.MatchString(message) {
+				return nil, &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Halt"}
+			}
+
+		case "author":
+			author = m[2]
+
+			if d.halt_on_author != nil && d.halt_on_author.MatchString(author) {
+				return nil, &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Halt"}
+			}
+
+		default:
+			log.Printf("Unhandled preamble '%s'", ln)
+		}
+	}
+
+	err := scanner.Err()
+
+	if err != nil {
+		return nil, &webhookd.WebhookError{Code: 999, Message: fmt.Sprintf("Failed to read body, %v", err)}
+	}
+
+	wr.Flush()
+	processed := buf.Bytes()
+
+	// The scanner doesn't report whether the last line ended with a newline so trim the one added above if the original body didn't
+
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		processed = bytes.TrimSuffix(processed, []byte("\n"))
+	}
+
+	return processed, nil
+}
@@ -0,0 +1,141 @@
+package dispatcher
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+func TestLambdaDispatcherProcessBody(t *testing.T) {
+
+	ctx := context.Background()
+
+	d := &LambdaDispatcher{
+		LambdaFunction:  "test",
+		halt_on_message: regexp.MustCompile(`\[skip\]`),
+		halt_on_author:  regexp.MustCompile(`^bot$`),
+	}
+
+	tests := map[string]string{
+		"a.geojson\nb.geojson\n": "a.geojson\nb.geojson\n",
+		"a.geojson\nb.geojson":   "a.geojson\nb.geojson",
+		"#message Update records\n#author alice\na.geojson\nb.geojson\n": "a.geojson\nb.geojson\n",
+		"a.geojson\r\nb.geojson\r\n":                                     "a.geojson\nb.geojson\n",
+		"a.geojson\n\nb.geojson\n":                                       "a.geojson\n\nb.geojson\n",
+		"":                                                               "",
+	}
+
+	for body, expected := range tests {
+
+		processed, err := d.processBody(ctx, []byte(body))
+
+		if err != nil {
+			t.Fatalf("Failed to process %q, %v", body, err)
+		}
+
+		if string(processed) != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, body, processed)
+		}
+	}
+
+	// Lines longer than the default bufio.Scanner buffer are preserved
+
+	long := strings.Repeat("x", 128*1024)
+
+	processed, err := d.processBody(ctx, []byte("#author alice\n"+long))
+
+	if err != nil {
+		t.Fatalf("Failed to process long line, %v", err)
+	}
+
+	if string(processed) != long {
+		t.Fatalf("Expected long line to be preserved, got %d bytes", len(processed))
+	}
+
+	halt := []string{
+		"#message Update records [skip]\na.geojson\n",
+		"#author bot\na.geojson\n",
+	}
+
+	for _, body := range halt {
+
+		_, err := d.processBody(ctx, []byte(body))
+
+		if err == nil {
+			t.Fatalf("Expected %q to halt", body)
+		}
+
+		if err.(*webhookd.WebhookError).Code != webhookd.HaltEvent {
+			t.Fatalf("Expected halt event for %q, got %v", body, err)
+		}
+	}
+
+	// Without halt parameters the body is passed through untouched
+
+	d = &LambdaDispatcher{}
+
+	body := "#message Update records\na.geojson"
+
+	processed, err = d.processBody(ctx, []byte(body))
+
+	if err != nil {
+		t.Fatalf("Failed to process body, %v", err)
+	}
+
+	if string(processed) != body {
+		t.Fatalf("Expected body to be unchanged, got %q", processed)
+	}
+}
+
+func TestLambdaDispatcherFunctionError(t *testing.T) {
+
+	d := &LambdaDispatcher{
+		LambdaFunction: "test",
+	}
+
+	ok := []*lambda.InvokeOutput{
+		nil,
+		&lambda.InvokeOutput{StatusCode: aws.Int64(200), Payload: []byte(`"ok"`)},
+		&lambda.InvokeOutput{StatusCode: aws.Int64(202)},
+	}
+
+	for idx, rsp := range ok {
+
+		err := d.functionError(rsp)
+
+		if err != nil {
+			t.Fatalf("Expected response %d to succeed, got %v", idx, err)
+		}
+	}
+
+	tests := map[string]string{
+		`{"errorMessage":"Failed to clone repo","errorType":"Exception"}`: "Lambda function test failed (Unhandled), Exception: Failed to clone repo",
+		`{"errorMessage":"Task timed out after 3.00 seconds"}`:            "Lambda function test failed (Unhandled): Task timed out after 3.00 seconds",
+		`Internal error`: "Lambda function test failed (Unhandled): Internal error",
+		``:               "Lambda function test failed (Unhandled)",
+	}
+
+	for payload, expected := range tests {
+
+		rsp := &lambda.InvokeOutput{
+			StatusCode:    aws.Int64(200),
+			FunctionError: aws.String("Unhandled"),
+			Payload:       []byte(payload),
+		}
+
+		err := d.functionError(rsp)
+
+		if err == nil {
+			t.Fatalf("Expected error for %q", payload)
+		}
+
+		if err.Message != expected {
+			t.Fatalf("Expected '%s', got '%s'", expected, err.Message)
+		}
+	}
+}
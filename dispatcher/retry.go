@@ -0,0 +1,347 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	aa_log "github.com/aaronland/go-log/v2"
+	"github.com/whosonfirst/go-webhookd/v3"
+	wh_dispatcher "github.com/whosonfirst/go-webhookd/v3/dispatcher"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/delivery"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/envelope"
+)
+
+// RETRY_THROTTLED is the retryable error class for errors caused by throttling or rate limits.
+const RETRY_THROTTLED string = "throttled"
+
+// RETRY_TIMEOUT is the retryable error class for errors caused by timeouts.
+const RETRY_TIMEOUT string = "timeout"
+
+// RETRY_SERVER is the retryable error class for errors caused by (remote) server errors.
+const RETRY_SERVER string = "server"
+
+// RETRY_ANY is the retryable error class for any error that is not a `webhookd.HaltEvent` or `webhookd.UnhandledEvent` error.
+const RETRY_ANY string = "any"
+
+var retry_classes = map[string]*regexp.Regexp{
+	RETRY_THROTTLED: regexp.MustCompile(`(?i)(throttl|too\s?many\s?requests|rate\s?exceeded|slow\s?down|\b429\b)`),
+	RETRY_TIMEOUT:   regexp.MustCompile(`(?i)(timeout|timed\s?out|deadline\s?exceeded)`),
+	RETRY_SERVER:    regexp.MustCompile(`(?i)(service\s?(exception|unavailable)|internal\s?(server\s?)?error|\b50[0-4]\b)`),
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := wh_dispatcher.RegisterDispatcher(ctx, "retry", NewRetryDispatcher)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RetryDispatcher implements the `webhookd.WebhookDispatcher` interface for wrapping another dispatcher and retrying
+// failed dispatches with an exponential backoff.
+type RetryDispatcher struct {
+	webhookd.WebhookDispatcher
+	// dispatcher is the underlying `webhookd.WebhookDispatcher` instance that messages are relayed to.
+	dispatcher webhookd.WebhookDispatcher
+	// max_attempts is the maximum number of times a message will be dispatched, including the first attempt.
+	max_attempts int
+	// base_backoff is the amount of time to wait before the first retry. It is doubled for each subsequent retry.
+	base_backoff time.Duration
+	// max_backoff is the maximum amount of time to wait between retries.
+	max_backoff time.Duration
+	// jitter is the strategy used to randomize backoff intervals. Valid options are: full, equal, none.
+	jitter string
+	// retry_on is the list of error classes that will be retried.
+	retry_on []string
+	// logger is the `log.Logger` instance used to record each attempt.
+	logger *log.Logger
+}
+
+// NewRetryDispatcher returns a new `RetryDispatcher` instance configured by 'uri' in the form of:
+//
+//	retry://?dispatcher={DISPATCHER_URI}&{PARAMETERS}
+//
+// Where {DISPATCHER_URI} is the URL-encoded URI of any registered `webhookd.WebhookDispatcher` and {PARAMETERS} are:
+// * `?max_attempts=` The maximum number of times a message will be dispatched, including the first attempt. Default is 3.
+// * `?base_backoff=` A valid `time.Duration` string for the amount of time to wait before the first retry. Default is 1s.
+// * `?max_backoff=` A valid `time.Duration` string for the maximum amount of time to wait between retries. Default is 30s.
+// * `?jitter=` The strategy used to randomize backoff intervals. Valid options are: full, equal, none. Default is full.
+// * `?retry_on=` One or more error classes to retry. Valid options are: throttled, timeout, server, any. Default is any.
+func NewRetryDispatcher(ctx context.Context, uri string) (webhookd.WebhookDispatcher, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	dispatcher_uri := q.Get("dispatcher")
+
+	if dispatcher_uri == "" {
+		return nil, fmt.Errorf("Missing ?dispatcher= parameter")
+	}
+
+	dr, err := wh_dispatcher.NewDispatcher(ctx, dispatcher_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create dispatcher for '%s', %w", dispatcher_uri, err)
+	}
+
+	d := &RetryDispatcher{
+		dispatcher:   dr,
+		max_attempts: 3,
+		base_backoff: 1 * time.Second,
+		max_backoff:  30 * time.Second,
+		jitter:       "full",
+		retry_on:     []string{RETRY_ANY},
+		logger:       log.Default(),
+	}
+
+	q_attempts := q.Get("max_attempts")
+
+	if q_attempts != "" {
+
+		v, err := strconv.Atoi(q_attempts)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max_attempts= parameter, %w", err)
+		}
+
+		if v < 1 {
+			return nil, fmt.Errorf("Invalid ?max_attempts= parameter, must be greater than zero")
+		}
+
+		d.max_attempts = v
+	}
+
+	q_base := q.Get("base_backoff")
+
+	if q_base != "" {
+
+		v, err := time.ParseDuration(q_base)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?base_backoff= parameter, %w", err)
+		}
+
+		d.base_backoff = v
+	}
+
+	q_max := q.Get("max_backoff")
+
+	if q_max != "" {
+
+		v, err := time.ParseDuration(q_max)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max_backoff= parameter, %w", err)
+		}
+
+		d.max_backoff = v
+	}
+
+	if d.max_backoff < d.base_backoff {
+		return nil, fmt.Errorf("Invalid ?max_backoff= parameter, must be greater than or equal to base backoff")
+	}
+
+	q_jitter := q.Get("jitter")
+
+	switch q_jitter {
+	case "":
+		// pass
+	case "full", "equal", "none":
+		d.jitter = q_jitter
+	default:
+		return nil, fmt.Errorf("Invalid ?jitter= parameter")
+	}
+
+	q_retry_on, ok := q["retry_on"]
+
+	if ok {
+
+		retry_on := make([]string, 0)
+
+		for _, str_classes := range q_retry_on {
+
+			for _, class := range strings.Split(str_classes, ",") {
+
+				class = strings.TrimSpace(class)
+
+				_, known := retry_classes[class]
+
+				if !known && class != RETRY_ANY {
+					return nil, fmt.Errorf("Invalid ?retry_on= parameter, '%s'", class)
+				}
+
+				retry_on = append(retry_on, class)
+			}
+		}
+
+		d.retry_on = retry_on
+	}
+
+	return d, nil
+}
+
+// Dispatch() relays 'body' to the underlying dispatcher, retrying failed attempts according to the rules defined
+// when 'd' was instantiated. Errors with `webhookd.HaltEvent` or `webhookd.UnhandledEvent` codes are never retried.
+func (d *RetryDispatcher) Dispatch(ctx context.Context, body []byte) *webhookd.WebhookError {
+
+	fn := func(ctx context.Context) *webhookd.WebhookError {
+		return d.dispatcher.Dispatch(ctx, body)
+	}
+
+	return d.retry(ctx, fn)
+}
+
+// DispatchEnvelope() relays 'e' to the underlying dispatcher, retrying failed attempts according to the rules defined
+// when 'd' was instantiated. If the underlying dispatcher does not implement the `envelope.EnvelopeDispatcher` interface
+// only the body of 'e' is relayed.
+func (d *RetryDispatcher) DispatchEnvelope(ctx context.Context, e *envelope.Envelope) *webhookd.WebhookError {
+
+	dr := envelope.NewDispatcherAdapter(d.dispatcher)
+
+	fn := func(ctx context.Context) *webhookd.WebhookError {
+		return dr.DispatchEnvelope(ctx, e)
+	}
+
+	return d.retry(ctx, fn)
+}
+
+// retry() invokes 'fn', retrying failed attempts according to the rules defined when 'd' was instantiated.
+func (d *RetryDispatcher) retry(ctx context.Context, fn func(context.Context) *webhookd.WebhookError) *webhookd.WebhookError {
+
+	label := delivery.Dispatcher(ctx)
+
+	if label == "" {
+		label = fmt.Sprintf("%T", d.dispatcher)
+	}
+
+	delivery_id := delivery.ID(ctx)
+
+	var err *webhookd.WebhookError
+
+	for attempt := 1; attempt <= d.max_attempts; attempt++ {
+
+		select {
+		case <-ctx.Done():
+			return delivery.ContextError(ctx)
+		default:
+			// pass
+		}
+
+		err = fn(ctx)
+
+		if err == nil {
+
+			if attempt > 1 {
+				aa_log.Info(d.logger, "Dispatcher %s succeeded for delivery %s on attempt %d/%d", label, delivery_id, attempt, d.max_attempts)
+			}
+
+			return nil
+		}
+
+		if !d.isRetryable(err) {
+			return err
+		}
+
+		if attempt == d.max_attempts {
+			break
+		}
+
+		backoff := d.backoff(attempt)
+
+		aa_log.Warning(d.logger, "Dispatcher %s failed for delivery %s on attempt %d/%d, retrying in %v, %v", label, delivery_id, attempt, d.max_attempts, backoff, err)
+
+		t := time.NewTimer(backoff)
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return delivery.ContextError(ctx)
+		case <-t.C:
+			// pass
+		}
+	}
+
+	aa_log.Error(d.logger, "Dispatcher %s failed for delivery %s after %d attempts, %v", label, delivery_id, d.max_attempts, err)
+	return err
+}
+
+// Close() closes the underlying dispatcher if it implements a `Close(context.Context) error` method.
+func (d *RetryDispatcher) Close(ctx context.Context) error {
+
+	c, ok := d.dispatcher.(closer)
+
+	if !ok {
+		return nil
+	}
+
+	return c.Close(ctx)
+}
+
+// isRetryable() returns a boolean value indicating whether 'err' belongs to one of the error classes that 'd' retries.
+func (d *RetryDispatcher) isRetryable(err *webhookd.WebhookError) bool {
+
+	switch err.Code {
+	case webhookd.HaltEvent, webhookd.UnhandledEvent:
+		return false
+	}
+
+	str_code := strconv.Itoa(err.Code)
+
+	for _, class := range d.retry_on {
+
+		if class == RETRY_ANY {
+			return true
+		}
+
+		re := retry_classes[class]
+
+		if re.MatchString(err.Message) || re.MatchString(str_code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff() returns the amount of time to wait after 'attempt' before trying again.
+func (d *RetryDispatcher) backoff(attempt int) time.Duration {
+
+	backoff := d.max_backoff
+
+	if attempt < 32 {
+
+		v := d.base_backoff * time.Duration(1<<uint(attempt-1))
+
+		if v > 0 && v < d.max_backoff {
+			backoff = v
+		}
+	}
+
+	if backoff <= 0 {
+		return 0
+	}
+
+	switch d.jitter {
+	case "full":
+		backoff = time.Duration(rand.Int63n(int64(backoff) + 1))
+	case "equal":
+		half := backoff / 2
+		backoff = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+
+	return backoff
+}
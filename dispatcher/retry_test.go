@@ -0,0 +1,329 @@
+package dispatcher
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// type failingDispatcher implements the `webhookd.WebhookDispatcher` interface for dispatchers which fail a fixed
+// number of times before succeeding.
+type failingDispatcher struct {
+	webhookd.WebhookDispatcher
+	// mu is used to synchronize access to 'calls'.
+	mu *sync.Mutex
+	// calls is the number of times Dispatch() has been called.
+	calls int
+	// fail is the number of calls which fail before messages are dispatched successfully.
+	fail int
+	// err is the error returned by calls which fail.
+	err *webhookd.WebhookError
+}
+
+// Dispatch() returns 'dr.err' for the first 'dr.fail' calls and nil thereafter.
+func (dr *failingDispatcher) Dispatch(ctx context.Context, body []byte) *webhookd.WebhookError {
+
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	dr.calls += 1
+
+	if dr.calls <= dr.fail {
+		return dr.err
+	}
+
+	return nil
+}
+
+// newTestRetryDispatcher() returns a new `RetryDispatcher` instance, which does not wait between attempts, wrapping
+// a `failingDispatcher` instance which fails 'fail' times with 'err'.
+func newTestRetryDispatcher(max_attempts int, retry_on []string, fail int, err *webhookd.WebhookError) (*RetryDispatcher, *failingDispatcher) {
+
+	dr := &failingDispatcher{
+		mu:   new(sync.Mutex),
+		fail: fail,
+		err:  err,
+	}
+
+	d := &RetryDispatcher{
+		dispatcher:   dr,
+		max_attempts: max_attempts,
+		base_backoff: 0,
+		max_backoff:  0,
+		jitter:       "none",
+		retry_on:     retry_on,
+		logger:       log.New(io.Discard, "", 0),
+	}
+
+	return d, dr
+}
+
+func TestRetryDispatcherIsRetryable(t *testing.T) {
+
+	tests := []struct {
+		retry_on  string
+		code      int
+		message   string
+		retryable bool
+	}{
+		{RETRY_ANY, http.StatusBadRequest, "Bad request", true},
+		{RETRY_ANY, webhookd.HaltEvent, "Halted", false},
+		{RETRY_ANY, webhookd.UnhandledEvent, "Unhandled", false},
+		{RETRY_THROTTLED, http.StatusTooManyRequests, "Slow down", true},
+		{RETRY_THROTTLED, 999, "ThrottlingException: Rate exceeded", true},
+		{RETRY_THROTTLED, 999, "TooManyRequestsException", true},
+		{RETRY_THROTTLED, 999, "Request was throttled", true},
+		{RETRY_THROTTLED, 999, "Received status 429", true},
+		{RETRY_THROTTLED, 999, "Received status 4290", false},
+		{RETRY_THROTTLED, http.StatusInternalServerError, "Internal server error", false},
+		{RETRY_TIMEOUT, 999, "context deadline exceeded", true},
+		{RETRY_TIMEOUT, 999, "Request timed out", true},
+		{RETRY_TIMEOUT, 999, "i/o timeout", true},
+		{RETRY_TIMEOUT, http.StatusTooManyRequests, "Too many requests", false},
+		{RETRY_SERVER, http.StatusInternalServerError, "Failed", true},
+		{RETRY_SERVER, http.StatusServiceUnavailable, "Failed", true},
+		{RETRY_SERVER, 999, "ServiceException: Lambda was unable to run the function", true},
+		{RETRY_SERVER, 999, "Service Unavailable", true},
+		{RETRY_SERVER, 999, "Internal Error", true},
+		{RETRY_SERVER, http.StatusBadRequest, "Failed", false},
+		{RETRY_SERVER, http.StatusHTTPVersionNotSupported, "Failed", false},
+		{RETRY_SERVER, webhookd.HaltEvent, "Internal server error", false},
+	}
+
+	for _, test := range tests {
+
+		d, _ := newTestRetryDispatcher(3, []string{test.retry_on}, 0, nil)
+
+		err := &webhookd.WebhookError{Code: test.code, Message: test.message}
+		retryable := d.isRetryable(err)
+
+		if retryable != test.retryable {
+			t.Fatalf("Expected error %d '%s' retryable to be %t for '%s'", test.code, test.message, test.retryable, test.retry_on)
+		}
+	}
+
+	// Multiple classes
+
+	d, _ := newTestRetryDispatcher(3, []string{RETRY_THROTTLED, RETRY_SERVER}, 0, nil)
+
+	if !d.isRetryable(&webhookd.WebhookError{Code: http.StatusBadGateway, Message: "Bad gateway"}) {
+		t.Fatalf("Expected 502 error to be retryable for throttled,server")
+	}
+
+	if d.isRetryable(&webhookd.WebhookError{Code: 999, Message: "Request timed out"}) {
+		t.Fatalf("Expected timeout error not to be retryable for throttled,server")
+	}
+}
+
+func TestRetryDispatcherBackoff(t *testing.T) {
+
+	d := &RetryDispatcher{
+		base_backoff: 100 * time.Millisecond,
+		max_backoff:  time.Second,
+		jitter:       "none",
+	}
+
+	tests := map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		3:  400 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		6:  time.Second,
+		31: time.Second,
+		64: time.Second,
+	}
+
+	for attempt, expected := range tests {
+
+		backoff := d.backoff(attempt)
+
+		if backoff != expected {
+			t.Fatalf("Expected backoff for attempt %d to be %v, got %v", attempt, expected, backoff)
+		}
+	}
+
+	d.base_backoff = 0
+	d.max_backoff = 0
+
+	if d.backoff(1) != 0 {
+		t.Fatalf("Expected zero backoff, got %v", d.backoff(1))
+	}
+}
+
+func TestRetryDispatcherBackoffJitter(t *testing.T) {
+
+	tests := []struct {
+		jitter string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"full", 0, 400 * time.Millisecond},
+		{"equal", 200 * time.Millisecond, 400 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+
+		d := &RetryDispatcher{
+			base_backoff: 100 * time.Millisecond,
+			max_backoff:  time.Second,
+			jitter:       test.jitter,
+		}
+
+		distinct := make(map[time.Duration]bool)
+
+		for i := 0; i < 1000; i++ {
+
+			backoff := d.backoff(3)
+
+			if backoff < test.min || backoff > test.max {
+				t.Fatalf("Expected %s jitter backoff to be between %v and %v, got %v", test.jitter, test.min, test.max, backoff)
+			}
+
+			distinct[backoff] = true
+		}
+
+		if len(distinct) < 2 {
+			t.Fatalf("Expected %s jitter backoff to vary", test.jitter)
+		}
+	}
+}
+
+func TestRetryDispatcherDispatch(t *testing.T) {
+
+	ctx := context.Background()
+
+	server_err := &webhookd.WebhookError{Code: http.StatusServiceUnavailable, Message: "Service unavailable"}
+	client_err := &webhookd.WebhookError{Code: http.StatusBadRequest, Message: "Bad request"}
+	halt_err := &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Halted"}
+
+	tests := []struct {
+		label        string
+		max_attempts int
+		retry_on     []string
+		fail         int
+		err          *webhookd.WebhookError
+		calls        int
+		code         int
+	}{
+		{"success", 3, []string{RETRY_ANY}, 0, nil, 1, 0},
+		{"success after failures", 3, []string{RETRY_ANY}, 2, server_err, 3, 0},
+		{"failure after max attempts", 3, []string{RETRY_ANY}, 5, server_err, 3, http.StatusServiceUnavailable},
+		{"single attempt", 1, []string{RETRY_ANY}, 1, server_err, 1, http.StatusServiceUnavailable},
+		{"retryable class", 5, []string{RETRY_SERVER}, 4, server_err, 5, 0},
+		{"non-retryable class", 5, []string{RETRY_SERVER}, 4, client_err, 1, http.StatusBadRequest},
+		{"halt event", 5, []string{RETRY_ANY}, 4, halt_err, 1, webhookd.HaltEvent},
+	}
+
+	for _, test := range tests {
+
+		d, dr := newTestRetryDispatcher(test.max_attempts, test.retry_on, test.fail, test.err)
+
+		err := d.Dispatch(ctx, []byte("hello world"))
+
+		if dr.calls != test.calls {
+			t.Fatalf("Expected '%s' to make %d attempts, got %d", test.label, test.calls, dr.calls)
+		}
+
+		if test.code == 0 {
+
+			if err != nil {
+				t.Fatalf("Expected '%s' to succeed, %v", test.label, err)
+			}
+
+			continue
+		}
+
+		if err == nil || err.Code != test.code {
+			t.Fatalf("Expected '%s' to fail with code %d, got %v", test.label, test.code, err)
+		}
+	}
+}
+
+func TestRetryDispatcherContextCancelled(t *testing.T) {
+
+	server_err := &webhookd.WebhookError{Code: http.StatusServiceUnavailable, Message: "Service unavailable"}
+
+	d, dr := newTestRetryDispatcher(3, []string{RETRY_ANY}, 5, server_err)
+	d.base_backoff = time.Minute
+	d.max_backoff = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	err := d.Dispatch(ctx, []byte("hello world"))
+
+	if time.Since(start) > 10*time.Second {
+		t.Fatalf("Expected dispatch to stop waiting when its context was cancelled")
+	}
+
+	if dr.calls != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", dr.calls)
+	}
+
+	if err == nil || err.Code != http.StatusServiceUnavailable || err.Message != context.Canceled.Error() {
+		t.Fatalf("Expected context error, got %v", err)
+	}
+
+	// A context which is already done is not dispatched at all
+
+	d, dr = newTestRetryDispatcher(3, []string{RETRY_ANY}, 0, nil)
+
+	err = d.Dispatch(ctx, []byte("hello world"))
+
+	if dr.calls != 0 || err == nil {
+		t.Fatalf("Expected cancelled context not to be dispatched, %d attempts, %v", dr.calls, err)
+	}
+}
+
+func TestNewRetryDispatcher(t *testing.T) {
+
+	ctx := context.Background()
+
+	null_uri := url.QueryEscape("null://")
+
+	valid := []string{
+		"retry://?dispatcher=" + null_uri,
+		"retry://?dispatcher=" + null_uri + "&max_attempts=5&base_backoff=10ms&max_backoff=1s&jitter=equal",
+		"retry://?dispatcher=" + null_uri + "&retry_on=throttled,timeout&retry_on=server",
+	}
+
+	for _, uri := range valid {
+
+		_, err := NewRetryDispatcher(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create dispatcher for '%s', %v", uri, err)
+		}
+	}
+
+	invalid := []string{
+		"retry://",
+		"retry://?dispatcher=" + null_uri + "&max_attempts=0",
+		"retry://?dispatcher=" + null_uri + "&max_attempts=many",
+		"retry://?dispatcher=" + null_uri + "&base_backoff=2s&max_backoff=1s",
+		"retry://?dispatcher=" + null_uri + "&jitter=some",
+		"retry://?dispatcher=" + null_uri + "&retry_on=server,client",
+	}
+
+	for _, uri := range invalid {
+
+		_, err := NewRetryDispatcher(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", uri)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package envelope
+
+import (
+	"context"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// type EnvelopeTransformation is an optional interface for `webhookd.WebhookTransformation` implementations that want to
+// access the metadata for a webhook message.
+type EnvelopeTransformation interface {
+	// TransformEnvelope() alters the body, and optionally the metadata, of a webhook message returning a new `Envelope` instance.
+	TransformEnvelope(context.Context, *Envelope) (*Envelope, *webhookd.WebhookError)
+}
+
+// type EnvelopeDispatcher is an optional interface for `webhookd.WebhookDispatcher` implementations that want to
+// access the metadata for a webhook message.
+type EnvelopeDispatcher interface {
+	// DispatchEnvelope() relays the body, and optionally the metadata, of a webhook message.
+	DispatchEnvelope(context.Context, *Envelope) *webhookd.WebhookError
+}
+
+// TransformationAdapter implements the `EnvelopeTransformation` interface for `webhookd.WebhookTransformation` implementations
+// that only operate on message bodies. The metadata for the message is preserved.
+type TransformationAdapter struct {
+	EnvelopeTransformation
+	// transformation is the underlying `webhookd.WebhookTransformation` instance.
+	transformation webhookd.WebhookTransformation
+}
+
+// NewTransformationAdapter() returns an `EnvelopeTransformation` instance for 't'. If 't' already implements the
+// `EnvelopeTransformation` interface it is returned as-is, otherwise it is wrapped in a `TransformationAdapter` instance.
+func NewTransformationAdapter(t webhookd.WebhookTransformation) EnvelopeTransformation {
+
+	et, ok := t.(EnvelopeTransformation)
+
+	if ok {
+		return et
+	}
+
+	return &TransformationAdapter{
+		transformation: t,
+	}
+}
+
+// TransformEnvelope() applies the underlying transformation to the body of 'e' and returns a copy of 'e' with the new body.
+func (a *TransformationAdapter) TransformEnvelope(ctx context.Context, e *Envelope) (*Envelope, *webhookd.WebhookError) {
+
+	body, err := a.transformation.Transform(ctx, e.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.WithBody(body), nil
+}
+
+// DispatcherAdapter implements the `EnvelopeDispatcher` interface for `webhookd.WebhookDispatcher` implementations
+// that only operate on message bodies.
+type DispatcherAdapter struct {
+	EnvelopeDispatcher
+	// dispatcher is the underlying `webhookd.WebhookDispatcher` instance.
+	dispatcher webhookd.WebhookDispatcher
+}
+
+// NewDispatcherAdapter() returns an `EnvelopeDispatcher` instance for 'd'. If 'd' already implements the
+// `EnvelopeDispatcher` interface it is returned as-is, otherwise it is wrapped in a `DispatcherAdapter` instance.
+func NewDispatcherAdapter(d webhookd.WebhookDispatcher) EnvelopeDispatcher {
+
+	ed, ok := d.(EnvelopeDispatcher)
+
+	if ok {
+		return ed
+	}
+
+	return &DispatcherAdapter{
+		dispatcher: d,
+	}
+}
+
+// DispatchEnvelope() relays the body of 'e' using the underlying dispatcher.
+func (a *DispatcherAdapter) DispatchEnvelope(ctx context.Context, e *Envelope) *webhookd.WebhookError {
+	return a.dispatcher.Dispatch(ctx, e.Body)
+}
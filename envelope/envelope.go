@@ -0,0 +1,99 @@
+// Package envelope provides a type for carrying the body of a webhook message, and metadata about the request that
+// delivered it, through the webhook pipeline along with optional interfaces, and adapters, for transformations and
+// dispatchers that want to use that metadata.
+package envelope
+
+import (
+	"net/http"
+	"time"
+)
+
+// EVENT_HEADERS is the list of request headers, in order of precedence, used to derive the event type of a webhook message.
+var EVENT_HEADERS = []string{
+	"X-GitHub-Event",
+	"X-Gitlab-Event",
+	"X-Gitea-Event",
+	"X-Forgejo-Event",
+	"X-Event-Key",
+}
+
+// DELIVERY_HEADERS is the list of request headers, in order of precedence, used to derive the delivery ID of a webhook message.
+var DELIVERY_HEADERS = []string{
+	"X-GitHub-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Gitea-Delivery",
+	"X-Forgejo-Delivery",
+	"X-Request-UUID",
+}
+
+// type Envelope is a struct containing the body of a webhook message and metadata about the request that delivered it.
+type Envelope struct {
+	// Body is the body of the message. After each transformation it is the output of that transformation.
+	Body []byte `json:"body"`
+	// Headers are the headers of the request that delivered the message.
+	Headers http.Header `json:"headers,omitempty"`
+	// EventType is the type of event that triggered the message, for example the value of the `X-GitHub-Event` header.
+	EventType string `json:"event_type,omitempty"`
+	// DeliveryID is the unique identifier for the message (delivery).
+	DeliveryID string `json:"delivery_id"`
+	// Endpoint is the identifier of the webhook that received the message. This is typically its relative URI but it
+	// does not contain any secrets, like a secret path component, since it may be relayed to other services.
+	Endpoint string `json:"endpoint"`
+	// ReceivedAt is the time the message was received.
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// NewEnvelope() returns a new `Envelope` instance for 'body', received by the webhook identified by 'endpoint', whose metadata
+// is derived from 'req'.
+func NewEnvelope(req *http.Request, endpoint string, delivery_id string, body []byte) *Envelope {
+
+	e := &Envelope{
+		Body:       body,
+		Headers:    req.Header.Clone(),
+		EventType:  EventType(req.Header),
+		DeliveryID: delivery_id,
+		Endpoint:   endpoint,
+		ReceivedAt: time.Now(),
+	}
+
+	return e
+}
+
+// WithBody() returns a copy of 'e' whose body is 'body'.
+func (e *Envelope) WithBody(body []byte) *Envelope {
+
+	c := *e
+	c.Body = body
+
+	return &c
+}
+
+// EventType() returns the event type derived from the first header in `EVENT_HEADERS` present in 'h'.
+func EventType(h http.Header) string {
+
+	for _, k := range EVENT_HEADERS {
+
+		v := h.Get(k)
+
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// DeliveryID() returns the delivery ID derived from the first header in `DELIVERY_HEADERS` present in 'h'.
+func DeliveryID(h http.Header) string {
+
+	for _, k := range DELIVERY_HEADERS {
+
+		v := h.Get(k)
+
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
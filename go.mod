@@ -1,14 +1,19 @@
 module github.com/whosonfirst/go-whosonfirst-webhookd
 
-go 1.18
+go 1.21
 
 require (
 	github.com/aaronland/go-aws-ecs v0.0.4
 	github.com/aaronland/go-aws-session v0.1.0
+	github.com/aaronland/go-http-server v1.0.0
 	github.com/aaronland/go-log/v2 v2.0.0
+	github.com/aaronland/go-roster v1.0.0
 	github.com/aaronland/gocloud-blob-s3 v0.2.2
 	github.com/aws/aws-lambda-go v1.37.0
 	github.com/aws/aws-sdk-go v1.44.198
+	github.com/aws/aws-sdk-go-v2 v1.17.1
+	github.com/aws/aws-sdk-go-v2/service/sns v1.18.6
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.19.15
 	github.com/sfomuseum/go-flags v0.10.0
 	github.com/sfomuseum/runtimevar v1.0.4
 	github.com/whosonfirst/go-webhookd-aws/v2 v2.4.1
@@ -20,12 +25,9 @@ require (
 
 require (
 	github.com/aaronland/go-chicken v0.2.2 // indirect
-	github.com/aaronland/go-http-server v1.0.0 // indirect
-	github.com/aaronland/go-roster v1.0.0 // indirect
 	github.com/aaronland/go-string v1.0.0 // indirect
 	github.com/aaronland/go-ucd/v13 v13.0.0 // indirect
 	github.com/akrylysov/algnhsa v0.12.1 // indirect
-	github.com/aws/aws-sdk-go-v2 v1.17.1 // indirect
 	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.4.9 // indirect
 	github.com/aws/aws-sdk-go-v2/config v1.18.3 // indirect
 	github.com/aws/aws-sdk-go-v2/credentials v1.13.3 // indirect
@@ -40,8 +42,6 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/internal/presigned-url v1.9.19 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/s3shared v1.13.19 // indirect
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.29.4 // indirect
-	github.com/aws/aws-sdk-go-v2/service/sns v1.18.6 // indirect
-	github.com/aws/aws-sdk-go-v2/service/sqs v1.19.15 // indirect
 	github.com/aws/aws-sdk-go-v2/service/ssm v1.33.1 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sso v1.11.25 // indirect
 	github.com/aws/aws-sdk-go-v2/service/ssooidc v1.13.8 // indirect
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"gocloud.dev/blob"
+)
+
+// BlobProbe implements the `Probe` interface for checking that a `gocloud.dev/blob.Bucket` instance is accessible.
+type BlobProbe struct {
+	// bucket is the `gocloud.dev/blob.Bucket` instance to check.
+	bucket *blob.Bucket
+}
+
+// NewBlobProbe returns a new `BlobProbe` instance configured by 'uri' which is expected to be a valid and registered
+// `gocloud.dev/blob.Bucket` URI. The `whosonfirst/go-webhookd-gocloud` specific `?dispatch_prefix=` parameter is removed
+// before the underlying bucket is opened.
+func NewBlobProbe(ctx context.Context, uri string) (Probe, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+	q.Del("dispatch_prefix")
+	u.RawQuery = q.Encode()
+
+	bucket, err := blob.OpenBucket(ctx, u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	p := &BlobProbe{
+		bucket: bucket,
+	}
+
+	return p, nil
+}
+
+// Probe() returns an error if the bucket associated with 'p' is not accessible.
+func (p *BlobProbe) Probe(ctx context.Context) error {
+
+	ok, err := p.bucket.IsAccessible(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to determine whether bucket is accessible, %w", err)
+	}
+
+	if !ok {
+		return fmt.Errorf("Bucket is not accessible")
+	}
+
+	return nil
+}
+
+// Close() closes the bucket associated with 'p'.
+func (p *BlobProbe) Close(ctx context.Context) error {
+	return p.bucket.Close()
+}
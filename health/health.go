@@ -0,0 +1,175 @@
+// Package health provides methods for probing whether the resources used by webhookd, like dispatchers, are available.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/pubsub"
+)
+
+// STATUS_OK is the status for a component whose probe succeeded.
+const STATUS_OK string = "ok"
+
+// STATUS_UNAVAILABLE is the status for a component whose probe failed.
+const STATUS_UNAVAILABLE string = "unavailable"
+
+// type Probe is an interface for checking whether a resource is available.
+type Probe interface {
+	// Probe() returns an error if the resource is not available.
+	Probe(context.Context) error
+}
+
+// type ClosableProbe is an optional interface for `Probe` implementations that hold resources, for example buckets or
+// pubsub topics, that should be released when the probe is no longer used.
+type ClosableProbe interface {
+	// Close() releases any resources held by the probe.
+	Close(context.Context) error
+}
+
+// type ComponentReport is a struct containing the outcome of probing an individual component.
+type ComponentReport struct {
+	// Name is the name of the component.
+	Name string `json:"name"`
+	// Status is the outcome of the probe. Valid options are: ok, unavailable.
+	Status string `json:"status"`
+	// Error is the message of the error returned by the probe, if any.
+	Error string `json:"error,omitempty"`
+	// Duration is the amount of time it took to probe the component.
+	Duration string `json:"duration"`
+}
+
+// type Report is a struct containing the outcome of probing zero or more components.
+type Report struct {
+	// Status is the overall status of the report. It is "ok" only if every component is "ok".
+	Status string `json:"status"`
+	// Components is the list of outcomes for each component.
+	Components []*ComponentReport `json:"components,omitempty"`
+}
+
+// NewDispatcherProbe() returns a new `Probe` instance for the dispatcher defined by 'uri'. If there is no way to
+// probe the dispatcher, for example `log://` or `null://` dispatchers, then the method returns nil.
+func NewDispatcherProbe(ctx context.Context, uri string) (Probe, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	switch {
+	case u.Scheme == "lambda":
+		return NewLambdaProbe(ctx, uri)
+	case u.Scheme == "retry":
+
+		dispatcher_uri := u.Query().Get("dispatcher")
+
+		if dispatcher_uri == "" {
+			return nil, fmt.Errorf("Missing ?dispatcher= parameter")
+		}
+
+		return NewDispatcherProbe(ctx, dispatcher_uri)
+
+	case blob.DefaultURLMux().ValidBucketScheme(u.Scheme):
+		return NewBlobProbe(ctx, uri)
+	case pubsub.DefaultURLMux().ValidTopicScheme(u.Scheme):
+		return NewTopicProbe(ctx, uri)
+	default:
+		return nil, nil
+	}
+}
+
+// Close() closes each of the probes in 'probes' that implement the `ClosableProbe` interface and returns the errors,
+// if any, for the probes that could not be closed.
+func Close(ctx context.Context, probes map[string]Probe) error {
+
+	close_errors := make([]error, 0)
+
+	for name, p := range probes {
+
+		c, ok := p.(ClosableProbe)
+
+		if !ok {
+			continue
+		}
+
+		err := c.Close(ctx)
+
+		if err != nil {
+			close_errors = append(close_errors, fmt.Errorf("Failed to close probe for '%s', %w", name, err))
+		}
+	}
+
+	return errors.Join(close_errors...)
+}
+
+// Run() runs each of the probes in 'probes', a dictionary of component names and their `Probe` instances, concurrently
+// and returns a `Report` instance containing their outcomes. Each probe is cancelled if it does not complete within 'timeout'.
+func Run(ctx context.Context, probes map[string]Probe, timeout time.Duration) *Report {
+
+	components := make([]*ComponentReport, 0)
+
+	mu := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+
+	for name, p := range probes {
+
+		wg.Add(1)
+
+		go func(name string, p Probe) {
+
+			defer wg.Done()
+
+			probe_ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			t1 := time.Now()
+
+			c := &ComponentReport{
+				Name:   name,
+				Status: STATUS_OK,
+			}
+
+			err := p.Probe(probe_ctx)
+
+			if err != nil {
+				c.Status = STATUS_UNAVAILABLE
+				c.Error = err.Error()
+			}
+
+			c.Duration = fmt.Sprintf("%v", time.Since(t1))
+
+			mu.Lock()
+			components = append(components, c)
+			mu.Unlock()
+
+		}(name, p)
+	}
+
+	wg.Wait()
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+
+	r := &Report{
+		Status:     STATUS_OK,
+		Components: components,
+	}
+
+	for _, c := range components {
+
+		if c.Status != STATUS_OK {
+			r.Status = STATUS_UNAVAILABLE
+			break
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,61 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/aaronland/go-aws-session"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// LambdaProbe implements the `Probe` interface for checking that an AWS Lambda function exists and can be resolved.
+type LambdaProbe struct {
+	// function is the name of the Lambda function to resolve.
+	function string
+	// service is the `aws-sdk-go/service/lambda.Lambda` instance used to resolve the Lambda function.
+	service *lambda.Lambda
+}
+
+// NewLambdaProbe returns a new `LambdaProbe` instance configured by 'uri' which is expected to be a valid
+// `whosonfirst/go-webhookd-aws` Lambda dispatcher URI in the form of:
+//
+//	lambda://{FUNCTION_NAME}?dsn={DSN}
+func NewLambdaProbe(ctx context.Context, uri string) (Probe, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	sess, err := session.NewSessionWithDSN(u.Query().Get("dsn"))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new AWS session, %w", err)
+	}
+
+	p := &LambdaProbe{
+		function: u.Host,
+		service:  lambda.New(sess),
+	}
+
+	return p, nil
+}
+
+// Probe() returns an error if the Lambda function associated with 'p' can not be resolved using the `GetFunction` API method.
+func (p *LambdaProbe) Probe(ctx context.Context) error {
+
+	input := &lambda.GetFunctionInput{
+		FunctionName: aws.String(p.function),
+	}
+
+	_, err := p.service.GetFunctionWithContext(ctx, input)
+
+	if err != nil {
+		return fmt.Errorf("Failed to get function %s, %w", p.function, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	aws_v2 "github.com/aws/aws-sdk-go-v2/aws"
+	sns_v2 "github.com/aws/aws-sdk-go-v2/service/sns"
+	sqs_v2 "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"gocloud.dev/pubsub"
+)
+
+// TopicProbe implements the `Probe` interface for checking that a `gocloud.dev/pubsub.Topic` instance exists.
+type TopicProbe struct {
+	// topic is the `gocloud.dev/pubsub.Topic` instance to check.
+	topic *pubsub.Topic
+	// scheme is the URI scheme used to open 'topic'.
+	scheme string
+	// name is the AWS SNS topic ARN or AWS SQS queue URL derived from the URI used to open 'topic'.
+	name string
+}
+
+// NewTopicProbe returns a new `TopicProbe` instance configured by 'uri' which is expected to be a valid and registered
+// `gocloud.dev/pubsub.Topic` URI. The `whosonfirst/go-webhookd-gocloud` specific `?mode=` parameter is removed before
+// the underlying topic is opened.
+func NewTopicProbe(ctx context.Context, uri string) (Probe, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+	q.Del("mode")
+	u.RawQuery = q.Encode()
+
+	topic, err := pubsub.OpenTopic(ctx, u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open topic, %w", err)
+	}
+
+	// This mirrors the way that gocloud.dev/pubsub/awssnssqs derives topic ARNs and queue URLs
+
+	var name string
+
+	switch u.Scheme {
+	case "awssns":
+		name = strings.TrimPrefix(path.Join(u.Host, u.Path), "/")
+	case "awssqs":
+		name = "https://" + path.Join(u.Host, u.Path)
+	}
+
+	p := &TopicProbe{
+		topic:  topic,
+		scheme: u.Scheme,
+		name:   name,
+	}
+
+	return p, nil
+}
+
+// Probe() returns an error if the topic associated with 'p' does not exist. Currently only AWS SNS and SQS topics are
+// checked; other topics are assumed to exist if they could be opened.
+func (p *TopicProbe) Probe(ctx context.Context) error {
+
+	switch p.scheme {
+	case "awssns":
+
+		var client *sns.SNS
+		var client_v2 *sns_v2.Client
+
+		switch {
+		case p.topic.As(&client):
+
+			input := &sns.GetTopicAttributesInput{
+				TopicArn: aws.String(p.name),
+			}
+
+			_, err := client.GetTopicAttributesWithContext(ctx, input)
+
+			if err != nil {
+				return fmt.Errorf("Failed to get attributes for topic %s, %w", p.name, err)
+			}
+
+		case p.topic.As(&client_v2):
+
+			input := &sns_v2.GetTopicAttributesInput{
+				TopicArn: aws_v2.String(p.name),
+			}
+
+			_, err := client_v2.GetTopicAttributes(ctx, input)
+
+			if err != nil {
+				return fmt.Errorf("Failed to get attributes for topic %s, %w", p.name, err)
+			}
+		}
+
+	case "awssqs":
+
+		var client *sqs.SQS
+		var client_v2 *sqs_v2.Client
+
+		switch {
+		case p.topic.As(&client):
+
+			input := &sqs.GetQueueAttributesInput{
+				QueueUrl: aws.String(p.name),
+			}
+
+			_, err := client.GetQueueAttributesWithContext(ctx, input)
+
+			if err != nil {
+				return fmt.Errorf("Failed to get attributes for queue %s, %w", p.name, err)
+			}
+
+		case p.topic.As(&client_v2):
+
+			input := &sqs_v2.GetQueueAttributesInput{
+				QueueUrl: aws_v2.String(p.name),
+			}
+
+			_, err := client_v2.GetQueueAttributes(ctx, input)
+
+			if err != nil {
+				return fmt.Errorf("Failed to get attributes for queue %s, %w", p.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close() shuts down the topic associated with 'p'.
+func (p *TopicProbe) Close(ctx context.Context) error {
+	return p.topic.Shutdown(ctx)
+}
@@ -0,0 +1,138 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// type record is a struct containing the information stored for each key by `BlobStore`.
+type record struct {
+	// Key is the key identifying the webhook message.
+	Key string `json:"key"`
+	// Expires is the Unix timestamp when the record expires.
+	Expires int64 `json:"expires"`
+}
+
+// BlobStore implements the `Store` interface for recording seen webhook messages in a `gocloud.dev/blob.Bucket` instance.
+// This allows records to be shared between multiple instances of `webhookd`, for example when it is deployed as a Lambda
+// function. Each key is stored as a JSON-encoded file whose name is the SHA-256 hash of the key. Checking and recording
+// a key are separate (read, then write) operations, and are not atomic, so two instances receiving the same message at
+// the same time may both process it. `gocloud.dev/blob` does not provide conditional writes for all the buckets it supports
+// so if duplicate processing must be ruled out the webhook's dispatchers need to be idempotent too.
+// Expired records are not removed from the bucket; consider configuring a lifecycle (expiration) policy for the bucket.
+type BlobStore struct {
+	Store
+	// bucket is the `gocloud.dev/blob.Bucket` instance where records are stored.
+	bucket *blob.Bucket
+}
+
+// NewBlobStore returns a new `BlobStore` instance configured by 'uri' which is expected to be a valid
+// and registered `gocloud.dev/blob.Bucket` URI. For example:
+//
+//	s3blob://{BUCKET}?region={REGION}&prefix=seen/&credentials=session
+func NewBlobStore(ctx context.Context, uri string) (Store, error) {
+
+	bucket, err := blob.OpenBucket(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	s := &BlobStore{
+		bucket: bucket,
+	}
+
+	return s, nil
+}
+
+// Add() records that the message identified by 'key' has been seen for 'ttl'. The existing record for 'key', if any, is
+// read and then a new record is written; this is not atomic (see `BlobStore`).
+func (s *BlobStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	path := s.path(key)
+
+	body, err := s.bucket.ReadAll(ctx, path)
+
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return false, fmt.Errorf("Failed to read record for %s, %w", key, err)
+	}
+
+	now := time.Now()
+
+	if err == nil {
+
+		var r record
+
+		err := json.Unmarshal(body, &r)
+
+		if err == nil && now.Unix() < r.Expires {
+			return false, nil
+		}
+	}
+
+	err = s.write(ctx, key, now.Add(ttl))
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Commit() records that the message identified by 'key' has been processed and should be remembered for 'ttl'.
+func (s *BlobStore) Commit(ctx context.Context, key string, ttl time.Duration) error {
+	return s.write(ctx, key, time.Now().Add(ttl))
+}
+
+// Remove() removes the record for 'key' from the underlying bucket.
+func (s *BlobStore) Remove(ctx context.Context, key string) error {
+
+	err := s.bucket.Delete(ctx, s.path(key))
+
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return fmt.Errorf("Failed to remove record for %s, %w", key, err)
+	}
+
+	return nil
+}
+
+// Close() closes the underlying bucket.
+func (s *BlobStore) Close() error {
+	return s.bucket.Close()
+}
+
+// write() writes the record for 'key', which expires at 'expires', to the underlying bucket.
+func (s *BlobStore) write(ctx context.Context, key string, expires time.Time) error {
+
+	r := record{
+		Key:     key,
+		Expires: expires.Unix(),
+	}
+
+	enc, err := json.Marshal(r)
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode record for %s, %w", key, err)
+	}
+
+	err = s.bucket.WriteAll(ctx, s.path(key), enc, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write record for %s, %w", key, err)
+	}
+
+	return nil
+}
+
+// path() returns the name of the file in the underlying bucket for 'key'.
+func (s *BlobStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
@@ -0,0 +1,46 @@
+// Package idempotency provides an interface for recording which webhook messages have already been seen so that
+// duplicate deliveries can be ignored.
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// type Store is an interface for recording which webhook messages, identified by a key, have already been seen. Keys are
+// typically added with a short 'ttl' while a message is being processed and committed, with a longer 'ttl', once it has been
+// processed successfully so that a message whose processing was interrupted, for example by a crash, can be delivered again.
+// Implementations are not required to check and record keys atomically; see the documentation for each implementation.
+type Store interface {
+	// Add() records that the message identified by 'key' has been seen for 'ttl'. It returns false if 'key'
+	// had already been recorded and has not expired, in which case its expiry is left unchanged.
+	Add(context.Context, string, time.Duration) (bool, error)
+	// Commit() records that the message identified by 'key' has been processed and should be remembered for 'ttl',
+	// replacing the expiry assigned when it was added.
+	Commit(context.Context, string, time.Duration) error
+	// Remove() removes the record for 'key' so that the message it identifies will no longer be considered a duplicate.
+	Remove(context.Context, string) error
+	// Close() closes the store and any underlying resources.
+	Close() error
+}
+
+// NewStore() returns a new `Store` instance derived from 'uri'. If the scheme of 'uri' is "mem" then a `MemoryStore`
+// instance is returned. Otherwise 'uri' is expected to be a valid and registered `gocloud.dev/blob` URI and a `BlobStore`
+// instance is returned.
+func NewStore(ctx context.Context, uri string) (Store, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	switch u.Scheme {
+	case "mem":
+		return NewMemoryStore(ctx, uri)
+	default:
+		return NewBlobStore(ctx, uri)
+	}
+}
@@ -0,0 +1,198 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	_ "gocloud.dev/blob/fileblob"
+)
+
+// newTestStores() returns a dictionary of `Store` instances, keyed by label, for each of the stores provided by this package.
+func newTestStores(t *testing.T) map[string]Store {
+
+	ctx := context.Background()
+
+	uris := map[string]string{
+		"memory": "mem://",
+		"blob":   "file://" + t.TempDir(),
+	}
+
+	stores := make(map[string]Store)
+
+	for label, uri := range uris {
+
+		s, err := NewStore(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create %s store, %v", label, err)
+		}
+
+		t.Cleanup(func() {
+			s.Close()
+		})
+
+		stores[label] = s
+	}
+
+	return stores
+}
+
+// mustAdd() adds 'key' to 's' for 'ttl' and fails the test unless the result is 'expected'.
+func mustAdd(t *testing.T, label string, s Store, key string, ttl time.Duration, expected bool) {
+
+	ok, err := s.Add(context.Background(), key, ttl)
+
+	if err != nil {
+		t.Fatalf("Failed to add '%s' to %s store, %v", key, label, err)
+	}
+
+	if ok != expected {
+		t.Fatalf("Expected adding '%s' to %s store to return %t", key, label, expected)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewStore(ctx, "mem://")
+
+	if err != nil {
+		t.Fatalf("Failed to create memory store, %v", err)
+	}
+
+	_, ok := s.(*MemoryStore)
+
+	if !ok {
+		t.Fatalf("Expected mem:// to return a MemoryStore, got %T", s)
+	}
+
+	s, err = NewStore(ctx, "file://"+t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Failed to create blob store, %v", err)
+	}
+
+	_, ok = s.(*BlobStore)
+
+	if !ok {
+		t.Fatalf("Expected file:// to return a BlobStore, got %T", s)
+	}
+
+	_, err = NewStore(ctx, "unregistered://")
+
+	if err == nil {
+		t.Fatalf("Expected unregistered scheme to fail")
+	}
+}
+
+func TestStoreDuplicates(t *testing.T) {
+
+	ctx := context.Background()
+
+	for label, s := range newTestStores(t) {
+
+		mustAdd(t, label, s, "/github#abc", time.Hour, true)
+		mustAdd(t, label, s, "/github#abc", time.Hour, false)
+		mustAdd(t, label, s, "/github#def", time.Hour, true)
+		mustAdd(t, label, s, "/gitlab#abc", time.Hour, true)
+
+		// Removed keys are no longer duplicates
+
+		err := s.Remove(ctx, "/github#abc")
+
+		if err != nil {
+			t.Fatalf("Failed to remove key from %s store, %v", label, err)
+		}
+
+		mustAdd(t, label, s, "/github#abc", time.Hour, true)
+
+		// Removing a key that does not exist is not an error
+
+		err = s.Remove(ctx, "/github#missing")
+
+		if err != nil {
+			t.Fatalf("Failed to remove missing key from %s store, %v", label, err)
+		}
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+
+	ctx := context.Background()
+
+	stores := newTestStores(t)
+
+	for label, s := range stores {
+
+		// A pending key that is never committed, a pending key that is committed and a pending key that is removed
+
+		mustAdd(t, label, s, "pending", time.Second, true)
+		mustAdd(t, label, s, "committed", time.Second, true)
+		mustAdd(t, label, s, "removed", time.Second, true)
+
+		err := s.Commit(ctx, "committed", time.Hour)
+
+		if err != nil {
+			t.Fatalf("Failed to commit key to %s store, %v", label, err)
+		}
+
+		err = s.Remove(ctx, "removed")
+
+		if err != nil {
+			t.Fatalf("Failed to remove key from %s store, %v", label, err)
+		}
+
+		mustAdd(t, label, s, "pending", time.Second, false)
+		mustAdd(t, label, s, "committed", time.Second, false)
+	}
+
+	// BlobStore records expiry times in seconds
+
+	time.Sleep(1100 * time.Millisecond)
+
+	for label, s := range stores {
+
+		mustAdd(t, label, s, "committed", time.Hour, false)
+		mustAdd(t, label, s, "removed", time.Hour, true)
+
+		// Adding an expired key does not extend the expiry of an unexpired key
+
+		mustAdd(t, label, s, "pending", time.Hour, true)
+		mustAdd(t, label, s, "pending", time.Hour, false)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+
+	ctx := context.Background()
+
+	s, err := NewMemoryStore(ctx, "mem://")
+
+	if err != nil {
+		t.Fatalf("Failed to create memory store, %v", err)
+	}
+
+	m := s.(*MemoryStore)
+
+	mustAdd(t, "memory", s, "expired", time.Hour, true)
+	mustAdd(t, "memory", s, "current", time.Hour, true)
+
+	m.seen["expired"] = time.Now().Add(-time.Second)
+	m.pruned = time.Now().Add(-2 * time.Hour)
+
+	mustAdd(t, "memory", s, "new", time.Hour, true)
+
+	_, ok := m.seen["expired"]
+
+	if ok {
+		t.Fatalf("Expected expired key to be pruned")
+	}
+
+	_, ok = m.seen["current"]
+
+	if !ok {
+		t.Fatalf("Expected current key not to be pruned")
+	}
+}
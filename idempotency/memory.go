@@ -0,0 +1,94 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements the `Store` interface for recording seen webhook messages in memory. It is suitable
+// for single instances of `webhookd`; records are not shared between instances or preserved across restarts.
+type MemoryStore struct {
+	Store
+	mu *sync.Mutex
+	// seen is a dictionary mapping keys to the time they expire.
+	seen map[string]time.Time
+	// pruned is the time that expired keys were last removed.
+	pruned time.Time
+}
+
+// NewMemoryStore returns a new `MemoryStore` instance configured by 'uri' in the form of:
+//
+//	mem://
+func NewMemoryStore(ctx context.Context, uri string) (Store, error) {
+
+	s := &MemoryStore{
+		mu:     new(sync.Mutex),
+		seen:   make(map[string]time.Time),
+		pruned: time.Now(),
+	}
+
+	return s, nil
+}
+
+// Add() records that the message identified by 'key' has been seen for 'ttl'.
+func (s *MemoryStore) Add(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	s.prune(now, ttl)
+
+	expires, ok := s.seen[key]
+
+	if ok && now.Before(expires) {
+		return false, nil
+	}
+
+	s.seen[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Commit() records that the message identified by 'key' has been processed and should be remembered for 'ttl'.
+func (s *MemoryStore) Commit(ctx context.Context, key string, ttl time.Duration) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen[key] = time.Now().Add(ttl)
+	return nil
+}
+
+// Remove() removes the record for 'key'.
+func (s *MemoryStore) Remove(ctx context.Context, key string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.seen, key)
+	return nil
+}
+
+// Close() is a no-op to conform to the `Store` interface.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// prune() removes any expired keys. This is done at most once every 'ttl'.
+func (s *MemoryStore) prune(now time.Time, ttl time.Duration) {
+
+	if now.Sub(s.pruned) < ttl {
+		return
+	}
+
+	for key, expires := range s.seen {
+
+		if !now.Before(expires) {
+			delete(s.seen, key)
+		}
+	}
+
+	s.pruned = now
+}
@@ -0,0 +1,212 @@
+// Package logging provides methods for creating structured `log/slog` loggers and for writing the records of those loggers
+// to the `log.Logger` instances used throughout webhookd.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	aa_log "github.com/aaronland/go-log/v2"
+)
+
+// FORMAT_TEXT is the log format for emitting logs as `key=value` pairs.
+const FORMAT_TEXT string = "text"
+
+// FORMAT_JSON is the log format for emitting logs as JSON objects, one per line.
+const FORMAT_JSON string = "json"
+
+// DEFAULT_FORMAT is the default log format.
+const DEFAULT_FORMAT string = FORMAT_TEXT
+
+// DEFAULT_LEVEL is the default minimum log level.
+const DEFAULT_LEVEL string = "info"
+
+// REQUEST_ID_KEY is the name of the attribute used to record the request ID for log records.
+const REQUEST_ID_KEY string = "request_id"
+
+// ENDPOINT_KEY is the name of the attribute used to record the webhook endpoint for log records.
+const ENDPOINT_KEY string = "endpoint"
+
+// STAGE_KEY is the name of the attribute used to record the stage ("receive", "transform" or "dispatch") of the webhook
+// pipeline for log records.
+const STAGE_KEY string = "stage"
+
+// STEP_KEY is the name of the attribute used to record the type of the receiver or transformation for log records.
+const STEP_KEY string = "step"
+
+// DISPATCHER_KEY is the name of the attribute used to record the type of the dispatcher for log records.
+const DISPATCHER_KEY string = "dispatcher"
+
+// OFFSET_KEY is the name of the attribute used to record the offset of a transformation or dispatcher for log records.
+const OFFSET_KEY string = "offset"
+
+// ERROR_KEY is the name of the attribute used to record errors for log records.
+const ERROR_KEY string = "error"
+
+// NewSlogLogger() returns a new `slog.Logger` instance that writes records encoded as 'format' ("text" or "json") to 'wr'.
+// Records with a level lower than 'level' ("debug", "info", "warn" or "error") are discarded.
+func NewSlogLogger(wr io.Writer, format string, level string) (*slog.Logger, error) {
+
+	var lvl slog.Level
+
+	err := lvl.UnmarshalText([]byte(level))
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log level '%s', %w", level, err)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: lvl,
+	}
+
+	var h slog.Handler
+
+	switch strings.ToLower(format) {
+	case FORMAT_TEXT:
+		h = slog.NewTextHandler(wr, opts)
+	case FORMAT_JSON:
+		h = slog.NewJSONHandler(wr, opts)
+	default:
+		return nil, fmt.Errorf("Invalid log format '%s'", format)
+	}
+
+	return slog.New(h), nil
+}
+
+// type LogHandler implements the `slog.Handler` interface for writing records to a `log.Logger` instance, prefixed by the
+// `aaronland/go-log` prefix for their level and followed by their attributes as `key=value` pairs. It is used to log
+// structured messages when webhookd is run with a `log.Logger` instance rather than a `slog.Logger` instance.
+type LogHandler struct {
+	slog.Handler
+	// logger is the `log.Logger` instance that records are written to.
+	logger *log.Logger
+	// attrs are the attributes, already qualified by their group, to append to every record.
+	attrs []slog.Attr
+	// group is the qualifier for the keys of attributes added to records.
+	group string
+}
+
+// NewLogHandler() returns a new `LogHandler` instance that writes records to 'logger'.
+func NewLogHandler(logger *log.Logger) *LogHandler {
+
+	h := &LogHandler{
+		logger: logger,
+	}
+
+	return h
+}
+
+// Enabled() returns true for all levels. Like the `aaronland/go-log` methods, messages of every level are written.
+func (h *LogHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return true
+}
+
+// Handle() writes 'r' to the underlying `log.Logger` instance.
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+
+	var sb strings.Builder
+
+	sb.WriteString(levelPrefix(r.Level))
+	sb.WriteString(" ")
+	sb.WriteString(r.Message)
+
+	for _, a := range h.attrs {
+		writeAttr(&sb, "", a)
+	}
+
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&sb, h.group, a)
+		return true
+	})
+
+	h.logger.Print(sb.String())
+	return nil
+}
+
+// WithAttrs() returns a new `LogHandler` instance that appends 'attrs' to every record.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	h2 := *h
+	h2.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	h2.attrs = append(h2.attrs, h.attrs...)
+
+	for _, a := range attrs {
+		h2.attrs = append(h2.attrs, slog.Attr{Key: qualifyKey(h.group, a.Key), Value: a.Value})
+	}
+
+	return &h2
+}
+
+// WithGroup() returns a new `LogHandler` instance that qualifies the keys of attributes added to records with 'name'.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.group = qualifyKey(h.group, name)
+
+	return &h2
+}
+
+// levelPrefix() returns the `aaronland/go-log` prefix for 'lvl'.
+func levelPrefix(lvl slog.Level) string {
+
+	switch {
+	case lvl >= slog.LevelError:
+		return aa_log.ERROR_PREFIX
+	case lvl >= slog.LevelWarn:
+		return aa_log.WARNING_PREFIX
+	case lvl >= slog.LevelInfo:
+		return aa_log.INFO_PREFIX
+	default:
+		return aa_log.DEBUG_PREFIX
+	}
+}
+
+// qualifyKey() returns 'key' qualified by 'group', if present.
+func qualifyKey(group string, key string) string {
+
+	if group == "" {
+		return key
+	}
+
+	return group + "." + key
+}
+
+// writeAttr() writes 'a', qualified by 'group', to 'sb' as a `key=value` pair. Group attributes are written as one
+// pair for each of their members.
+func writeAttr(sb *strings.Builder, group string, a slog.Attr) {
+
+	v := a.Value.Resolve()
+
+	if v.Kind() == slog.KindGroup {
+
+		for _, ga := range v.Group() {
+			writeAttr(sb, qualifyKey(group, a.Key), ga)
+		}
+
+		return
+	}
+
+	if a.Key == "" {
+		return
+	}
+
+	str_v := v.String()
+
+	if str_v == "" || strings.ContainsAny(str_v, " \t\n\"=") {
+		str_v = strconv.Quote(str_v)
+	}
+
+	sb.WriteString(" ")
+	sb.WriteString(qualifyKey(group, a.Key))
+	sb.WriteString("=")
+	sb.WriteString(str_v)
+}
@@ -0,0 +1,388 @@
+// Package metrics provides a minimal implementation of counters, gauges and histograms that can be exported
+// using the Prometheus text-based exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CONTENT_TYPE is the content type for the Prometheus text-based exposition format.
+const CONTENT_TYPE string = "text/plain; version=0.0.4; charset=utf-8"
+
+// DEFAULT_BUCKETS are the default upper bounds, in seconds, for histograms.
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// type collector is an interface for metrics that can be written using the Prometheus text-based exposition format.
+type collector interface {
+	write(*bufio.Writer)
+}
+
+// type Registry is a struct containing zero or more metrics.
+type Registry struct {
+	mu         *sync.RWMutex
+	collectors []collector
+}
+
+// NewRegistry() returns a new `Registry` instance.
+func NewRegistry() *Registry {
+
+	r := &Registry{
+		mu:         new(sync.RWMutex),
+		collectors: make([]collector, 0),
+	}
+
+	return r
+}
+
+// NewCounterVec() returns a new `CounterVec` instance named 'name' partitioned by 'labels' and adds it to 'r'.
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+
+	c := &CounterVec{
+		vec: newVec(name, help, labels),
+	}
+
+	r.register(c)
+	return c
+}
+
+// NewGauge() returns a new `Gauge` instance named 'name' and adds it to 'r'.
+func (r *Registry) NewGauge(name string, help string) *Gauge {
+
+	g := &Gauge{
+		name: name,
+		help: help,
+		mu:   new(sync.Mutex),
+	}
+
+	r.register(g)
+	return g
+}
+
+// NewHistogramVec() returns a new `HistogramVec` instance named 'name' partitioned by 'labels' and adds it to 'r'.
+// If 'buckets' is empty then `DEFAULT_BUCKETS` will be used.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+
+	if len(buckets) == 0 {
+		buckets = DEFAULT_BUCKETS
+	}
+
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	h := &HistogramVec{
+		vec:     newVec(name, help, labels),
+		buckets: sorted,
+	}
+
+	r.register(h)
+	return h
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write() writes each of the metrics in 'r' to 'wr' using the Prometheus text-based exposition format.
+func (r *Registry) Write(wr io.Writer) error {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	buf := bufio.NewWriter(wr)
+
+	for _, c := range r.collectors {
+		c.write(buf)
+	}
+
+	return buf.Flush()
+}
+
+// HandlerFunc() returns a `http.HandlerFunc` that writes each of the metrics in 'r' using the Prometheus text-based exposition format.
+func (r *Registry) HandlerFunc() (http.HandlerFunc, error) {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		rsp.Header().Set("Content-Type", CONTENT_TYPE)
+		rsp.Header().Set("Cache-Control", "no-store")
+
+		r.Write(rsp)
+	}
+
+	return http.HandlerFunc(fn), nil
+}
+
+// type vec is a struct containing the common properties for metrics partitioned by labels.
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mu     *sync.Mutex
+}
+
+func newVec(name string, help string, labels []string) vec {
+
+	v := vec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		mu:     new(sync.Mutex),
+	}
+
+	return v
+}
+
+// key() returns a unique key for 'values'. It panics if the number of values does not match the number of labels.
+func (v vec) key(values []string) string {
+
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("Metric %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// type CounterVec is a struct for counting events partitioned by one or more labels.
+type CounterVec struct {
+	vec
+	values map[string]*sample
+}
+
+// type sample is a struct containing the value of a metric for a specific set of label values.
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// Inc() increments the counter for 'values' by 1.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add() increments the counter for 'values' by 'v'. Negative values are ignored.
+func (c *CounterVec) Add(v float64, values ...string) {
+
+	if v < 0 {
+		return
+	}
+
+	k := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.values == nil {
+		c.values = make(map[string]*sample)
+	}
+
+	s, ok := c.values[k]
+
+	if !ok {
+		s = &sample{labels: append([]string(nil), values...)}
+		c.values[k] = s
+	}
+
+	s.value += v
+}
+
+func (c *CounterVec) write(buf *bufio.Writer) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(buf, c.name, c.help, "counter")
+
+	for _, k := range sortedKeys(c.values) {
+		s := c.values[k]
+		writeSample(buf, c.name, c.labels, s.labels, "", "", s.value)
+	}
+}
+
+// type Gauge is a struct for recording a single value that can go up and down.
+type Gauge struct {
+	name  string
+	help  string
+	mu    *sync.Mutex
+	value float64
+}
+
+// Inc() increments 'g' by 1.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec() decrements 'g' by 1.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Add() adds 'v' to 'g'.
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value += v
+}
+
+// Set() assigns 'v' to 'g'.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = v
+}
+
+func (g *Gauge) write(buf *bufio.Writer) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeHeader(buf, g.name, g.help, "gauge")
+	writeSample(buf, g.name, nil, nil, "", "", g.value)
+}
+
+// type HistogramVec is a struct for recording the distribution of observed values partitioned by one or more labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	values  map[string]*histogram
+}
+
+// type histogram is a struct containing the observed values for a specific set of label values.
+type histogram struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe() records 'v' for 'values'.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+
+	k := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.values == nil {
+		h.values = make(map[string]*histogram)
+	}
+
+	hg, ok := h.values[k]
+
+	if !ok {
+
+		hg = &histogram{
+			labels: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+
+		h.values[k] = hg
+	}
+
+	for i, upper := range h.buckets {
+
+		if v <= upper {
+			hg.counts[i] += 1
+		}
+	}
+
+	hg.count += 1
+	hg.sum += v
+}
+
+func (h *HistogramVec) write(buf *bufio.Writer) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(buf, h.name, h.help, "histogram")
+
+	bucket_name := h.name + "_bucket"
+
+	for _, k := range sortedKeys(h.values) {
+
+		hg := h.values[k]
+
+		for i, upper := range h.buckets {
+			writeSample(buf, bucket_name, h.labels, hg.labels, "le", formatFloat(upper), float64(hg.counts[i]))
+		}
+
+		writeSample(buf, bucket_name, h.labels, hg.labels, "le", "+Inf", float64(hg.count))
+		writeSample(buf, h.name+"_sum", h.labels, hg.labels, "", "", hg.sum)
+		writeSample(buf, h.name+"_count", h.labels, hg.labels, "", "", float64(hg.count))
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(buf *bufio.Writer, name string, help string, kind string) {
+
+	if help != "" {
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, help_escaper.Replace(help))
+	}
+
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(buf *bufio.Writer, name string, labels []string, values []string, extra_label string, extra_value string, v float64) {
+
+	buf.WriteString(name)
+
+	if len(labels) > 0 || extra_label != "" {
+
+		pairs := make([]string, 0, len(labels)+1)
+
+		for i, l := range labels {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", l, label_escaper.Replace(values[i])))
+		}
+
+		if extra_label != "" {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra_label, extra_value))
+		}
+
+		buf.WriteString("{")
+		buf.WriteString(strings.Join(pairs, ","))
+		buf.WriteString("}")
+	}
+
+	buf.WriteString(" ")
+	buf.WriteString(formatFloat(v))
+	buf.WriteString("\n")
+}
+
+var label_escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+var help_escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
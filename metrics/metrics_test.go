@@ -0,0 +1,288 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// writeRegistry() returns the metrics in 'r' using the Prometheus text-based exposition format.
+func writeRegistry(t *testing.T, r *Registry) string {
+
+	var buf bytes.Buffer
+
+	err := r.Write(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write metrics, %v", err)
+	}
+
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+
+	r := NewRegistry()
+
+	c := r.NewCounterVec("webhookd_requests_total", "Total number of requests.", "endpoint", "outcome")
+
+	c.Inc("/github", "ok")
+	c.Inc("/github", "ok")
+	c.Add(2.5, "/gitlab", "failed")
+	c.Add(-1, "/gitlab", "failed")
+	c.Inc("/a", "ok")
+
+	expected := `# HELP webhookd_requests_total Total number of requests.
+# TYPE webhookd_requests_total counter
+webhookd_requests_total{endpoint="/a",outcome="ok"} 1
+webhookd_requests_total{endpoint="/github",outcome="ok"} 2
+webhookd_requests_total{endpoint="/gitlab",outcome="failed"} 2.5
+`
+
+	out := writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestCounterVecWithoutSamples(t *testing.T) {
+
+	r := NewRegistry()
+	r.NewCounterVec("webhookd_requests_total", "", "endpoint")
+
+	// Metrics without any samples, or help text, are still declared
+
+	expected := "# TYPE webhookd_requests_total counter\n"
+
+	out := writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestCounterVecLabelCount(t *testing.T) {
+
+	r := NewRegistry()
+	c := r.NewCounterVec("webhookd_requests_total", "", "endpoint", "outcome")
+
+	defer func() {
+
+		if recover() == nil {
+			t.Fatalf("Expected mismatched label values to panic")
+		}
+	}()
+
+	c.Inc("/github")
+}
+
+func TestEscaping(t *testing.T) {
+
+	r := NewRegistry()
+
+	c := r.NewCounterVec("webhookd_errors_total", "Errors with a \\ backslash\nand a \"newline\".", "error")
+
+	c.Inc("quote \" backslash \\ newline \n end")
+
+	expected := `# HELP webhookd_errors_total Errors with a \\ backslash\nand a "newline".
+# TYPE webhookd_errors_total counter
+webhookd_errors_total{error="quote \" backslash \\ newline \n end"} 1
+`
+
+	out := writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestGauge(t *testing.T) {
+
+	r := NewRegistry()
+
+	g := r.NewGauge("webhookd_in_flight", "In-flight requests.")
+
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(0.5)
+
+	expected := `# HELP webhookd_in_flight In-flight requests.
+# TYPE webhookd_in_flight gauge
+webhookd_in_flight 1.5
+`
+
+	out := writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+
+	g.Set(-3)
+
+	expected = `# HELP webhookd_in_flight In-flight requests.
+# TYPE webhookd_in_flight gauge
+webhookd_in_flight -3
+`
+
+	out = writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+
+	r := NewRegistry()
+
+	// Buckets are sorted
+
+	h := r.NewHistogramVec("webhookd_duration_seconds", "Duration.", []float64{1, 0.1, 0.5}, "endpoint")
+
+	h.Observe(0.05, "/github")
+	h.Observe(0.1, "/github")
+	h.Observe(0.3, "/github")
+	h.Observe(2, "/github")
+	h.Observe(0.5, "/gitlab")
+
+	expected := `# HELP webhookd_duration_seconds Duration.
+# TYPE webhookd_duration_seconds histogram
+webhookd_duration_seconds_bucket{endpoint="/github",le="0.1"} 2
+webhookd_duration_seconds_bucket{endpoint="/github",le="0.5"} 3
+webhookd_duration_seconds_bucket{endpoint="/github",le="1"} 3
+webhookd_duration_seconds_bucket{endpoint="/github",le="+Inf"} 4
+webhookd_duration_seconds_sum{endpoint="/github"} 2.45
+webhookd_duration_seconds_count{endpoint="/github"} 4
+webhookd_duration_seconds_bucket{endpoint="/gitlab",le="0.1"} 0
+webhookd_duration_seconds_bucket{endpoint="/gitlab",le="0.5"} 1
+webhookd_duration_seconds_bucket{endpoint="/gitlab",le="1"} 1
+webhookd_duration_seconds_bucket{endpoint="/gitlab",le="+Inf"} 1
+webhookd_duration_seconds_sum{endpoint="/gitlab"} 0.5
+webhookd_duration_seconds_count{endpoint="/gitlab"} 1
+`
+
+	out := writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestHistogramVecDefaultBuckets(t *testing.T) {
+
+	r := NewRegistry()
+
+	h := r.NewHistogramVec("webhookd_duration_seconds", "", nil)
+	h.Observe(100)
+
+	if len(h.buckets) != len(DEFAULT_BUCKETS) {
+		t.Fatalf("Expected %d default buckets, got %d", len(DEFAULT_BUCKETS), len(h.buckets))
+	}
+
+	out := writeRegistry(t, r)
+
+	expected_lines := []string{
+		"# TYPE webhookd_duration_seconds histogram\n",
+		`webhookd_duration_seconds_bucket{le="0.005"} 0` + "\n",
+		`webhookd_duration_seconds_bucket{le="60"} 0` + "\n",
+		`webhookd_duration_seconds_bucket{le="+Inf"} 1` + "\n",
+		"webhookd_duration_seconds_sum 100\n",
+		"webhookd_duration_seconds_count 1\n",
+	}
+
+	offset := 0
+
+	for _, line := range expected_lines {
+
+		idx := strings.Index(out[offset:], line)
+
+		if idx == -1 {
+			t.Fatalf("Expected output to contain '%s' after offset %d:\n%s", line, offset, out)
+		}
+
+		offset += idx + len(line)
+	}
+}
+
+func TestRegistryOrder(t *testing.T) {
+
+	r := NewRegistry()
+
+	// Metrics are written in the order they were registered, each with its HELP and TYPE lines before its samples
+
+	g := r.NewGauge("b_gauge", "B.")
+	c := r.NewCounterVec("a_total", "A.", "label")
+
+	c.Inc("x")
+	g.Set(1)
+
+	expected := `# HELP b_gauge B.
+# TYPE b_gauge gauge
+b_gauge 1
+# HELP a_total A.
+# TYPE a_total counter
+a_total{label="x"} 1
+`
+
+	out := writeRegistry(t, r)
+
+	if out != expected {
+		t.Fatalf("Unexpected output:\n%s\nexpected:\n%s", out, expected)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+
+	tests := map[float64]string{
+		0:            "0",
+		1:            "1",
+		-2.5:         "-2.5",
+		0.005:        "0.005",
+		1e21:         "1e+21",
+		math.Inf(1):  "+Inf",
+		math.Inf(-1): "-Inf",
+	}
+
+	for v, expected := range tests {
+
+		if formatFloat(v) != expected {
+			t.Fatalf("Expected %v to be formatted as '%s', got '%s'", v, expected, formatFloat(v))
+		}
+	}
+
+	if formatFloat(math.NaN()) != "NaN" {
+		t.Fatalf("Expected NaN to be formatted as 'NaN', got '%s'", formatFloat(math.NaN()))
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+
+	r := NewRegistry()
+	r.NewGauge("webhookd_in_flight", "").Set(2)
+
+	h, err := r.HandlerFunc()
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rsp := httptest.NewRecorder()
+
+	h(rsp, req)
+
+	if rsp.Header().Get("Content-Type") != CONTENT_TYPE {
+		t.Fatalf("Unexpected content type, %s", rsp.Header().Get("Content-Type"))
+	}
+
+	expected := "# TYPE webhookd_in_flight gauge\nwebhookd_in_flight 2\n"
+
+	if rsp.Body.String() != expected {
+		t.Fatalf("Unexpected body:\n%s", rsp.Body.String())
+	}
+}
@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// BlobQueue implements the `Queue` interface for storing webhook messages in a `gocloud.dev/blob.Bucket` instance.
+// Each message is stored as a JSON-encoded file whose name is derived from the message endpoint and ID and a unique suffix.
+type BlobQueue struct {
+	Queue
+	// bucket is the `gocloud.dev/blob.Bucket` instance where messages are stored.
+	bucket *blob.Bucket
+}
+
+// NewBlobQueue returns a new `BlobQueue` instance configured by 'uri' which is expected to be a valid
+// and registered `gocloud.dev/blob.Bucket` URI. For example:
+//
+//	file:///usr/local/webhookd/queue
+func NewBlobQueue(ctx context.Context, uri string) (Queue, error) {
+
+	bucket, err := blob.OpenBucket(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	q := &BlobQueue{
+		bucket: bucket,
+	}
+
+	return q, nil
+}
+
+// Push() writes 'msg' to the underlying bucket, assigning it a unique key if it does not already have one.
+func (q *BlobQueue) Push(ctx context.Context, msg *Message) error {
+
+	if msg.Key == "" {
+		msg.Key = newKey(msg)
+	}
+
+	wr, err := q.bucket.NewWriter(ctx, q.key(msg), nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new writer for %s, %w", msg.ID, err)
+	}
+
+	enc := json.NewEncoder(wr)
+	err = enc.Encode(msg)
+
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Failed to encode message %s, %w", msg.ID, err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close writer for %s, %w", msg.ID, err)
+	}
+
+	return nil
+}
+
+// Remove() deletes 'msg' from the underlying bucket.
+func (q *BlobQueue) Remove(ctx context.Context, msg *Message) error {
+
+	err := q.bucket.Delete(ctx, q.key(msg))
+
+	if err != nil {
+		return fmt.Errorf("Failed to delete message %s, %w", msg.ID, err)
+	}
+
+	return nil
+}
+
+// Pending() returns all the messages stored in the underlying bucket, sorted by the time they were created. Files that
+// can not be read or decoded are skipped and reported in the (joined) error returned alongside the messages that could be read.
+func (q *BlobQueue) Pending(ctx context.Context) ([]*Message, error) {
+
+	messages := make([]*Message, 0)
+	read_errors := make([]error, 0)
+
+	iter := q.bucket.List(nil)
+
+	for {
+
+		obj, err := iter.Next(ctx)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to iterate bucket, %w", err)
+		}
+
+		if obj.IsDir || filepath.Ext(obj.Key) != ".json" {
+			continue
+		}
+
+		msg, err := q.read(ctx, obj.Key)
+
+		if err != nil {
+			read_errors = append(read_errors, err)
+			continue
+		}
+
+		messages = append(messages, msg)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Created < messages[j].Created
+	})
+
+	return messages, errors.Join(read_errors...)
+}
+
+// Close() closes the underlying bucket.
+func (q *BlobQueue) Close() error {
+	return q.bucket.Close()
+}
+
+func (q *BlobQueue) read(ctx context.Context, key string) (*Message, error) {
+
+	r, err := q.bucket.NewReader(ctx, key, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", key, err)
+	}
+
+	defer r.Close()
+
+	var msg *Message
+
+	dec := json.NewDecoder(r)
+	err = dec.Decode(&msg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", key, err)
+	}
+
+	if msg == nil {
+		return nil, fmt.Errorf("Failed to decode %s, empty message", key)
+	}
+
+	// Messages queued before keys were assigned are stored under their ID
+
+	msg.Key = key
+	return msg, nil
+}
+
+func (q *BlobQueue) key(msg *Message) string {
+
+	if msg.Key != "" {
+		return msg.Key
+	}
+
+	id := strings.Replace(msg.ID, "/", "-", -1)
+	return fmt.Sprintf("%s.json", id)
+}
+
+// newKey() returns a unique key for 'msg' derived from its endpoint, its ID, the current time and a random suffix.
+func newKey(msg *Message) string {
+
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	endpoint := strings.Trim(sanitizeKey(msg.Endpoint), "-")
+	id := sanitizeKey(msg.ID)
+
+	return fmt.Sprintf("%s-%s-%d-%s.json", endpoint, id, time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+// sanitizeKey() returns a copy of 's' with any characters other than ASCII letters, digits, "_" and "." replaced by "-"
+// so that it can be used as part of a key in any bucket.
+func sanitizeKey(s string) string {
+
+	fn := func(r rune) rune {
+
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+			return r
+		default:
+			return '-'
+		}
+	}
+
+	return strings.Map(fn, s)
+}
@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "gocloud.dev/blob/fileblob"
+)
+
+// newTestQueue() returns a new `BlobQueue` instance backed by a temporary directory, and the path to that directory.
+func newTestQueue(t *testing.T) (*BlobQueue, string) {
+
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	q, err := NewBlobQueue(ctx, "file://"+root)
+
+	if err != nil {
+		t.Fatalf("Failed to create queue, %v", err)
+	}
+
+	t.Cleanup(func() {
+		q.Close()
+	})
+
+	return q.(*BlobQueue), root
+}
+
+func TestNewKey(t *testing.T) {
+
+	msg := &Message{
+		ID:       "abc/123",
+		Endpoint: "/github/hooks",
+	}
+
+	seen := make(map[string]bool)
+
+	for i := 0; i < 1000; i++ {
+
+		k := newKey(msg)
+
+		if seen[k] {
+			t.Fatalf("Duplicate key %s", k)
+		}
+
+		seen[k] = true
+
+		if filepath.Ext(k) != ".json" {
+			t.Fatalf("Expected key %s to have a .json extension", k)
+		}
+
+		if filepath.Base(k) != k {
+			t.Fatalf("Expected key %s not to contain any path separators", k)
+		}
+	}
+}
+
+func TestBlobQueue(t *testing.T) {
+
+	ctx := context.Background()
+
+	q, _ := newTestQueue(t)
+
+	// The same delivery received twice, and by two endpoints, is stored three times
+
+	messages := []*Message{
+		{ID: "delivery-1", Endpoint: "/a", Body: []byte("one"), Created: 3},
+		{ID: "delivery-1", Endpoint: "/a", Body: []byte("two"), Created: 1},
+		{ID: "delivery-1", Endpoint: "/b", Body: []byte("three"), Created: 2},
+	}
+
+	for _, msg := range messages {
+
+		err := q.Push(ctx, msg)
+
+		if err != nil {
+			t.Fatalf("Failed to push message, %v", err)
+		}
+
+		if msg.Key == "" {
+			t.Fatalf("Expected message to be assigned a key")
+		}
+	}
+
+	pending, err := q.Pending(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve pending messages, %v", err)
+	}
+
+	if len(pending) != 3 {
+		t.Fatalf("Expected 3 pending messages, got %d", len(pending))
+	}
+
+	// Messages are sorted by the time they were created
+
+	for idx, expected := range []string{"two", "three", "one"} {
+
+		if string(pending[idx].Body) != expected {
+			t.Fatalf("Expected message %d to be '%s', got '%s'", idx, expected, pending[idx].Body)
+		}
+	}
+
+	err = q.Remove(ctx, pending[0])
+
+	if err != nil {
+		t.Fatalf("Failed to remove message, %v", err)
+	}
+
+	pending, err = q.Pending(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve pending messages, %v", err)
+	}
+
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 pending messages after removal, got %d", len(pending))
+	}
+
+	if string(pending[0].Body) != "three" {
+		t.Fatalf("Unexpected first message after removal, '%s'", pending[0].Body)
+	}
+}
+
+func TestBlobQueuePendingUnreadable(t *testing.T) {
+
+	ctx := context.Background()
+
+	q, root := newTestQueue(t)
+
+	err := q.Push(ctx, &Message{ID: "delivery-1", Endpoint: "/a", Body: []byte("one")})
+
+	if err != nil {
+		t.Fatalf("Failed to push message, %v", err)
+	}
+
+	unreadable := map[string]string{
+		"corrupt.json": "{",
+		"null.json":    "null",
+	}
+
+	for name, body := range unreadable {
+
+		err := os.WriteFile(filepath.Join(root, name), []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", name, err)
+		}
+	}
+
+	// Files without a .json extension are ignored
+
+	err = os.WriteFile(filepath.Join(root, "README.txt"), []byte("hello"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write README.txt, %v", err)
+	}
+
+	pending, err := q.Pending(ctx)
+
+	if err == nil {
+		t.Fatalf("Expected an error for unreadable messages")
+	}
+
+	if pending == nil || len(pending) != 1 {
+		t.Fatalf("Expected the readable message to be returned, %v", pending)
+	}
+
+	if string(pending[0].Body) != "one" {
+		t.Fatalf("Unexpected message, '%s'", pending[0].Body)
+	}
+
+	for name := range unreadable {
+
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("Expected error to describe %s, %v", name, err)
+		}
+	}
+}
+
+func TestSanitizeKey(t *testing.T) {
+
+	tests := map[string]string{
+		"abc-123_X.json":       "abc-123_X.json",
+		"/github":              "-github",
+		"/hook/{SECRET}#9f86d": "-hook--SECRET--9f86d",
+		"a b?c=d&e":            "a-b-c-d-e",
+		"é":                    "-",
+	}
+
+	for s, expected := range tests {
+
+		sanitized := sanitizeKey(s)
+
+		if sanitized != expected {
+			t.Fatalf("Expected '%s' to be sanitized as '%s', got '%s'", s, expected, sanitized)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package queue provides an interface for durably storing webhook messages that will be processed asynchronously.
+package queue
+
+import (
+	"context"
+	"net/http"
+)
+
+// type Message is a struct containing a webhook message that has been received but not yet transformed or dispatched.
+type Message struct {
+	// ID is the unique identifier for the message (delivery).
+	ID string `json:"id"`
+	// Endpoint is the identifier of the webhook that received the message. This is typically its relative URI but it
+	// should not contain any secrets, like a secret path component, since it is stored with the message.
+	Endpoint string `json:"endpoint"`
+	// Body is the output of the webhook's receiver.
+	Body []byte `json:"body"`
+	// Headers are the headers of the request that delivered the message which are used after it has been queued, for example
+	// to derive its event type or to match routes. Other headers, which may contain credentials, are not stored.
+	Headers http.Header `json:"headers,omitempty"`
+	// Created is the Unix timestamp when the message was received.
+	Created int64 `json:"created"`
+	// Traceparent is the W3C Trace Context "traceparent" value of the request that received the message, if any.
+	Traceparent string `json:"traceparent,omitempty"`
+	// Overflow is a boolean flag signaling that the message exceeded the webhook's rate limit and should be relayed to its overflow dispatcher.
+	Overflow bool `json:"overflow,omitempty"`
+	// IdempotencyKey is the key recorded in the daemon's idempotency store for the message, if any. It is removed if
+	// the message can not be processed so that it can be delivered again.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Key is the unique name under which the message is stored in the queue. It is assigned by the queue when the message
+	// is pushed so that redeliveries, or the same delivery received by more than one endpoint, do not overwrite each other.
+	Key string `json:"key,omitempty"`
+}
+
+// type Queue is an interface for durably storing webhook messages until they have been processed.
+type Queue interface {
+	// Push() durably stores a `Message` instance in the queue.
+	Push(context.Context, *Message) error
+	// Remove() removes a `Message` instance from the queue, typically after it has been processed.
+	Remove(context.Context, *Message) error
+	// Pending() returns the list of all the `Message` instances that are currently stored in the queue. If some messages
+	// can not be read they are skipped and Pending() returns the messages that could be read along with an error describing
+	// the ones that could not. If the queue itself can not be read the list of messages is nil.
+	Pending(context.Context) ([]*Message, error)
+	// Close() closes the queue and any underlying resources.
+	Close() error
+}
+
+// NewQueue() returns a new `Queue` instance derived from 'uri'. Currently all queues are backed by
+// a `gocloud.dev/blob.Bucket` so 'uri' is expected to be a valid and registered `gocloud.dev/blob` URI.
+func NewQueue(ctx context.Context, uri string) (Queue, error) {
+	return NewBlobQueue(ctx, uri)
+}
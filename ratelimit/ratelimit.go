@@ -0,0 +1,124 @@
+// Package ratelimit provides a keyed token bucket rate limiter.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// type bucket is a struct containing the state of an individual token bucket.
+type bucket struct {
+	// tokens is the number of tokens available when the bucket was last updated.
+	tokens float64
+	// updated is the time the bucket was last updated.
+	updated time.Time
+}
+
+// type Limiter is a struct that maintains a separate token bucket for each key it is asked about. Each bucket
+// holds up to 'burst' tokens and is refilled at a constant rate. Buckets that have been refilled to capacity are
+// discarded periodically so that the number of buckets does not grow without bound.
+type Limiter struct {
+	mu *sync.Mutex
+	// rate is the number of tokens added to each bucket per second.
+	rate float64
+	// burst is the maximum number of tokens a bucket can hold.
+	burst float64
+	// buckets is a dictionary of token buckets where the key is the rate limit key.
+	buckets map[string]*bucket
+	// pruned is the time that full buckets were last discarded.
+	pruned time.Time
+}
+
+// NewLimiter() returns a new `Limiter` instance that allows 'requests' requests per 'interval' for each key,
+// with bursts of up to 'burst' requests. If 'burst' is zero then it defaults to 'requests'.
+func NewLimiter(requests int, interval time.Duration, burst int) (*Limiter, error) {
+
+	if requests <= 0 {
+		return nil, fmt.Errorf("Invalid number of requests, %d", requests)
+	}
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid interval, %v", interval)
+	}
+
+	if burst < 0 {
+		return nil, fmt.Errorf("Invalid burst, %d", burst)
+	}
+
+	if burst == 0 {
+		burst = requests
+	}
+
+	l := &Limiter{
+		mu:      new(sync.Mutex),
+		rate:    float64(requests) / interval.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+
+	return l, nil
+}
+
+// Allow() takes a token from the bucket for 'key' and returns true if one was available. If not it returns false
+// and the amount of time until the next token will be available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+
+	if !ok {
+		b = &bucket{
+			tokens:  l.burst,
+			updated: now,
+		}
+
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+
+	wait := time.Duration(math.Ceil((1 - b.tokens) / l.rate * float64(time.Second)))
+	return false, wait
+}
+
+// refill() returns the number of tokens in 'b' at time 'now'.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+
+	elapsed := now.Sub(b.updated).Seconds()
+	return math.Min(l.burst, b.tokens+(elapsed*l.rate))
+}
+
+// prune() discards any buckets that will have been refilled to capacity by 'now'. This is done at most once
+// every time it takes to refill an empty bucket.
+func (l *Limiter) prune(now time.Time) {
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+
+	if now.Sub(l.pruned) < full {
+		return
+	}
+
+	for key, b := range l.buckets {
+
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.pruned = now
+}
@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewLimiter(t *testing.T) {
+
+	l, err := NewLimiter(60, time.Minute, 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create limiter, %v", err)
+	}
+
+	if l.rate != 1 {
+		t.Fatalf("Expected rate of 1 token per second, got %f", l.rate)
+	}
+
+	if l.burst != 60 {
+		t.Fatalf("Expected burst to default to requests, got %f", l.burst)
+	}
+
+	invalid := []struct {
+		requests int
+		interval time.Duration
+		burst    int
+	}{
+		{0, time.Minute, 0},
+		{-1, time.Minute, 0},
+		{10, 0, 0},
+		{10, -time.Second, 0},
+		{10, time.Minute, -1},
+	}
+
+	for _, test := range invalid {
+
+		_, err := NewLimiter(test.requests, test.interval, test.burst)
+
+		if err == nil {
+			t.Fatalf("Expected limiter for %d requests per %v with burst %d to fail", test.requests, test.interval, test.burst)
+		}
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+
+	l, err := NewLimiter(1, time.Hour, 3)
+
+	if err != nil {
+		t.Fatalf("Failed to create limiter, %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+
+		ok, wait := l.Allow("a")
+
+		if !ok || wait != 0 {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+
+	ok, wait := l.Allow("a")
+
+	if ok {
+		t.Fatalf("Expected request exceeding burst to be rejected")
+	}
+
+	if wait <= 59*time.Minute || wait > time.Hour {
+		t.Fatalf("Expected wait of about an hour, got %v", wait)
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+
+	l, err := NewLimiter(1, time.Second, 2)
+
+	if err != nil {
+		t.Fatalf("Failed to create limiter, %v", err)
+	}
+
+	l.Allow("a")
+	l.Allow("a")
+
+	ok, _ := l.Allow("a")
+
+	if ok {
+		t.Fatalf("Expected empty bucket to reject request")
+	}
+
+	// Pretend the bucket was last updated one and a half seconds ago
+
+	l.buckets["a"].updated = time.Now().Add(-1500 * time.Millisecond)
+
+	ok, _ = l.Allow("a")
+
+	if !ok {
+		t.Fatalf("Expected refilled bucket to allow request")
+	}
+
+	ok, wait := l.Allow("a")
+
+	if ok {
+		t.Fatalf("Expected partially refilled bucket to reject second request")
+	}
+
+	if wait <= 0 || wait > 500*time.Millisecond {
+		t.Fatalf("Expected wait of up to 500ms, got %v", wait)
+	}
+
+	// Buckets are never refilled beyond the burst
+
+	l.buckets["a"].updated = time.Now().Add(-time.Hour)
+
+	for i := 0; i < 2; i++ {
+
+		ok, _ := l.Allow("a")
+
+		if !ok {
+			t.Fatalf("Expected request %d to full bucket to be allowed", i+1)
+		}
+	}
+
+	ok, _ = l.Allow("a")
+
+	if ok {
+		t.Fatalf("Expected bucket not to be refilled beyond its burst")
+	}
+}
+
+func TestLimiterKeys(t *testing.T) {
+
+	l, err := NewLimiter(1, time.Hour, 1)
+
+	if err != nil {
+		t.Fatalf("Failed to create limiter, %v", err)
+	}
+
+	for _, key := range []string{"a", "b", ""} {
+
+		ok, _ := l.Allow(key)
+
+		if !ok {
+			t.Fatalf("Expected first request for '%s' to be allowed", key)
+		}
+	}
+
+	for _, key := range []string{"a", "b", ""} {
+
+		ok, _ := l.Allow(key)
+
+		if ok {
+			t.Fatalf("Expected second request for '%s' to be rejected", key)
+		}
+	}
+
+	ok, _ := l.Allow("c")
+
+	if !ok {
+		t.Fatalf("Expected request for new key to be allowed")
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+
+	l, err := NewLimiter(1, time.Second, 1)
+
+	if err != nil {
+		t.Fatalf("Failed to create limiter, %v", err)
+	}
+
+	l.Allow("a")
+	l.Allow("b")
+
+	l.buckets["a"].updated = time.Now().Add(-time.Minute)
+	l.pruned = time.Now().Add(-time.Minute)
+
+	l.Allow("c")
+
+	_, ok := l.buckets["a"]
+
+	if ok {
+		t.Fatalf("Expected full bucket to be pruned")
+	}
+
+	_, ok = l.buckets["b"]
+
+	if !ok {
+		t.Fatalf("Expected empty bucket not to be pruned")
+	}
+}
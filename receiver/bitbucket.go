@@ -0,0 +1,393 @@
+package receiver
+
+// https://support.atlassian.com/bitbucket-cloud/docs/manage-webhooks/
+// https://confluence.atlassian.com/bitbucketserver/manage-webhooks-938025878.html
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/bitbucket"
+)
+
+// BITBUCKET_EVENT_HEADER is the request header containing the event type of a Bitbucket webhook message.
+const BITBUCKET_EVENT_HEADER string = "X-Event-Key"
+
+// BITBUCKET_CLOUD_PUSH is the event type for push events sent by Bitbucket Cloud.
+const BITBUCKET_CLOUD_PUSH string = "repo:push"
+
+// BITBUCKET_SERVER_PUSH is the event type for push events sent by Bitbucket Server (and Data Center).
+const BITBUCKET_SERVER_PUSH string = "repo:refs_changed"
+
+// BITBUCKET_SERVER_PING is the event type for the "Test connection" messages sent by Bitbucket Server.
+const BITBUCKET_SERVER_PING string = "diagnostics:ping"
+
+// REDACTED_PATH_SECRET is the value used to replace the path secret in redacted webhook endpoints.
+const REDACTED_PATH_SECRET string = "{PATH_SECRET}"
+
+func init() {
+
+	ctx := context.Background()
+	err := registerReceiver(ctx, "bitbucket", NewBitbucketReceiver)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// BitbucketReceiver implements the `webhookd.WebhookReceiver` interface for receiving webhook messages from Bitbucket Cloud
+// and Bitbucket Server. Push events are converted in to GitHub push events which, once the `bitbucketpaths://` transformation
+// has assigned the files changed by each commit, can be transformed using the `githubrepo://` and `githubcommits://` transformations.
+type BitbucketReceiver struct {
+	webhookd.WebhookReceiver
+	// secret is the optional shared secret used to generate signatures to validate messages.
+	secret string
+	// path_secret is the optional secret which the final component of the request path is expected to match.
+	path_secret string
+	// allow_ips is the optional list of network prefixes from which messages will be accepted.
+	allow_ips []netip.Prefix
+	// trust_forwarded_for is a boolean flag signaling that the client address should be derived from the `X-Forwarded-For` header.
+	trust_forwarded_for bool
+	// refs are the optional patterns for the branches (references) for which messages will be processed.
+	refs refPatterns
+	// exclude_refs are the optional patterns for the branches (references) for which messages will not be processed.
+	exclude_refs refPatterns
+	// events is the optional list of `X-Event-Key` types for which messages will be processed.
+	events map[string]bool
+}
+
+// NewBitbucketReceiver instantiates a new `BitbucketReceiver` for receiving webhook messages from Bitbucket, configured
+// by 'uri' which is expected to take the form of:
+//
+//	bitbucket://?secret={SECRET}&ref={BRANCH}&event={EVENT}
+//
+// Where {SECRET} is the shared secret used to generate signatures (in the `X-Hub-Signature` header) to validate messages,
+// {BRANCH} is the optional branch (reference) name to limit message processing to and {EVENT} is an optional `X-Event-Key`
+// type to limit message processing to. The `?event=` parameter may be repeated or contain a comma-separated list of events.
+//
+// Webhooks which can not be configured with a secret, for example older Bitbucket Cloud webhooks, may instead be validated
+// using both of the following parameters:
+// * `?allow_ip=` One or more (comma-separated or repeated) IP addresses or CIDR prefixes from which messages will be accepted.
+// * `?path_secret=` A secret which the final component of the request path, for example "/bitbucket/{PATH_SECRET}", must match.
+//
+// Other optional parameters are:
+// * `?trust_forwarded_for=` A boolean flag signaling that the client address should be derived from the last address in the `X-Forwarded-For` header.
+// * `?exclude_ref=` One or more patterns, in the same form as `?ref=`, for branches (references) not to process.
+//
+// The paths of the files changed by push events are not fetched by the receiver; use the `bitbucketpaths://` transformation.
+func NewBitbucketReceiver(ctx context.Context, uri string) (webhookd.WebhookReceiver, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	refs, err := newRefPatterns(q["ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?ref= parameter, %w", err)
+	}
+
+	exclude_refs, err := newRefPatterns(q["exclude_ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?exclude_ref= parameter, %w", err)
+	}
+
+	for _, k := range []string{"fetch_paths", "api_uri", "server_uri", "api_user", "api_token"} {
+
+		if q.Has(k) {
+			return nil, fmt.Errorf("Invalid ?%s= parameter, changed paths are fetched using the bitbucketpaths:// transformation", k)
+		}
+	}
+
+	wh := &BitbucketReceiver{
+		secret:       q.Get("secret"),
+		path_secret:  q.Get("path_secret"),
+		refs:         refs,
+		exclude_refs: exclude_refs,
+		events:       parseEvents(q["event"]),
+	}
+
+	for _, str_ips := range q["allow_ip"] {
+
+		for _, str_ip := range strings.Split(str_ips, ",") {
+
+			str_ip = strings.TrimSpace(str_ip)
+
+			if str_ip == "" {
+				continue
+			}
+
+			prefix, err := parsePrefix(str_ip)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ?allow_ip= parameter, %w", err)
+			}
+
+			wh.allow_ips = append(wh.allow_ips, prefix)
+		}
+	}
+
+	if wh.secret == "" && (wh.path_secret == "" || len(wh.allow_ips) == 0) {
+		return nil, fmt.Errorf("Missing ?secret= parameter or ?allow_ip= and ?path_secret= parameters")
+	}
+
+	if q.Has("trust_forwarded_for") {
+
+		v, err := strconv.ParseBool(q.Get("trust_forwarded_for"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?trust_forwarded_for= parameter, %w", err)
+		}
+
+		wh.trust_forwarded_for = v
+	}
+
+	return wh, nil
+}
+
+// Receive() returns the body of the message in 'req'. It ensures that messages are sent as HTTP `POST` requests, that they
+// are sent from an allowed address and to the secret path, if necessary, that the `X-Event-Key` header is present, that the
+// message body produces a valid signature using the secret used to create 'wh' and that the message is associated with the
+// events used to create 'wh'. Push events ("repo:push" and "repo:refs_changed") are converted in to GitHub push events with
+// a commit for each of the changes, for branches (references) not excluded by 'wh', in the push. The files changed by each
+// commit are assigned by the `bitbucketpaths://` transformation. Changes which delete a branch are ignored since there are
+// no changed files to report and push events which only delete branches are halted.
+func (wh *BitbucketReceiver) Receive(ctx context.Context, req *http.Request) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	if req.Method != "POST" {
+
+		code := http.StatusMethodNotAllowed
+		message := "Method not allowed"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if wh.path_secret != "" {
+
+		path_secret := path.Base(req.URL.Path)
+
+		if subtle.ConstantTimeCompare([]byte(path_secret), []byte(wh.path_secret)) != 1 {
+
+			code := http.StatusForbidden
+			message := "Forbidden"
+
+			err := &webhookd.WebhookError{Code: code, Message: message}
+			return nil, err
+		}
+	}
+
+	if len(wh.allow_ips) > 0 && !wh.isAllowed(req) {
+
+		code := http.StatusForbidden
+		message := "Forbidden"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	event_type := req.Header.Get(BITBUCKET_EVENT_HEADER)
+
+	if event_type == "" {
+
+		code := http.StatusBadRequest
+		message := "Bad Request - Missing X-Event-Key Header"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+
+		code := http.StatusInternalServerError
+		message := err.Error()
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if wh.secret != "" {
+
+		sig := req.Header.Get(SIGNATURE_HEADER)
+
+		if sig == "" {
+
+			code := http.StatusForbidden
+			message := "Missing X-Hub-Signature required for HMAC verification"
+
+			err := &webhookd.WebhookError{Code: code, Message: message}
+			return nil, err
+		}
+
+		if !VerifySignature(body, wh.secret, sig, SIGNATURE_SHA256) {
+
+			code := http.StatusForbidden
+			message := "HMAC verification failed"
+
+			err := &webhookd.WebhookError{Code: code, Message: message}
+			return nil, err
+		}
+	}
+
+	if event_type == BITBUCKET_SERVER_PING {
+		err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: "ping message is a no-op"}
+		return nil, err
+	}
+
+	if wh.events != nil && !wh.events[event_type] {
+		msg := fmt.Sprintf("%s event is not handled", event_type)
+		err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: msg}
+		return nil, err
+	}
+
+	var push *bitbucket.Push
+
+	switch event_type {
+	case BITBUCKET_CLOUD_PUSH:
+		push, err = bitbucket.ParseCloudPush(body)
+	case BITBUCKET_SERVER_PUSH:
+		push, err = bitbucket.ParseServerPush(body)
+	default:
+		return body, nil
+	}
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	changes := make([]*bitbucket.Change, 0)
+	var ref_err *webhookd.WebhookError
+
+	for _, c := range push.Changes {
+
+		if c.Deleted {
+			continue
+		}
+
+		err := checkRef(wh.refs, wh.exclude_refs, c.Ref)
+
+		if err != nil {
+			ref_err = err
+			continue
+		}
+
+		changes = append(changes, c)
+	}
+
+	if len(changes) == 0 {
+
+		if ref_err != nil {
+			return nil, ref_err
+		}
+
+		err := &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Ignoring branch deletion"}
+		return nil, err
+	}
+
+	push.Changes = changes
+
+	enc_body, err := push.GitHubPushEvent()
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	return enc_body, nil
+}
+
+// RedactEndpoint() returns a copy of 'endpoint' with its final path component replaced by `REDACTED_PATH_SECRET` if it matches
+// the path secret used to create 'wh'. This method implements the optional `daemon.EndpointRedactor` interface so that the path
+// secret is not included in log messages, metrics, traces, audit records or the admin API.
+func (wh *BitbucketReceiver) RedactEndpoint(endpoint string) string {
+
+	if wh.path_secret == "" || path.Base(endpoint) != wh.path_secret {
+		return endpoint
+	}
+
+	return path.Join(path.Dir(endpoint), REDACTED_PATH_SECRET)
+}
+
+// isAllowed() returns a boolean value indicating whether the client address of 'req' is contained by any of the
+// network prefixes used to create 'wh'.
+func (wh *BitbucketReceiver) isAllowed(req *http.Request) bool {
+
+	str_addr := req.RemoteAddr
+
+	host, _, err := net.SplitHostPort(str_addr)
+
+	if err == nil {
+		str_addr = host
+	}
+
+	if wh.trust_forwarded_for {
+
+		// The last address is the one appended by the (trusted) proxy closest to webhookd, earlier addresses
+		// may have been supplied by the client itself.
+
+		forwarded := req.Header.Values("X-Forwarded-For")
+
+		if len(forwarded) > 0 {
+			addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+			str_addr = strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	addr, err := netip.ParseAddr(str_addr)
+
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	for _, prefix := range wh.allow_ips {
+
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parsePrefix() returns a new `netip.Prefix` instance derived from 'str_prefix' which may be a CIDR prefix or a single IP address.
+func parsePrefix(str_prefix string) (netip.Prefix, error) {
+
+	if strings.Contains(str_prefix, "/") {
+		return netip.ParsePrefix(str_prefix)
+	}
+
+	addr, err := netip.ParseAddr(str_prefix)
+
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
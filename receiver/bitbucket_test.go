@@ -0,0 +1,123 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-webhookd/bitbucket"
+)
+
+func TestBitbucketReceiverPathSecret(t *testing.T) {
+
+	ctx := context.Background()
+
+	wh, err := NewBitbucketReceiver(ctx, "bitbucket://?path_secret=s33kret&allow_ip=192.0.2.0/24")
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver, %v", err)
+	}
+
+	body, err := os.ReadFile("../fixtures/events/bitbucket-cloud-push.json")
+
+	if err != nil {
+		t.Fatalf("Failed to read Bitbucket push event, %v", err)
+	}
+
+	tests := []struct {
+		label       string
+		path        string
+		remote_addr string
+		ok          bool
+	}{
+		{"valid", "/bitbucket/s33kret", "192.0.2.10:1234", true},
+		{"wrong path secret", "/bitbucket/wrong", "192.0.2.10:1234", false},
+		{"address not allowed", "/bitbucket/s33kret", "198.51.100.10:1234", false},
+	}
+
+	for _, test := range tests {
+
+		req := httptest.NewRequest(http.MethodPost, test.path, bytes.NewReader(body))
+		req.RemoteAddr = test.remote_addr
+		req.Header.Set(BITBUCKET_EVENT_HEADER, BITBUCKET_CLOUD_PUSH)
+
+		out, wh_err := wh.Receive(ctx, req)
+
+		if !test.ok {
+
+			if wh_err == nil || wh_err.Code != http.StatusForbidden {
+				t.Fatalf("Expected '%s' to be forbidden, %v", test.label, wh_err)
+			}
+
+			continue
+		}
+
+		if wh_err != nil {
+			t.Fatalf("Expected '%s' to be received, %v", test.label, wh_err)
+		}
+
+		// The files changed by each commit are assigned by the bitbucketpaths:// transformation which needs to
+		// be able to reconstruct the push event
+
+		push, err := bitbucket.ParseGitHubPushEvent(out)
+
+		if err != nil {
+			t.Fatalf("Failed to parse received push event, %v", err)
+		}
+
+		if push.FullName != "whosonfirst-data/whosonfirst-data-admin-xy" {
+			t.Fatalf("Unexpected repository for received push event, %s", push.FullName)
+		}
+	}
+}
+
+func TestBitbucketReceiverRedactEndpoint(t *testing.T) {
+
+	ctx := context.Background()
+
+	wh, err := NewBitbucketReceiver(ctx, "bitbucket://?path_secret=s33kret&allow_ip=192.0.2.0/24")
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver, %v", err)
+	}
+
+	signed_wh, err := NewBitbucketReceiver(ctx, "bitbucket://?secret=s33kret")
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver, %v", err)
+	}
+
+	tests := []struct {
+		receiver *BitbucketReceiver
+		endpoint string
+		expected string
+	}{
+		{wh.(*BitbucketReceiver), "/bitbucket/s33kret", "/bitbucket/" + REDACTED_PATH_SECRET},
+		{wh.(*BitbucketReceiver), "/s33kret", "/" + REDACTED_PATH_SECRET},
+		{wh.(*BitbucketReceiver), "/bitbucket/other", "/bitbucket/other"},
+		{signed_wh.(*BitbucketReceiver), "/bitbucket/s33kret", "/bitbucket/s33kret"},
+	}
+
+	for _, test := range tests {
+
+		redacted := test.receiver.RedactEndpoint(test.endpoint)
+
+		if redacted != test.expected {
+			t.Fatalf("Expected '%s' to be redacted as '%s', got '%s'", test.endpoint, test.expected, redacted)
+		}
+	}
+}
+
+func TestNewBitbucketReceiverAPIParameters(t *testing.T) {
+
+	ctx := context.Background()
+
+	_, err := NewBitbucketReceiver(ctx, "bitbucket://?secret=s33kret&api_token=t0ken")
+
+	if err == nil {
+		t.Fatalf("Expected receiver with ?api_token= parameter to fail")
+	}
+}
@@ -0,0 +1,11 @@
+// Package receiver provides Who's On First specific implementations of the `whosonfirst/go-webhookd/v3.WebhookReceiver` interface.
+//
+// The `wofgithub://` receiver in this package is derived from the `github://` receiver in the `whosonfirst/go-webhookd-github`
+// package. It is registered using a different scheme because the `whosonfirst/go-webhookd-github` package, which also defines the
+// GitHub transformations, always registers its own receiver.
+//
+// The `gitlab://` receiver in this package receives webhook messages, including system hook messages, from GitLab and the
+// `gitea://` receiver receives webhook messages from Gitea and Forgejo. The `bitbucket://` receiver receives webhook messages
+// from Bitbucket Cloud and Bitbucket Server and converts push events in to GitHub push events, whose changed files are assigned
+// by the `bitbucketpaths://` transformation in the `transformation` package.
+package receiver
@@ -0,0 +1,344 @@
+package receiver
+
+// https://docs.gitea.com/usage/webhooks
+// https://forgejo.org/docs/latest/user/webhooks/
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// GITEA_SIGNATURE_HEADER is the request header containing the HMAC-SHA256 signature of a Gitea webhook message.
+const GITEA_SIGNATURE_HEADER string = "X-Gitea-Signature"
+
+// GITEA_EVENT_HEADER is the request header containing the event type of a Gitea webhook message.
+const GITEA_EVENT_HEADER string = "X-Gitea-Event"
+
+// FORGEJO_SIGNATURE_HEADER is the request header containing the HMAC-SHA256 signature of a Forgejo webhook message.
+const FORGEJO_SIGNATURE_HEADER string = "X-Forgejo-Signature"
+
+// FORGEJO_EVENT_HEADER is the request header containing the event type of a Forgejo webhook message.
+const FORGEJO_EVENT_HEADER string = "X-Forgejo-Event"
+
+func init() {
+
+	ctx := context.Background()
+	err := registerReceiver(ctx, "gitea", NewGiteaReceiver)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GiteaReceiver implements the `webhookd.WebhookReceiver` interface for receiving webhook messages from Gitea and Forgejo.
+type GiteaReceiver struct {
+	webhookd.WebhookReceiver
+	// secret is the shared secret used to generate signatures to validate messages.
+	secret string
+	// refs are the optional patterns for the branches (references) for which messages will be processed.
+	refs refPatterns
+	// exclude_refs are the optional patterns for the branches (references) for which messages will not be processed.
+	exclude_refs refPatterns
+	// ignore_deletions is a boolean flag signaling that push and delete events which delete a branch should be halted.
+	ignore_deletions bool
+	// events is the optional list of `X-Gitea-Event` types for which messages will be processed.
+	events map[string]bool
+}
+
+// NewGiteaReceiver instantiates a new `GiteaReceiver` for receiving webhook messages from Gitea (or Forgejo), configured
+// by 'uri' which is expected to take the form of:
+//
+//	gitea://?secret={SECRET}&ref={BRANCH}&event={EVENT}
+//
+// Where {SECRET} is the shared secret used to generate signatures to validate messages, {BRANCH} is the optional
+// branch (reference) name to limit message processing to and {EVENT} is an optional `X-Gitea-Event` type to limit
+// message processing to. The `?event=` parameter may be repeated or contain a comma-separated list of events.
+//
+// The `?ref=` parameter may be repeated and each value may be a glob or a regular expression prefixed with "regexp:". Other
+// optional parameters are:
+// * `?exclude_ref=` One or more patterns, in the same form as `?ref=`, for branches (references) not to process.
+// * `?ignore_deletions=` A boolean flag signaling that push and delete events which delete a branch should be halted.
+func NewGiteaReceiver(ctx context.Context, uri string) (webhookd.WebhookReceiver, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	secret := q.Get("secret")
+
+	if secret == "" {
+		return nil, fmt.Errorf("Missing ?secret= parameter")
+	}
+
+	refs, err := newRefPatterns(q["ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?ref= parameter, %w", err)
+	}
+
+	exclude_refs, err := newRefPatterns(q["exclude_ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?exclude_ref= parameter, %w", err)
+	}
+
+	wh := &GiteaReceiver{
+		secret:       secret,
+		refs:         refs,
+		exclude_refs: exclude_refs,
+		events:       parseEvents(q["event"]),
+	}
+
+	if q.Has("ignore_deletions") {
+
+		v, err := strconv.ParseBool(q.Get("ignore_deletions"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?ignore_deletions= parameter, %w", err)
+		}
+
+		wh.ignore_deletions = v
+	}
+
+	return wh, nil
+}
+
+// Receive() returns the body of the message in 'req'. It ensures that messages are sent as HTTP `POST` requests, that
+// the `X-Gitea-Event` (or `X-Forgejo-Event`) header is present, that the message body produces a valid signature using
+// the secret used to create 'wh' and, if necessary, that the message is associated with the events and branches used to
+// create 'wh'. Push events without a `head_commit` property are assigned one so that they can be consumed by the `githubrepo://`
+// and `githubcommits://` transformations.
+func (wh *GiteaReceiver) Receive(ctx context.Context, req *http.Request) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	if req.Method != "POST" {
+
+		code := http.StatusMethodNotAllowed
+		message := "Method not allowed"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	event_type := req.Header.Get(GITEA_EVENT_HEADER)
+
+	if event_type == "" {
+		event_type = req.Header.Get(FORGEJO_EVENT_HEADER)
+	}
+
+	if event_type == "" {
+
+		code := http.StatusBadRequest
+		message := "Bad Request - Missing X-Gitea-Event Header"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	sig := req.Header.Get(GITEA_SIGNATURE_HEADER)
+
+	if sig == "" {
+		sig = req.Header.Get(FORGEJO_SIGNATURE_HEADER)
+	}
+
+	if sig == "" {
+
+		code := http.StatusForbidden
+		message := "Missing X-Gitea-Signature required for HMAC verification"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+
+		code := http.StatusInternalServerError
+		message := err.Error()
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if !VerifyGiteaSignature(body, wh.secret, sig) {
+
+		code := http.StatusForbidden
+		message := "HMAC verification failed"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if wh.events != nil && !wh.events[event_type] {
+		msg := fmt.Sprintf("%s event is not handled", event_type)
+		err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: msg}
+		return nil, err
+	}
+
+	if event_type == "push" {
+
+		body, err = ensureHeadCommit(body)
+
+		if err != nil {
+			err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+			return nil, err
+		}
+	}
+
+	if len(wh.refs) == 0 && len(wh.exclude_refs) == 0 && !wh.ignore_deletions {
+		return body, nil
+	}
+
+	// Gitea "create" and "delete" events carry the same short reference names and reference types as GitHub
+
+	event, err := parseRefEvent(body)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	// Unlike GitHub, Gitea push events do not have a "deleted" property so deletions are identified by their "after" property.
+	// Gitea sends both a "delete" event and a push event when a branch is deleted so both are halted.
+
+	if wh.ignore_deletions && isGiteaDeletion(event_type, event) {
+		err := &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Ignoring branch deletion"}
+		return nil, err
+	}
+
+	if event.Ref == "" {
+		return body, nil
+	}
+
+	ref_err := checkRef(wh.refs, wh.exclude_refs, event.Ref)
+
+	if ref_err != nil {
+		return nil, ref_err
+	}
+
+	return body, nil
+}
+
+// isGiteaDeletion() returns a boolean value indicating whether 'event', whose type is 'event_type', is a push event or a
+// "delete" event that deleted a branch.
+func isGiteaDeletion(event_type string, event *refEvent) bool {
+
+	switch event_type {
+	case "push":
+		return event.After == ZERO_SHA
+	case "delete":
+		return strings.HasPrefix(event.Ref, "refs/heads/")
+	default:
+		return false
+	}
+}
+
+// ensureHeadCommit() returns 'body', a Gitea push event, with a `head_commit` property. If 'body' already has a (non-null)
+// `head_commit` property, or no commits, it is returned unchanged. Otherwise the head commit is the commit whose ID matches
+// the `after` property or, failing that, the last commit.
+func ensureHeadCommit(body []byte) ([]byte, error) {
+
+	var event map[string]json.RawMessage
+
+	err := json.Unmarshal(body, &event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	head, ok := event["head_commit"]
+
+	if ok && !bytes.Equal(bytes.TrimSpace(head), []byte("null")) {
+		return body, nil
+	}
+
+	var commits []json.RawMessage
+
+	raw_commits, ok := event["commits"]
+
+	if ok {
+
+		err = json.Unmarshal(raw_commits, &commits)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal commits, %w", err)
+		}
+	}
+
+	if len(commits) == 0 {
+		return body, nil
+	}
+
+	var after string
+
+	raw_after, ok := event["after"]
+
+	if ok {
+		json.Unmarshal(raw_after, &after)
+	}
+
+	head = commits[len(commits)-1]
+
+	for _, c := range commits {
+
+		var commit struct {
+			ID string `json:"id"`
+		}
+
+		err := json.Unmarshal(c, &commit)
+
+		if err == nil && commit.ID == after {
+			head = c
+			break
+		}
+	}
+
+	event["head_commit"] = head
+
+	enc_body, err := json.Marshal(event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal event, %w", err)
+	}
+
+	return enc_body, nil
+}
+
+// VerifyGiteaSignature() returns a boolean value indicating whether 'sig', the hex-encoded value of the `X-Gitea-Signature`
+// header, is the HMAC-SHA256 of 'body' using 'secret'. Digests are decoded and compared in constant time.
+func VerifyGiteaSignature(body []byte, secret string, sig string) bool {
+
+	digest, err := hex.DecodeString(strings.TrimSpace(sig))
+
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(digest, mac.Sum(nil))
+}
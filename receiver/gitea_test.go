@@ -0,0 +1,242 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	_ "github.com/whosonfirst/go-webhookd-github"
+	"github.com/whosonfirst/go-webhookd/v3"
+	wh_transformation "github.com/whosonfirst/go-webhookd/v3/transformation"
+)
+
+// signGitea() returns the hex-encoded HMAC-SHA256 digest of 'body' using 'secret'.
+func signGitea(body []byte, secret string) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newGiteaRequest() returns a new `http.Request` for the Gitea event 'event_type' whose body is 'body' signed using 'secret'.
+func newGiteaRequest(body []byte, event_type string, secret string) *http.Request {
+
+	req := httptest.NewRequest(http.MethodPost, "/gitea", bytes.NewReader(body))
+	req.Header.Set(GITEA_EVENT_HEADER, event_type)
+	req.Header.Set(GITEA_SIGNATURE_HEADER, signGitea(body, secret))
+
+	return req
+}
+
+// readFixture() returns the body of the event fixture 'name'.
+func readFixture(t *testing.T, name string) []byte {
+
+	body, err := os.ReadFile("../fixtures/events/" + name)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", name, err)
+	}
+
+	return body
+}
+
+func TestGiteaReceiver(t *testing.T) {
+
+	ctx := context.Background()
+
+	secret := "s33kret"
+
+	tests := []struct {
+		label      string
+		uri        string
+		fixture    string
+		event_type string
+		secret     string
+		code       int
+	}{
+		{"push", "gitea://?secret=s33kret", "gitea-push.json", "push", secret, 0},
+		{"delete", "gitea://?secret=s33kret", "gitea-delete.json", "delete", secret, 0},
+		{"push with wrong secret", "gitea://?secret=s33kret", "gitea-push.json", "push", "wrong", http.StatusForbidden},
+		{"delete with wrong secret", "gitea://?secret=s33kret", "gitea-delete.json", "delete", "wrong", http.StatusForbidden},
+		{"push for matching ref", "gitea://?secret=s33kret&ref=refs/heads/{main,master}", "gitea-push.json", "push", secret, 0},
+		{"push for other ref", "gitea://?secret=s33kret&ref=refs/heads/main", "gitea-push.json", "push", secret, 666},
+		{"push for excluded ref", "gitea://?secret=s33kret&exclude_ref=refs/heads/master", "gitea-push.json", "push", secret, 666},
+		{"delete for matching ref", "gitea://?secret=s33kret&ref=refs/heads/feature-*", "gitea-delete.json", "delete", secret, 0},
+		{"delete for other ref", "gitea://?secret=s33kret&ref=refs/heads/master", "gitea-delete.json", "delete", secret, 666},
+		{"push ignoring deletions", "gitea://?secret=s33kret&ignore_deletions=true", "gitea-push.json", "push", secret, 0},
+		{"delete ignoring deletions", "gitea://?secret=s33kret&ignore_deletions=true", "gitea-delete.json", "delete", secret, webhookd.HaltEvent},
+		{"unhandled event", "gitea://?secret=s33kret&event=push", "gitea-delete.json", "delete", secret, webhookd.UnhandledEvent},
+	}
+
+	for _, test := range tests {
+
+		wh, err := NewGiteaReceiver(ctx, test.uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create receiver for '%s', %v", test.label, err)
+		}
+
+		body := readFixture(t, test.fixture)
+		req := newGiteaRequest(body, test.event_type, test.secret)
+
+		out, wh_err := wh.Receive(ctx, req)
+
+		if test.code == 0 {
+
+			if wh_err != nil {
+				t.Fatalf("Expected '%s' to be received, %v", test.label, wh_err)
+			}
+
+			if len(out) == 0 {
+				t.Fatalf("Expected '%s' to return a body", test.label)
+			}
+
+			continue
+		}
+
+		if wh_err == nil {
+			t.Fatalf("Expected '%s' to fail with code %d", test.label, test.code)
+		}
+
+		if wh_err.Code != test.code {
+			t.Fatalf("Expected '%s' to fail with code %d, got %d", test.label, test.code, wh_err.Code)
+		}
+	}
+}
+
+func TestGiteaReceiverPushDeletion(t *testing.T) {
+
+	ctx := context.Background()
+
+	secret := "s33kret"
+
+	var event map[string]interface{}
+
+	err := json.Unmarshal(readFixture(t, "gitea-push.json"), &event)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal push event, %v", err)
+	}
+
+	event["after"] = ZERO_SHA
+	event["commits"] = []interface{}{}
+	event["head_commit"] = nil
+
+	body, err := json.Marshal(event)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal push event, %v", err)
+	}
+
+	wh, err := NewGiteaReceiver(ctx, "gitea://?secret=s33kret&ignore_deletions=true")
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver, %v", err)
+	}
+
+	_, wh_err := wh.Receive(ctx, newGiteaRequest(body, "push", secret))
+
+	if wh_err == nil || wh_err.Code != webhookd.HaltEvent {
+		t.Fatalf("Expected push deleting a branch to be halted, %v", wh_err)
+	}
+}
+
+func TestGiteaReceiverHeadCommit(t *testing.T) {
+
+	ctx := context.Background()
+
+	secret := "s33kret"
+
+	var event map[string]interface{}
+
+	err := json.Unmarshal(readFixture(t, "gitea-push.json"), &event)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal push event, %v", err)
+	}
+
+	expected_head := event["after"].(string)
+
+	// Older versions of Gitea do not include a head_commit property in push events
+
+	delete(event, "head_commit")
+
+	body, err := json.Marshal(event)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal push event, %v", err)
+	}
+
+	wh, err := NewGiteaReceiver(ctx, "gitea://?secret=s33kret")
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver, %v", err)
+	}
+
+	out, wh_err := wh.Receive(ctx, newGiteaRequest(body, "push", secret))
+
+	if wh_err != nil {
+		t.Fatalf("Failed to receive push event, %v", wh_err)
+	}
+
+	var received struct {
+		HeadCommit *struct {
+			ID string `json:"id"`
+		} `json:"head_commit"`
+	}
+
+	err = json.Unmarshal(out, &received)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal received push event, %v", err)
+	}
+
+	if received.HeadCommit == nil || received.HeadCommit.ID != expected_head {
+		t.Fatalf("Expected head commit to be %s", expected_head)
+	}
+
+	// The githubrepo:// and githubcommits:// transformations dereference the head commit when prepending messages and authors
+
+	tests := map[string]string{
+		"githubrepo://?prepend_message=true&prepend_author=true": "whosonfirst-data-admin-xy",
+		"githubcommits://?prepend_message=true":                  expected_head + ",whosonfirst-data-admin-xy,",
+	}
+
+	for uri, expected := range tests {
+
+		tr, err := wh_transformation.NewTransformation(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create transformation for %s, %v", uri, err)
+		}
+
+		tr_out, tr_err := tr.Transform(ctx, out)
+
+		if tr_err != nil {
+			t.Fatalf("Failed to transform push event with %s, %v", uri, tr_err)
+		}
+
+		if !bytes.Contains(tr_out, []byte(expected)) {
+			t.Fatalf("Expected output of %s to contain '%s', got '%s'", uri, expected, tr_out)
+		}
+	}
+}
+
+func TestNewGiteaReceiverMissingSecret(t *testing.T) {
+
+	ctx := context.Background()
+
+	_, err := NewGiteaReceiver(ctx, "gitea://?secret=")
+
+	if err == nil {
+		t.Fatalf("Expected receiver without a secret to fail")
+	}
+}
@@ -0,0 +1,347 @@
+package receiver
+
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// SIGNATURE_256_HEADER is the request header containing the HMAC-SHA256 signature of a GitHub webhook message.
+const SIGNATURE_256_HEADER string = "X-Hub-Signature-256"
+
+// SIGNATURE_HEADER is the request header containing the legacy HMAC-SHA1 signature of a GitHub webhook message.
+const SIGNATURE_HEADER string = "X-Hub-Signature"
+
+// SIGNATURE_SHA256 is the algorithm prefix for signatures in the `X-Hub-Signature-256` header.
+const SIGNATURE_SHA256 string = "sha256"
+
+// SIGNATURE_SHA1 is the algorithm prefix for signatures in the `X-Hub-Signature` header.
+const SIGNATURE_SHA1 string = "sha1"
+
+func init() {
+
+	ctx := context.Background()
+	err := registerReceiver(ctx, "wofgithub", NewGitHubReceiver)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GitHubReceiver implements the `webhookd.WebhookReceiver` interface for receiving webhook messages from GitHub.
+type GitHubReceiver struct {
+	webhookd.WebhookReceiver
+	// secret is the shared secret used to generate signatures to validate messages.
+	secret string
+	// refs are the optional patterns for the branches (references) for which messages will be processed.
+	refs refPatterns
+	// exclude_refs are the optional patterns for the branches (references) for which messages will not be processed.
+	exclude_refs refPatterns
+	// ignore_deletions is a boolean flag signaling that push events which delete a branch should be halted.
+	ignore_deletions bool
+	// require_sha256 is a boolean flag signaling that messages must be signed using HMAC-SHA256.
+	require_sha256 bool
+	// events is the optional list of `X-GitHub-Event` types for which messages will be processed.
+	events map[string]bool
+}
+
+// NewGitHubReceiver instantiates a new `GitHubReceiver` for receiving webhook messages from GitHub, configured
+// by 'uri' which is expected to take the form of:
+//
+//	wofgithub://?secret={SECRET}&ref={BRANCH}&require_sha256={BOOLEAN}&event={EVENT}
+//
+// Where {SECRET} is the shared secret used to generate signatures to validate messages, {BRANCH} is the optional
+// branch (reference) name to limit message processing to, {BOOLEAN} is an optional flag signaling that messages
+// without an `X-Hub-Signature-256` header should be rejected and {EVENT} is an optional `X-GitHub-Event` type to
+// limit message processing to. The `?event=` parameter may be repeated or contain a comma-separated list of events.
+//
+// The `?ref=` parameter may be repeated and each value may be a glob, for example "refs/heads/{main,master}" or
+// "refs/tags/v*", or a regular expression prefixed with "regexp:". Other optional parameters are:
+// * `?exclude_ref=` One or more patterns, in the same form as `?ref=`, for branches (references) not to process.
+// * `?ignore_deletions=` A boolean flag signaling that push events which delete a branch should be halted.
+func NewGitHubReceiver(ctx context.Context, uri string) (webhookd.WebhookReceiver, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	secret := q.Get("secret")
+
+	if secret == "" {
+		return nil, fmt.Errorf("Missing ?secret= parameter")
+	}
+
+	refs, err := newRefPatterns(q["ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?ref= parameter, %w", err)
+	}
+
+	exclude_refs, err := newRefPatterns(q["exclude_ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?exclude_ref= parameter, %w", err)
+	}
+
+	wh := &GitHubReceiver{
+		secret:       secret,
+		refs:         refs,
+		exclude_refs: exclude_refs,
+	}
+
+	if q.Has("ignore_deletions") {
+
+		v, err := strconv.ParseBool(q.Get("ignore_deletions"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?ignore_deletions= parameter, %w", err)
+		}
+
+		wh.ignore_deletions = v
+	}
+
+	if q.Has("require_sha256") {
+
+		v, err := strconv.ParseBool(q.Get("require_sha256"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?require_sha256= parameter, %w", err)
+		}
+
+		wh.require_sha256 = v
+	}
+
+	wh.events = parseEvents(q["event"])
+
+	return wh, nil
+}
+
+// Receive() returns the body of the message in 'req'. It ensures that messages are sent as HTTP `POST` requests, that
+// the `X-GitHub-Event` header is present, that the message body produces a valid signature using the secret used to
+// create 'wh' and, if necessary, that the message is associated with the branches used to create 'wh'. Signatures in the
+// `X-Hub-Signature-256` header are used in preference to those in the legacy `X-Hub-Signature` header.
+func (wh *GitHubReceiver) Receive(ctx context.Context, req *http.Request) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	if req.Method != "POST" {
+
+		code := http.StatusMethodNotAllowed
+		message := "Method not allowed"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	event_type := req.Header.Get("X-GitHub-Event")
+
+	if event_type == "" {
+
+		code := http.StatusBadRequest
+		message := "Bad Request - Missing X-GitHub-Event Header"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	sig := req.Header.Get(SIGNATURE_256_HEADER)
+	algorithm := SIGNATURE_SHA256
+
+	if sig == "" {
+
+		if wh.require_sha256 {
+
+			code := http.StatusForbidden
+			message := "Missing X-Hub-Signature-256 required for HMAC verification"
+
+			err := &webhookd.WebhookError{Code: code, Message: message}
+			return nil, err
+		}
+
+		sig = req.Header.Get(SIGNATURE_HEADER)
+		algorithm = SIGNATURE_SHA1
+	}
+
+	if sig == "" {
+
+		code := http.StatusForbidden
+		message := "Missing X-Hub-Signature-256 or X-Hub-Signature required for HMAC verification"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if event_type == "ping" {
+		err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: "ping message is a no-op"}
+		return nil, err
+	}
+
+	// remember that you want to configure GitHub to send webhooks as 'application/json'
+	// or all this code will get confused (20190212/thisisaaronland)
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+
+		code := http.StatusInternalServerError
+		message := err.Error()
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if !VerifySignature(body, wh.secret, sig, algorithm) {
+
+		code := http.StatusForbidden
+		message := "HMAC verification failed"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if wh.events != nil && !wh.events[event_type] {
+		msg := fmt.Sprintf("%s event is not handled", event_type)
+		err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: msg}
+		return nil, err
+	}
+
+	if len(wh.refs) == 0 && len(wh.exclude_refs) == 0 && !wh.ignore_deletions {
+		return body, nil
+	}
+
+	event, err := parseRefEvent(body)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	if wh.ignore_deletions && event_type == "push" && event.isDeletion() {
+		err := &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Ignoring branch deletion"}
+		return nil, err
+	}
+
+	// Only check events that actually carry a ref, for example "push" but not "release" or "issues"
+
+	if event.Ref == "" {
+		return body, nil
+	}
+
+	ref_err := checkRef(wh.refs, wh.exclude_refs, event.Ref)
+
+	if ref_err != nil {
+		return nil, ref_err
+	}
+
+	return body, nil
+}
+
+// type refEvent is a struct containing the properties of a GitHub event used to filter messages by branch (reference).
+type refEvent struct {
+	// Ref is the fully-qualified Git reference, for example "refs/heads/main", carried by the event. It is empty if the
+	// event does not carry a reference.
+	Ref string
+	// Deleted is a boolean flag signaling that the (push) event deleted its reference.
+	Deleted bool
+	// After is the commit hash of the reference after the (push) event.
+	After string
+}
+
+// isDeletion() returns a boolean value indicating whether 'e' is a push event that deleted its branch (reference).
+func (e *refEvent) isDeletion() bool {
+	return e.Deleted && e.After == ZERO_SHA
+}
+
+// parseRefEvent() returns a new `refEvent` instance derived from the GitHub event in 'body'. The "create" and "delete"
+// events carry short reference names and a reference type which are converted to fully-qualified references.
+func parseRefEvent(body []byte) (*refEvent, error) {
+
+	var event struct {
+		Ref     *string `json:"ref"`
+		RefType string  `json:"ref_type"`
+		Deleted bool    `json:"deleted"`
+		After   string  `json:"after"`
+	}
+
+	err := json.Unmarshal(body, &event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	e := &refEvent{
+		Deleted: event.Deleted,
+		After:   event.After,
+	}
+
+	if event.Ref == nil {
+		return e, nil
+	}
+
+	e.Ref = *event.Ref
+
+	switch event.RefType {
+	case "branch":
+		e.Ref = "refs/heads/" + e.Ref
+	case "tag":
+		e.Ref = "refs/tags/" + e.Ref
+	}
+
+	return e, nil
+}
+
+// VerifySignature() returns a boolean value indicating whether 'sig', a GitHub signature in the form of "{ALGORITHM}={HEX_DIGEST}",
+// is the HMAC of 'body' using 'secret' and 'algorithm' ("sha256" or "sha1"). Digests are decoded and compared in constant time.
+func VerifySignature(body []byte, secret string, sig string, algorithm string) bool {
+
+	var new_hash func() hash.Hash
+
+	switch algorithm {
+	case SIGNATURE_SHA256:
+		new_hash = sha256.New
+	case SIGNATURE_SHA1:
+		new_hash = sha1.New
+	default:
+		return false
+	}
+
+	parts := strings.SplitN(sig, "=", 2)
+
+	if len(parts) != 2 || parts[0] != algorithm {
+		return false
+	}
+
+	digest, err := hex.DecodeString(parts[1])
+
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(new_hash, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(digest, mac.Sum(nil))
+}
@@ -0,0 +1,236 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// signGitHub() returns a GitHub signature, in the form of "{ALGORITHM}={HEX_DIGEST}", for 'body' using 'secret' and 'algorithm'.
+func signGitHub(body []byte, secret string, algorithm string) string {
+
+	new_hash := sha256.New
+
+	if algorithm == SIGNATURE_SHA1 {
+		new_hash = sha1.New
+	}
+
+	mac := hmac.New(new_hash, []byte(secret))
+	mac.Write(body)
+
+	return algorithm + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newGitHubRequest() returns a new `http.Request` for the GitHub event 'event_type' whose body is 'body' and whose
+// `X-Hub-Signature-256` and `X-Hub-Signature` headers are 'sig256' and 'sig1', if not empty.
+func newGitHubRequest(body []byte, event_type string, sig256 string, sig1 string) *http.Request {
+
+	req := httptest.NewRequest(http.MethodPost, "/github", bytes.NewReader(body))
+
+	if event_type != "" {
+		req.Header.Set("X-GitHub-Event", event_type)
+	}
+
+	if sig256 != "" {
+		req.Header.Set(SIGNATURE_256_HEADER, sig256)
+	}
+
+	if sig1 != "" {
+		req.Header.Set(SIGNATURE_HEADER, sig1)
+	}
+
+	return req
+}
+
+// receiveGitHub() creates a new `GitHubReceiver` from 'uri' and uses it to receive 'req', failing the test if the
+// outcome does not match 'code' where 0 means the message is expected to be received.
+func receiveGitHub(t *testing.T, label string, uri string, req *http.Request, code int) {
+
+	ctx := context.Background()
+
+	wh, err := NewGitHubReceiver(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver for '%s', %v", label, err)
+	}
+
+	out, wh_err := wh.Receive(ctx, req)
+
+	if code == 0 {
+
+		if wh_err != nil {
+			t.Fatalf("Expected '%s' to be received, %v", label, wh_err)
+		}
+
+		if len(out) == 0 {
+			t.Fatalf("Expected '%s' to return a body", label)
+		}
+
+		return
+	}
+
+	if wh_err == nil {
+		t.Fatalf("Expected '%s' to fail with code %d", label, code)
+	}
+
+	if wh_err.Code != code {
+		t.Fatalf("Expected '%s' to fail with code %d, got %d (%s)", label, code, wh_err.Code, wh_err.Message)
+	}
+}
+
+func TestGitHubReceiverSignatures(t *testing.T) {
+
+	secret := "s33kret"
+	uri := "wofgithub://?secret=s33kret"
+
+	body := readFixture(t, "github-push.json")
+
+	sig256 := signGitHub(body, secret, SIGNATURE_SHA256)
+	sig1 := signGitHub(body, secret, SIGNATURE_SHA1)
+
+	tampered_body := bytes.Replace(body, []byte("refs/heads/master"), []byte("refs/heads/evil"), 1)
+
+	tampered_sig256 := tamperSignature(sig256)
+
+	tests := []struct {
+		label  string
+		uri    string
+		body   []byte
+		sig256 string
+		sig1   string
+		code   int
+	}{
+		{"sha256", uri, body, sig256, "", 0},
+		{"sha256 and sha1", uri, body, sig256, sig1, 0},
+		{"sha1 fallback", uri, body, "", sig1, 0},
+		{"sha1 fallback when sha256 is required", uri + "&require_sha256=true", body, "", sig1, http.StatusForbidden},
+		{"sha256 when sha256 is required", uri + "&require_sha256=true", body, sig256, "", 0},
+		{"invalid sha256 with valid sha1", uri, body, tampered_sig256, sig1, http.StatusForbidden},
+		{"wrong secret", uri, body, signGitHub(body, "wrong", SIGNATURE_SHA256), "", http.StatusForbidden},
+		{"wrong secret sha1", uri, body, "", signGitHub(body, "wrong", SIGNATURE_SHA1), http.StatusForbidden},
+		{"tampered body", uri, tampered_body, sig256, "", http.StatusForbidden},
+		{"tampered body sha1", uri, tampered_body, "", sig1, http.StatusForbidden},
+		{"tampered signature", uri, body, tampered_sig256, "", http.StatusForbidden},
+		{"truncated signature", uri, body, sig256[:len(sig256)-2], "", http.StatusForbidden},
+		{"malformed hex digest", uri, body, "sha256=" + "zz" + sig256[len("sha256=")+2:], "", http.StatusForbidden},
+		{"odd length hex digest", uri, body, sig256 + "0", "", http.StatusForbidden},
+		{"mismatched algorithm", uri, body, "sha1=" + sig256[len("sha256="):], "", http.StatusForbidden},
+		{"missing algorithm", uri, body, sig256[len("sha256="):], "", http.StatusForbidden},
+		{"missing signature", uri, body, "", "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req := newGitHubRequest(test.body, "push", test.sig256, test.sig1)
+		receiveGitHub(t, test.label, test.uri, req, test.code)
+	}
+}
+
+func TestGitHubReceiverRequests(t *testing.T) {
+
+	secret := "s33kret"
+	uri := "wofgithub://?secret=s33kret"
+
+	body := readFixture(t, "github-push.json")
+	sig256 := signGitHub(body, secret, SIGNATURE_SHA256)
+
+	// Ping messages are acknowledged (as unhandled) before the body is verified, but not without a signature
+
+	receiveGitHub(t, "ping", uri, newGitHubRequest([]byte(`{"zen":"Keep it logically awesome."}`), "ping", sig256, ""), webhookd.UnhandledEvent)
+	receiveGitHub(t, "ping without signature", uri, newGitHubRequest(body, "ping", "", ""), http.StatusForbidden)
+	receiveGitHub(t, "ping without sha256 signature", uri+"&require_sha256=true", newGitHubRequest(body, "ping", "", signGitHub(body, secret, SIGNATURE_SHA1)), http.StatusForbidden)
+
+	receiveGitHub(t, "missing event", uri, newGitHubRequest(body, "", sig256, ""), http.StatusBadRequest)
+
+	req := newGitHubRequest(body, "push", sig256, "")
+	req.Method = http.MethodGet
+
+	receiveGitHub(t, "GET request", uri, req, http.StatusMethodNotAllowed)
+}
+
+func TestGitHubReceiverEvents(t *testing.T) {
+
+	secret := "s33kret"
+
+	push := readFixture(t, "github-push.json")
+	issues := []byte(`{"action":"opened","issue":{"number":1}}`)
+	create_branch := []byte(`{"ref":"main","ref_type":"branch","master_branch":"main"}`)
+	create_other_branch := []byte(`{"ref":"feature","ref_type":"branch","master_branch":"main"}`)
+	create_tag := []byte(`{"ref":"v1.0.0","ref_type":"tag","master_branch":"main"}`)
+	delete_branch := []byte(`{"ref":"main","ref_type":"branch"}`)
+
+	tests := []struct {
+		label      string
+		uri        string
+		body       []byte
+		event_type string
+		secret     string
+		code       int
+	}{
+		{"allowlisted push", "wofgithub://?secret=s33kret&event=push", push, "push", secret, 0},
+		{"non-allowlisted issues", "wofgithub://?secret=s33kret&event=push", issues, "issues", secret, webhookd.UnhandledEvent},
+		{"non-allowlisted create", "wofgithub://?secret=s33kret&event=push", create_branch, "create", secret, webhookd.UnhandledEvent},
+		{"non-allowlisted issues with wrong secret", "wofgithub://?secret=s33kret&event=push", issues, "issues", "wrong", http.StatusForbidden},
+		{"repeated event parameters", "wofgithub://?secret=s33kret&event=push&event=issues", issues, "issues", secret, 0},
+		{"any event", "wofgithub://?secret=s33kret", issues, "issues", secret, 0},
+		{"event without ref", "wofgithub://?secret=s33kret&event=issues&ref=refs/heads/main", issues, "issues", secret, 0},
+		{"allowlisted create for matching branch", "wofgithub://?secret=s33kret&event=push,create,delete&ref=refs/heads/main", create_branch, "create", secret, 0},
+		{"allowlisted create for other branch", "wofgithub://?secret=s33kret&event=push,create,delete&ref=refs/heads/main", create_other_branch, "create", secret, 666},
+		{"allowlisted create for excluded branch", "wofgithub://?secret=s33kret&event=create&exclude_ref=refs/heads/feat*", create_other_branch, "create", secret, 666},
+		{"allowlisted create for matching tag", "wofgithub://?secret=s33kret&event=create&ref=refs/tags/v*", create_tag, "create", secret, 0},
+		{"allowlisted create for tag not matching branch", "wofgithub://?secret=s33kret&event=create&ref=refs/heads/*", create_tag, "create", secret, 666},
+		{"allowlisted delete for matching branch", "wofgithub://?secret=s33kret&event=push,create,delete&ref=refs/heads/main", delete_branch, "delete", secret, 0},
+		{"allowlisted delete for other branch", "wofgithub://?secret=s33kret&event=delete&ref=refs/heads/feature", delete_branch, "delete", secret, 666},
+		{"allowlisted delete ignoring deletions", "wofgithub://?secret=s33kret&event=delete&ignore_deletions=true", delete_branch, "delete", secret, 0},
+		{"allowlisted invalid create", "wofgithub://?secret=s33kret&event=create&ref=refs/heads/main", []byte(`{"ref":`), "create", secret, 999},
+	}
+
+	for _, test := range tests {
+		req := newGitHubRequest(test.body, test.event_type, signGitHub(test.body, test.secret, SIGNATURE_SHA256), "")
+		receiveGitHub(t, test.label, test.uri, req, test.code)
+	}
+}
+
+func TestParseRefEvent(t *testing.T) {
+
+	tests := map[string]string{
+		`{"ref":"refs/heads/main","after":"abc"}`: "refs/heads/main",
+		`{"ref":"main","ref_type":"branch"}`:      "refs/heads/main",
+		`{"ref":"v1.0.0","ref_type":"tag"}`:       "refs/tags/v1.0.0",
+		`{"ref":null,"ref_type":"repository"}`:    "",
+		`{"action":"opened"}`:                     "",
+	}
+
+	for body, expected := range tests {
+
+		e, err := parseRefEvent([]byte(body))
+
+		if err != nil {
+			t.Fatalf("Failed to parse '%s', %v", body, err)
+		}
+
+		if e.Ref != expected {
+			t.Fatalf("Expected '%s' to have ref '%s', got '%s'", body, expected, e.Ref)
+		}
+	}
+}
+
+// tamperSignature() returns a copy of 'sig' with its last hex digit changed.
+func tamperSignature(sig string) string {
+
+	last := "0"
+
+	if strings.HasSuffix(sig, "0") {
+		last = "1"
+	}
+
+	return sig[:len(sig)-1] + last
+}
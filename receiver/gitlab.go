@@ -0,0 +1,456 @@
+package receiver
+
+// https://docs.gitlab.com/user/project/integrations/webhooks/
+// https://docs.gitlab.com/administration/system_hooks/
+// https://www.standardwebhooks.com/
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// GITLAB_TOKEN_HEADER is the request header containing the secret token of a GitLab webhook message.
+const GITLAB_TOKEN_HEADER string = "X-Gitlab-Token"
+
+// GITLAB_EVENT_HEADER is the request header containing the event type of a GitLab webhook message.
+const GITLAB_EVENT_HEADER string = "X-Gitlab-Event"
+
+// GITLAB_SYSTEM_HOOK is the value of the `X-Gitlab-Event` header for messages sent by GitLab system hooks.
+const GITLAB_SYSTEM_HOOK string = "System Hook"
+
+// WEBHOOK_ID_HEADER is the request header containing the unique identifier of a message signed with a signing token.
+const WEBHOOK_ID_HEADER string = "Webhook-Id"
+
+// WEBHOOK_TIMESTAMP_HEADER is the request header containing the time (in Unix seconds) a message signed with a signing token was sent.
+const WEBHOOK_TIMESTAMP_HEADER string = "Webhook-Timestamp"
+
+// WEBHOOK_SIGNATURE_HEADER is the request header containing the signatures of a message signed with a signing token.
+const WEBHOOK_SIGNATURE_HEADER string = "Webhook-Signature"
+
+// SIGNING_TOKEN_PREFIX is the prefix for signing tokens whose (remaining) value is a base64-encoded key.
+const SIGNING_TOKEN_PREFIX string = "whsec_"
+
+// DEFAULT_SIGNATURE_TOLERANCE is the default maximum difference between the time a signed message was sent and the
+// time it was received.
+const DEFAULT_SIGNATURE_TOLERANCE time.Duration = 5 * time.Minute
+
+// gitlab_system_hook_events maps the `event_name` (or `object_kind`) property of system hook messages to the
+// equivalent `X-Gitlab-Event` types for project webhooks.
+var gitlab_system_hook_events = map[string]string{
+	"push":          "Push Hook",
+	"tag_push":      "Tag Push Hook",
+	"merge_request": "Merge Request Hook",
+}
+
+func init() {
+
+	ctx := context.Background()
+	err := registerReceiver(ctx, "gitlab", NewGitLabReceiver)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GitLabReceiver implements the `webhookd.WebhookReceiver` interface for receiving webhook messages from GitLab.
+type GitLabReceiver struct {
+	webhookd.WebhookReceiver
+	// token is the optional secret token which messages are expected to include in the `X-Gitlab-Token` header.
+	token string
+	// signing_key is the optional key used to generate signatures to validate messages.
+	signing_key []byte
+	// signature_tolerance is the maximum difference between the time a signed message was sent and the time it was received.
+	signature_tolerance time.Duration
+	// refs are the optional patterns for the branches (references) for which messages will be processed.
+	refs refPatterns
+	// exclude_refs are the optional patterns for the branches (references) for which messages will not be processed.
+	exclude_refs refPatterns
+	// ignore_deletions is a boolean flag signaling that push events which delete a branch should be halted.
+	ignore_deletions bool
+	// system_hooks is a boolean flag signaling that messages sent by GitLab system hooks should be processed.
+	system_hooks bool
+	// events is the optional list of `X-Gitlab-Event` types for which messages will be processed.
+	events map[string]bool
+}
+
+// NewGitLabReceiver instantiates a new `GitLabReceiver` for receiving webhook messages from GitLab, configured
+// by 'uri' which is expected to take the form of:
+//
+//	gitlab://?token={TOKEN}&signing_token={SIGNING_TOKEN}&event={EVENT}&ref={BRANCH}&system_hooks={BOOLEAN}
+//
+// Where {TOKEN} is the secret token which messages are expected to include in the `X-Gitlab-Token` header, {SIGNING_TOKEN}
+// is the signing token used to generate signatures to validate messages, {EVENT} is an optional `X-Gitlab-Event` type,
+// for example "Push Hook", to limit message processing to, {BRANCH} is the optional branch (reference) name to limit message
+// processing to and {BOOLEAN} is an optional flag signaling that messages sent by GitLab system hooks should be processed.
+// At least one of `?token=` or `?signing_token=` is required. The `?event=` parameter may be repeated or contain a
+// comma-separated list of events.
+//
+// The `?ref=` parameter may be repeated and each value may be a glob or a regular expression prefixed with "regexp:". Other
+// optional parameters are:
+// * `?exclude_ref=` One or more patterns, in the same form as `?ref=`, for branches (references) not to process.
+// * `?ignore_deletions=` A boolean flag signaling that push events which delete a branch should be halted.
+// * `?signature_tolerance=` The maximum difference, as a duration, between the time a signed message was sent and the time it was received. Default is 5 minutes.
+func NewGitLabReceiver(ctx context.Context, uri string) (webhookd.WebhookReceiver, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	token := q.Get("token")
+	signing_token := q.Get("signing_token")
+
+	if token == "" && signing_token == "" {
+		return nil, fmt.Errorf("Missing ?token= or ?signing_token= parameter")
+	}
+
+	refs, err := newRefPatterns(q["ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?ref= parameter, %w", err)
+	}
+
+	exclude_refs, err := newRefPatterns(q["exclude_ref"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ?exclude_ref= parameter, %w", err)
+	}
+
+	wh := &GitLabReceiver{
+		token:               token,
+		signature_tolerance: DEFAULT_SIGNATURE_TOLERANCE,
+		refs:                refs,
+		exclude_refs:        exclude_refs,
+	}
+
+	if signing_token != "" {
+
+		key, err := signingKey(signing_token)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?signing_token= parameter, %w", err)
+		}
+
+		wh.signing_key = key
+	}
+
+	if q.Has("signature_tolerance") {
+
+		v, err := time.ParseDuration(q.Get("signature_tolerance"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?signature_tolerance= parameter, %w", err)
+		}
+
+		wh.signature_tolerance = v
+	}
+
+	if q.Has("ignore_deletions") {
+
+		v, err := strconv.ParseBool(q.Get("ignore_deletions"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?ignore_deletions= parameter, %w", err)
+		}
+
+		wh.ignore_deletions = v
+	}
+
+	if q.Has("system_hooks") {
+
+		v, err := strconv.ParseBool(q.Get("system_hooks"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?system_hooks= parameter, %w", err)
+		}
+
+		wh.system_hooks = v
+	}
+
+	wh.events = parseEvents(q["event"])
+
+	return wh, nil
+}
+
+// Receive() returns the body of the message in 'req'. It ensures that messages are sent as HTTP `POST` requests, that
+// the `X-Gitlab-Event` header is present, that the `X-Gitlab-Token` header and, if a signing token was used to create 'wh',
+// the `Webhook-Signature` header are valid and, if necessary, that the message is associated with the events and branches
+// used to create 'wh'. Messages sent by system hooks are halted unless 'wh' was created with `?system_hooks=true` in which
+// case push, tag push and merge request events are treated as their project webhook equivalents.
+func (wh *GitLabReceiver) Receive(ctx context.Context, req *http.Request) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	if req.Method != "POST" {
+
+		code := http.StatusMethodNotAllowed
+		message := "Method not allowed"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	event_type := req.Header.Get(GITLAB_EVENT_HEADER)
+
+	if event_type == "" {
+
+		code := http.StatusBadRequest
+		message := "Bad Request - Missing X-Gitlab-Event Header"
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if wh.token != "" {
+
+		token := req.Header.Get(GITLAB_TOKEN_HEADER)
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(wh.token)) != 1 {
+
+			code := http.StatusForbidden
+			message := "Invalid or missing X-Gitlab-Token"
+
+			err := &webhookd.WebhookError{Code: code, Message: message}
+			return nil, err
+		}
+	}
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+
+		code := http.StatusInternalServerError
+		message := err.Error()
+
+		err := &webhookd.WebhookError{Code: code, Message: message}
+		return nil, err
+	}
+
+	if wh.signing_key != nil {
+
+		err := wh.verifySignedRequest(req, body)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	event, err := parseGitLabEvent(body)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	if event_type == GITLAB_SYSTEM_HOOK {
+
+		if !wh.system_hooks {
+			err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: "System Hook event is not handled"}
+			return nil, err
+		}
+
+		event_type = event.systemHookEventType()
+	}
+
+	if wh.events != nil && !wh.events[event_type] {
+		msg := fmt.Sprintf("%s event is not handled", event_type)
+		err := &webhookd.WebhookError{Code: webhookd.UnhandledEvent, Message: msg}
+		return nil, err
+	}
+
+	if wh.ignore_deletions && event.isDeletion() {
+		err := &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Ignoring branch deletion"}
+		return nil, err
+	}
+
+	// Only check events that actually carry a ref, for example "Push Hook" but not "Issue Hook"
+
+	if event.Ref == "" {
+		return body, nil
+	}
+
+	ref_err := checkRef(wh.refs, wh.exclude_refs, event.Ref)
+
+	if ref_err != nil {
+		return nil, ref_err
+	}
+
+	return body, nil
+}
+
+// verifySignedRequest() ensures that the `Webhook-Id`, `Webhook-Timestamp` and `Webhook-Signature` headers in 'req'
+// are present, that the timestamp is within the signature tolerance of 'wh' and that one of the signatures is valid for 'body'.
+func (wh *GitLabReceiver) verifySignedRequest(req *http.Request, body []byte) *webhookd.WebhookError {
+
+	id := req.Header.Get(WEBHOOK_ID_HEADER)
+	str_ts := req.Header.Get(WEBHOOK_TIMESTAMP_HEADER)
+	sigs := req.Header.Get(WEBHOOK_SIGNATURE_HEADER)
+
+	if id == "" || str_ts == "" || sigs == "" {
+
+		code := http.StatusForbidden
+		message := "Missing Webhook-Id, Webhook-Timestamp or Webhook-Signature required for signature verification"
+
+		return &webhookd.WebhookError{Code: code, Message: message}
+	}
+
+	ts, err := strconv.ParseInt(str_ts, 10, 64)
+
+	if err != nil {
+
+		code := http.StatusForbidden
+		message := "Invalid Webhook-Timestamp"
+
+		return &webhookd.WebhookError{Code: code, Message: message}
+	}
+
+	if wh.signature_tolerance > 0 {
+
+		age := time.Since(time.Unix(ts, 0))
+
+		if age < 0 {
+			age = -age
+		}
+
+		if age > wh.signature_tolerance {
+
+			code := http.StatusForbidden
+			message := "Webhook-Timestamp is outside the signature tolerance"
+
+			return &webhookd.WebhookError{Code: code, Message: message}
+		}
+	}
+
+	if !VerifySigningToken(body, wh.signing_key, id, str_ts, sigs) {
+
+		code := http.StatusForbidden
+		message := "Signature verification failed"
+
+		return &webhookd.WebhookError{Code: code, Message: message}
+	}
+
+	return nil
+}
+
+// type gitLabEvent is a struct containing the properties of a GitLab event used to filter messages.
+type gitLabEvent struct {
+	// Ref is the fully-qualified Git reference, for example "refs/heads/main", carried by the event. It is empty if the
+	// event does not carry a reference.
+	Ref string `json:"ref"`
+	// After is the commit hash of the reference after the (push) event.
+	After string `json:"after"`
+	// EventName is the name of the event, for example "push" or "project_create", for system hook messages.
+	EventName string `json:"event_name"`
+	// ObjectKind is the kind of object, for example "push" or "merge_request", the event is about.
+	ObjectKind string `json:"object_kind"`
+}
+
+// isDeletion() returns a boolean value indicating whether 'e' is a push event that deleted its branch (reference).
+// Unlike GitHub, GitLab push events do not have a "deleted" property so deletions are identified by their "after" property.
+func (e *gitLabEvent) isDeletion() bool {
+	return e.Ref != "" && e.After == ZERO_SHA
+}
+
+// systemHookEventType() returns the `X-Gitlab-Event` type for project webhooks equivalent to the system hook event 'e'.
+// If there is no equivalent, for example for "project_create" events, then the name of the event is returned.
+func (e *gitLabEvent) systemHookEventType() string {
+
+	name := e.EventName
+
+	if name == "" {
+		name = e.ObjectKind
+	}
+
+	event_type, ok := gitlab_system_hook_events[name]
+
+	if ok {
+		return event_type
+	}
+
+	return name
+}
+
+// parseGitLabEvent() returns a new `gitLabEvent` instance derived from the GitLab event in 'body'.
+func parseGitLabEvent(body []byte) (*gitLabEvent, error) {
+
+	var event gitLabEvent
+
+	err := json.Unmarshal(body, &event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	return &event, nil
+}
+
+// signingKey() returns the key used to generate signatures derived from 'token'. Tokens prefixed with "whsec_" are
+// base64-decoded, other tokens are used as-is.
+func signingKey(token string) ([]byte, error) {
+
+	if !strings.HasPrefix(token, SIGNING_TOKEN_PREFIX) {
+		return []byte(token), nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(token, SIGNING_TOKEN_PREFIX))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode signing token, %w", err)
+	}
+
+	return key, nil
+}
+
+// VerifySigningToken() returns a boolean value indicating whether any of 'sigs', a space-separated list of signatures in
+// the form of "v1,{BASE64_DIGEST}", is the HMAC-SHA256 of "{ID}.{TIMESTAMP}.{BODY}" using 'key'. This is the scheme defined
+// by the Standard Webhooks specification and used by GitLab signing tokens. Digests are decoded and compared in constant time.
+func VerifySigningToken(body []byte, key []byte, id string, timestamp string, sigs string) bool {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+
+	expected := mac.Sum(nil)
+
+	for _, sig := range strings.Fields(sigs) {
+
+		parts := strings.SplitN(sig, ",", 2)
+
+		if len(parts) != 2 || parts[0] != "v1" {
+			continue
+		}
+
+		digest, err := base64.StdEncoding.DecodeString(parts[1])
+
+		if err != nil {
+			continue
+		}
+
+		if hmac.Equal(digest, expected) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,143 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signGitLab() returns the Standard Webhooks signature, in the form of "v1,{BASE64_DIGEST}", of 'body' using 'key', 'id' and 'timestamp'.
+func signGitLab(body []byte, key []byte, id string, timestamp string) string {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySigningToken(t *testing.T) {
+
+	body := []byte(`{"object_kind":"push"}`)
+	key := []byte("s33kret")
+	wrong_key := []byte("wrong")
+
+	id := "msg_123"
+	ts := "1700000000"
+
+	valid := signGitLab(body, key, id, ts)
+	invalid := signGitLab(body, wrong_key, id, ts)
+
+	tests := []struct {
+		label    string
+		body     []byte
+		key      []byte
+		id       string
+		ts       string
+		sigs     string
+		expected bool
+	}{
+		{"valid", body, key, id, ts, valid, true},
+		{"wrong key", body, wrong_key, id, ts, valid, false},
+		{"signed with wrong key", body, key, id, ts, invalid, false},
+		{"modified body", []byte(`{"object_kind":"tag_push"}`), key, id, ts, valid, false},
+		{"modified id", body, key, "msg_456", ts, valid, false},
+		{"modified timestamp", body, key, id, "1700000001", valid, false},
+		{"multiple signatures, valid last", body, key, id, ts, invalid + " " + valid, true},
+		{"multiple signatures, valid first", body, key, id, ts, valid + " " + invalid, true},
+		{"multiple signatures, none valid", body, key, id, ts, invalid + " " + invalid, false},
+		{"unsupported version", body, key, id, ts, "v1a" + valid[2:], false},
+		{"invalid base64", body, key, id, ts, "v1,!!!! " + valid, true},
+		{"empty", body, key, id, ts, "", false},
+	}
+
+	for _, test := range tests {
+
+		ok := VerifySigningToken(test.body, test.key, test.id, test.ts, test.sigs)
+
+		if ok != test.expected {
+			t.Fatalf("Expected '%s' to be %t", test.label, test.expected)
+		}
+	}
+}
+
+func TestGitLabReceiverSigningToken(t *testing.T) {
+
+	ctx := context.Background()
+
+	key := []byte("s33kret")
+	token := "whsec_" + base64.StdEncoding.EncodeToString(key)
+
+	uri := fmt.Sprintf("gitlab://?signing_token=%s&signature_tolerance=5m", token)
+
+	wh, err := NewGitLabReceiver(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create receiver, %v", err)
+	}
+
+	body, err := os.ReadFile("../fixtures/events/gitlab-push.json")
+
+	if err != nil {
+		t.Fatalf("Failed to read GitLab push event, %v", err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		label string
+		ts    time.Time
+		key   []byte
+		ok    bool
+	}{
+		{"valid", now, key, true},
+		{"wrong key", now, []byte("wrong"), false},
+		{"expired timestamp", now.Add(-10 * time.Minute), key, false},
+		{"future timestamp", now.Add(10 * time.Minute), key, false},
+		{"within tolerance", now.Add(-2 * time.Minute), key, true},
+	}
+
+	for _, test := range tests {
+
+		id := "msg_" + test.label
+		ts := strconv.FormatInt(test.ts.Unix(), 10)
+
+		req := httptest.NewRequest(http.MethodPost, "/gitlab", bytes.NewReader(body))
+		req.Header.Set(GITLAB_EVENT_HEADER, "Push Hook")
+		req.Header.Set(WEBHOOK_ID_HEADER, id)
+		req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, ts)
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, signGitLab(body, test.key, id, ts))
+
+		out, wh_err := wh.Receive(ctx, req)
+
+		if test.ok {
+
+			if wh_err != nil {
+				t.Fatalf("Expected '%s' to be received, %v", test.label, wh_err)
+			}
+
+			if !bytes.Equal(out, body) {
+				t.Fatalf("Unexpected body for '%s'", test.label)
+			}
+
+			continue
+		}
+
+		if wh_err == nil {
+			t.Fatalf("Expected '%s' to be rejected", test.label)
+		}
+
+		if wh_err.Code != http.StatusForbidden {
+			t.Fatalf("Unexpected error code for '%s', %d", test.label, wh_err.Code)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package receiver
+
+import (
+	"context"
+	"strings"
+
+	wh_receiver "github.com/whosonfirst/go-webhookd/v3/receiver"
+)
+
+// registerReceiver() associates 'scheme' with 'init_func' unless another package has already registered a receiver
+// for 'scheme'.
+func registerReceiver(ctx context.Context, scheme string, init_func wh_receiver.ReceiverInitializationFunc) error {
+
+	for _, s := range wh_receiver.Schemes() {
+
+		if strings.EqualFold(s, scheme+"://") {
+			return nil
+		}
+	}
+
+	return wh_receiver.RegisterReceiver(ctx, scheme, init_func)
+}
+
+// parseEvents() returns the set of event types derived from 'values', each of which may contain a comma-separated list of
+// event types. If 'values' does not contain any event types then nil is returned.
+func parseEvents(values []string) map[string]bool {
+
+	var events map[string]bool
+
+	for _, str_events := range values {
+
+		for _, ev := range strings.Split(str_events, ",") {
+
+			ev = strings.TrimSpace(ev)
+
+			if ev == "" {
+				continue
+			}
+
+			if events == nil {
+				events = make(map[string]bool)
+			}
+
+			events[ev] = true
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,279 @@
+package receiver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// REF_REGEXP_PREFIX is the prefix for reference patterns that are regular expressions rather than globs. Unlike globs,
+// regular expressions are not anchored and match any reference that contains a match unless they start with "^" and end with "$".
+const REF_REGEXP_PREFIX string = "regexp:"
+
+// ZERO_SHA is the commit hash GitHub uses for the "after" property of push events that delete a branch (or tag).
+const ZERO_SHA string = "0000000000000000000000000000000000000000"
+
+// type refPatterns is a list of compiled reference patterns.
+type refPatterns []*regexp.Regexp
+
+// newRefPatterns() returns a new `refPatterns` instance derived from 'patterns'. Each pattern is either a regular
+// expression, if it is prefixed with "regexp:", or a glob. Globs match entire references; regular expressions are not anchored.
+func newRefPatterns(patterns []string) (refPatterns, error) {
+
+	refs := make(refPatterns, 0)
+
+	for _, pat := range patterns {
+
+		pat = strings.TrimSpace(pat)
+
+		if pat == "" {
+			continue
+		}
+
+		var re *regexp.Regexp
+		var err error
+
+		if strings.HasPrefix(pat, REF_REGEXP_PREFIX) {
+			re, err = regexp.Compile(strings.TrimPrefix(pat, REF_REGEXP_PREFIX))
+		} else {
+			re, err = compileGlob(pat)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ref pattern '%s', %w", pat, err)
+		}
+
+		refs = append(refs, re)
+	}
+
+	return refs, nil
+}
+
+// matches() returns a boolean value indicating whether 'ref' matches any of the patterns in 'p'.
+func (p refPatterns) matches(ref string) bool {
+
+	for _, re := range p {
+
+		if re.MatchString(ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRef() returns an error with code 666 if 'ref' does not match any of the patterns in 'refs', when there are any,
+// or if it matches any of the patterns in 'exclude_refs'.
+func checkRef(refs refPatterns, exclude_refs refPatterns, ref string) *webhookd.WebhookError {
+
+	if len(refs) > 0 && !refs.matches(ref) {
+
+		msg := "Invalid ref for commit"
+		err := &webhookd.WebhookError{Code: 666, Message: msg}
+		return err
+	}
+
+	if exclude_refs.matches(ref) {
+
+		msg := "Excluded ref for commit"
+		err := &webhookd.WebhookError{Code: 666, Message: msg}
+		return err
+	}
+
+	return nil
+}
+
+// compileGlob() returns a regular expression that matches the same strings as 'glob'. In addition to the `path.Match`
+// syntax, where "*", "?" and "[...]" character classes do not match "/", globs may contain "{a,b}" alternations, for
+// example "refs/heads/{main,master}".
+func compileGlob(glob string) (*regexp.Regexp, error) {
+
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	depth := 0
+
+	for i := 0; i < len(glob); i++ {
+
+		c := glob[i]
+
+		switch c {
+		case '*':
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '{':
+			depth += 1
+			sb.WriteString("(?:")
+		case '}':
+
+			if depth == 0 {
+				return nil, fmt.Errorf("Unbalanced '}' at offset %d", i)
+			}
+
+			depth -= 1
+			sb.WriteString(")")
+		case ',':
+
+			if depth > 0 {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+
+		case '[':
+
+			class, end, err := compileGlobClass(glob, i)
+
+			if err != nil {
+				return nil, err
+			}
+
+			sb.WriteString(class)
+			i = end
+
+		case '\\':
+
+			if i+1 < len(glob) {
+				i += 1
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("Unbalanced '{'")
+	}
+
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
+
+// compileGlobClass() returns a regular expression character class equivalent to the glob character class starting at
+// offset 'start' of 'glob', and the offset of the "]" that closes it. Classes follow the `path.Match` syntax: they may
+// be negated with a leading "!" or "^" and contain characters, "lo-hi" ranges and characters escaped with "\". Since
+// "*" and "?" do not match "/" neither do classes: negated classes never match "/" and classes that would match "/"
+// are invalid. POSIX classes like "[:alpha:]" are not supported so an unescaped "[" in a class is invalid.
+func compileGlobClass(glob string, start int) (string, int, error) {
+
+	var sb strings.Builder
+	sb.WriteString("[")
+
+	i := start + 1
+	negated := false
+
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		// Exclude "/" from negated classes for the same reason that "*" and "?" do not match it
+		sb.WriteString("^/")
+		negated = true
+		i += 1
+	}
+
+	// readChar() returns the (possibly escaped) character at offset 'i' of 'glob'
+
+	readChar := func() (rune, error) {
+
+		if i >= len(glob) {
+			return 0, fmt.Errorf("Unterminated '[' at offset %d", start)
+		}
+
+		switch glob[i] {
+		case '\\':
+
+			i += 1
+
+			if i >= len(glob) {
+				return 0, fmt.Errorf("Unterminated '[' at offset %d", start)
+			}
+
+		case '-', ']':
+			return 0, fmt.Errorf("Invalid character class at offset %d, unexpected '%c' at offset %d", start, glob[i], i)
+		case '[':
+			return 0, fmt.Errorf("Invalid character class at offset %d, unescaped '[' at offset %d (POSIX classes are not supported)", start, i)
+		}
+
+		r, size := utf8.DecodeRuneInString(glob[i:])
+
+		if r == utf8.RuneError && size <= 1 {
+			return 0, fmt.Errorf("Invalid character class at offset %d, invalid UTF-8 at offset %d", start, i)
+		}
+
+		i += size
+		return r, nil
+	}
+
+	empty := true
+
+	for {
+
+		if i >= len(glob) {
+			return "", 0, fmt.Errorf("Unterminated '[' at offset %d", start)
+		}
+
+		if glob[i] == ']' {
+
+			if empty {
+				return "", 0, fmt.Errorf("Invalid character class at offset %d, class is empty", start)
+			}
+
+			break
+		}
+
+		lo, err := readChar()
+
+		if err != nil {
+			return "", 0, err
+		}
+
+		hi := lo
+
+		if i < len(glob) && glob[i] == '-' {
+
+			i += 1
+
+			hi, err = readChar()
+
+			if err != nil {
+				return "", 0, err
+			}
+
+			if hi < lo {
+				return "", 0, fmt.Errorf("Invalid character class at offset %d, range '%c-%c' is out of order", start, lo, hi)
+			}
+		}
+
+		if !negated && lo <= '/' && hi >= '/' {
+			return "", 0, fmt.Errorf("Invalid character class at offset %d, classes can not match '/'", start)
+		}
+
+		sb.WriteString(quoteClassChar(lo))
+
+		if hi != lo {
+			sb.WriteString("-")
+			sb.WriteString(quoteClassChar(hi))
+		}
+
+		empty = false
+	}
+
+	sb.WriteString("]")
+
+	return sb.String(), i, nil
+}
+
+// quoteClassChar() returns 'r' escaped, if necessary, for use in a regular expression character class.
+func quoteClassChar(r rune) string {
+
+	if r < utf8.RuneSelf && strings.ContainsRune(`\^-[]`, r) {
+		return `\` + string(r)
+	}
+
+	return string(r)
+}
@@ -0,0 +1,272 @@
+package receiver
+
+import (
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+
+	tests := []struct {
+		glob    string
+		matches map[string]bool
+	}{
+		{
+			glob: "refs/heads/{main,master}",
+			matches: map[string]bool{
+				"refs/heads/main":          true,
+				"refs/heads/master":        true,
+				"refs/heads/mainline":      false,
+				"refs/heads/develop":       false,
+				"refs/tags/main":           false,
+				"refs/heads/{main,master}": false,
+			},
+		},
+		{
+			glob: "refs/tags/v*",
+			matches: map[string]bool{
+				"refs/tags/v1.0.0":     true,
+				"refs/tags/v":          true,
+				"refs/tags/release-v1": false,
+				"refs/heads/v1":        false,
+			},
+		},
+		{
+			glob: "refs/heads/*",
+			matches: map[string]bool{
+				"refs/heads/main":          true,
+				"refs/heads/feature/thing": false,
+				"refs/heads/":              true,
+			},
+		},
+		{
+			glob: "refs/heads/feature-?",
+			matches: map[string]bool{
+				"refs/heads/feature-a":  true,
+				"refs/heads/feature-ab": false,
+				"refs/heads/feature-/":  false,
+			},
+		},
+		{
+			glob: "refs/heads/[!x]*",
+			matches: map[string]bool{
+				"refs/heads/main":   true,
+				"refs/heads/xmain":  false,
+				"refs/heads/master": true,
+			},
+		},
+		{
+			glob: "refs/heads/[^x]*",
+			matches: map[string]bool{
+				"refs/heads/main":  true,
+				"refs/heads/xmain": false,
+			},
+		},
+		{
+			// Negated classes do not match "/"
+			glob: "refs/heads/feature[!a]x",
+			matches: map[string]bool{
+				"refs/heads/feature-x": true,
+				"refs/heads/featureax": false,
+				"refs/heads/feature/x": false,
+			},
+		},
+		{
+			glob: "refs/heads/[a-c]*",
+			matches: map[string]bool{
+				"refs/heads/bugfix": true,
+				"refs/heads/main":   false,
+			},
+		},
+		{
+			glob: `refs/tags/v[0-9].[0-9x\-]`,
+			matches: map[string]bool{
+				"refs/tags/v1.2": true,
+				"refs/tags/v1.x": true,
+				"refs/tags/v1.-": true,
+				"refs/tags/v1.y": false,
+				"refs/tags/va.1": false,
+			},
+		},
+		{
+			// Characters that are special in regular expressions are literals in classes
+			glob: `refs/heads/[\]^.]`,
+			matches: map[string]bool{
+				"refs/heads/]":  true,
+				"refs/heads/^":  true,
+				"refs/heads/.":  true,
+				"refs/heads/\\": false,
+				"refs/heads/a":  false,
+			},
+		},
+		{
+			glob: "refs/heads/[é]",
+			matches: map[string]bool{
+				"refs/heads/é": true,
+				"refs/heads/e": false,
+			},
+		},
+		{
+			glob: "refs/heads/release.1",
+			matches: map[string]bool{
+				"refs/heads/release.1": true,
+				"refs/heads/releasex1": false,
+			},
+		},
+		{
+			glob: `refs/heads/\*`,
+			matches: map[string]bool{
+				"refs/heads/*":    true,
+				"refs/heads/main": false,
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		re, err := compileGlob(test.glob)
+
+		if err != nil {
+			t.Fatalf("Failed to compile glob '%s', %v", test.glob, err)
+		}
+
+		for ref, expected := range test.matches {
+
+			if re.MatchString(ref) != expected {
+				t.Fatalf("Expected glob '%s' matching '%s' to be %t", test.glob, ref, expected)
+			}
+		}
+	}
+}
+
+func TestCompileGlobInvalid(t *testing.T) {
+
+	tests := []string{
+		"refs/heads/{main,master",
+		"refs/heads/main,master}",
+		"refs/heads/{main,{master}",
+		"refs/heads/[main",
+		"refs/heads/[!main",
+		"refs/heads/[]",
+		"refs/heads/[!]",
+		"refs/heads/[]a]",
+		"refs/heads/[z-a]",
+		"refs/heads/[a-]",
+		"refs/heads/[-a]",
+		"refs/heads/[a\\",
+		// POSIX classes are not supported
+		"refs/heads/[[:alpha:]]",
+		// Classes do not match "/"
+		"refs/heads/[/]",
+		"refs/heads/[+-0]",
+	}
+
+	for _, glob := range tests {
+
+		_, err := compileGlob(glob)
+
+		if err == nil {
+			t.Fatalf("Expected glob '%s' to be invalid", glob)
+		}
+	}
+}
+
+func TestNewRefPatternsRegexp(t *testing.T) {
+
+	tests := []struct {
+		pattern string
+		matches map[string]bool
+	}{
+		{
+			// regexp: patterns are not anchored
+			pattern: "regexp:-rc[0-9]+$",
+			matches: map[string]bool{
+				"refs/tags/v1.0.0-rc1": true,
+				"refs/tags/v1.0.0":     false,
+				"refs/tags/v1-rc1-fix": false,
+			},
+		},
+		{
+			pattern: "regexp:^refs/heads/(main|master)$",
+			matches: map[string]bool{
+				"refs/heads/main":        true,
+				"refs/heads/master":      true,
+				"refs/heads/maintenance": false,
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		refs, err := newRefPatterns([]string{test.pattern})
+
+		if err != nil {
+			t.Fatalf("Failed to create ref patterns for '%s', %v", test.pattern, err)
+		}
+
+		for ref, expected := range test.matches {
+
+			if refs.matches(ref) != expected {
+				t.Fatalf("Expected pattern '%s' matching '%s' to be %t", test.pattern, ref, expected)
+			}
+		}
+	}
+
+	_, err := newRefPatterns([]string{"regexp:refs/heads/(main"})
+
+	if err == nil {
+		t.Fatalf("Expected invalid regular expression to fail")
+	}
+}
+
+func TestCheckRef(t *testing.T) {
+
+	refs, err := newRefPatterns([]string{"refs/heads/{main,master}", "refs/tags/v*"})
+
+	if err != nil {
+		t.Fatalf("Failed to create ref patterns, %v", err)
+	}
+
+	exclude_refs, err := newRefPatterns([]string{"regexp:-rc[0-9]+$", "refs/heads/master"})
+
+	if err != nil {
+		t.Fatalf("Failed to create exclude ref patterns, %v", err)
+	}
+
+	tests := []struct {
+		refs         refPatterns
+		exclude_refs refPatterns
+		ref          string
+		ok           bool
+	}{
+		{refs, exclude_refs, "refs/heads/main", true},
+		{refs, exclude_refs, "refs/tags/v1.0.0", true},
+		{refs, exclude_refs, "refs/heads/develop", false},
+		// Exclusions take precedence over inclusions
+		{refs, exclude_refs, "refs/heads/master", false},
+		{refs, exclude_refs, "refs/tags/v1.0.0-rc1", false},
+		// No inclusions means every ref that is not excluded is allowed
+		{nil, exclude_refs, "refs/heads/develop", true},
+		{nil, exclude_refs, "refs/tags/v2.0.0-rc2", false},
+		{nil, nil, "refs/heads/anything", true},
+	}
+
+	for _, test := range tests {
+
+		ref_err := checkRef(test.refs, test.exclude_refs, test.ref)
+
+		if test.ok && ref_err != nil {
+			t.Fatalf("Expected '%s' to be allowed, %v", test.ref, ref_err)
+		}
+
+		if !test.ok {
+
+			if ref_err == nil {
+				t.Fatalf("Expected '%s' to be rejected", test.ref)
+			}
+
+			if ref_err.Code != 666 {
+				t.Fatalf("Unexpected error code for '%s', %d", test.ref, ref_err.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/aaronland/go-roster"
+)
+
+// exporters is a `aaronland/go-roster.Roster` instance used to maintain a list of registered `Exporter` initialization functions.
+var exporters roster.Roster
+
+// ExporterInitializationFunc is a function used to initialize an implementation of the `Exporter` interface.
+type ExporterInitializationFunc func(ctx context.Context, uri string) (Exporter, error)
+
+// type Exporter is an interface for recording spans after they have ended.
+type Exporter interface {
+	// Export() records a `Span` instance belonging to the service named by the second argument.
+	Export(context.Context, string, *Span) error
+	// Close() closes the exporter and any underlying resources.
+	Close() error
+}
+
+func init() {
+
+	ctx := context.Background()
+
+	err := RegisterExporter(ctx, "null", NewNullExporter)
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, scheme := range []string{"stdout", "stderr", "file"} {
+
+		err := RegisterExporter(ctx, scheme, NewOTLPFileExporter)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, scheme := range []string{"otlphttp", "otlphttps"} {
+
+		err := RegisterExporter(ctx, scheme, NewOTLPHTTPExporter)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// NewExporter() returns a new `Exporter` instance derived from 'uri'. The semantics of and requirements for
+// 'uri' as specific to the package implementing the interface.
+func NewExporter(ctx context.Context, uri string) (Exporter, error) {
+
+	err := ensureExporterRoster()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to ensure exporter roster, %w", err)
+	}
+
+	parsed, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	scheme := parsed.Scheme
+
+	i, err := exporters.Driver(ctx, scheme)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find initialization function for '%s', %w", scheme, err)
+	}
+
+	init_func := i.(ExporterInitializationFunc)
+	return init_func(ctx, uri)
+}
+
+// RegisterExporter() associates 'scheme' with 'init_func' in an internal list of avilable `Exporter` implementations.
+func RegisterExporter(ctx context.Context, scheme string, init_func ExporterInitializationFunc) error {
+
+	err := ensureExporterRoster()
+
+	if err != nil {
+		return fmt.Errorf("Failed to ensure exporter roster, %w", err)
+	}
+
+	return exporters.Register(ctx, scheme, init_func)
+}
+
+// ensureExporterRoster() ensures that a `aaronland/go-roster.Roster` instance used to maintain a list of registered `Exporter`
+// initialization functions is present
+func ensureExporterRoster() error {
+
+	if exporters == nil {
+
+		r, err := roster.NewDefaultRoster()
+
+		if err != nil {
+			return fmt.Errorf("Failed to create new roster, %w", err)
+		}
+
+		exporters = r
+	}
+
+	return nil
+}
+
+// Schemes() returns the list of schemes that have been "registered".
+func Schemes() []string {
+
+	ctx := context.Background()
+	drivers := exporters.Drivers(ctx)
+
+	schemes := make([]string, len(drivers))
+
+	for idx, dr := range drivers {
+		schemes[idx] = fmt.Sprintf("%s://", dr)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NullExporter implements the `Exporter` interface for discarding spans.
+type NullExporter struct {
+	Exporter
+}
+
+// NewNullExporter returns a new `NullExporter` instance configured by 'uri' in the form of:
+//
+//	null://
+func NewNullExporter(ctx context.Context, uri string) (Exporter, error) {
+	ex := &NullExporter{}
+	return ex, nil
+}
+
+// Export() discards 's'.
+func (ex *NullExporter) Export(ctx context.Context, service string, s *Span) error {
+	return nil
+}
+
+// Close() is a no-op.
+func (ex *NullExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// INSTRUMENTATION_SCOPE is the name of the instrumentation scope reported with exported spans.
+const INSTRUMENTATION_SCOPE string = "github.com/whosonfirst/go-whosonfirst-webhookd"
+
+// OTLPFileExporter implements the `Exporter` interface for writing spans as OTLP JSON-encoded `ExportTraceServiceRequest`
+// messages, one per line, to a file or to STDOUT or STDERR. This is the same format written by the OpenTelemetry Collector's
+// "file" exporter and read by its "otlpjsonfile" receiver.
+type OTLPFileExporter struct {
+	Exporter
+	// writer is the `io.Writer` instance that spans are written to.
+	writer io.Writer
+	// closer is the underlying file, if any, that 'writer' writes to.
+	closer io.Closer
+	mu     *sync.Mutex
+}
+
+// NewOTLPFileExporter returns a new `OTLPFileExporter` instance configured by 'uri' in the form of:
+//
+//	stdout://
+//	stderr://
+//	file://{PATH}
+//
+// Where {PATH} is the absolute path of a file that spans will be appended to. It will be created if it does not exist.
+func NewOTLPFileExporter(ctx context.Context, uri string) (Exporter, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	ex := &OTLPFileExporter{
+		mu: new(sync.Mutex),
+	}
+
+	switch u.Scheme {
+	case "stdout":
+		ex.writer = os.Stdout
+	case "stderr":
+		ex.writer = os.Stderr
+	case "file":
+
+		if u.Path == "" {
+			return nil, fmt.Errorf("Missing file path")
+		}
+
+		fh, err := os.OpenFile(u.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open %s, %w", u.Path, err)
+		}
+
+		ex.writer = fh
+		ex.closer = fh
+	default:
+		return nil, fmt.Errorf("Unsupported scheme '%s'", u.Scheme)
+	}
+
+	return ex, nil
+}
+
+// Export() writes 's' as an OTLP JSON-encoded `ExportTraceServiceRequest` message followed by a newline.
+func (ex *OTLPFileExporter) Export(ctx context.Context, service string, s *Span) error {
+
+	req := newOTLPRequest(service, s)
+
+	enc, err := json.Marshal(req)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal span, %w", err)
+	}
+
+	enc = append(enc, '\n')
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	_, err = ex.writer.Write(enc)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write span, %w", err)
+	}
+
+	return nil
+}
+
+// Close() closes the underlying file for 'ex', if any.
+func (ex *OTLPFileExporter) Close() error {
+
+	if ex.closer == nil {
+		return nil
+	}
+
+	return ex.closer.Close()
+}
+
+// The following types mirror the OTLP JSON encoding of the OpenTelemetry trace protocol. Note that 64-bit
+// integers are encoded as strings and that trace and span identifiers are encoded as hex strings.
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string     `json:"key"`
+	Value *otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPRequest(service string, s *Span) *otlpRequest {
+	return newOTLPRequestWithSpans(service, []*otlpSpan{newOTLPSpan(s)})
+}
+
+func newOTLPSpan(s *Span) *otlpSpan {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.attributes))
+
+	for k := range s.attributes {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	attrs := make([]*otlpKeyValue, len(keys))
+
+	for i, k := range keys {
+		attrs[i] = newOTLPKeyValue(k, s.attributes[k])
+	}
+
+	sp := &otlpSpan{
+		TraceID:           s.sc.TraceID.String(),
+		SpanID:            s.sc.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        attrs,
+		Status: &otlpStatus{
+			Code:    s.status,
+			Message: s.status_desc,
+		},
+	}
+
+	if s.parent.IsValid() {
+		sp.ParentSpanID = s.parent.String()
+	}
+
+	return sp
+}
+
+func newOTLPRequestWithSpans(service string, spans []*otlpSpan) *otlpRequest {
+
+	req := &otlpRequest{
+		ResourceSpans: []*otlpResourceSpans{
+			&otlpResourceSpans{
+				Resource: &otlpResource{
+					Attributes: []*otlpKeyValue{
+						newOTLPKeyValue("service.name", service),
+					},
+				},
+				ScopeSpans: []*otlpScopeSpans{
+					&otlpScopeSpans{
+						Scope: &otlpScope{
+							Name: INSTRUMENTATION_SCOPE,
+						},
+						Spans: spans,
+					},
+				},
+			},
+		},
+	}
+
+	return req
+}
+
+func newOTLPKeyValue(key string, value interface{}) *otlpKeyValue {
+
+	v := new(otlpValue)
+
+	switch t := value.(type) {
+	case string:
+		v.StringValue = &t
+	case bool:
+		v.BoolValue = &t
+	case int:
+		str := strconv.Itoa(t)
+		v.IntValue = &str
+	case int64:
+		str := strconv.FormatInt(t, 10)
+		v.IntValue = &str
+	case float64:
+		v.DoubleValue = &t
+	default:
+		str := fmt.Sprintf("%v", t)
+		v.StringValue = &str
+	}
+
+	kv := &otlpKeyValue{
+		Key:   key,
+		Value: v,
+	}
+
+	return kv
+}
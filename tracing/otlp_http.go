@@ -0,0 +1,317 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	aa_log "github.com/aaronland/go-log/v2"
+)
+
+// OTLP_HTTP_TRACES_PATH is the default path for the OTLP/HTTP traces endpoint.
+const OTLP_HTTP_TRACES_PATH string = "/v1/traces"
+
+// DEFAULT_OTLP_HTTP_BATCH_SIZE is the default number of spans after which buffered spans are sent immediately.
+const DEFAULT_OTLP_HTTP_BATCH_SIZE int = 512
+
+// DEFAULT_OTLP_HTTP_MAX_QUEUE_SIZE is the default maximum number of spans buffered before new spans are dropped.
+const DEFAULT_OTLP_HTTP_MAX_QUEUE_SIZE int = 2048
+
+// DEFAULT_OTLP_HTTP_INTERVAL is the default interval at which buffered spans are sent.
+const DEFAULT_OTLP_HTTP_INTERVAL time.Duration = 5 * time.Second
+
+// DEFAULT_OTLP_HTTP_TIMEOUT is the default timeout for each request sent to the OTLP/HTTP endpoint.
+const DEFAULT_OTLP_HTTP_TIMEOUT time.Duration = 10 * time.Second
+
+// OTLPHTTPExporter implements the `Exporter` interface for sending spans as OTLP JSON-encoded `ExportTraceServiceRequest`
+// messages to an OTLP/HTTP endpoint, like the OpenTelemetry Collector's "otlp" receiver. Spans are buffered and sent in
+// batches by a background goroutine so that ending a span never waits on the network.
+type OTLPHTTPExporter struct {
+	Exporter
+	// endpoint is the URL that batches of spans are POST-ed to.
+	endpoint string
+	// client is the `http.Client` instance used to send batches of spans.
+	client *http.Client
+	// batch_size is the number of buffered spans after which they are sent immediately.
+	batch_size int
+	// max_queue_size is the maximum number of spans buffered before new spans are dropped.
+	max_queue_size int
+	// pending is the list of buffered spans keyed by service name.
+	pending map[string][]*otlpSpan
+	// count is the total number of buffered spans.
+	count int
+	// flush is used to signal the background goroutine that a batch is ready to be sent.
+	flush chan bool
+	// done is closed to signal the background goroutine to send any remaining spans and exit.
+	done chan bool
+	// stopped is closed once the background goroutine has exited.
+	stopped chan bool
+	// logger is the `log.Logger` instance used to record errors sending spans.
+	logger    *log.Logger
+	closed    bool
+	close_err error
+	mu        *sync.Mutex
+	close_mu  *sync.Mutex
+}
+
+// NewOTLPHTTPExporter returns a new `OTLPHTTPExporter` instance configured by 'uri' in the form of:
+//
+//	otlphttp://{HOST}[:{PORT}][{PATH}]?{PARAMETERS}
+//	otlphttps://{HOST}[:{PORT}][{PATH}]?{PARAMETERS}
+//
+// Where `otlphttp` sends spans using plain HTTP and `otlphttps` sends spans using HTTPS. If {PATH} is empty then
+// `OTLP_HTTP_TRACES_PATH` is used. Valid parameters are:
+// * `batch_size` – The number of buffered spans after which they are sent immediately. Default is `DEFAULT_OTLP_HTTP_BATCH_SIZE`.
+// * `max_queue_size` – The maximum number of spans to buffer before new spans are dropped. Default is `DEFAULT_OTLP_HTTP_MAX_QUEUE_SIZE`.
+// * `interval` – A valid Go duration string for the interval at which buffered spans are sent. Default is `DEFAULT_OTLP_HTTP_INTERVAL`.
+// * `timeout` – A valid Go duration string for the timeout for each request. Default is `DEFAULT_OTLP_HTTP_TIMEOUT`.
+func NewOTLPHTTPExporter(ctx context.Context, uri string) (Exporter, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Missing host")
+	}
+
+	q := u.Query()
+
+	batch_size := DEFAULT_OTLP_HTTP_BATCH_SIZE
+	max_queue_size := DEFAULT_OTLP_HTTP_MAX_QUEUE_SIZE
+	interval := DEFAULT_OTLP_HTTP_INTERVAL
+	timeout := DEFAULT_OTLP_HTTP_TIMEOUT
+
+	if q.Has("batch_size") {
+
+		v, err := strconv.Atoi(q.Get("batch_size"))
+
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("Invalid ?batch_size= parameter")
+		}
+
+		batch_size = v
+	}
+
+	if q.Has("max_queue_size") {
+
+		v, err := strconv.Atoi(q.Get("max_queue_size"))
+
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("Invalid ?max_queue_size= parameter")
+		}
+
+		max_queue_size = v
+	}
+
+	if q.Has("interval") {
+
+		v, err := time.ParseDuration(q.Get("interval"))
+
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("Invalid ?interval= parameter")
+		}
+
+		interval = v
+	}
+
+	if q.Has("timeout") {
+
+		v, err := time.ParseDuration(q.Get("timeout"))
+
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("Invalid ?timeout= parameter")
+		}
+
+		timeout = v
+	}
+
+	endpoint := url.URL{
+		Host: u.Host,
+		Path: u.Path,
+	}
+
+	switch u.Scheme {
+	case "otlphttp":
+		endpoint.Scheme = "http"
+	case "otlphttps":
+		endpoint.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("Unsupported scheme '%s'", u.Scheme)
+	}
+
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = OTLP_HTTP_TRACES_PATH
+	}
+
+	ex := &OTLPHTTPExporter{
+		endpoint: endpoint.String(),
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		batch_size:     batch_size,
+		max_queue_size: max_queue_size,
+		pending:        make(map[string][]*otlpSpan),
+		flush:          make(chan bool, 1),
+		done:           make(chan bool),
+		stopped:        make(chan bool),
+		logger:         log.Default(),
+		mu:             new(sync.Mutex),
+		close_mu:       new(sync.Mutex),
+	}
+
+	go ex.run(interval)
+
+	return ex, nil
+}
+
+// Export() adds 's' to the list of spans waiting to be sent. If there are already `max_queue_size` spans waiting
+// to be sent then 's' is dropped and an error is returned.
+func (ex *OTLPHTTPExporter) Export(ctx context.Context, service string, s *Span) error {
+
+	sp := newOTLPSpan(s)
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	if ex.closed {
+		return fmt.Errorf("Exporter has been closed")
+	}
+
+	if ex.count >= ex.max_queue_size {
+		return fmt.Errorf("Span queue is full (%d spans)", ex.count)
+	}
+
+	ex.pending[service] = append(ex.pending[service], sp)
+	ex.count += 1
+
+	if ex.count >= ex.batch_size {
+
+		select {
+		case ex.flush <- true:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Close() stops accepting new spans, sends any spans that are still waiting to be sent and returns the error, if
+// any, from doing so.
+func (ex *OTLPHTTPExporter) Close() error {
+
+	ex.close_mu.Lock()
+	defer ex.close_mu.Unlock()
+
+	ex.mu.Lock()
+	closed := ex.closed
+	ex.closed = true
+	ex.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	close(ex.done)
+	<-ex.stopped
+
+	return ex.close_err
+}
+
+// run() sends buffered spans every 'interval', or when signaled that a batch is ready, until 'ex' is closed.
+func (ex *OTLPHTTPExporter) run(interval time.Duration) {
+
+	defer close(ex.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ex.done:
+			ex.close_err = ex.send(context.Background())
+			return
+		case <-ticker.C:
+		case <-ex.flush:
+		}
+
+		err := ex.send(context.Background())
+
+		if err != nil {
+			aa_log.Warning(ex.logger, "Failed to send spans to %s, %v", ex.endpoint, err)
+		}
+	}
+}
+
+// send() POSTs all the buffered spans, grouped by service, to the OTLP/HTTP endpoint. Spans that fail to be sent are discarded.
+func (ex *OTLPHTTPExporter) send(ctx context.Context) error {
+
+	ex.mu.Lock()
+	pending := ex.pending
+	ex.pending = make(map[string][]*otlpSpan)
+	ex.count = 0
+	ex.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	services := make([]string, 0, len(pending))
+
+	for service := range pending {
+		services = append(services, service)
+	}
+
+	sort.Strings(services)
+
+	req := &otlpRequest{
+		ResourceSpans: make([]*otlpResourceSpans, 0, len(services)),
+	}
+
+	for _, service := range services {
+		service_req := newOTLPRequestWithSpans(service, pending[service])
+		req.ResourceSpans = append(req.ResourceSpans, service_req.ResourceSpans...)
+	}
+
+	enc, err := json.Marshal(req)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal spans, %w", err)
+	}
+
+	http_req, err := http.NewRequestWithContext(ctx, http.MethodPost, ex.endpoint, bytes.NewReader(enc))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create new request, %w", err)
+	}
+
+	http_req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := ex.client.Do(http_req)
+
+	if err != nil {
+		return fmt.Errorf("Failed to send spans, %w", err)
+	}
+
+	defer rsp.Body.Close()
+
+	// Drain the body so the underlying connection can be reused
+	io.Copy(io.Discard, io.LimitReader(rsp.Body, 64*1024))
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("Failed to send spans, endpoint returned %s", rsp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type otlpCollector struct {
+	mu       *sync.Mutex
+	requests []*otlpRequest
+}
+
+func (c *otlpCollector) spans() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				count += len(ss.Spans)
+			}
+		}
+	}
+
+	return count
+}
+
+func newOTLPCollector(t *testing.T) (*otlpCollector, *httptest.Server) {
+
+	c := &otlpCollector{
+		mu: new(sync.Mutex),
+	}
+
+	handler := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodPost || req.URL.Path != OTLP_HTTP_TRACES_PATH {
+			http.Error(rsp, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if req.Header.Get("Content-Type") != "application/json" {
+			http.Error(rsp, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var otlp_req *otlpRequest
+
+		err := json.NewDecoder(req.Body).Decode(&otlp_req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		c.requests = append(c.requests, otlp_req)
+		c.mu.Unlock()
+
+		rsp.Header().Set("Content-Type", "application/json")
+		rsp.Write([]byte("{}"))
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(s.Close)
+
+	return c, s
+}
+
+func TestOTLPHTTPExporter(t *testing.T) {
+
+	ctx := context.Background()
+
+	c, s := newOTLPCollector(t)
+
+	uri := fmt.Sprintf("%s?interval=1h", strings.Replace(s.URL, "http://", "otlphttp://", 1))
+
+	tr, err := NewTracer(ctx, uri, "test")
+
+	if err != nil {
+		t.Fatalf("Failed to create tracer, %v", err)
+	}
+
+	ctx, parent := tr.Start(ctx, "parent", SPAN_KIND_SERVER)
+	_, child := tr.Start(ctx, "child", SPAN_KIND_INTERNAL)
+
+	child.SetAttribute("webhookd.endpoint", "test")
+	child.SetError("Failed")
+	child.End()
+
+	parent.SetOK()
+	parent.End()
+
+	// Spans are buffered until the interval elapses or the exporter is closed
+
+	if c.spans() != 0 {
+		t.Fatalf("Expected spans to be buffered, got %d", c.spans())
+	}
+
+	err = tr.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close tracer, %v", err)
+	}
+
+	if len(c.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(c.requests))
+	}
+
+	req := c.requests[0]
+
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("Expected 1 resource, got %d", len(req.ResourceSpans))
+	}
+
+	service := req.ResourceSpans[0].Resource.Attributes[0]
+
+	if service.Key != "service.name" || *service.Value.StringValue != "test" {
+		t.Fatalf("Unexpected service attribute %s", service.Key)
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	if spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Fatalf("Unexpected span order, %s, %s", spans[0].Name, spans[1].Name)
+	}
+
+	if spans[0].ParentSpanID != spans[1].SpanID || spans[0].TraceID != spans[1].TraceID {
+		t.Fatalf("Expected child span to belong to parent span")
+	}
+
+	if spans[0].Status.Code != STATUS_ERROR || spans[0].Status.Message != "Failed" {
+		t.Fatalf("Unexpected child status, %d", spans[0].Status.Code)
+	}
+
+	// Spans ended after the exporter is closed are not sent
+
+	_, late := tr.Start(context.Background(), "late", SPAN_KIND_INTERNAL)
+	late.End()
+
+	err = tr.Close()
+
+	if err != nil {
+		t.Fatalf("Expected closing twice to succeed, %v", err)
+	}
+
+	if c.spans() != 2 {
+		t.Fatalf("Expected 2 spans, got %d", c.spans())
+	}
+}
+
+func TestOTLPHTTPExporterBatchSize(t *testing.T) {
+
+	ctx := context.Background()
+
+	c, s := newOTLPCollector(t)
+
+	uri := fmt.Sprintf("%s?interval=1h&batch_size=2", strings.Replace(s.URL, "http://", "otlphttp://", 1))
+
+	tr, err := NewTracer(ctx, uri, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create tracer, %v", err)
+	}
+
+	defer tr.Close()
+
+	for i := 0; i < 2; i++ {
+		_, span := tr.Start(ctx, "test", SPAN_KIND_INTERNAL)
+		span.End()
+	}
+
+	timeout := time.Now().Add(5 * time.Second)
+
+	for c.spans() != 2 {
+
+		if time.Now().After(timeout) {
+			t.Fatalf("Timed out waiting for batch to be sent")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOTLPHTTPExporterMaxQueueSize(t *testing.T) {
+
+	ctx := context.Background()
+
+	ex, err := NewOTLPHTTPExporter(ctx, "otlphttp://localhost:4318?interval=1h&max_queue_size=1&timeout=100ms")
+
+	if err != nil {
+		t.Fatalf("Failed to create exporter, %v", err)
+	}
+
+	// Closing the exporter will try to send the queued span to a collector that is not running
+
+	defer ex.Close()
+
+	tr := &Tracer{
+		exporter: ex,
+		service:  DEFAULT_SERVICE_NAME,
+	}
+
+	_, first := tr.Start(ctx, "first", SPAN_KIND_INTERNAL)
+	_, second := tr.Start(ctx, "second", SPAN_KIND_INTERNAL)
+
+	err = ex.Export(ctx, DEFAULT_SERVICE_NAME, first)
+
+	if err != nil {
+		t.Fatalf("Failed to export first span, %v", err)
+	}
+
+	err = ex.Export(ctx, DEFAULT_SERVICE_NAME, second)
+
+	if err == nil {
+		t.Fatalf("Expected second span to be dropped")
+	}
+}
+
+func TestNewOTLPHTTPExporter(t *testing.T) {
+
+	ctx := context.Background()
+
+	valid := map[string]string{
+		"otlphttp://localhost:4318":                          "http://localhost:4318/v1/traces",
+		"otlphttp://localhost:4318/":                         "http://localhost:4318/v1/traces",
+		"otlphttps://collector.example.com/custom":           "https://collector.example.com/custom",
+		"otlphttp://localhost:4318?timeout=1s&batch_size=10": "http://localhost:4318/v1/traces",
+	}
+
+	for uri, endpoint := range valid {
+
+		ex, err := NewExporter(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create exporter for '%s', %v", uri, err)
+		}
+
+		if ex.(*OTLPHTTPExporter).endpoint != endpoint {
+			t.Fatalf("Expected endpoint '%s' for '%s', got '%s'", endpoint, uri, ex.(*OTLPHTTPExporter).endpoint)
+		}
+
+		ex.Close()
+	}
+
+	invalid := []string{
+		"otlphttp://",
+		"otlphttp://localhost:4318?batch_size=0",
+		"otlphttp://localhost:4318?max_queue_size=-1",
+		"otlphttp://localhost:4318?interval=soon",
+		"otlphttp://localhost:4318?timeout=0s",
+	}
+
+	for _, uri := range invalid {
+
+		_, err := NewExporter(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to be an invalid exporter URI", uri)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	aa_log "github.com/aaronland/go-log/v2"
+)
+
+// DEFAULT_SERVICE_NAME is the default name of the service reported with exported spans.
+const DEFAULT_SERVICE_NAME string = "webhookd"
+
+// SPAN_KIND_INTERNAL is the kind for spans that represent an internal operation.
+const SPAN_KIND_INTERNAL int = 1
+
+// SPAN_KIND_SERVER is the kind for spans that represent the handling of an inbound request.
+const SPAN_KIND_SERVER int = 2
+
+// SPAN_KIND_CLIENT is the kind for spans that represent an outbound request.
+const SPAN_KIND_CLIENT int = 3
+
+// STATUS_UNSET is the default status code for spans.
+const STATUS_UNSET int = 0
+
+// STATUS_OK is the status code for spans that completed successfully.
+const STATUS_OK int = 1
+
+// STATUS_ERROR is the status code for spans that failed.
+const STATUS_ERROR int = 2
+
+// type Tracer is a struct for starting spans and exporting them once they have ended.
+type Tracer struct {
+	// exporter is the `Exporter` instance that ended spans are handed off to.
+	exporter Exporter
+	// service is the name of the service reported with exported spans.
+	service string
+	// logger is the `log.Logger` instance used to record export errors.
+	logger *log.Logger
+}
+
+// NewTracer() returns a new `Tracer` instance that exports spans to the `Exporter` defined by 'uri' and reports them
+// as belonging to 'service'. If 'service' is empty then `DEFAULT_SERVICE_NAME` is used.
+func NewTracer(ctx context.Context, uri string, service string) (*Tracer, error) {
+
+	ex, err := NewExporter(ctx, uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new exporter, %w", err)
+	}
+
+	if service == "" {
+		service = DEFAULT_SERVICE_NAME
+	}
+
+	t := &Tracer{
+		exporter: ex,
+		service:  service,
+		logger:   log.Default(),
+	}
+
+	return t, nil
+}
+
+// Start() returns a new `Span` instance named 'name' and a copy of 'ctx' associated with that span. The span's
+// parent is the span associated with 'ctx', if present, or the remote `SpanContext` associated with 'ctx'. Otherwise
+// a new trace is started.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{
+		SpanID: newSpanID(),
+		Flags:  FLAG_SAMPLED,
+	}
+
+	var parent_id SpanID
+
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Flags = parent.Flags
+		parent_id = parent.SpanID
+	} else {
+		sc.TraceID = newTraceID()
+	}
+
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		sc:         sc,
+		parent:     parent_id,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+		mu:         new(sync.Mutex),
+	}
+
+	return ContextWithSpan(ctx, s), s
+}
+
+// Close() closes the underlying exporter for 't'.
+func (t *Tracer) Close() error {
+	return t.exporter.Close()
+}
+
+// type Span is a struct representing a single, timed, operation within a trace.
+type Span struct {
+	tracer      *Tracer
+	name        string
+	kind        int
+	sc          SpanContext
+	parent      SpanID
+	start       time.Time
+	end         time.Time
+	attributes  map[string]interface{}
+	status      int
+	status_desc string
+	ended       bool
+	mu          *sync.Mutex
+}
+
+// SpanContext() returns the `SpanContext` for 's'.
+func (s *Span) SpanContext() SpanContext {
+	return s.sc
+}
+
+// SetAttribute() associates 'key' with 'value' for 's'. 'value' is expected to be a string, bool, int, int64 or float64.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// SetError() assigns an error status, and 'message', to 's'.
+func (s *Span) SetError(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = STATUS_ERROR
+	s.status_desc = message
+}
+
+// Status() returns the status code (`STATUS_UNSET`, `STATUS_OK` or `STATUS_ERROR`) currently assigned to 's'.
+func (s *Span) Status() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// SetOK() assigns an OK status to 's'.
+func (s *Span) SetOK() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = STATUS_OK
+	s.status_desc = ""
+}
+
+// End() records the end time for 's' and hands it off to the exporter for the tracer that created it. Calling
+// End() more than once has no effect.
+func (s *Span) End() {
+
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	t := s.tracer
+
+	err := t.exporter.Export(context.Background(), t.service, s)
+
+	if err != nil {
+		aa_log.Warning(t.logger, "Failed to export span %s (%s), %v", s.name, s.sc.SpanID, err)
+	}
+}
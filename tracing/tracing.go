@@ -0,0 +1,202 @@
+// Package tracing provides a minimal, dependency-free implementation of distributed tracing for webhookd compatible with
+// the W3C Trace Context specification and the OpenTelemetry (OTLP) JSON encoding for spans.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// TRACEPARENT_HEADER is the name of the W3C Trace Context header used to propagate trace context.
+const TRACEPARENT_HEADER string = "traceparent"
+
+// FLAG_SAMPLED is the W3C Trace Context flag signaling that a trace is sampled.
+const FLAG_SAMPLED byte = 0x01
+
+type contextKey string
+
+// SPAN_CONTEXT_KEY is the context key used to store the current `Span` instance.
+const SPAN_CONTEXT_KEY contextKey = "webhookd.tracing.span"
+
+// REMOTE_CONTEXT_KEY is the context key used to store a `SpanContext` instance propagated from a remote caller.
+const REMOTE_CONTEXT_KEY contextKey = "webhookd.tracing.remote"
+
+// type TraceID is a 16-byte unique identifier for a trace.
+type TraceID [16]byte
+
+// String() returns the lower-case hex encoding of 't'.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid() returns a boolean value indicating whether 't' is not all zeroes.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// type SpanID is an 8-byte unique identifier for a span.
+type SpanID [8]byte
+
+// String() returns the lower-case hex encoding of 's'.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid() returns a boolean value indicating whether 's' is not all zeroes.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// type SpanContext is a struct containing the identifiers for a span that are propagated across process boundaries.
+type SpanContext struct {
+	// TraceID is the unique identifier of the trace the span belongs to.
+	TraceID TraceID
+	// SpanID is the unique identifier of the span.
+	SpanID SpanID
+	// Flags are the W3C Trace Context flags for the span.
+	Flags byte
+}
+
+// IsValid() returns a boolean value indicating whether both the trace and span identifiers for 'sc' are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent() returns the W3C Trace Context "traceparent" header value for 'sc'.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent() returns a new `SpanContext` derived from 'str' which is expected to be a valid W3C Trace Context "traceparent" header value.
+func ParseTraceparent(str string) (SpanContext, error) {
+
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(str), "-")
+
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("Invalid traceparent, expected at least 4 parts")
+	}
+
+	version := parts[0]
+
+	var v [1]byte
+
+	if decodeHex(version, v[:]) != nil || version == "ff" {
+		return sc, fmt.Errorf("Invalid traceparent version")
+	}
+
+	// Version 00 is the only version with exactly four parts, future versions may append more
+
+	if version == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("Invalid traceparent, expected 4 parts for version 00")
+	}
+
+	err := decodeHex(parts[1], sc.TraceID[:])
+
+	if err != nil {
+		return sc, fmt.Errorf("Invalid traceparent trace ID, %w", err)
+	}
+
+	err = decodeHex(parts[2], sc.SpanID[:])
+
+	if err != nil {
+		return sc, fmt.Errorf("Invalid traceparent span ID, %w", err)
+	}
+
+	var flags [1]byte
+
+	err = decodeHex(parts[3], flags[:])
+
+	if err != nil {
+		return sc, fmt.Errorf("Invalid traceparent flags, %w", err)
+	}
+
+	sc.Flags = flags[0]
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("Invalid traceparent, trace and span IDs must not be zero")
+	}
+
+	return sc, nil
+}
+
+// ContextWithRemoteSpanContext() returns a copy of 'ctx' associated with 'sc' which will be used as the parent of
+// the next span started from that context.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, REMOTE_CONTEXT_KEY, sc)
+}
+
+// ContextWithSpan() returns a copy of 'ctx' associated with 's'.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, SPAN_CONTEXT_KEY, s)
+}
+
+// SpanFromContext() returns the `Span` instance associated with 'ctx' or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+
+	v := ctx.Value(SPAN_CONTEXT_KEY)
+
+	if v == nil {
+		return nil
+	}
+
+	return v.(*Span)
+}
+
+// SpanContextFromContext() returns the `SpanContext` of the span associated with 'ctx' or, if there is no span, the remote
+// `SpanContext` associated with 'ctx'. If neither is present an empty (invalid) `SpanContext` is returned.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+
+	s := SpanFromContext(ctx)
+
+	if s != nil {
+		return s.SpanContext()
+	}
+
+	v := ctx.Value(REMOTE_CONTEXT_KEY)
+
+	if v == nil {
+		return SpanContext{}
+	}
+
+	return v.(SpanContext)
+}
+
+// Traceparent() returns the W3C Trace Context "traceparent" header value for the span associated with 'ctx' or an
+// empty string if there is none.
+func Traceparent(ctx context.Context) string {
+
+	sc := SpanContextFromContext(ctx)
+
+	if !sc.IsValid() {
+		return ""
+	}
+
+	return sc.Traceparent()
+}
+
+func decodeHex(str string, b []byte) error {
+
+	if len(str) != len(b)*2 || strings.ToLower(str) != str {
+		return fmt.Errorf("Expected %d lower-case hex characters", len(b)*2)
+	}
+
+	_, err := hex.Decode(b, []byte(str))
+	return err
+}
+
+func newTraceID() TraceID {
+	var t TraceID
+	rand.Read(t[:])
+	return t
+}
+
+func newSpanID() SpanID {
+	var s SpanID
+	rand.Read(s[:])
+	return s
+}
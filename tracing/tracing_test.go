@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+
+	// https://www.w3.org/TR/trace-context/#traceparent-header
+
+	valid := []struct {
+		value    string
+		trace_id string
+		span_id  string
+		flags    byte
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x01},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x00},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x01},
+		// Unknown flags are preserved
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x09},
+		// Future versions may append additional fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x01},
+		{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-holds", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 0x01},
+	}
+
+	for _, tc := range valid {
+
+		sc, err := ParseTraceparent(tc.value)
+
+		if err != nil {
+			t.Fatalf("Failed to parse '%s', %v", tc.value, err)
+		}
+
+		if sc.TraceID.String() != tc.trace_id {
+			t.Fatalf("Expected trace ID '%s' for '%s', got '%s'", tc.trace_id, tc.value, sc.TraceID)
+		}
+
+		if sc.SpanID.String() != tc.span_id {
+			t.Fatalf("Expected span ID '%s' for '%s', got '%s'", tc.span_id, tc.value, sc.SpanID)
+		}
+
+		if sc.Flags != tc.flags {
+			t.Fatalf("Expected flags %02x for '%s', got %02x", tc.flags, tc.value, sc.Flags)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"00",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		// Version 00 must have exactly four fields
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		// Version ff is invalid
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		// Version must be two lower-case hex characters
+		"0-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"000-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"0A-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		// Identifiers must be lower-case hex of the correct length
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		// All-zero identifiers are invalid
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	}
+
+	for _, str := range invalid {
+
+		_, err := ParseTraceparent(str)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to be an invalid traceparent", str)
+		}
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+
+	str := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceparent(str)
+
+	if err != nil {
+		t.Fatalf("Failed to parse traceparent, %v", err)
+	}
+
+	if sc.Traceparent() != str {
+		t.Fatalf("Expected '%s', got '%s'", str, sc.Traceparent())
+	}
+
+	// Spans started from a remote context continue the same trace with a new span ID
+
+	tr, err := NewTracer(context.Background(), "null://", "")
+
+	if err != nil {
+		t.Fatalf("Failed to create tracer, %v", err)
+	}
+
+	ctx := ContextWithRemoteSpanContext(context.Background(), sc)
+	ctx, span := tr.Start(ctx, "test", SPAN_KIND_SERVER)
+
+	child := span.SpanContext()
+
+	if child.TraceID != sc.TraceID {
+		t.Fatalf("Expected trace ID %s, got %s", sc.TraceID, child.TraceID)
+	}
+
+	if child.SpanID == sc.SpanID || !child.SpanID.IsValid() {
+		t.Fatalf("Expected new valid span ID, got %s", child.SpanID)
+	}
+
+	if child.Flags != sc.Flags {
+		t.Fatalf("Expected flags %02x, got %02x", sc.Flags, child.Flags)
+	}
+
+	if Traceparent(ctx) != child.Traceparent() {
+		t.Fatalf("Expected context traceparent '%s', got '%s'", child.Traceparent(), Traceparent(ctx))
+	}
+
+	if span.parent != sc.SpanID {
+		t.Fatalf("Expected parent span ID %s, got %s", sc.SpanID, span.parent)
+	}
+
+	if Traceparent(context.Background()) != "" {
+		t.Fatalf("Expected empty traceparent for context without a span")
+	}
+}
@@ -0,0 +1,141 @@
+package transformation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/bitbucket"
+)
+
+func init() {
+
+	ctx := context.Background()
+	err := registerTransformation(ctx, "bitbucketpaths", NewBitbucketPathsTransformation)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// BitbucketPathsTransformation implements the `webhookd.WebhookTransformation` interface for assigning the files changed
+// by each commit in the GitHub push events produced by the `bitbucket://` receiver, which are fetched from the Bitbucket
+// Cloud or Bitbucket Server APIs. The output can be transformed using the `githubrepo://` and `githubcommits://` transformations.
+type BitbucketPathsTransformation struct {
+	webhookd.WebhookTransformation
+	// api is the client used to fetch the paths of the files changed by push events.
+	api *bitbucket.API
+}
+
+// NewBitbucketPathsTransformation() creates a new `BitbucketPathsTransformation` instance, configured by 'uri'
+// which is expected to take the form of:
+//
+//	bitbucketpaths://?{PARAMETERS}
+//
+// Where {PARAMETERS} are:
+// * `?api_uri=` The root URI of the Bitbucket Cloud API. Default is "https://api.bitbucket.org/2.0".
+// * `?server_uri=` The root URI of the Bitbucket Server instance, required to fetch changed paths for Bitbucket Server push events.
+// * `?api_user=` The username used to authenticate Bitbucket API requests with an app password.
+// * `?api_token=` The access token (or app password, if `?api_user=` is present) used to authenticate Bitbucket API requests.
+// * `?timeout=` A `time.Duration` string for the maximum amount of time to wait for each Bitbucket API request. Default is "30s".
+// * `?max_attempts=` The maximum number of times each Bitbucket API request is attempted. Default is 3.
+// * `?backoff=` A `time.Duration` string for the amount of time to wait before retrying a failed Bitbucket API request. Default is "1s".
+func NewBitbucketPathsTransformation(ctx context.Context, uri string) (webhookd.WebhookTransformation, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	opts := &bitbucket.APIOptions{
+		APIURI:    q.Get("api_uri"),
+		ServerURI: q.Get("server_uri"),
+		User:      q.Get("api_user"),
+		Token:     q.Get("api_token"),
+	}
+
+	durations := map[string]*time.Duration{
+		"timeout": &opts.Timeout,
+		"backoff": &opts.Backoff,
+	}
+
+	for k, ptr := range durations {
+
+		if !q.Has(k) {
+			continue
+		}
+
+		d, err := time.ParseDuration(q.Get(k))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?%s= parameter, %w", k, err)
+		}
+
+		*ptr = d
+	}
+
+	if q.Has("max_attempts") {
+
+		v, err := strconv.Atoi(q.Get("max_attempts"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?max_attempts= parameter, %w", err)
+		}
+
+		if v < 1 {
+			return nil, fmt.Errorf("Invalid ?max_attempts= parameter, must be greater than zero")
+		}
+
+		opts.MaxAttempts = v
+	}
+
+	p := &BitbucketPathsTransformation{
+		api: bitbucket.NewAPI(opts),
+	}
+
+	return p, nil
+}
+
+// Transform() transforms 'body' (which is assumed to be a GitHub push event produced by the `bitbucket://` receiver) in to
+// the same push event with the files changed by each of its commits assigned. API requests which fail are retried, with an
+// exponential backoff, until they succeed, the maximum number of attempts is reached or 'ctx' is cancelled in which case an
+// error with a `502 Bad Gateway` code is returned.
+func (p *BitbucketPathsTransformation) Transform(ctx context.Context, body []byte) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	push, err := bitbucket.ParseGitHubPushEvent(body)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	err = p.api.FetchChanges(ctx, push)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: http.StatusBadGateway, Message: err.Error()}
+		return nil, err
+	}
+
+	enc_body, err := push.GitHubPushEvent()
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	return enc_body, nil
+}
@@ -0,0 +1,157 @@
+package transformation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	_ "github.com/whosonfirst/go-webhookd-github"
+	wh_transformation "github.com/whosonfirst/go-webhookd/v3/transformation"
+	"github.com/whosonfirst/go-whosonfirst-webhookd/bitbucket"
+)
+
+// newBitbucketPushEvent() returns the GitHub push event, produced by the `bitbucket://` receiver, for fixtures/events/bitbucket-cloud-push.json.
+func newBitbucketPushEvent(t *testing.T) []byte {
+
+	body, err := os.ReadFile("../fixtures/events/bitbucket-cloud-push.json")
+
+	if err != nil {
+		t.Fatalf("Failed to read Bitbucket push event, %v", err)
+	}
+
+	push, err := bitbucket.ParseCloudPush(body)
+
+	if err != nil {
+		t.Fatalf("Failed to parse Bitbucket push event, %v", err)
+	}
+
+	enc, err := push.GitHubPushEvent()
+
+	if err != nil {
+		t.Fatalf("Failed to encode GitHub push event, %v", err)
+	}
+
+	return enc
+}
+
+func TestBitbucketPathsTransformation(t *testing.T) {
+
+	ctx := context.Background()
+
+	var requests int32
+
+	// The first request fails in order to ensure that failed requests are retried
+
+	server := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(rsp, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		if !strings.HasPrefix(req.URL.Path, "/repositories/whosonfirst-data/whosonfirst-data-admin-xy/diffstat/") {
+			http.Error(rsp, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		spec := strings.Replace(path.Base(req.URL.Path), ".", "-", -1)
+
+		values := []map[string]interface{}{
+			{"status": "modified", "new": map[string]string{"path": "data/" + spec + "-modified.geojson"}},
+			{"status": "added", "new": map[string]string{"path": "data/" + spec + "-added.geojson"}},
+		}
+
+		rsp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rsp).Encode(map[string]interface{}{"values": values})
+	}))
+
+	defer server.Close()
+
+	uri := fmt.Sprintf("bitbucketpaths://?api_uri=%s&backoff=10ms", url.QueryEscape(server.URL))
+
+	tr, err := wh_transformation.NewTransformation(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create transformation, %v", err)
+	}
+
+	out, wh_err := tr.Transform(ctx, newBitbucketPushEvent(t))
+
+	if wh_err != nil {
+		t.Fatalf("Failed to transform push event, %v", wh_err)
+	}
+
+	commits_tr, err := wh_transformation.NewTransformation(ctx, "githubcommits://")
+
+	if err != nil {
+		t.Fatalf("Failed to create githubcommits transformation, %v", err)
+	}
+
+	commits, wh_err := commits_tr.Transform(ctx, out)
+
+	if wh_err != nil {
+		t.Fatalf("Failed to transform push event with githubcommits, %v", wh_err)
+	}
+
+	for _, expected := range []string{
+		"data/9f1e2d3c4b5a69788796a5b4c3d2e1f0a9b8c7d6--1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d-modified.geojson",
+		"data/abcdefabcdefabcdefabcdefabcdefabcdefabcd-added.geojson",
+	} {
+
+		if !strings.Contains(string(commits), expected) {
+			t.Fatalf("Expected output to contain '%s', got '%s'", expected, commits)
+		}
+	}
+}
+
+func TestBitbucketPathsTransformationFailure(t *testing.T) {
+
+	ctx := context.Background()
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(rsp, "Service Unavailable", http.StatusServiceUnavailable)
+	}))
+
+	defer server.Close()
+
+	uri := fmt.Sprintf("bitbucketpaths://?api_uri=%s&backoff=10ms&max_attempts=2", url.QueryEscape(server.URL))
+
+	tr, err := wh_transformation.NewTransformation(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create transformation, %v", err)
+	}
+
+	_, wh_err := tr.Transform(ctx, newBitbucketPushEvent(t))
+
+	if wh_err == nil || wh_err.Code != http.StatusBadGateway {
+		t.Fatalf("Expected transformation to fail with code %d, %v", http.StatusBadGateway, wh_err)
+	}
+
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", requests)
+	}
+
+	_, wh_err = tr.Transform(ctx, []byte(`{"ref":"refs/heads/main","commits":[]}`))
+
+	if wh_err == nil || wh_err.Code != 999 {
+		t.Fatalf("Expected push event without bitbucket property to fail, %v", wh_err)
+	}
+
+	_, wh_err = tr.Transform(ctx, []byte(`{`))
+
+	if wh_err == nil || wh_err.Code != 999 {
+		t.Fatalf("Expected invalid push event to fail, %v", wh_err)
+	}
+}
@@ -0,0 +1,9 @@
+// Package transformation provides Who's On First specific implementations of the `whosonfirst/go-webhookd/v3.WebhookTransformation` interface.
+//
+// The `gitlabrepo://` and `gitlabcommits://` transformations in this package produce the same output as the `githubrepo://` and
+// `githubcommits://` transformations in the `whosonfirst/go-webhookd-github` package, for GitLab push event messages, so that the
+// same downstream tools can be used for repositories hosted by either service.
+//
+// The `bitbucketpaths://` transformation assigns the files changed by each commit, fetched from the Bitbucket APIs, in the GitHub
+// push events produced by the `bitbucket://` receiver in the `receiver` package.
+package transformation
@@ -0,0 +1,99 @@
+package transformation
+
+// https://docs.gitlab.com/user/project/integrations/webhook_events/#push-events
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+)
+
+// type gitLabPushEvent is a struct containing the properties of a GitLab push event (or system hook push event) message
+// used to derive a `pushEvent` instance.
+type gitLabPushEvent struct {
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"`
+	Project     struct {
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	Repository struct {
+		Name string `json:"name"`
+	} `json:"repository"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+}
+
+// parseGitLabPushEvent() returns a new `pushEvent` instance derived from the GitLab push event in 'body'. The name of the
+// repository is the last component of the `project.path_with_namespace` property, which is equivalent to the `repository.name`
+// property of GitHub push events. The head commit is the commit whose ID matches the `checkout_sha` (or `after`) property,
+// or the last commit if there is no match.
+//
+// Note that GitLab limits the number of commits included in a push event to 20 (the `total_commits_count` property contains
+// the actual number of commits) so, unlike GitHub, the output of the push transformations may not include every file changed
+// by a large push.
+func parseGitLabPushEvent(body []byte) (*pushEvent, error) {
+
+	var gl_event gitLabPushEvent
+
+	err := json.Unmarshal(body, &gl_event)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal event, %w", err)
+	}
+
+	repo := gl_event.Repository.Name
+
+	switch {
+	case gl_event.Project.PathWithNamespace != "":
+		repo = path.Base(gl_event.Project.PathWithNamespace)
+	case gl_event.Project.Name != "":
+		repo = gl_event.Project.Name
+	}
+
+	head_sha := gl_event.CheckoutSHA
+
+	if head_sha == "" {
+		head_sha = gl_event.After
+	}
+
+	event := &pushEvent{
+		Repo:       repo,
+		HeadCommit: head_sha,
+		Commits:    make([]pushCommit, len(gl_event.Commits)),
+	}
+
+	head_idx := len(gl_event.Commits) - 1
+
+	for idx, c := range gl_event.Commits {
+
+		event.Commits[idx] = pushCommit{
+			Added:    c.Added,
+			Modified: c.Modified,
+			Removed:  c.Removed,
+		}
+
+		if c.ID == head_sha {
+			head_idx = idx
+		}
+	}
+
+	if head_idx >= 0 {
+
+		head := gl_event.Commits[head_idx]
+
+		event.HeadCommit = head.ID
+		event.HeadMessage = head.Message
+		event.HeadAuthor = head.Author.Name
+	}
+
+	return event, nil
+}
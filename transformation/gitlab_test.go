@@ -0,0 +1,114 @@
+package transformation
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	_ "github.com/whosonfirst/go-webhookd-github"
+	"github.com/whosonfirst/go-webhookd/v3"
+	wh_transformation "github.com/whosonfirst/go-webhookd/v3/transformation"
+)
+
+// TestGitLabGitHubParity ensures that the gitlab* transformations produce the same output for fixtures/events/gitlab-push.json
+// as the github* transformations do for fixtures/events/github-push.json, the equivalent GitHub push event.
+func TestGitLabGitHubParity(t *testing.T) {
+
+	ctx := context.Background()
+
+	gitlab_body, err := os.ReadFile("../fixtures/events/gitlab-push.json")
+
+	if err != nil {
+		t.Fatalf("Failed to read GitLab push event, %v", err)
+	}
+
+	github_body, err := os.ReadFile("../fixtures/events/github-push.json")
+
+	if err != nil {
+		t.Fatalf("Failed to read GitHub push event, %v", err)
+	}
+
+	tests := []struct {
+		gitlab_uri string
+		github_uri string
+	}{
+		{"gitlabcommits://", "githubcommits://"},
+		{"gitlabcommits://?prepend_message=true", "githubcommits://?prepend_message=true"},
+		{"gitlabcommits://?prepend_author=true", "githubcommits://?prepend_author=true"},
+		{"gitlabcommits://?exclude_additions=true", "githubcommits://?exclude_additions=true"},
+		{"gitlabcommits://?exclude_modifications=true&exclude_deletions=true", "githubcommits://?exclude_modifications=true&exclude_deletions=true"},
+		{"gitlabrepo://", "githubrepo://"},
+		{"gitlabrepo://?prepend_message=true&prepend_author=true", "githubrepo://?prepend_message=true&prepend_author=true"},
+		{"gitlabrepo://?exclude_additions=true&exclude_modifications=true&exclude_deletions=true", "githubrepo://?exclude_additions=true&exclude_modifications=true&exclude_deletions=true"},
+	}
+
+	for _, test := range tests {
+
+		gitlab_tr, err := wh_transformation.NewTransformation(ctx, test.gitlab_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create transformation for %s, %v", test.gitlab_uri, err)
+		}
+
+		github_tr, err := wh_transformation.NewTransformation(ctx, test.github_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create transformation for %s, %v", test.github_uri, err)
+		}
+
+		gitlab_out, wh_err := gitlab_tr.Transform(ctx, gitlab_body)
+
+		if wh_err != nil {
+			t.Fatalf("Failed to transform GitLab push event with %s, %v", test.gitlab_uri, wh_err)
+		}
+
+		github_out, wh_err := github_tr.Transform(ctx, github_body)
+
+		if wh_err != nil {
+			t.Fatalf("Failed to transform GitHub push event with %s, %v", test.github_uri, wh_err)
+		}
+
+		if !bytes.Equal(gitlab_out, github_out) {
+			t.Fatalf("Output for %s does not match %s, '%s' != '%s'", test.gitlab_uri, test.github_uri, gitlab_out, github_out)
+		}
+	}
+}
+
+// TestGitLabHalt ensures that the gitlab* transformations halt messages using the head commit message and author.
+func TestGitLabHalt(t *testing.T) {
+
+	ctx := context.Background()
+
+	body, err := os.ReadFile("../fixtures/events/gitlab-push.json")
+
+	if err != nil {
+		t.Fatalf("Failed to read GitLab push event, %v", err)
+	}
+
+	tests := map[string]bool{
+		"gitlabcommits://?halt_on_message=readme":   true,
+		"gitlabcommits://?halt_on_message=Catalan":  false,
+		"gitlabrepo://?halt_on_author=GitLab%20dev": true,
+		"gitlabrepo://?halt_on_author=Jordi":        false,
+	}
+
+	for uri, halted := range tests {
+
+		tr, err := wh_transformation.NewTransformation(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create transformation for %s, %v", uri, err)
+		}
+
+		_, wh_err := tr.Transform(ctx, body)
+
+		if halted && (wh_err == nil || wh_err.Code != webhookd.HaltEvent) {
+			t.Fatalf("Expected %s to halt, %v", uri, wh_err)
+		}
+
+		if !halted && wh_err != nil {
+			t.Fatalf("Expected %s not to halt, %v", uri, wh_err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package transformation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+func init() {
+
+	ctx := context.Background()
+	err := registerTransformation(ctx, "gitlabcommits", NewGitLabCommitsTransformation)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GitLabCommitsTransformation implements the `webhookd.WebhookTransformation` interface for transforming GitLab
+// push event webhook messages in to CSV data containing: the commit hash, the name of the repository and the path
+// to the file commited. The output is the same as the `githubcommits://` transformation.
+type GitLabCommitsTransformation struct {
+	webhookd.WebhookTransformation
+	// options are the options used to filter and format the final output.
+	options *pushOptions
+}
+
+// NewGitLabCommitsTransformation() creates a new `GitLabCommitsTransformation` instance, configured by 'uri'
+// which is expected to take the form of:
+//
+//	gitlabcommits://?{PARAMETERS}
+//
+// Where {PARAMETERS} are the same as those for the `githubcommits://` transformation:
+// * `?exclude_additions` An optional boolean value to exclude newly added files from the final output.
+// * `?exclude_modifications` An optional boolean value to exclude update (modified) files from the final output.
+// * `?exclude_deletions` An optional boolean value to exclude deleted files from the final output.
+// * `?prepend_message` An optional boolean value to prepend the commit message to the final output. This takes the form of '#message,{COMMIT_MESSAGE},'
+// * `?prepend_author` An optional boolean value to prepend the name of the commit author to the final output. This takes the form of '#author,{COMMIT_AUTHOR},'
+// * `?halt_on_message` An optional regular expression that will be compared to the commit message; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+// * `?halt_on_author` An optional regular expression that will be compared to the commit author; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+func NewGitLabCommitsTransformation(ctx context.Context, uri string) (webhookd.WebhookTransformation, error) {
+
+	opts, err := newPushOptions(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive options, %w", err)
+	}
+
+	p := &GitLabCommitsTransformation{
+		options: opts,
+	}
+
+	return p, nil
+}
+
+// Transform() transforms 'body' (which is assumed to be a GitLab push event webhook message) in to CSV data containing:
+// the commit hash, the name of the repository and the path to the file commited.
+func (p *GitLabCommitsTransformation) Transform(ctx context.Context, body []byte) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	event, err := parseGitLabPushEvent(body)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	halt_err := p.options.halt(event)
+
+	if halt_err != nil {
+		return nil, halt_err
+	}
+
+	return p.options.commits(event), nil
+}
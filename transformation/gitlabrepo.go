@@ -0,0 +1,82 @@
+package transformation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+func init() {
+
+	ctx := context.Background()
+	err := registerTransformation(ctx, "gitlabrepo", NewGitLabRepoTransformation)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GitLabRepoTransformation implements the `webhookd.WebhookTransformation` interface for transforming GitLab
+// push event webhook messages in to the name of the repository where the commit occurred. The output is the same
+// as the `githubrepo://` transformation.
+type GitLabRepoTransformation struct {
+	webhookd.WebhookTransformation
+	// options are the options used to filter and format the final output.
+	options *pushOptions
+}
+
+// NewGitLabRepoTransformation() creates a new `GitLabRepoTransformation` instance, configured by 'uri'
+// which is expected to take the form of:
+//
+//	gitlabrepo://?{PARAMETERS}
+//
+// Where {PARAMETERS} are the same as those for the `githubrepo://` transformation:
+// * `?exclude_additions` An optional boolean value to exclude newly added files from consideration.
+// * `?exclude_modifications` An optional boolean value to exclude update (modified) files from consideration.
+// * `?exclude_deletions` An optional boolean value to exclude deleted files from consideration.
+// * `?prepend_message` An optional boolean value to prepend the commit message to the final output. This takes the form of '#message {COMMIT_MESSAGE}'
+// * `?prepend_author` An optional boolean value to prepend the name of the commit author to the final output. This takes the form of '#author {COMMIT_AUTHOR}'
+// * `?halt_on_message` An optional regular expression that will be compared to the commit message; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+// * `?halt_on_author` An optional regular expression that will be compared to the commit author; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+func NewGitLabRepoTransformation(ctx context.Context, uri string) (webhookd.WebhookTransformation, error) {
+
+	opts, err := newPushOptions(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive options, %w", err)
+	}
+
+	p := &GitLabRepoTransformation{
+		options: opts,
+	}
+
+	return p, nil
+}
+
+// Transform() transforms 'body' (which is assumed to be a GitLab push event webhook message) in to name of the repository
+// where the commit occurred. If none of the commits changed files which are not excluded an empty value is returned.
+func (p *GitLabRepoTransformation) Transform(ctx context.Context, body []byte) ([]byte, *webhookd.WebhookError) {
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	default:
+		// pass
+	}
+
+	event, err := parseGitLabPushEvent(body)
+
+	if err != nil {
+		err := &webhookd.WebhookError{Code: 999, Message: err.Error()}
+		return nil, err
+	}
+
+	halt_err := p.options.halt(event)
+
+	if halt_err != nil {
+		return nil, halt_err
+	}
+
+	return p.options.repo(event), nil
+}
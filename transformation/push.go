@@ -0,0 +1,222 @@
+package transformation
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/whosonfirst/go-webhookd/v3"
+)
+
+// type pushCommit is a struct containing the files changed by an individual commit in a push event.
+type pushCommit struct {
+	// Added are the paths of the files added by the commit.
+	Added []string
+	// Modified are the paths of the files updated (modified) by the commit.
+	Modified []string
+	// Removed are the paths of the files deleted by the commit.
+	Removed []string
+}
+
+// type pushEvent is a struct containing the properties of a push event, independent of the service that sent it,
+// used to produce the output of the push transformations.
+type pushEvent struct {
+	// Repo is the name of the repository, without its owner or namespace, that was pushed to.
+	Repo string
+	// HeadCommit is the hash of the most recent commit in the push event.
+	HeadCommit string
+	// HeadMessage is the message of the most recent commit in the push event.
+	HeadMessage string
+	// HeadAuthor is the name of the author of the most recent commit in the push event.
+	HeadAuthor string
+	// Commits are the commits in the push event.
+	Commits []pushCommit
+}
+
+// type pushOptions is a struct containing the options, shared by the push transformations, derived from a transformation URI.
+type pushOptions struct {
+	// ExcludeAdditions is a boolean flag to exclude newly added files from the final output.
+	ExcludeAdditions bool
+	// ExcludeModifications is a boolean flag to exclude updated (modified) files from the final output.
+	ExcludeModifications bool
+	// ExcludeDeletions is a boolean flag to exclude deleted files from the final output.
+	ExcludeDeletions bool
+	// A boolean flag signaling the commit message should be prepended to the top of the final output in the form of '#message {COMMIT_MESSAGE}'
+	prepend_message bool
+	// A boolean flag signaling the commit author should be prepended to the top of the final output in the form of '#author {COMMIT_AUTHOR}'
+	prepend_author bool
+	// An optional regular expression that will be compared to the commit message; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+	halt_on_message *regexp.Regexp
+	// An optional regular expression that will be compared to the commit author; if it matches the transformer will return an error with code `webhookd.HaltEvent`
+	halt_on_author *regexp.Regexp
+}
+
+// newPushOptions() returns a new `pushOptions` instance derived from the query parameters of the transformation URI 'uri'.
+// These are the same parameters used by the `githubrepo://` and `githubcommits://` transformations.
+func newPushOptions(uri string) (*pushOptions, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	opts := &pushOptions{}
+
+	flags := map[string]*bool{
+		"exclude_additions":     &opts.ExcludeAdditions,
+		"exclude_modifications": &opts.ExcludeModifications,
+		"exclude_deletions":     &opts.ExcludeDeletions,
+		"prepend_message":       &opts.prepend_message,
+		"prepend_author":        &opts.prepend_author,
+	}
+
+	for k, ptr := range flags {
+
+		str_v := q.Get(k)
+
+		if str_v == "" {
+			continue
+		}
+
+		v, err := strconv.ParseBool(str_v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse '%s', %w", str_v, err)
+		}
+
+		*ptr = v
+	}
+
+	q_halt_on_message := q.Get("halt_on_message")
+	q_halt_on_author := q.Get("halt_on_author")
+
+	if q_halt_on_message != "" {
+
+		r, err := regexp.Compile(q_halt_on_message)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?halt_on_message= parameter, %w", err)
+		}
+
+		opts.halt_on_message = r
+	}
+
+	if q_halt_on_author != "" {
+
+		r, err := regexp.Compile(q_halt_on_author)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?halt_on_author= parameter, %w", err)
+		}
+
+		opts.halt_on_author = r
+	}
+
+	return opts, nil
+}
+
+// halt() returns an error with code `webhookd.HaltEvent` if the head commit message or author of 'event' match the
+// `halt_on_message` or `halt_on_author` regular expressions of 'opts'.
+func (opts *pushOptions) halt(event *pushEvent) *webhookd.WebhookError {
+
+	if opts.halt_on_message != nil && opts.halt_on_message.MatchString(event.HeadMessage) {
+		return &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Halt"}
+	}
+
+	if opts.halt_on_author != nil && opts.halt_on_author.MatchString(event.HeadAuthor) {
+		return &webhookd.WebhookError{Code: webhookd.HaltEvent, Message: "Halt"}
+	}
+
+	return nil
+}
+
+// paths() returns the paths of the files changed by 'c' which are not excluded by 'opts', in the order: additions,
+// modifications, deletions.
+func (opts *pushOptions) paths(c pushCommit) []string {
+
+	paths := make([]string, 0)
+
+	if !opts.ExcludeAdditions {
+		paths = append(paths, c.Added...)
+	}
+
+	if !opts.ExcludeModifications {
+		paths = append(paths, c.Modified...)
+	}
+
+	if !opts.ExcludeDeletions {
+		paths = append(paths, c.Removed...)
+	}
+
+	return paths
+}
+
+// commits() returns CSV data containing the head commit hash, the name of the repository and the path of each file
+// changed by 'event'. This is the same output produced by the `githubcommits://` transformation.
+func (opts *pushOptions) commits(event *pushEvent) []byte {
+
+	buf := new(bytes.Buffer)
+	wr := csv.NewWriter(buf)
+
+	if opts.prepend_message {
+		v := fmt.Sprintf("#message %s", event.HeadMessage)
+		wr.Write([]string{v, "", ""})
+	}
+
+	if opts.prepend_author {
+		v := fmt.Sprintf("#author %s", event.HeadAuthor)
+		wr.Write([]string{v, "", ""})
+	}
+
+	for _, c := range event.Commits {
+
+		for _, path := range opts.paths(c) {
+			commit := []string{event.HeadCommit, event.Repo, path}
+			wr.Write(commit)
+		}
+	}
+
+	wr.Flush()
+
+	return buf.Bytes()
+}
+
+// repo() returns the name of the repository for 'event' if any of its commits changed files which are not excluded
+// by 'opts', or an empty value otherwise. This is the same output produced by the `githubrepo://` transformation.
+func (opts *pushOptions) repo(event *pushEvent) []byte {
+
+	buf := new(bytes.Buffer)
+
+	has_updates := false
+
+	for _, c := range event.Commits {
+
+		if len(opts.paths(c)) > 0 {
+			has_updates = true
+			break
+		}
+	}
+
+	if has_updates {
+
+		if opts.prepend_message {
+			msg := fmt.Sprintf("#message %s\n", event.HeadMessage)
+			buf.WriteString(msg)
+		}
+
+		if opts.prepend_author {
+			msg := fmt.Sprintf("#author %s\n", event.HeadAuthor)
+			buf.WriteString(msg)
+		}
+
+		buf.WriteString(event.Repo)
+	}
+
+	return buf.Bytes()
+}
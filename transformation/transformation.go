@@ -0,0 +1,22 @@
+package transformation
+
+import (
+	"context"
+	"strings"
+
+	wh_transformation "github.com/whosonfirst/go-webhookd/v3/transformation"
+)
+
+// registerTransformation() associates 'scheme' with 'init_func' unless another package has already registered a
+// transformation for 'scheme'.
+func registerTransformation(ctx context.Context, scheme string, init_func wh_transformation.TransformationInitializationFunc) error {
+
+	for _, s := range wh_transformation.Schemes() {
+
+		if strings.EqualFold(s, scheme+"://") {
+			return nil
+		}
+	}
+
+	return wh_transformation.RegisterTransformation(ctx, scheme, init_func)
+}